@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+	"github.com/xorvercom/ziphttpd/cmd/internal/logic"
+)
+
+func init() {
+	register("backup", "<host> <file> : save api data of the host to a zip archive", runBackup)
+	register("restore", "<host> <file> ["+logic.RestoreMerge+"|"+logic.RestoreReplace+"] : load api data of the host from a backup archive", runRestore)
+}
+
+// runBackup はホストの API のデータをバックアップします。
+// 起動中のサーバのデータは api の backup でバックアップするので、起動中は断ります。
+func runBackup(u common.ZipHttpdUtil, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: backup <host> <file>")
+		return 2
+	}
+	if refuseRunning(u, "backup", "use the backup api of the running server") {
+		return 1
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+
+	host, filename := args[0], args[1]
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	count, err := logic.BackupStorage(conf, host, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filename)
+		fmt.Fprintf(os.Stderr, "%s : %v\n", host, err)
+		return 1
+	}
+	fmt.Printf("%s : %d keys -> %s\n", host, count, filename)
+	return 0
+}
+
+// runRestore はバックアップを検証してホストの API のデータに復元します。
+// 起動中のサーバには api の restore で復元するので、起動中は断ります。
+func runRestore(u common.ZipHttpdUtil, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: restore <host> <file> ["+logic.RestoreMerge+"|"+logic.RestoreReplace+"]")
+		return 2
+	}
+	mode := logic.RestoreMerge
+	if len(args) > 2 {
+		mode = strings.ToLower(args[2])
+	}
+	if refuseRunning(u, "restore", "use the restore api of the running server") {
+		return 1
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+
+	host, filename := args[0], args[1]
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	folder := conf.APIPath(host)
+	os.MkdirAll(folder, 0755)
+	written, deleted, err := logic.RestoreStorage(conf, host, file, info.Size(), mode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s : %v\n", host, err)
+		return 1
+	}
+	fmt.Printf("%s : %d keys written, %d keys deleted (%s)\n", host, written, deleted, mode)
+	return 0
+}
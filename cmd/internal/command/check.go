@@ -0,0 +1,156 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	fpath "path/filepath"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/util/pkg/zip"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+)
+
+const checkUsage = "[-json] : validate ziphttpd.json, password.json, portlockins.json, document settings and archives"
+
+func init() {
+	register("check", checkUsage, runCheck)
+}
+
+// checker は検査の結果を集めます。
+type checker struct {
+	results json.ElemArray
+	failed  int
+	jsonOut bool
+}
+
+// add は検査の結果を一つ記録します。err が nil であれば合格です。
+// path は誤りのある JSON のパスで、無ければ空文字列です。
+func (c *checker) add(kind, target, path string, err error) {
+	res := json.NewElemObject()
+	res.Put("kind", json.NewElemString(kind))
+	res.Put("target", json.NewElemString(target))
+	if path != "" {
+		res.Put("path", json.NewElemString(path))
+	}
+	res.Put("ok", json.NewElemBool(err == nil))
+	if err != nil {
+		c.failed++
+		res.Put("message", json.NewElemString(err.Error()))
+	}
+	c.results.Append(res)
+	if c.jsonOut {
+		return
+	}
+	if path != "" {
+		target += " " + path
+	}
+	if err != nil {
+		fmt.Printf("FAIL %-8s %s : %v\n", kind, target, err)
+	} else {
+		fmt.Printf("ok   %-8s %s\n", kind, target)
+	}
+}
+
+// finish は結果を出力して終了コードを返します。
+func (c *checker) finish() int {
+	if c.jsonOut {
+		res := json.NewElemObject()
+		res.Put("ok", json.NewElemBool(c.failed == 0))
+		res.Put("failed", json.NewElemFloat(float64(c.failed)))
+		res.Put("results", c.results)
+		printJSON(os.Stdout, res)
+	} else {
+		fmt.Printf("%d checked, %d failed\n", c.results.Size(), c.failed)
+	}
+	if c.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runCheck は設定ファイル、ドキュメントの設定ファイル、アーカイブを検査します。
+// 設定ファイルが無い場合は、標準の設定ファイルを書き出さずに失敗します。
+// 設定ファイルの形の誤りは、設定を読み込んだときに集めたものを報告します。
+func runCheck(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("check", checkUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c := &checker{results: json.NewElemArray(), jsonOut: *jsonOut}
+
+	// 設定ファイル
+	configfile := fpath.Join(u.ConfigDir(), common.ConfigFile)
+	if false == common.FileExists(configfile) { // nolint:gosimple
+		c.add("config", configfile, "", fmt.Errorf("not found"))
+		return c.finish()
+	}
+	elem, err := json.LoadFromJSONFile(configfile)
+	if err == nil {
+		if _, ok := elem.AsObject(); false == ok { // nolint:gosimple
+			err = fmt.Errorf("not an object")
+		}
+	}
+	if err != nil {
+		c.add("config", configfile, "", err)
+		return c.finish()
+	}
+	conf, err := iconfig.OpenReadOnly(u)
+	if err != nil {
+		c.add("config", configfile, "", err)
+		return c.finish()
+	}
+	defer conf.Close()
+
+	// 設定ファイルの形、アーカイブの有無、ドキュメント識別子の重複
+	errs := conf.ConfigErrors()
+	if len(errs) == 0 {
+		c.add("config", configfile, "", nil)
+	}
+	for _, e := range errs {
+		c.add("config", e.File, e.Path, fmt.Errorf("%s", e.Message))
+	}
+
+	// store のカタログと署名
+	for _, mes := range conf.StoreErrors() {
+		target := mes
+		if i := strings.Index(mes, " : "); i >= 0 {
+			target = mes[:i]
+			mes = mes[i+3:]
+		}
+		c.add("store", target, "", fmt.Errorf("%s", mes))
+	}
+
+	// 公開するドキュメントのアーカイブ
+	for _, hostName := range conf.HostNames() {
+		docHost := conf.DocHost(hostName)
+		for _, groupName := range docHost.Ids() {
+			docGroup := docHost.Get(groupName)
+			for _, docid := range docGroup.Ids() {
+				ids := []common.DocID{docid}
+				for _, v := range docGroup.Versions(docid) {
+					ids = append(ids, docid+common.VersionSeparator+v)
+				}
+				for _, id := range ids {
+					doc := docGroup.Get(id)
+					if doc == nil {
+						continue
+					}
+					c.add("archive", hostName+"/"+groupName+"/"+id, "", checkArchive(doc.ZipPath()))
+				}
+			}
+		}
+	}
+	return c.finish()
+}
+
+// checkArchive はアーカイブを zip として開けるかを検査します。
+func checkArchive(zipfile string) error {
+	dic, err := zip.OpenDictionary(zipfile, false)
+	if err != nil {
+		return err
+	}
+	dic.Close()
+	return nil
+}
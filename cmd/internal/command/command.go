@@ -0,0 +1,55 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// subcommand はサブコマンドの実体です。
+type subcommand struct {
+	// 説明
+	usage string
+	// 実行
+	run func(u common.ZipHttpdUtil, args []string) int
+}
+
+var commands = map[string]*subcommand{}
+
+// register はサブコマンドを登録します。
+func register(name, usage string, run func(u common.ZipHttpdUtil, args []string) int) {
+	commands[name] = &subcommand{usage: usage, run: run}
+}
+
+// Exists はサブコマンドが存在するかを返します。
+func Exists(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Run はサブコマンドを実行して終了コードを返します。
+// args[0] がサブコマンド名で、以降がサブコマンドの引数です。
+func Run(u common.ZipHttpdUtil, args []string) int {
+	cmd, ok := commands[args[0]]
+	if false == ok { // nolint:gosimple
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		Usage()
+		return 2
+	}
+	return cmd.run(u, args[1:])
+}
+
+// Usage はサブコマンドの一覧を出力します。
+func Usage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// consoleCommand は起動中のサーバの標準入力から受け付けるコマンドです。
+type consoleCommand struct {
+	// 説明
+	usage string
+	// 実行
+	run func(conf common.Config, out io.Writer, args []string)
+}
+
+var consoleCommands = map[string]*consoleCommand{}
+
+func init() {
+	registerConsole("help", "show console commands", consoleHelp)
+}
+
+// registerConsole はコンソールコマンドを登録します。
+func registerConsole(name, usage string, run func(conf common.Config, out io.Writer, args []string)) {
+	consoleCommands[name] = &consoleCommand{usage: usage, run: run}
+}
+
+// Console は標準入力の一行をコンソールコマンドとして実行します。
+func Console(conf common.Config, out io.Writer, line string) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	cmd, ok := consoleCommands[strings.ToLower(args[0])]
+	if false == ok { // nolint:gosimple
+		fmt.Fprintf(out, "unknown command: %s\n", args[0])
+		return
+	}
+	conf.Logger().Infof("console: %s", line)
+	cmd.run(conf, out, args[1:])
+}
+
+// consoleHelp はコンソールコマンドの一覧を出力します。
+func consoleHelp(conf common.Config, out io.Writer, args []string) {
+	names := []string{"quit"}
+	for name := range consoleCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		usage := "stop server"
+		if cmd, ok := consoleCommands[name]; ok {
+			usage = cmd.usage
+		}
+		fmt.Fprintf(out, "  %-10s %s\n", name, usage)
+	}
+}
@@ -0,0 +1,255 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+)
+
+const (
+	listUsage     = "[-json] [host] : list hosts, groups and documents"
+	addUsage      = "[-json] [-title <title>] [-group <group>] [-replace] <archive> : add an archive to the docs folder"
+	removeUsage   = "[-json] <docid> : remove a document from the docs folder"
+	setTitleUsage = "[-json] <host> <docid> <title> [description] : change the title of a document"
+	setGroupUsage = "[-json] <docid> <group> : change the group of a document in the docs folder"
+)
+
+func init() {
+	register("list", listUsage, runList)
+	register("add", addUsage, runAdd)
+	register("remove", removeUsage, runRemove)
+	register("set-title", setTitleUsage, runSetTitle)
+	register("set-group", setGroupUsage, runSetGroup)
+}
+
+// runList はホスト、グループ、ドキュメントの一覧を出力します。
+func runList(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("list", listUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	conf, err := iconfig.OpenReadOnly(u)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	defer conf.Close()
+
+	hosts := conf.HostNames()
+	if fs.NArg() > 0 {
+		if conf.DocHost(fs.Arg(0)) == nil {
+			printError(*jsonOut, fmt.Errorf("unknown host %s", fs.Arg(0)))
+			return 1
+		}
+		hosts = fs.Args()[:1]
+	}
+	res := json.NewElemArray()
+	for _, hostName := range hosts {
+		docHost := conf.DocHost(hostName)
+		host := json.NewElemObject()
+		host.Put("name", json.NewElemString(hostName))
+		host.Put("title", json.NewElemString(docHost.Title()))
+		groups := json.NewElemArray()
+		if false == *jsonOut { // nolint:gosimple
+			fmt.Printf("%s\n", hostName)
+		}
+		for _, groupName := range docHost.Ids() {
+			docGroup := docHost.Get(groupName)
+			group := json.NewElemObject()
+			group.Put("name", json.NewElemString(groupName))
+			group.Put("title", json.NewElemString(docGroup.Title()))
+			group.Put("description", json.NewElemString(docGroup.Description()))
+			docs := json.NewElemArray()
+			if false == *jsonOut { // nolint:gosimple
+				fmt.Printf("  %s : %s\n", groupName, docGroup.Title())
+			}
+			for _, docid := range docGroup.Ids() {
+				doc := docGroup.Get(docid)
+				versions := docGroup.Versions(docid)
+				elem := json.NewElemObject()
+				elem.Put("id", json.NewElemString(docid))
+				elem.Put("title", json.NewElemString(doc.Title()))
+				elem.Put("description", json.NewElemString(doc.Description()))
+				elem.Put("path", json.NewElemString(doc.ZipPath()))
+				elem.Put("conf", json.NewElemString(doc.ConfPath()))
+				arr := json.NewElemArray()
+				for _, v := range versions {
+					arr.Append(json.NewElemString(v))
+				}
+				elem.Put("versions", arr)
+				docs.Append(elem)
+				if false == *jsonOut { // nolint:gosimple
+					fmt.Printf("    %-20s %s\n", docid, doc.Title())
+					fmt.Printf("    %-20s %s\n", "", doc.ZipPath())
+					if len(versions) > 0 {
+						fmt.Printf("    %-20s versions: %s\n", "", strings.Join(versions, ", "))
+					}
+				}
+			}
+			group.Put("docs", docs)
+			groups.Append(group)
+		}
+		host.Put("groups", groups)
+		res.Append(host)
+	}
+	if *jsonOut {
+		printJSON(os.Stdout, res)
+	}
+	return 0
+}
+
+// runAdd はアーカイブを docs フォルダに追加します。
+func runAdd(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("add", addUsage)
+	title := fs.String("title", "", "title of the document")
+	group := fs.String("group", "", "group of the document")
+	replace := fs.Bool("replace", false, "replace the document of the same name")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	if refuseEdit(u, *jsonOut, "add") {
+		return 1
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	defer conf.Close()
+
+	filename := fs.Arg(0)
+	file, err := os.Open(filename)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	defer file.Close()
+	docid, _, err := conf.AdminMan().AddDoc(filename, file, *title, *group, *replace)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	return printDone(*jsonOut, "add", common.LocalHostName, docid)
+}
+
+// runRemove は docs フォルダのドキュメントを削除します。
+func runRemove(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("remove", removeUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	if refuseEdit(u, *jsonOut, "remove") {
+		return 1
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	defer conf.Close()
+
+	docid := strings.ToLower(fs.Arg(0))
+	if err := conf.AdminMan().RemoveDoc(docid); err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	return printDone(*jsonOut, "remove", common.LocalHostName, docid)
+}
+
+// runSetTitle はドキュメントのタイトルと説明を変更します。
+func runSetTitle(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("set-title", setTitleUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 3 {
+		fs.Usage()
+		return 2
+	}
+	host, docid := fs.Arg(0), strings.ToLower(fs.Arg(1))
+	return updateDoc(u, *jsonOut, "set-title", host, docid, func(edit *common.DocEdit) {
+		edit.Title = fs.Arg(2)
+		if fs.NArg() > 3 {
+			edit.Description = fs.Arg(3)
+		}
+	})
+}
+
+// runSetGroup は docs フォルダのドキュメントのグループを変更します。
+func runSetGroup(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("set-group", setGroupUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	docid := strings.ToLower(fs.Arg(0))
+	return updateDoc(u, *jsonOut, "set-group", common.LocalHostName, docid, func(edit *common.DocEdit) {
+		edit.DocGroup = fs.Arg(1)
+	})
+}
+
+// updateDoc はドキュメントの設定ファイルを change で変更します。
+func updateDoc(u common.ZipHttpdUtil, jsonOut bool, action string, host common.HostName, docid common.DocID, change func(edit *common.DocEdit)) int {
+	if refuseEdit(u, jsonOut, action) {
+		return 1
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		printError(jsonOut, err)
+		return 1
+	}
+	defer conf.Close()
+
+	admin := conf.AdminMan()
+	edit, err := admin.Doc(host, docid)
+	if err != nil {
+		printError(jsonOut, err)
+		return 1
+	}
+	change(&edit)
+	if _, err := admin.UpdateDoc(host, docid, edit); err != nil {
+		printError(jsonOut, err)
+		return 1
+	}
+	return printDone(jsonOut, action, host, docid)
+}
+
+// refuseEdit は起動中のサーバがあれば、その旨を出力して真を返します。
+// サーバと別のプロセスから同じ設定ファイルを書き換えないよう、起動中は管理画面で変更します。
+func refuseEdit(u common.ZipHttpdUtil, jsonOut bool, action string) bool {
+	pid, running := runningServer(u)
+	if false == running { // nolint:gosimple
+		return false
+	}
+	printError(jsonOut, fmt.Errorf("%s : the server is running (pid %d); use the admin console or stop the server", action, pid))
+	return true
+}
+
+// printDone は変更の結果を出力します。
+func printDone(jsonOut bool, action string, host common.HostName, docid common.DocID) int {
+	if jsonOut {
+		res := json.NewElemObject()
+		res.Put("action", json.NewElemString(action))
+		res.Put("host", json.NewElemString(host))
+		res.Put("doc", json.NewElemString(docid))
+		printJSON(os.Stdout, res)
+		return 0
+	}
+	fmt.Printf("%s : %s/%s\n", action, host, docid)
+	return 0
+}
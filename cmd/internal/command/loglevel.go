@@ -0,0 +1,29 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func init() {
+	registerConsole("loglevel", "[debug|info|warn|error] : show or change the log level", consoleLogLevel)
+}
+
+// consoleLogLevel は起動中のサーバのログの出力レベルを表示、変更します。
+// 変更は再起動すると設定ファイルのレベルに戻ります。
+func consoleLogLevel(conf common.Config, out io.Writer, args []string) {
+	log := conf.Logger()
+	if len(args) == 0 {
+		fmt.Fprintf(out, "log level : %s\n", log.Level())
+		return
+	}
+	level, ok := common.ParseLogLevel(args[0])
+	if false == ok { // nolint:gosimple
+		fmt.Fprintf(out, "unknown level: %s\n", args[0])
+		return
+	}
+	log.SetLevel(level)
+	fmt.Fprintf(out, "log level : %s\n", level)
+}
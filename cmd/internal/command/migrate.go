@@ -0,0 +1,54 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+	"github.com/xorvercom/ziphttpd/cmd/internal/logic"
+)
+
+func init() {
+	register("migrate-storage", "<from> <to> [host...] : copy api data between storages ("+strings.Join(logic.StorageKinds(), "/")+")", runMigrate)
+}
+
+// runMigrate は API のデータを別の格納方式に複写します。
+// 複写後に ziphttpd.json の apistorage を書き換えると切り替わります。
+func runMigrate(u common.ZipHttpdUtil, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate-storage <from> <to> [host...]")
+		return 2
+	}
+	from, to := strings.ToLower(args[0]), strings.ToLower(args[1])
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+
+	hosts := args[2:]
+	if len(hosts) == 0 {
+		hosts = conf.HostNames()
+	}
+	status := 0
+	for _, host := range hosts {
+		folder := conf.APIPath(host)
+		if false == common.DirExists(folder) {
+			continue
+		}
+		count, err := logic.MigrateStorage(folder, from, to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s : %v\n", host, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s : %d keys %s -> %s\n", host, count, from, to)
+	}
+	if status == 0 && conf.APIStorage() != to {
+		fmt.Printf("set \"apistorage\": \"%s\" in ziphttpd.json to use it\n", to)
+	}
+	return status
+}
@@ -0,0 +1,38 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// newFlagSet はサブコマンドの引数の解析を作ります。-json で出力を JSON にします。
+func newFlagSet(name, usage string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	jsonOut := fs.Bool("json", false, "output in JSON")
+	return fs, jsonOut
+}
+
+// printJSON は JSON を整形して出力します。
+func printJSON(out io.Writer, elem json.Element) {
+	fmt.Fprintln(out, json.ToJSON(elem, true))
+}
+
+// printError はエラーを出力します。-json の場合は {"error": "..."} を標準出力に出力します。
+func printError(jsonOut bool, err error) {
+	if jsonOut {
+		res := json.NewElemObject()
+		res.Put("error", json.NewElemString(err.Error()))
+		printJSON(os.Stdout, res)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
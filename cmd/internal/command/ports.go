@@ -0,0 +1,92 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+)
+
+const portsUsage = "[-json] [set <host> <port> | remove <host>] : show or edit the port lock-ins"
+
+func init() {
+	register("ports", portsUsage, runPorts)
+}
+
+// runPorts はポートロックインファイルのポートを表示、変更します。
+// 起動中のサーバがあれば変更せず、表示だけを行います。
+func runPorts(u common.ZipHttpdUtil, args []string) int {
+	fs, jsonOut := newFlagSet("ports", portsUsage)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	open := iconfig.OpenReadOnly
+	if fs.NArg() > 0 {
+		if refuseEdit(u, *jsonOut, "ports "+fs.Arg(0)) {
+			return 1
+		}
+		open = iconfig.OpenOffline
+	}
+	conf, err := open(u)
+	if err != nil {
+		printError(*jsonOut, err)
+		return 1
+	}
+	defer conf.Close()
+
+	admin := conf.AdminMan()
+	switch fs.Arg(0) {
+	case "":
+	case "set":
+		if fs.NArg() < 3 {
+			fs.Usage()
+			return 2
+		}
+		port, err := strconv.Atoi(fs.Arg(2))
+		if err == nil && port == 0 {
+			err = fmt.Errorf("invalid port %d", port)
+		}
+		if err == nil {
+			err = admin.SetLockIn(fs.Arg(1), port)
+		}
+		if err != nil {
+			printError(*jsonOut, err)
+			return 1
+		}
+	case "remove":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		if err := admin.SetLockIn(fs.Arg(1), 0); err != nil {
+			printError(*jsonOut, err)
+			return 1
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	ports := admin.LockIns()
+	hosts := []common.HostName{}
+	for host := range ports {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	if *jsonOut {
+		res := json.NewElemObject()
+		for _, host := range hosts {
+			res.Put(host, json.NewElemFloat(float64(ports[host])))
+		}
+		printJSON(os.Stdout, res)
+		return 0
+	}
+	for _, host := range hosts {
+		fmt.Printf("%-20s %d\n", host, ports[host])
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+	"github.com/xorvercom/ziphttpd/cmd/internal/publish"
+)
+
+func init() {
+	register("publish", "write zhsig catalog and signatures of docs", runPublish)
+}
+
+// runPublish は docs のドキュメントを配布用に書き出します。
+func runPublish(u common.ZipHttpdUtil, args []string) int {
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+
+	results, err := publish.Publish(conf)
+	for _, res := range results {
+		fmt.Printf("%s/%s : %s (sha256:%s)\n", res.Group, res.DocID, res.File, res.Hash)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("published %d documents as %s to %s\n", len(results), conf.PublishHost(), conf.PublishPath())
+	return 0
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+	"github.com/xorvercom/ziphttpd/cmd/internal/logic"
+)
+
+func init() {
+	register("rekey", "<host> [password:<old>|keyfile:<old file>] : re-encrypt api data of the host with the key in ziphttpd.json", runRekey)
+}
+
+// runRekey はホストの API のデータを現在の apiencryption の鍵で暗号化し直します。
+// 古い鍵を省略すると平文のデータを暗号化し、apiencryption の指定を外してから実行すると平文に戻します。
+// パスワードや鍵ファイルを変える時は、設定を新しい鍵にしてから古い鍵を指定して実行します。
+// サーバを停止してから実行します。
+func runRekey(u common.ZipHttpdUtil, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: rekey <host> [password:<old>|keyfile:<old file>]")
+		return 2
+	}
+	var oldSecret []byte
+	if len(args) > 1 {
+		parts := strings.SplitN(args[1], ":", 2)
+		switch parts[0] {
+		case "password":
+			if len(parts) < 2 {
+				fmt.Fprintln(os.Stderr, "no password")
+				return 2
+			}
+			oldSecret = []byte(parts[1])
+		case "keyfile":
+			if len(parts) < 2 {
+				fmt.Fprintln(os.Stderr, "no keyfile")
+				return 2
+			}
+			data, err := os.ReadFile(parts[1])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			oldSecret = data
+		default:
+			fmt.Fprintf(os.Stderr, "unknown old key %s\n", args[1])
+			return 2
+		}
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+
+	host := args[0]
+	count, err := logic.Rekey(conf, host, oldSecret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s : %v\n", host, err)
+		return 1
+	}
+	if _, encrypted := conf.APIEncryption(host); encrypted {
+		fmt.Printf("%s : %d keys encrypted\n", host, count)
+	} else {
+		fmt.Printf("%s : %d keys decrypted\n", host, count)
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func init() {
+	registerConsole("reload", "reload configuration and restart the servers", consoleReload)
+}
+
+// consoleReload は起動中のサーバに設定の再読み込みを要求します。
+func consoleReload(conf common.Config, out io.Writer, args []string) {
+	if err := conf.AdminMan().Reload(); err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	fmt.Fprintln(out, "reload requested")
+}
@@ -0,0 +1,61 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
+)
+
+const rollbackUsage = "<host> <docid> [version|latest] : switch served version of a store document"
+
+func init() {
+	register("rollback", rollbackUsage, runRollback)
+	registerConsole("rollback", rollbackUsage, consoleRollback)
+}
+
+// runRollback はドキュメントのバージョンを固定します。起動中のサーバには再起動後に反映されます。
+func runRollback(u common.ZipHttpdUtil, args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: rollback "+rollbackUsage)
+		return 2
+	}
+	conf, err := iconfig.OpenOffline(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conf.Close()
+	if err := rollback(conf, os.Stdout, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// consoleRollback は起動中のサーバでドキュメントのバージョンを切り替えます。
+func consoleRollback(conf common.Config, out io.Writer, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(out, "usage: rollback "+rollbackUsage)
+		return
+	}
+	if err := rollback(conf, out, args); err != nil {
+		fmt.Fprintln(out, err)
+	}
+}
+
+func rollback(conf common.Config, out io.Writer, args []string) error {
+	version := ""
+	if len(args) > 2 {
+		version = args[2]
+	}
+	served, err := conf.Rollback(args[0], args[1], version)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s/%s : %s%s\n", args[0], args[1], common.VersionSeparator, served)
+	conf.Logger().Infof("rollback %s/%s to %s", args[0], args[1], served)
+	return nil
+}
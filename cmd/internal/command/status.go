@@ -0,0 +1,156 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	fpath "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 起動中のサーバへの問い合わせの時間切れ
+	statusTimeout = 5 * time.Second
+)
+
+func init() {
+	register("status", ": show whether the running server is alive and ready", runStatus)
+}
+
+// runStatus は pid ファイルと待ち受けポートの /healthz, /readyz で起動中のサーバの状態を表示します。
+// 終了コードは、準備ができていれば 0、起動しているが準備ができていなければ 1、応答が無ければ 3 です。
+func runStatus(u common.ZipHttpdUtil, args []string) int {
+	// pid ファイル、サーバが書いた代表ポートがあればそこに問い合わせる
+	pidfile := fpath.Join(u.ConfigDir(), common.PidFile)
+	pid, port, err := readPidFile(u)
+	if err == nil {
+		fmt.Printf("pid file : %s (pid %d)\n", pidfile, pid)
+	} else {
+		fmt.Printf("pid file : %s (none)\n", pidfile)
+	}
+	if port == 0 {
+		port = u.ListenPort()
+	}
+
+	base := fmt.Sprintf("http://localhost:%d", port)
+	client := &http.Client{Timeout: statusTimeout}
+
+	// 死活
+	status, health, err := getStatus(client, base+"/healthz")
+	if err != nil || status != http.StatusOK {
+		fmt.Printf("health   : not responding on port %d", port)
+		if err != nil {
+			fmt.Printf(" (%v)", err)
+		}
+		fmt.Println()
+		if pid != 0 {
+			fmt.Println("           the pid file may be stale")
+		}
+		return 3
+	}
+	alivePid := 0
+	if p, ok := json.QueryElemFloat(health, "pid"); ok {
+		alivePid = int(p.Float())
+	}
+	uptime := 0.0
+	if t, ok := json.QueryElemFloat(health, "uptime"); ok {
+		uptime = t.Float()
+	}
+	fmt.Printf("health   : ok (pid %d, up %s)\n", alivePid, time.Duration(uptime)*time.Second)
+	if pid != 0 && pid != alivePid {
+		fmt.Printf("           pid file %d does not match the running server\n", pid)
+	}
+
+	// 準備
+	status, ready, err := getStatus(client, base+"/readyz")
+	if err != nil {
+		fmt.Printf("ready    : %v\n", err)
+		return 1
+	}
+	if checks, ok := json.QueryElemObject(ready, "checks"); ok {
+		for _, name := range checks.Keys() {
+			res := "ok"
+			if ok, found := json.QueryElemBool(checks.Child(name), "ok"); found && false == ok.Bool() { // nolint:gosimple
+				res = "fail " + json.ToJSON(checks.Child(name), false)
+			}
+			fmt.Printf("  %-9s: %s\n", name, res)
+		}
+	}
+	if status != http.StatusOK {
+		fmt.Println("ready    : not ready")
+		return 1
+	}
+	fmt.Println("ready    : ok")
+	return 0
+}
+
+// readPidFile は pid ファイルのプロセス ID と代表ポートを返します。
+// 一行目がプロセス ID、二行目が代表ポートです。ポートの無い pid ファイルでは port は 0 です。
+func readPidFile(u common.ZipHttpdUtil) (pid, port int, err error) {
+	data, err := os.ReadFile(fpath.Join(u.ConfigDir(), common.PidFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) > 0 {
+		pid, _ = strconv.Atoi(lines[0])
+	}
+	if len(lines) > 1 {
+		port, _ = strconv.Atoi(lines[1])
+	}
+	return pid, port, nil
+}
+
+// runningServer は同じ設定の置き場で起動中のサーバがあれば、そのプロセス ID を返します。
+// pid ファイルがあり、代表ポートの /healthz が同じプロセス ID を返せば起動中とみなします。
+func runningServer(u common.ZipHttpdUtil) (int, bool) {
+	pid, port, err := readPidFile(u)
+	if err != nil || pid == 0 {
+		return 0, false
+	}
+	if port == 0 {
+		port = u.ListenPort()
+	}
+	client := &http.Client{Timeout: statusTimeout}
+	status, health, err := getStatus(client, fmt.Sprintf("http://localhost:%d/healthz", port))
+	if err != nil || status != http.StatusOK {
+		return 0, false
+	}
+	alive, ok := json.QueryElemFloat(health, "pid")
+	return pid, ok && int(alive.Float()) == pid
+}
+
+// refuseRunning は起動中のサーバがあればその旨を出力して真を返します。
+// 起動中のサーバと同じデータを別のプロセスから書き換えないためのものです。
+func refuseRunning(u common.ZipHttpdUtil, name, instead string) bool {
+	pid, running := runningServer(u)
+	if false == running { // nolint:gosimple
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s : the server is running (pid %d); %s\n", name, pid, instead)
+	return true
+}
+
+// getStatus は url を GET して状態コードと JSON の本文を返します。
+func getStatus(client *http.Client, url string) (int, json.Element, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	elem, err := json.LoadFromJSONByte(data)
+	if err != nil {
+		return res.StatusCode, nil, fmt.Errorf("%s : %s", url, res.Status)
+	}
+	return res.StatusCode, elem, nil
+}
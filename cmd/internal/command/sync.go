@@ -0,0 +1,46 @@
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/logic"
+)
+
+func init() {
+	registerConsole("sync", "[peer] [host] : synchronize api data with the peers in ziphttpd.json", consoleSync)
+}
+
+// consoleSync は起動中のサーバで同期の相手と WebAPI のデータを同期します。
+// 相手を省略すると全ての相手と、ホストを省略すると相手に指定した全てのホストを同期します。
+func consoleSync(conf common.Config, out io.Writer, args []string) {
+	peers := conf.SyncPeers()
+	if len(peers) == 0 {
+		fmt.Fprintln(out, "no sync peers")
+		return
+	}
+	found := false
+	for _, peer := range peers {
+		if len(args) > 0 && args[0] != peer.Name {
+			continue
+		}
+		found = true
+		hosts := logic.SyncHosts(conf, peer)
+		if len(args) > 1 {
+			hosts = []common.HostName{args[1]}
+		}
+		for _, host := range hosts {
+			result, err := logic.SyncHost(conf, host, peer)
+			if err != nil {
+				fmt.Fprintf(out, "%s %s : %v\n", peer.Name, host, err)
+				continue
+			}
+			fmt.Fprintf(out, "%s %s : pulled %d, pushed %d, conflicts %d, skipped %d\n",
+				peer.Name, host, result.Pulled, result.Pushed, result.Conflicts, result.Skipped)
+		}
+	}
+	if false == found { // nolint:gosimple
+		fmt.Fprintf(out, "unknown peer: %s\n", args[0])
+	}
+}
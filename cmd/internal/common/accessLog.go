@@ -0,0 +1,196 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// アクセスログの形式
+const (
+	// AccessLogCommon は Common Log Format です。
+	AccessLogCommon = "common"
+	// AccessLogCombined は Combined Log Format の後ろに "ホスト" "ドキュメント" 処理時間(ミリ秒) を付けた形式です。
+	AccessLogCombined = "combined"
+	// AccessLogJSON は一行ごとの JSON の形式です。
+	AccessLogJSON = "json"
+)
+
+// AccessEntry はアクセスログの一行です。
+type AccessEntry struct {
+	// Time は要求を受け付けた時刻です。
+	Time time.Time
+	// RemoteAddr は接続元のアドレスです。
+	RemoteAddr string
+	// Method は要求のメソッドです。
+	Method string
+	// URI は要求の URI です。
+	URI string
+	// Proto は要求のプロトコルです。
+	Proto string
+	// Status は応答の状態コードです。
+	Status int
+	// Bytes は応答の本文のバイト数です。
+	Bytes int64
+	// Referer は要求の Referer ヘッダです。
+	Referer string
+	// UserAgent は要求の User-Agent ヘッダです。
+	UserAgent string
+	// Host は要求を処理したホストです。
+	Host HostName
+	// Doc は要求されたドキュメント ({グループ}/{ドキュメント}) です。ドキュメントでなければ空文字列です。
+	Doc string
+	// Duration は処理時間です。
+	Duration time.Duration
+	// Redact は DefaultRedactFields に加えて URI と Referer の問い合わせで伏せる項目名です。
+	Redact []string
+}
+
+// AccessLogOptions はアクセスログの出力の指定です。
+type AccessLogOptions struct {
+	// Enabled が偽であれば出力しません。
+	Enabled bool
+	// Format は AccessLogCommon, AccessLogCombined, AccessLogJSON のいずれかです。
+	Format string
+	// MaxSize はログファイルを切り替える大きさ (バイト) です。0 は大きさでは切り替えません。
+	MaxSize int64
+	// Daily が真であれば日付が変わったらログファイルを切り替えます。
+	Daily bool
+	// MaxBackups は残す古いログファイルの数です。0 は数では消しません。
+	MaxBackups int
+	// MaxAge は古いログファイルを残す期間です。0 は期間では消しません。
+	MaxAge time.Duration
+}
+
+// AccessLogger はアクセスログを出力します。
+type AccessLogger interface {
+	// Access はアクセスログを一行出力します。
+	Access(entry *AccessEntry)
+}
+
+// AccessLoggerInst は AccessLogger の実体です。
+type AccessLoggerInst struct {
+	mu sync.Mutex
+	// フォルダ
+	logPath string
+	// 出力の指定
+	opt AccessLogOptions
+	// 出力先、出力しない間は nil
+	out *rotateWriter
+}
+
+// NewAccessLogger は AccessLogger を生成します。
+// ログファイル access.log は Configure で出力を指定してから開きます。
+func NewAccessLogger(logPath string) *AccessLoggerInst {
+	return &AccessLoggerInst{logPath: logPath, opt: AccessLogOptions{Format: AccessLogCombined}}
+}
+
+// Configure はアクセスログの出力の指定を変更します。
+func (l *AccessLoggerInst) Configure(opt AccessLogOptions) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opt = opt
+	if false == opt.Enabled { // nolint:gosimple
+		return nil
+	}
+	if l.out == nil {
+		out, err := openRotateWriter(l.logPath, "access", ".log")
+		if err != nil {
+			l.opt.Enabled = false
+			return err
+		}
+		l.out = out
+	}
+	l.out.configure(opt.MaxSize, opt.Daily, opt.MaxBackups, opt.MaxAge)
+	return nil
+}
+
+// Access はアクセスログを一行出力します。
+func (l *AccessLoggerInst) Access(entry *AccessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if false == l.opt.Enabled || l.out == nil { // nolint:gosimple
+		return
+	}
+	// 通知と WebSocket の古いクライアントは token パラメータでトークンを送る
+	// Referer も同じ URI の問い合わせを含むことがある
+	redacted := *entry
+	redacted.URI = RedactURI(entry.URI, entry.Redact)
+	redacted.Referer = RedactURI(entry.Referer, entry.Redact)
+	entry = &redacted
+	var text string
+	switch l.opt.Format {
+	case AccessLogJSON:
+		text = accessJSON(entry)
+	case AccessLogCommon:
+		text = accessCommon(entry)
+	default:
+		text = fmt.Sprintf("%s %s %s %s %s", accessCommon(entry), accessQuote(entry.Referer), accessQuote(entry.UserAgent),
+			accessQuote(entry.Host), accessQuote(entry.Doc)) + " " + strconv.FormatInt(entry.Duration.Milliseconds(), 10)
+	}
+	if _, err := io.WriteString(l.out, text+"\n"); err != nil {
+		fmt.Fprintf(os.Stderr, "access log error : %v\n", err)
+	}
+}
+
+// Close はログファイルを閉じます。
+func (l *AccessLoggerInst) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out != nil {
+		l.out.close()
+		l.out = nil
+	}
+}
+
+// accessCommon は Common Log Format の一行を返します。
+func accessCommon(e *AccessEntry) string {
+	host := e.RemoteAddr
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		host = "-"
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	request := e.Method + " " + e.URI + " " + e.Proto
+	return fmt.Sprintf("%s - - [%s] %s %d %s", host, e.Time.Format("02/Jan/2006:15:04:05 -0700"), accessQuote(request), e.Status, bytes)
+}
+
+// accessQuote は値を二重引用符で囲みます。空文字列は "-" です。
+func accessQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// accessJSON は JSON の一行を返します。
+func accessJSON(e *AccessEntry) string {
+	obj := json.NewElemObject()
+	obj.Put("time", json.NewElemString(e.Time.Format(time.RFC3339Nano)))
+	obj.Put("remote", json.NewElemString(e.RemoteAddr))
+	obj.Put("method", json.NewElemString(e.Method))
+	obj.Put("uri", json.NewElemString(e.URI))
+	obj.Put("proto", json.NewElemString(e.Proto))
+	obj.Put("status", json.NewElemFloat(float64(e.Status)))
+	obj.Put("bytes", json.NewElemFloat(float64(e.Bytes)))
+	obj.Put("referer", json.NewElemString(e.Referer))
+	obj.Put("useragent", json.NewElemString(e.UserAgent))
+	obj.Put("host", json.NewElemString(e.Host))
+	obj.Put("doc", json.NewElemString(e.Doc))
+	obj.Put("duration", json.NewElemFloat(float64(e.Duration.Microseconds())/1000))
+	return json.ToJSON(obj, false)
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// accessLine は形式 format でアクセスログを一行出力して、その行を返します。
+func accessLine(t *testing.T, format string, entry *AccessEntry) string {
+	t.Helper()
+	dir := t.TempDir()
+	l := NewAccessLogger(dir)
+	defer l.Close()
+	if err := l.Configure(AccessLogOptions{Enabled: true, Format: format}); err != nil {
+		t.Fatal(err)
+	}
+	l.Access(entry)
+	b, err := os.ReadFile(filepath.Join(dir, "access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if false == strings.HasSuffix(string(b), "\n") || strings.Count(string(b), "\n") != 1 { // nolint:gosimple
+		t.Fatalf("log is not one line: %q", b)
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+func TestAccessFormat(t *testing.T) {
+	full := &AccessEntry{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60)),
+		RemoteAddr: "[::1]:5000",
+		Method:     "GET",
+		URI:        "/docs/a?x=1",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      1234,
+		Referer:    "http://localhost/p",
+		UserAgent:  `agent "q" \`,
+		Host:       "localhost",
+		Doc:        "group/doc",
+		Duration:   1500 * time.Millisecond,
+	}
+	empty := &AccessEntry{
+		Time:     full.Time,
+		Method:   "GET",
+		URI:      "/",
+		Proto:    "HTTP/1.1",
+		Status:   304,
+		Duration: 2 * time.Millisecond,
+	}
+	tests := []struct {
+		name   string
+		format string
+		entry  *AccessEntry
+		want   string
+	}{
+		{
+			name:   "common",
+			format: AccessLogCommon,
+			entry:  full,
+			want:   `::1 - - [02/Jan/2024:03:04:05 +0900] "GET /docs/a?x=1 HTTP/1.1" 200 1234`,
+		},
+		{
+			name:   "combined",
+			format: AccessLogCombined,
+			entry:  full,
+			want:   `::1 - - [02/Jan/2024:03:04:05 +0900] "GET /docs/a?x=1 HTTP/1.1" 200 1234 "http://localhost/p" "agent \"q\" \\" "localhost" "group/doc" 1500`,
+		},
+		{
+			name:   "combined empty fields",
+			format: AccessLogCombined,
+			entry:  empty,
+			want:   `- - - [02/Jan/2024:03:04:05 +0900] "GET / HTTP/1.1" 304 - "-" "-" "-" "-" 2`,
+		},
+		{
+			name:   "default is combined",
+			format: "",
+			entry:  empty,
+			want:   `- - - [02/Jan/2024:03:04:05 +0900] "GET / HTTP/1.1" 304 - "-" "-" "-" "-" 2`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accessLine(t, tt.format, tt.entry); got != tt.want {
+				t.Errorf("line = %s\nwant   %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessFormatJSON(t *testing.T) {
+	entry := &AccessEntry{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.UTC),
+		RemoteAddr: "192.0.2.1:5000",
+		Method:     "POST",
+		URI:        "/api/",
+		Proto:      "HTTP/1.1",
+		Status:     429,
+		Bytes:      12,
+		Referer:    "http://localhost/p",
+		UserAgent:  `agent "q"`,
+		Host:       "localhost",
+		Doc:        "group/doc",
+		Duration:   1250 * time.Microsecond,
+	}
+	elem, err := json.LoadFromJSONByte([]byte(accessLine(t, AccessLogJSON, entry)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		t.Fatalf("not an object : %s", json.ToJSON(elem, false))
+	}
+	want := map[string]string{
+		"time":      "2024-01-02T03:04:05.6Z",
+		"remote":    "192.0.2.1:5000",
+		"method":    "POST",
+		"uri":       "/api/",
+		"proto":     "HTTP/1.1",
+		"status":    "429",
+		"bytes":     "12",
+		"referer":   "http://localhost/p",
+		"useragent": `agent "q"`,
+		"host":      "localhost",
+		"doc":       "group/doc",
+		"duration":  "1.25",
+	}
+	if len(obj.Keys()) != len(want) {
+		t.Errorf("keys = %v", obj.Keys())
+	}
+	for key, value := range want {
+		if got := obj.Child(key).Text(); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
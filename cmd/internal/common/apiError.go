@@ -0,0 +1,129 @@
+package common
+
+import (
+	"net/http"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// WebAPI のエラーコードです。
+// 応答は {"error":{"code":"コード", "status":状態コード, "message":"理由", "detail":{...}}} です。
+const (
+	// ErrInvalidRequest は要求が JSON オブジェクトでないことを表します。
+	ErrInvalidRequest = "invalid_request"
+	// ErrInvalidVersion は version が無いか未知であることを表します。
+	ErrInvalidVersion = "invalid_version"
+	// ErrNoAPI は api が指定されていないことを表します。
+	ErrNoAPI = "no_api"
+	// ErrInvalidParameter は api のパラメータが不正であることを表します。
+	ErrInvalidParameter = "invalid_parameter"
+	// ErrUnauthorized はトークンが一致しないことを表します。
+	ErrUnauthorized = "unauthorized"
+	// ErrForbidden は許可されていない方法での要求を表します。
+	ErrForbidden = "forbidden"
+	// ErrUnknownAPI は未知の api を表します。
+	ErrUnknownAPI = "unknown_api"
+	// ErrConflict は期待する改訂番号と一致しなかったことを表します。detail.conflicts に現在の改訂番号があります。
+	ErrConflict = "conflict"
+	// ErrCheckFailed は batch の check が失敗したことを表します。detail.failed に失敗した操作の番号があります。
+	ErrCheckFailed = "check_failed"
+	// ErrLimitExceeded はデータの上限を超えたことを表します。detail に超えた上限があります。
+	ErrLimitExceeded = "limit_exceeded"
+	// ErrBodyTooLarge は要求の本文が上限を超えたことを表します。
+	ErrBodyTooLarge = "body_too_large"
+	// ErrStorage はデータの読み書きに失敗したことを表します。
+	ErrStorage = "storage_error"
+	// ErrKeyUnavailable は暗号化の鍵が無いか一致しないため、データを読み書きできないことを表します。
+	ErrKeyUnavailable = "key_unavailable"
+	// ErrExtension は外部コマンドの API が失敗したことを表します。
+	ErrExtension = "extension_error"
+	// ErrUnavailable はサーバが終了中であることを表します。
+	ErrUnavailable = "unavailable"
+	// ErrInternal はその他のサーバのエラーです。
+	ErrInternal = "internal_error"
+)
+
+// apiErrorInfo はエラーコードの説明です。
+type apiErrorInfo struct {
+	code        string
+	status      int
+	description string
+}
+
+// apiErrorCatalog はエラーコードの一覧です。
+var apiErrorCatalog = []*apiErrorInfo{
+	{ErrInvalidRequest, http.StatusBadRequest, "request is not a json object"},
+	{ErrInvalidVersion, http.StatusBadRequest, "version is missing or unknown"},
+	{ErrNoAPI, http.StatusBadRequest, "api is missing"},
+	{ErrInvalidParameter, http.StatusBadRequest, "parameters of the api are invalid"},
+	{ErrUnauthorized, http.StatusUnauthorized, "token does not match"},
+	{ErrForbidden, http.StatusForbidden, "request method is not allowed"},
+	{ErrUnknownAPI, http.StatusNotFound, "api is unknown"},
+	{ErrConflict, http.StatusConflict, "revision does not match (detail.conflicts)"},
+	{ErrCheckFailed, http.StatusConflict, "batch check failed (detail.failed)"},
+	{ErrLimitExceeded, http.StatusRequestEntityTooLarge, "storage limit exceeded (detail.limit)"},
+	{ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "request body too large"},
+	{ErrStorage, http.StatusInternalServerError, "storage read or write failed"},
+	{ErrKeyUnavailable, http.StatusServiceUnavailable, "encryption key is missing or does not match"},
+	{ErrExtension, http.StatusInternalServerError, "extension command failed or timed out"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "server is shutting down"},
+	{ErrInternal, http.StatusInternalServerError, "internal error"},
+}
+
+// APIError は WebAPI のエラーです。
+type APIError struct {
+	// Code はエラーコードです。
+	Code string
+	// Status は HTTP の状態コードです。
+	Status int
+	// Message は理由です。
+	Message string
+	// Detail は付加情報です。無ければ nil です。
+	Detail json.Element
+}
+
+// NewAPIError はコンストラクタです。状態コードはエラーコードから決めます。
+func NewAPIError(code, message string) *APIError {
+	status := http.StatusInternalServerError
+	for _, info := range apiErrorCatalog {
+		if info.code == code {
+			status = info.status
+			break
+		}
+	}
+	return &APIError{Code: code, Status: status, Message: message}
+}
+
+// Error はエラーの文言です。
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// JSON はエラーの応答です。
+func (e *APIError) JSON() json.ElemObject {
+	body := json.NewElemObject()
+	body.Put("code", json.NewElemString(e.Code))
+	body.Put("status", json.NewElemFloat(float64(e.Status)))
+	body.Put("message", json.NewElemString(e.Message))
+	if e.Detail != nil {
+		body.Put("detail", e.Detail)
+	}
+	ret := json.NewElemObject()
+	ret.Put("error", body)
+	return ret
+}
+
+// APIErrorCatalog はエラーコードの一覧を返します。
+func APIErrorCatalog() json.ElemObject {
+	arr := json.NewElemArray()
+	for _, info := range apiErrorCatalog {
+		obj := json.NewElemObject()
+		obj.Put("code", json.NewElemString(info.code))
+		obj.Put("status", json.NewElemFloat(float64(info.status)))
+		obj.Put("description", json.NewElemString(info.description))
+		arr.Append(obj)
+	}
+	ret := json.NewElemObject()
+	ret.Put("errors", arr)
+	return ret
+}
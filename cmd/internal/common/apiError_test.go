@@ -0,0 +1,28 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewAPIErrorStatus(t *testing.T) {
+	tests := []struct {
+		code string
+		want int
+	}{
+		{code: ErrInvalidParameter, want: http.StatusBadRequest},
+		{code: ErrUnauthorized, want: http.StatusUnauthorized},
+		{code: ErrConflict, want: http.StatusConflict},
+		{code: ErrBodyTooLarge, want: http.StatusRequestEntityTooLarge},
+		{code: ErrStorage, want: http.StatusInternalServerError},
+		// 一時的に処理できないので再試行できる
+		{code: ErrKeyUnavailable, want: http.StatusServiceUnavailable},
+		{code: ErrUnavailable, want: http.StatusServiceUnavailable},
+		{code: "no_such_code", want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := NewAPIError(tt.code, "").Status; got != tt.want {
+			t.Errorf("NewAPIError(%q).Status = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package common
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/xorvercom/util/pkg/json"
@@ -21,12 +24,28 @@ type DocGroupName = string
 // Token はトークンです。
 type Token = string
 
+// VersionSeparator はドキュメント名とバージョン名の区切りです。(eg. /host/group/doc@version/)
+const VersionSeparator = "@"
+
+// LocalHostName は docs フォルダのドキュメントをホストするホスト名です。
+const LocalHostName HostName = "localhost"
+
 // Logger はログ出力を管理します。
 type Logger interface {
+	Debug(msg string)
+	Debugf(format string, arg ...interface{})
 	Info(msg string)
 	Infof(format string, arg ...interface{})
 	Warn(msg string)
 	Warnf(format string, arg ...interface{})
+	Error(msg string)
+	Errorf(format string, arg ...interface{})
+	// SetLevel は出力するログの最低のレベルを変更します。
+	SetLevel(level LogLevel)
+	// Level は出力するログの最低のレベルを返します。
+	Level() LogLevel
+	// Recent はログファイルの末尾の n 行を返します。
+	Recent(n int) []string
 }
 
 // Server はサーバです。
@@ -43,6 +62,16 @@ type Server interface {
 type Config interface {
 	// ログ
 	Logger() Logger
+	// AccessLogger はアクセスログを取得します。
+	AccessLogger() AccessLogger
+	// LogPolicy はホストのログの出力の指定を返します。
+	LogPolicy(host HostName) LogPolicy
+	// Metrics は計測値の公開の指定を返します。
+	Metrics() MetricsConfig
+	// StoreErrors は store のドキュメントのうち、カタログや署名を読めずに公開していないものの理由を返します。
+	StoreErrors() []string
+	// ConfigErrors は設定ファイルの誤りを返します。
+	ConfigErrors() []ConfigError
 	// Close はドキュメントをクローズします。
 	Close()
 	// DocPath はドキュメントの基準フォルダを取得します。
@@ -59,16 +88,60 @@ type Config interface {
 	Version() string
 	// APIPath はAPIのストレージを返します。
 	APIPath(host HostName) string
+	// APIStorage はAPIのストレージの格納方式を返します。
+	APIStorage() string
+	// APILimits はホストのAPIのデータの上限を返します。
+	APILimits(host HostName) APILimits
+	// APIExtension はホストで外部コマンドに割り当てられた API を返します。
+	APIExtension(host HostName, name string) (APIExtension, bool)
+	// APIEncryption はホストのAPIのデータの暗号化の指定を返します。
+	APIEncryption(host HostName) (APIEncryption, bool)
+	// SyncID は同期で使うこのインスタンスの識別子を返します。
+	SyncID() string
+	// SyncSecret は同期の共有の秘密を返します。空文字列であれば同期を受け付けません。
+	SyncSecret() string
+	// SyncPeers は同期の相手の一覧を返します。
+	SyncPeers() []SyncPeer
+	// SyncMaxBody は同期の要求と応答の本文の上限を返します。
+	SyncMaxBody() int64
 	// PortMan はポートマネージャを取得します。
 	PortMan() PortMan
 	// ConfigPath は設定ファイルのフォルダを取得します。
 	ConfigPath() string
 	// トークン管理
 	SecurityMan() SecurityMan
+	// AdminMan は管理画面からの設定の変更を扱います。
+	AdminMan() AdminMan
 	// タイトル管理
 	//HostTitle(name string) HostTitle
 	// ホスト名一覧
 	HostNames() []HostName
+	// PublishHost は配布するホスト名を取得します。
+	PublishHost() HostName
+	// PublishPath は配布物の出力先を取得します。
+	PublishPath() string
+	// DistPath は /dist/ で公開する配布物のフォルダを取得します。公開しない場合は空文字列です。
+	DistPath() string
+	// Rollback はドキュメントの公開するバージョンを切り替えて、切り替えたバージョン名を返します。
+	Rollback(hostname HostName, docid DocID, version string) (string, error)
+}
+
+// ConfigError は設定ファイルの誤りです。
+type ConfigError struct {
+	// File は設定ファイルのパスです。
+	File string
+	// Path は誤りのある JSON のパス (eg. /log/level) です。ファイル全体の誤りは空文字列です。
+	Path string
+	// Message は誤りの内容です。
+	Message string
+}
+
+// Error は誤りの文言を返します。
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s : %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s %s : %s", e.File, e.Path, e.Message)
 }
 
 // PortMan はポートを管理します。ポートはドキュメントグループの名称で管理します。
@@ -87,6 +160,8 @@ type PortMan interface {
 	Put(host HostName, port int) error
 	// Close は全てのリスナをクローズします。
 	Close()
+	// Listening はホストのポートを待ち受けているかを返します。
+	Listening(host HostName) bool
 	// Load はグループで使用するポートをポートロックインファイルから読みだします。
 	Load(portsfile string)
 	// Save はポートグループで使用しているポートをポートロックインファイルに書き出します。
@@ -103,6 +178,53 @@ type SecurityMan interface {
 	LoadPassword(passwordfile string)
 	// UseLocalStorage はドキュメントグループでのCSRFトークンをlocalStorageで行うかを返します。
 	UseLocalStorage(hostName HostName) bool
+	// Password はホストのパスワードを返します。APIのデータの暗号鍵の導出に使います。
+	Password(hostName HostName) (string, bool)
+}
+
+// AdminMan は管理画面からの設定の変更を扱います。
+// 変更は設定ファイルに書き込み、すぐに反映できないものは再読み込みで反映します。
+type AdminMan interface {
+	// Enabled は管理画面を公開するかを返します。管理者のパスワードが無ければ公開しません。
+	Enabled() bool
+	// IsValid は管理者のパスワードをチェックします。
+	IsValid(password string) bool
+	// Doc はドキュメントの設定ファイルの変更できる内容を返します。
+	Doc(host HostName, docid DocID) (DocEdit, error)
+	// UpdateDoc はドキュメントの設定ファイルを変更します。再読み込みが必要であれば真を返します。
+	UpdateDoc(host HostName, docid DocID, edit DocEdit) (bool, error)
+	// AddDoc は docs フォルダにアーカイブを追加して公開します。再読み込みが必要であれば真を返します。
+	// 同じ名前のドキュメントは replace が真の場合だけ置き換えます。title, group は空文字列であれば指定しません。
+	AddDoc(filename string, archive io.Reader, title string, group DocGroupName, replace bool) (DocID, bool, error)
+	// RemoveDoc は docs フォルダのドキュメントの公開をやめて、アーカイブと設定ファイルを削除します。
+	RemoveDoc(docid DocID) error
+	// MaxUpload はアップロードできるアーカイブの大きさの上限 (バイト) です。
+	MaxUpload() int64
+	// SetPassword はホストのパスワードを変更します。password が空文字列であれば削除します。
+	SetPassword(host HostName, password string, localStorage bool) error
+	// LockIns はポートロックインファイルに記録されたポートを返します。
+	LockIns() map[HostName]int
+	// SetLockIn はホストのポートロックインを変更します。port が 0 であれば削除します。
+	SetLockIn(host HostName, port int) error
+	// Reload は設定の再読み込みを要求します。
+	// 新しい設定を読み込めなければ要求せずにエラーを返し、今の設定のまま動き続けます。
+	Reload() error
+	// ReloadRequest は再読み込みの要求を受け取るチャネルです。
+	ReloadRequest() <-chan struct{}
+}
+
+// DocEdit はドキュメントの設定ファイルの変更内容です。
+type DocEdit struct {
+	// Title はタイトルです。
+	Title string
+	// Description は説明です。
+	Description string
+	// DocRoot は初期表示ファイルです。
+	DocRoot string
+	// ContentTypes は拡張子別の Content-Type です。
+	ContentTypes map[string]string
+	// DocGroup はドキュメントグループ名です。
+	DocGroup DocGroupName
 }
 
 // ContentTypeer はファイルの拡張子から Content-Type を取得します。
@@ -138,12 +260,23 @@ type DocData interface {
 	ZipDic() zip.Dictionary
 	// ZipPath はzipファイルのパスを返します。
 	ZipPath() string
+	// ConfPath は設定ファイルのパスを返します。
+	ConfPath() string
 	// FilePaths はドキュメント内のファイルパスの一覧(zip, static)を返します。
 	FilePaths() []string
 	// FileInfo はファイルパスの DocFileInfo を返します。
 	FileInfo(filepath string) (DocFileInfo, error)
 	// Close はドキュメントをクローズします。
 	Close()
+	// Acquire は要求の処理でドキュメントを使い始めます。公開をやめていれば偽を返します。
+	// 真を返した場合は、処理を終えたら Release を呼びます。
+	Acquire() bool
+	// Release は要求の処理でドキュメントを使い終えます。
+	Release()
+	// Retire は公開をやめて、処理中の要求が無くなったらクローズします。
+	Retire()
+	// Released は Retire の後、処理中の要求が無くなってクローズすると閉じるチャネルを返します。
+	Released() <-chan struct{}
 	// SetTitleInfo はタイトル情報を設定します。
 	SetTitleInfo(title, description string)
 	// Title はタイトルを返します。
@@ -159,10 +292,16 @@ type DocGroup interface {
 	Name() DocGroupName
 	// Put は zip ドキュメントを追加します。
 	Put(docid DocID, doc DocData)
-	// Get は zip ドキュメントを取得します。
+	// Get は zip ドキュメントを取得します。"docid@version" の場合は過去のバージョンを取得します。
 	Get(docid DocID) DocData
 	// Ids はホストしている zip ドキュメントの名前の一覧を取得します。
 	Ids() []DocID
+	// Remove は zip ドキュメントを取り除いて返します。無ければ nil です。
+	Remove(docid DocID) DocData
+	// PutVersion は zip ドキュメントのバージョンを追加します。
+	PutVersion(docid DocID, version string, doc DocData)
+	// Versions は zip ドキュメントのバージョン名の一覧を古い順に取得します。
+	Versions(docid DocID) []string
 	// Close はホストしている zip ドキュメントをクローズします。
 	Close()
 	// SetTitleInfo はタイトル情報を設定します。
@@ -184,6 +323,8 @@ type DocHost interface {
 	Put(groupid DocGroupName, group DocGroup)
 	// Get はドキュメントグループを取得します。
 	Get(groupid DocGroupName) DocGroup
+	// Remove はドキュメントグループを取り除きます。
+	Remove(groupid DocGroupName)
 	// Ids はホストしているドキュメントグループの名前の一覧を取得します。
 	Ids() []DocGroupName
 	// Close はホストしている zip ドキュメントをクローズします。
@@ -207,13 +348,121 @@ type DocHost interface {
 type API interface {
 	// Execute は API ロジックを同期実行する
 	Execute(text string) (string, error)
-	// Attach は非同期の情報取得を行います（未実装）
-	// TODO: デタッチできるように再検討
-	Attach() <-chan json.ElemObject
+	// Subscribe はデータの変更通知を購読します。
+	// names が nil であれば全ての名前空間、prefix はキーの接頭辞で絞り込みます。
+	// lastEventID が 0 以外であれば、その続きから通知します。
+	Subscribe(names []string, prefix string, lastEventID int64) APISubscription
+	// Sync は同期の相手からの要求を処理します。
+	Sync(request json.Element) (json.Element, error)
+	// Alive はバックグラウンド処理が動いていれば真を返します。
+	Alive() bool
 	// 強制終了
 	Terminate()
 }
 
+// APILimits は WebAPI のデータの上限です。0 は無制限です。
+type APILimits struct {
+	// HostBytes はホスト全体のキーと値の合計バイト数の上限です。
+	HostBytes int64
+	// HostKeys はホスト全体のキーの数の上限です。
+	HostKeys int64
+	// NamespaceBytes は名前空間ごとのキーと値の合計バイト数の上限です。
+	NamespaceBytes int64
+	// NamespaceKeys は名前空間ごとのキーの数の上限です。
+	NamespaceKeys int64
+	// KeyLength はキーのバイト数の上限です。
+	KeyLength int64
+	// ValueSize は一つの値のバイト数の上限です。
+	ValueSize int64
+	// BodySize は要求の本文のバイト数の上限です。
+	BodySize int64
+}
+
+// APIExtension は外部コマンドで実装する WebAPI です。
+type APIExtension struct {
+	// Command は実行するコマンドです。
+	Command string
+	// Args はコマンドの引数です。
+	Args []string
+	// Dir は作業フォルダの名前です。APIのストレージの _ext フォルダの下に作られます。
+	Dir string
+	// Timeout は実行時間の上限です。
+	Timeout time.Duration
+	// Data が真であれば名前空間の値を全て渡します。
+	Data bool
+}
+
+// BuiltinAPIs は組み込みの WebAPI の名前です。外部コマンドにはこの名前を割り当てられません。
+var BuiltinAPIs = []string{"noop", "list", "dirs", "write", "read", "delete", "query", "backup", "restore", "stats", "batch"}
+
+// IsBuiltinAPI は name が組み込みの WebAPI であれば真を返します。大文字小文字は区別しません。
+func IsBuiltinAPI(name string) bool {
+	for _, builtin := range BuiltinAPIs {
+		if strings.EqualFold(name, builtin) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIEncryption は WebAPI のデータの暗号化の指定です。
+type APIEncryption struct {
+	// KeyFile は鍵ファイルのパスです。空文字列であればホストのパスワードから鍵を導出します。
+	KeyFile string
+	// Invalid は指定の誤りです。空文字列でなければ鍵は使えません。
+	Invalid string
+}
+
+// 同期で競合した場合の扱い
+const (
+	// SyncLastWriterWins は最後に書き込んだ値を残します。
+	SyncLastWriterWins = "lww"
+	// SyncKeepBoth は最後に書き込んだ値を残し、もう一方の値を別のキーに残します。
+	SyncKeepBoth = "keepboth"
+)
+
+// MetricsConfig は /metrics で計測値を公開する指定です。
+type MetricsConfig struct {
+	// Enabled が偽であれば公開しません。
+	Enabled bool
+	// Port が 0 でなければ、待ち受けポートではなくこの管理用のポートで公開します。
+	Port int
+}
+
+// SyncPeer は WebAPI のデータを同期する相手です。
+type SyncPeer struct {
+	// Name は相手の名前です。
+	Name string
+	// URL は相手の待ち受けポートの URL です。
+	URL string
+	// Hosts は同期するホストです。空であれば全てのホストです。
+	Hosts []HostName
+	// Interval は定期的に同期する間隔です。0 であれば手動でのみ同期します。
+	Interval time.Duration
+	// Policy は競合した場合の扱いです。
+	Policy string
+}
+
+// APIEvent は WebAPI のデータの変更通知です。
+type APIEvent struct {
+	// ID は通知の連番です。
+	ID int64
+	// Kind は write / delete / expire と、続きから通知できない場合の reset です。
+	Kind string
+	// Name は名前空間です。
+	Name string
+	// Keys は変更されたキーです。
+	Keys []string
+}
+
+// APISubscription は変更通知の購読です。
+type APISubscription interface {
+	// Events は通知のチャネルです。受け取りが遅れて溢れた場合と終了時には閉じられます。
+	Events() <-chan *APIEvent
+	// Close は購読を終了します。
+	Close()
+}
+
 // DocTitle はドキュメントのタイトル情報
 type DocTitle interface {
 	// タイトル
@@ -2,46 +2,226 @@ package common
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"os"
 	fpath "path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
 )
 
 const (
 	hops = 2
 )
 
+// LogLevel はログの出力レベルです。
+type LogLevel int
+
+// ログの出力レベル
+const (
+	// LogDebug は調査用の詳細なログです。
+	LogDebug LogLevel = iota
+	// LogInfo は通常の動作のログです。
+	LogInfo
+	// LogWarn は要求の失敗など、注意すべきログです。
+	LogWarn
+	// LogError はサーバの動作に関わる異常のログです。
+	LogError
+)
+
+// ログの形式
+const (
+	// LogFormatText は一行の文字列の形式です。
+	LogFormatText = "text"
+	// LogFormatJSON は一行ごとの JSON の形式です。
+	LogFormatJSON = "json"
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String はレベルの名前を返します。
+func (l LogLevel) String() string {
+	if l < LogDebug || LogError < l {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel はレベルの名前からレベルを返します。
+func ParseLogLevel(name string) (LogLevel, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		name = "warn"
+	}
+	for i, n := range logLevelNames {
+		if n == name {
+			return LogLevel(i), true
+		}
+	}
+	return LogInfo, false
+}
+
+// LogOptions はログの出力の指定です。
+type LogOptions struct {
+	// Level は出力するログの最低のレベルです。
+	Level LogLevel
+	// Format は LogFormatText か LogFormatJSON です。
+	Format string
+	// MaxSize はログファイルを切り替える大きさ (バイト) です。0 は大きさでは切り替えません。
+	MaxSize int64
+	// Daily が真であれば日付が変わったらログファイルを切り替えます。
+	Daily bool
+	// MaxBackups は残す古いログファイルの数です。0 は数では消しません。
+	MaxBackups int
+	// MaxAge は古いログファイルを残す期間です。0 は期間では消しません。
+	MaxAge time.Duration
+	// Redact は DefaultRedactFields に加えてログで伏せる項目名です。
+	Redact []string
+}
+
 // LoggerInst は Logger の実体です。
 type LoggerInst struct {
-	log *log.Logger
+	mu sync.Mutex
+	// 出力するログの最低のレベル
+	level LogLevel
+	// 形式
+	format string
+	// 出力先
+	out io.Writer
+	// 伏せる値を見つける正規表現
+	redact *regexp.Regexp
 }
 
 // NewLogger は Loger を生成します。
 func NewLogger(logPath string) *LoggerInst {
-	var file *os.File
-	var err error
-	if file, err = os.OpenFile(fpath.Join(logPath, "ziphttpd.log"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666); err != nil {
+	out, err := openRotateWriter(logPath, "ziphttpd", ".log")
+	if err != nil {
 		panic(err)
 	}
-	return &LoggerInst{log: log.New(file, "", log.Ldate|log.Ltime|log.Llongfile)}
+	return &LoggerInst{level: LogInfo, format: LogFormatText, out: out, redact: newRedactPattern(nil)}
+}
+
+// Configure はログの出力の指定を変更します。
+func (l *LoggerInst) Configure(opt LogOptions) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = opt.Level
+	l.format = opt.Format
+	l.redact = newRedactPattern(opt.Redact)
+	if w, ok := l.out.(*rotateWriter); ok {
+		w.configure(opt.MaxSize, opt.Daily, opt.MaxBackups, opt.MaxAge)
+	}
+}
+
+// SetLevel は出力するログの最低のレベルを変更します。
+func (l *LoggerInst) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Level は出力するログの最低のレベルを返します。
+func (l *LoggerInst) Level() LogLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// Recent はログファイルの末尾の n 行を返します。読めなければ空です。
+func (l *LoggerInst) Recent(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.out.(*rotateWriter)
+	if false == ok { // nolint:gosimple
+		return []string{}
+	}
+	lines, err := w.tail(n)
+	if err != nil {
+		return []string{}
+	}
+	return lines
+}
+
+// Close はログファイルを閉じます。
+func (l *LoggerInst) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w, ok := l.out.(*rotateWriter); ok {
+		w.close()
+	}
+}
+
+// output はレベルが出力対象であれば一行出力します。
+// 呼び出し元は hops 段上の関数として記録します。
+func (l *LoggerInst) output(level LogLevel, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	// パスワードやトークンは出力しない
+	msg = redactText(l.redact, msg)
+	now := time.Now()
+	_, file, line, ok := runtime.Caller(hops)
+	if false == ok { // nolint:gosimple
+		file, line = "???", 0
+	}
+	var text string
+	if l.format == LogFormatJSON {
+		obj := json.NewElemObject()
+		obj.Put("time", json.NewElemString(now.Format(time.RFC3339Nano)))
+		obj.Put("level", json.NewElemString(level.String()))
+		obj.Put("caller", json.NewElemString(fmt.Sprintf("%s:%d", fpath.Base(file), line)))
+		obj.Put("msg", json.NewElemString(msg))
+		text = json.ToJSON(obj, false) + "\n"
+	} else {
+		text = fmt.Sprintf("%s %s:%d: [%s] %s\n", now.Format("2006/01/02 15:04:05"), file, line, strings.ToUpper(level.String()), msg)
+	}
+	if _, err := io.WriteString(l.out, text); err != nil {
+		fmt.Fprintf(os.Stderr, "log error : %v\n", err)
+	}
+}
+
+// Debug は [DEBUG] ログを出力します。
+func (l *LoggerInst) Debug(msg string) {
+	l.output(LogDebug, msg)
+}
+
+// Debugf は [DEBUG] ログを出力します。
+func (l *LoggerInst) Debugf(format string, arg ...interface{}) {
+	l.output(LogDebug, fmt.Sprintf(format, arg...))
 }
 
 // Info は [INFO] ログを出力します。
 func (l *LoggerInst) Info(msg string) {
-	l.log.Output(hops, fmt.Sprintf("[INFO] %s", msg))
+	l.output(LogInfo, msg)
 }
 
 // Infof は [INFO] ログを出力します。
 func (l *LoggerInst) Infof(format string, arg ...interface{}) {
-	l.log.Output(hops, fmt.Sprintf("[INFO] "+format, arg...))
+	l.output(LogInfo, fmt.Sprintf(format, arg...))
 }
 
 // Warn は [WARN] ログを出力します。
 func (l *LoggerInst) Warn(msg string) {
-	l.log.Output(hops, fmt.Sprintf("[WARN] %s", msg))
+	l.output(LogWarn, msg)
 }
 
 // Warnf は [WARN] ログを出力します。
 func (l *LoggerInst) Warnf(format string, arg ...interface{}) {
-	l.log.Output(hops, fmt.Sprintf("[WARN] "+format, arg...))
+	l.output(LogWarn, fmt.Sprintf(format, arg...))
+}
+
+// Error は [ERROR] ログを出力します。
+func (l *LoggerInst) Error(msg string) {
+	l.output(LogError, msg)
+}
+
+// Errorf は [ERROR] ログを出力します。
+func (l *LoggerInst) Errorf(format string, arg ...interface{}) {
+	l.output(LogError, fmt.Sprintf(format, arg...))
 }
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	fpath "path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// tail で読むファイルの末尾の大きさ
+	tailBytes = 256 * 1024
+)
+
+// rotateWriter は大きさや日付でファイルを切り替えるログの出力先です。
+// 切り替えた古いファイルは {name}-{日時}{ext} になり、数と期間で消します。
+type rotateWriter struct {
+	// フォルダ
+	dir string
+	// ファイル名
+	name string
+	// 拡張子
+	ext string
+	// 現在のファイル
+	file *os.File
+	// 現在のファイルの大きさ
+	size int64
+	// 現在のファイルを書き始めた時刻
+	opened time.Time
+	// 切り替える大きさ、0 は大きさでは切り替えない
+	maxSize int64
+	// 日付が変わったら切り替える
+	daily bool
+	// 残すファイルの数、0 は数では消さない
+	maxBackups int
+	// 残す期間、0 は期間では消さない
+	maxAge time.Duration
+}
+
+// openRotateWriter はログファイルを追記で開きます。
+func openRotateWriter(dir, name, ext string) (*rotateWriter, error) {
+	w := &rotateWriter{dir: dir, name: name, ext: ext}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// filename は現在のファイルのパスを返します。
+func (w *rotateWriter) filename() string {
+	return fpath.Join(w.dir, w.name+w.ext)
+}
+
+// open は現在のファイルを開きます。
+func (w *rotateWriter) open() error {
+	file, err := os.OpenFile(w.filename(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.opened = time.Now()
+	if info, err := file.Stat(); err == nil {
+		w.size = info.Size()
+		if w.size > 0 {
+			// 書きかけのファイルは最後に書いた日付のもの
+			w.opened = info.ModTime()
+		}
+	}
+	return nil
+}
+
+// configure は切り替えと削除の指定を変更します。
+func (w *rotateWriter) configure(maxSize int64, daily bool, maxBackups int, maxAge time.Duration) {
+	w.maxSize = maxSize
+	w.daily = daily
+	w.maxBackups = maxBackups
+	w.maxAge = maxAge
+	w.cleanup(time.Now())
+}
+
+// Write は必要であればファイルを切り替えてから書き込みます。
+// 切り替えに失敗しても、書き込みは続けます。
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if w.needRotate(int64(len(p)), now) {
+		if err := w.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotate error : %v\n", err)
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// close は現在のファイルを閉じます。
+func (w *rotateWriter) close() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// tail は現在のファイルの末尾の n 行を返します。読むのは末尾の tailBytes バイトまでです。
+func (w *rotateWriter) tail(n int) ([]string, error) {
+	file, err := os.Open(w.filename())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - tailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		// 途中から読んだ行は除く
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// needRotate は n バイト書く前に切り替えるかを返します。
+func (w *rotateWriter) needRotate(n int64, now time.Time) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+n > w.maxSize {
+		return true
+	}
+	if w.daily {
+		y1, m1, d1 := w.opened.Date()
+		y2, m2, d2 := now.Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+// rotate は現在のファイルを古いファイルにして、新しいファイルを開きます。
+func (w *rotateWriter) rotate(now time.Time) error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	base := fpath.Join(w.dir, w.name+"-"+now.Format("20060102-150405.000"))
+	backup := base + w.ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%d%s", base, i, w.ext)
+	}
+	err := os.Rename(w.filename(), backup)
+	if oerr := w.open(); err == nil {
+		err = oerr
+	}
+	w.cleanup(now)
+	return err
+}
+
+// cleanup は残す数と期間を超えた古いファイルを消します。
+func (w *rotateWriter) cleanup(now time.Time) {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return
+	}
+	names, err := fpath.Glob(fpath.Join(w.dir, w.name+"-*"+w.ext))
+	if err != nil {
+		return
+	}
+	// 最後に書き込んだ時刻の新しい順に並べる
+	type backup struct {
+		name    string
+		modTime time.Time
+	}
+	backups := []backup{}
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			backups = append(backups, backup{name: name, modTime: info.ModTime()})
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].modTime.Equal(backups[j].modTime) {
+			return backups[i].name > backups[j].name
+		}
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+	for i, b := range backups {
+		if (w.maxBackups > 0 && i >= w.maxBackups) || (w.maxAge > 0 && now.Sub(b.modTime) > w.maxAge) {
+			os.Remove(b.name)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package common
 
 import (
+	"bufio"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 )
@@ -23,6 +25,10 @@ type ResponseProxy interface {
 	WriteContentsByte(bytes []byte) (written int, err error)
 	// (*template.Template).Execute(wr io.Writer, data interface{}) error
 	ParseContents(template *template.Template, data interface{}) error
+	// (http.Flusher).Flush()
+	Flush()
+	// (http.Hijacker).Hijack() (net.Conn, *bufio.ReadWriter, error)
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
 }
 
 // RequestProxy はテストのため http.Request をラップします
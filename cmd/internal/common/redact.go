@@ -0,0 +1,159 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// RedactedValue はログで伏せた値の代わりに出力する文字列です。
+const RedactedValue = "***"
+
+// DefaultRedactFields は設定によらずログで伏せる項目名です。
+var DefaultRedactFields = []string{"password", "passwd", "token", "secret", "authorization", "cookie", "signature", "x-requested-with"}
+
+// LogPolicy はホストごとのログの出力の指定です。
+type LogPolicy struct {
+	// Body が偽であれば要求の本文をログに出力しません。
+	Body bool
+	// Redact は DefaultRedactFields に加えてログで伏せる項目名です。
+	Redact []string
+}
+
+// Redacted は値があれば RedactedValue を返します。
+func Redacted(value string) string {
+	if value == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// redactField は name が伏せる項目名であれば真を返します。大文字小文字は区別しません。
+func redactField(name string, fields []string) bool {
+	for _, f := range DefaultRedactFields {
+		if strings.EqualFold(name, f) {
+			return true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactJSON は JSON の伏せる項目の値を RedactedValue にした文字列を返します。
+// 入れ子のオブジェクトや配列の中の項目も伏せます。
+func RedactJSON(elem json.Element, fields []string) string {
+	if elem == nil {
+		return ""
+	}
+	return json.ToJSON(redactElem(elem.Clone(), fields), false)
+}
+
+// redactElem は elem の伏せる項目の値を置き換えます。
+func redactElem(elem json.Element, fields []string) json.Element {
+	if obj, ok := elem.AsObject(); ok {
+		for _, key := range obj.Keys() {
+			if redactField(key, fields) {
+				obj.Put(key, json.NewElemString(RedactedValue))
+			} else {
+				obj.Put(key, redactElem(obj.Child(key), fields))
+			}
+		}
+		return obj
+	}
+	if arr, ok := elem.AsArray(); ok {
+		res := json.NewElemArray()
+		for i := 0; i < arr.Size(); i++ {
+			res.Append(redactElem(arr.Child(i), fields))
+		}
+		return res
+	}
+	return elem
+}
+
+// RedactForm はフォームの伏せる項目の値を RedactedValue にした文字列を返します。
+// JSON の値は RedactJSON で中の項目も伏せます。
+func RedactForm(form url.Values, fields []string) string {
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := make([]string, 0, len(form[key]))
+		for _, value := range form[key] {
+			if redactField(key, fields) {
+				value = RedactedValue
+			} else if elem, err := json.LoadFromJSONByte([]byte(value)); err == nil {
+				value = RedactJSON(elem, fields)
+			}
+			values = append(values, value)
+		}
+		items = append(items, fmt.Sprintf("%s:%v", key, values))
+	}
+	return "map[" + strings.Join(items, " ") + "]"
+}
+
+// RedactURI は URI の問い合わせの伏せる項目の値を RedactedValue にした文字列を返します。
+// 値の無い項目と、問い合わせ以外の部分はそのまま返します。
+func RedactURI(uri string, fields []string) string {
+	i := strings.Index(uri, "?")
+	if i < 0 {
+		return uri
+	}
+	query, fragment := uri[i+1:], ""
+	if j := strings.Index(query, "#"); j >= 0 {
+		query, fragment = query[:j], query[j:]
+	}
+	pairs := strings.Split(query, "&")
+	for n, pair := range pairs {
+		k := strings.Index(pair, "=")
+		if k < 0 {
+			continue
+		}
+		name, err := url.QueryUnescape(pair[:k])
+		if err != nil {
+			name = pair[:k]
+		}
+		if redactField(name, fields) {
+			pairs[n] = pair[:k+1] + RedactedValue
+		}
+	}
+	return uri[:i+1] + strings.Join(pairs, "&") + fragment
+}
+
+// newRedactPattern はログの文字列の中の 名前=値、名前:値、"名前":"値" を見つける正規表現を返します。
+func newRedactPattern(fields []string) *regexp.Regexp {
+	names := []string{}
+	for _, f := range append(append([]string{}, DefaultRedactFields...), fields...) {
+		if f != "" {
+			names = append(names, regexp.QuoteMeta(f))
+		}
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)("?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|\[[^\]]*\]|[^\s&,;}\]]+)`)
+}
+
+// redactText はログの文字列の中の伏せる項目の値を置き換えます。
+func redactText(pattern *regexp.Regexp, text string) string {
+	return pattern.ReplaceAllStringFunc(text, func(match string) string {
+		sub := pattern.FindStringSubmatch(match)
+		value := sub[3]
+		switch {
+		case strings.HasPrefix(value, `"`):
+			value = `"` + RedactedValue + `"`
+		case strings.HasPrefix(value, "["):
+			value = "[" + RedactedValue + "]"
+		default:
+			value = RedactedValue
+		}
+		return sub[1] + sub[2] + value
+	})
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+func TestRedactURI(t *testing.T) {
+	tests := []struct {
+		name   string
+		uri    string
+		fields []string
+		want   string
+	}{
+		{name: "no query", uri: "/api/events", want: "/api/events"},
+		{name: "token", uri: "/api/events?token=abc%2B%3D&name=memo", want: "/api/events?token=***&name=memo"},
+		{name: "case", uri: "/api/ws?Token=abc", want: "/api/ws?Token=***"},
+		{name: "escaped name", uri: "/x?%74oken=abc", want: "/x?%74oken=***"},
+		{name: "no value", uri: "/x?token&a=1", want: "/x?token&a=1"},
+		{name: "fragment", uri: "http://h/x?secret=s#top", want: "http://h/x?secret=***#top"},
+		{name: "configured field", uri: "/x?pin=1234&a=1", fields: []string{"pin"}, want: "/x?pin=***&a=1"},
+		{name: "other fields", uri: "/x?name=token", want: "/x?name=token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactURI(tt.uri, tt.fields); got != tt.want {
+				t.Errorf("RedactURI(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		fields  []string
+		want    []string
+		notWant []string
+	}{
+		{name: "top level", text: `{"password":"p1","name":"memo"}`, want: []string{`"password":"***"`, `"name":"memo"`}, notWant: []string{"p1"}},
+		{name: "nested", text: `{"data":{"Token":"t1","items":[{"secret":"s1"},{"v":"x"}]}}`, want: []string{`"Token":"***"`, `"secret":"***"`, `"v":"x"`}, notWant: []string{"t1", "s1"}},
+		{name: "object value", text: `{"secret":{"a":"s1"}}`, want: []string{`"secret":"***"`}, notWant: []string{"s1"}},
+		{name: "configured field", text: `{"pin":"1234","a":"1"}`, fields: []string{"pin"}, want: []string{`"pin":"***"`, `"a":"1"`}, notWant: []string{"1234"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elem, err := json.LoadFromJSONByte([]byte(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := RedactJSON(elem, tt.fields)
+			for _, w := range tt.want {
+				if false == strings.Contains(got, w) { // nolint:gosimple
+					t.Errorf("RedactJSON(%s) = %s, want %s", tt.text, got, w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(got, w) {
+					t.Errorf("RedactJSON(%s) = %s, leaks %s", tt.text, got, w)
+				}
+			}
+			// 元の値は変えない
+			if strings.Contains(json.ToJSON(elem, false), RedactedValue) {
+				t.Errorf("RedactJSON(%s) changed the element", tt.text)
+			}
+		})
+	}
+	if got := RedactJSON(nil, nil); got != "" {
+		t.Errorf("RedactJSON(nil) = %q", got)
+	}
+}
+
+func TestRedactForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   url.Values
+		fields []string
+		want   string
+	}{
+		{name: "plain", form: url.Values{"b": {"2"}, "a": {"1"}}, want: "map[a:[1] b:[2]]"},
+		{name: "password", form: url.Values{"password": {"p1", "p2"}, "a": {"1"}}, want: "map[a:[1] password:[*** ***]]"},
+		{name: "configured field", form: url.Values{"PIN": {"1234"}}, fields: []string{"pin"}, want: "map[PIN:[***]]"},
+		{name: "json value", form: url.Values{"data": {`{"token":"t1"}`}}, want: `map[data:[{"token":"***"}]]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactForm(tt.form, tt.fields); got != tt.want {
+				t.Errorf("RedactForm(%v) = %q, want %q", tt.form, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		fields []string
+		want   string
+	}{
+		{name: "equal", text: "login password=p1 user=u", want: "login password=*** user=u"},
+		{name: "colon", text: "map[token:[t1] a:[1]]", want: "map[token:[***] a:[1]]"},
+		{name: "json", text: `{"secret":"s \"1\"","a":"1"}`, want: `{"secret":"***","a":"1"}`},
+		{name: "query", text: "GET /x?a=1&Token=t1&b=2", want: "GET /x?a=1&Token=***&b=2"},
+		{name: "configured field", text: "pin=1234 a=1", fields: []string{"pin"}, want: "pin=*** a=1"},
+		{name: "word boundary", text: "tokens=1 mytoken=2", want: "tokens=1 mytoken=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactText(newRedactPattern(tt.fields), tt.text); got != tt.want {
+				t.Errorf("redactText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessRedact(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{AccessLogCommon, AccessLogCombined, AccessLogJSON} {
+		t.Run(format, func(t *testing.T) {
+			l := NewAccessLogger(filepath.Join(dir, format))
+			if err := os.MkdirAll(filepath.Join(dir, format), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Configure(AccessLogOptions{Enabled: true, Format: format}); err != nil {
+				t.Fatal(err)
+			}
+			entry := &AccessEntry{
+				Time: time.Now(), RemoteAddr: "127.0.0.1:1234", Method: "GET", Proto: "HTTP/1.1", Status: 200,
+				URI:     "/api/events?token=t1&pin=9876&a=1",
+				Referer: "http://localhost/doc/?secret=s1",
+				Redact:  []string{"pin"},
+			}
+			l.Access(entry)
+			l.Close()
+			if entry.URI != "/api/events?token=t1&pin=9876&a=1" {
+				t.Errorf("Access changed the entry: %s", entry.URI)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, format, "access.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := string(b)
+			for _, leak := range []string{"t1", "9876", "s1"} {
+				if strings.Contains(text, leak) {
+					t.Errorf("access log leaks %s: %s", leak, text)
+				}
+			}
+			if false == strings.Contains(text, "a=1") { // nolint:gosimple
+				t.Errorf("access log lost the query: %s", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SyncTimeHeader は同期の要求と応答に署名した時刻 (UNIX 秒) のヘッダです。
+	SyncTimeHeader = "X-ZipHttpd-Sync-Time"
+	// SyncNonceHeader は同期の要求ごとの使い捨ての値のヘッダです。応答はこの値で署名します。
+	SyncNonceHeader = "X-ZipHttpd-Sync-Nonce"
+	// SyncSignatureHeader は同期の要求と応答の署名のヘッダです。
+	SyncSignatureHeader = "X-ZipHttpd-Sync-Signature"
+	// SyncClockSkew は署名の時刻として認めるずれです。
+	SyncClockSkew = 5 * time.Minute
+	// SyncNonceMin と SyncNonceMax は使い捨ての値として認める長さです。
+	SyncNonceMin = 16
+	SyncNonceMax = 64
+)
+
+// SyncSign は同期の要求と応答の署名を返します。
+// 署名は共有の秘密を鍵とした、時刻、使い捨ての値、ホスト名、本文の HMAC-SHA256 です。
+func SyncSign(secret, timestamp, nonce string, host HostName, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + host + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SyncVerify は同期の要求と応答の署名を検証します。
+// 時刻が now から SyncClockSkew 以上ずれているか、使い捨ての値の長さが範囲外であれば偽を返します。
+func SyncVerify(secret, timestamp, nonce, signature string, host HostName, body []byte, now time.Time) bool {
+	if secret == "" || len(nonce) < SyncNonceMin || SyncNonceMax < len(nonce) {
+		return false
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(sec, 0))
+	if skew < -SyncClockSkew || SyncClockSkew < skew {
+		return false
+	}
+	return hmac.Equal([]byte(SyncSign(secret, timestamp, nonce, host, body)), []byte(signature))
+}
+
+// SyncNonces は受け付けた同期の要求の使い捨ての値を覚えて、同じ要求の再送を拒みます。
+// 時刻のずれの範囲を過ぎた要求は署名の検証で拒むので、それより古い値は忘れます。
+type SyncNonces struct {
+	mu sync.Mutex
+	// 使い捨ての値 -> 受け付けた時刻
+	seen map[string]time.Time
+}
+
+// NewSyncNonces はコンストラクタです。
+func NewSyncNonces() *SyncNonces {
+	return &SyncNonces{seen: map[string]time.Time{}}
+}
+
+// Use は nonce が初めてであれば記録して真を返します。既に使われていれば偽を返します。
+func (n *SyncNonces) Use(nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for value, at := range n.seen {
+		if now.Sub(at) > 2*SyncClockSkew {
+			delete(n.seen, value)
+		}
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = now
+	return true
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSyncVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	nonce := "0123456789abcdef0123456789abcdef"
+	body := []byte(`{"phase":"state"}`)
+	sig := SyncSign("secret", ts, nonce, "memo", body)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		nonce     string
+		signature string
+		host      string
+		body      []byte
+		now       time.Time
+		want      bool
+	}{
+		{name: "valid", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: body, now: now, want: true},
+		{name: "skew within", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: body, now: now.Add(SyncClockSkew), want: true},
+		{name: "too old", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: body, now: now.Add(SyncClockSkew + time.Second)},
+		{name: "future", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: body, now: now.Add(-SyncClockSkew - time.Second)},
+		{name: "wrong secret", secret: "other", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: body, now: now},
+		{name: "no secret", secret: "", timestamp: ts, nonce: nonce, signature: SyncSign("", ts, nonce, "memo", body), host: "memo", body: body, now: now},
+		{name: "other host", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "other", body: body, now: now},
+		{name: "tampered body", secret: "secret", timestamp: ts, nonce: nonce, signature: sig, host: "memo", body: []byte(`{"phase":"apply"}`), now: now},
+		{name: "other nonce", secret: "secret", timestamp: ts, nonce: "fedcba9876543210fedcba9876543210", signature: sig, host: "memo", body: body, now: now},
+		{name: "short nonce", secret: "secret", timestamp: ts, nonce: "short", signature: SyncSign("secret", ts, "short", "memo", body), host: "memo", body: body, now: now},
+		{name: "changed time", secret: "secret", timestamp: strconv.FormatInt(now.Unix()+1, 10), nonce: nonce, signature: sig, host: "memo", body: body, now: now},
+		{name: "bad time", secret: "secret", timestamp: "now", nonce: nonce, signature: sig, host: "memo", body: body, now: now},
+		{name: "no signature", secret: "secret", timestamp: ts, nonce: nonce, signature: "", host: "memo", body: body, now: now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SyncVerify(tt.secret, tt.timestamp, tt.nonce, tt.signature, tt.host, tt.body, tt.now); got != tt.want {
+				t.Errorf("SyncVerify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncNonces(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	n := NewSyncNonces()
+	steps := []struct {
+		nonce string
+		at    time.Time
+		want  bool
+	}{
+		{nonce: "a", at: now, want: true},
+		{nonce: "b", at: now, want: true},
+		// 再送
+		{nonce: "a", at: now.Add(time.Minute)},
+		{nonce: "a", at: now.Add(2 * SyncClockSkew)},
+		// 時刻のずれの範囲を過ぎた値は署名で拒むので忘れてよい
+		{nonce: "a", at: now.Add(2*SyncClockSkew + time.Second), want: true},
+	}
+	for i, s := range steps {
+		if got := n.Use(s.nonce, s.at); got != s.want {
+			t.Errorf("step %d: Use(%q) = %v, want %v", i, s.nonce, got, s.want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"os"
 	fpath "path/filepath"
 )
@@ -23,3 +26,40 @@ func BaseName(filename string) string {
 	basename := fpath.Base(filename[:len(filename)-len(fpath.Ext(filename))])
 	return basename
 }
+
+// HashFile はファイルの SHA-256 を返します。
+func HashFile(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// CopyFile はファイルを複写して SHA-256 とサイズを返します。
+func CopyFile(srcName, dstName string) ([]byte, int64, error) {
+	src, err := os.Open(srcName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstName)
+	if err != nil {
+		return nil, 0, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		dst.Close()
+		return nil, 0, fmt.Errorf("error copy %s : %v", srcName, err)
+	}
+	if err := dst.Close(); err != nil {
+		return nil, 0, fmt.Errorf("error close %s : %v", dstName, err)
+	}
+	return h.Sum(nil), size, nil
+}
@@ -23,6 +23,14 @@ type ZipHttpdUtil interface {
 	SetFirstDocPort(port int)
 	// FirstDocPort はドキュメントグループのポートの開始番号を取得します。
 	FirstDocPort() int
+	// SetLogLevel は設定ファイルより優先するログの出力レベルを設定します。
+	SetLogLevel(level string)
+	// LogLevel は設定ファイルより優先するログの出力レベルを取得します。空文字列は設定ファイルに従います。
+	LogLevel() string
+	// SetStrict は設定ファイルに誤りがあれば起動しないようにします。
+	SetStrict(strict bool)
+	// Strict は設定ファイルに誤りがあれば起動しないかを取得します。
+	Strict() bool
 	// DefaultConfig は標準の設定ファイルの内容を取得します。
 	DefaultConfig() string
 }
@@ -31,9 +39,15 @@ type util struct {
 	logPath      string
 	listenPort   int
 	firstDocPort int
+	logLevel     string
+	strict       bool
 }
 
 const (
+	// PidFile は起動中のサーバのプロセス ID を書き出すファイルです。設定ファイルの置き場に置きます。
+	PidFile = "ziphttpd.pid"
+	// ConfigFile はサーバの設定ファイルです。設定ファイルの置き場に置きます。
+	ConfigFile = "ziphttpd.json"
 	// DefaultListenPort はデフォルトのポート番号
 	DefaultListenPort = 8823
 	// DefaultFirstDocPort はグループの先頭ポート番号
@@ -98,6 +112,26 @@ func (u *util) FirstDocPort() int {
 	return DefaultFirstDocPort
 }
 
+// SetLogLevel は設定ファイルより優先するログの出力レベルを設定します。
+func (u *util) SetLogLevel(level string) {
+	u.logLevel = level
+}
+
+// LogLevel は設定ファイルより優先するログの出力レベルを取得します。
+func (u *util) LogLevel() string {
+	return u.logLevel
+}
+
+// SetStrict は設定ファイルに誤りがあれば起動しないようにします。
+func (u *util) SetStrict(strict bool) {
+	u.strict = strict
+}
+
+// Strict は設定ファイルに誤りがあれば起動しないかを取得します。
+func (u *util) Strict() bool {
+	return u.strict
+}
+
 // DefaultConfig は標準の設定ファイルの内容を取得します。
 func (u *util) DefaultConfig() string {
 	// TODO: 標準の設定ファイルは Config から生成するように検討する。
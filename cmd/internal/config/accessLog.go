@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// アクセスログ
+	//	"accesslog": {
+	//	  "enabled": true,
+	//	  "format": "combined",
+	//	  "maxsize": 10,
+	//	  "daily": true,
+	//	  "maxbackups": 30,
+	//	  "maxage": 90
+	//	}
+	// ログのフォルダの access.log に出力します。
+	// format は common / combined / json、maxsize はログファイルを切り替える大きさ (MB)、maxage は古いログファイルを残す日数です。
+	docpathAccessLog = json.PathJSON("accesslog")
+)
+
+// setupAccessLog はアクセスログの指定を読みだします。
+// 指定が無ければ combined 形式で出力します。サーバを起動しないサブコマンドでは出力しません。
+func (c *conf) setupAccessLog() {
+	opt := common.AccessLogOptions{Enabled: false == c.offline, Format: common.AccessLogCombined} // nolint:gosimple
+	if elem, ok := json.QueryElemObject(c.element, docpathAccessLog); ok {
+		if enabled, ok := json.QueryElemBool(elem, "enabled"); ok {
+			opt.Enabled = enabled.Bool() && false == c.offline // nolint:gosimple
+		}
+		if format, ok := json.QueryElemString(elem, "format"); ok {
+			switch strings.ToLower(format.Text()) {
+			case common.AccessLogCommon, common.AccessLogCombined, common.AccessLogJSON:
+				opt.Format = strings.ToLower(format.Text())
+			default:
+				c.log.Warnf("accesslog : unknown format %s", format.Text())
+			}
+		}
+		if size, ok := json.QueryElemFloat(elem, "maxsize"); ok && size.Float() > 0 {
+			opt.MaxSize = int64(size.Float() * 1024 * 1024)
+		}
+		if daily, ok := json.QueryElemBool(elem, "daily"); ok {
+			opt.Daily = daily.Bool()
+		}
+		if backups, ok := json.QueryElemFloat(elem, "maxbackups"); ok && backups.Float() > 0 {
+			opt.MaxBackups = int(backups.Float())
+		}
+		if age, ok := json.QueryElemFloat(elem, "maxage"); ok && age.Float() > 0 {
+			opt.MaxAge = time.Duration(age.Float() * float64(24*time.Hour))
+		}
+	}
+	if err := c.accessLog.Configure(opt); err != nil {
+		c.log.Errorf("accesslog : %v", err)
+	}
+}
@@ -0,0 +1,576 @@
+package config
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/util/pkg/zip"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/model"
+)
+
+const (
+	// 管理画面
+	//	"admin": { "password": "管理者のパスワード", "maxupload": 256 }
+	// パスワードが無ければ管理画面を公開しません。maxupload はアップロードできるアーカイブの上限 (MB) です。
+	docpathAdminPassword = json.PathJSON("admin/password")
+	// アップロードできるアーカイブの上限 (MB)
+	docpathAdminMaxUpload = json.PathJSON("admin/maxupload")
+	// アップロードできるアーカイブの既定の上限
+	defaultAdminMaxUpload = 256 * 1024 * 1024
+	// 置き換えや削除の前に処理中の要求の終わりを待つ時間
+	adminReleaseTimeout = 30 * time.Second
+)
+
+// ドキュメントの設定ファイルのうち管理画面で変更する項目
+const (
+	docKeyTitle       = "title"
+	docKeyDescription = "description"
+	docKeyDocRoot     = "docroot"
+	docKeyContentType = "contenttype"
+	docKeyDocGroup    = "docgroup"
+)
+
+// adminMan は管理画面からの設定の変更を扱います。
+type adminMan struct {
+	mu   sync.Mutex
+	conf *conf
+	// 管理者のパスワード
+	password string
+	// アップロードできるアーカイブの上限
+	maxUpload int64
+	// 再読み込みの要求
+	reload chan struct{}
+}
+
+// newAdminMan はコンストラクタです。
+func newAdminMan(c *conf) *adminMan {
+	return &adminMan{conf: c, maxUpload: defaultAdminMaxUpload, reload: make(chan struct{}, 1)}
+}
+
+// setupAdmin は管理画面の指定を読みだします。
+func (c *conf) setupAdmin() {
+	if elem, ok := json.QueryElemString(c.element, docpathAdminPassword); ok {
+		c.adminMan.password = elem.Text()
+	}
+	if elem, ok := json.QueryElemFloat(c.element, docpathAdminMaxUpload); ok {
+		if size := int64(elem.Float() * 1024 * 1024); size > 0 {
+			c.adminMan.maxUpload = size
+		} else {
+			c.log.Warnf("admin : invalid maxupload %v", elem.Float())
+		}
+	}
+}
+
+// AdminMan は管理画面からの設定の変更を扱います。
+func (c *conf) AdminMan() common.AdminMan {
+	return c.adminMan
+}
+
+// Enabled は管理画面を公開するかを返します。
+func (a *adminMan) Enabled() bool {
+	return a.password != ""
+}
+
+// IsValid は管理者のパスワードをチェックします。
+func (a *adminMan) IsValid(password string) bool {
+	if false == a.Enabled() { // nolint:gosimple
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a.password), []byte(password)) == 1
+}
+
+// findDoc はホストのドキュメントを探します。
+func (a *adminMan) findDoc(host common.HostName, docid common.DocID) (common.DocData, error) {
+	docHost := a.conf.DocHost(host)
+	if docHost == nil {
+		return nil, fmt.Errorf("unknown host %s", host)
+	}
+	for _, groupName := range docHost.Ids() {
+		if doc := docHost.Get(groupName).Get(docid); doc != nil {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown document %s/%s", host, docid)
+}
+
+// Doc はドキュメントの設定ファイルの変更できる内容を返します。
+// タイトルと説明は設定ファイルに無ければ表示中のもの、グループは所属しているものです。
+func (a *adminMan) Doc(host common.HostName, docid common.DocID) (common.DocEdit, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	doc, err := a.findDoc(host, docid)
+	if err != nil {
+		return common.DocEdit{}, err
+	}
+	obj, err := loadObject(doc.ConfPath())
+	if err != nil {
+		return common.DocEdit{}, err
+	}
+	edit := common.DocEdit{
+		Title:        doc.Title(),
+		Description:  doc.Description(),
+		DocRoot:      childText(obj, docKeyDocRoot),
+		ContentTypes: map[string]string{},
+		DocGroup:     doc.DocGroupName(),
+	}
+	if title := childText(obj, docKeyTitle); title != "" {
+		edit.Title = title
+	}
+	if description := childText(obj, docKeyDescription); description != "" {
+		edit.Description = description
+	}
+	if types, ok := json.QueryElemObject(obj, docKeyContentType); ok {
+		for _, ext := range types.Keys() {
+			edit.ContentTypes[ext] = types.Child(ext).Text()
+		}
+	}
+	return edit, nil
+}
+
+// UpdateDoc はドキュメントの設定ファイルを変更します。
+// タイトルと説明はすぐに反映し、それ以外を変更した場合は再読み込みが必要なので真を返します。
+// store のドキュメントのグループはカタログで決まるので変更できません。
+func (a *adminMan) UpdateDoc(host common.HostName, docid common.DocID, edit common.DocEdit) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	doc, err := a.findDoc(host, docid)
+	if err != nil {
+		return false, err
+	}
+	edit.DocGroup = strings.TrimSpace(edit.DocGroup)
+	if edit.DocGroup == "" || strings.ContainsAny(edit.DocGroup, "/\\?#%") {
+		return false, fmt.Errorf("invalid group name %q", edit.DocGroup)
+	}
+	if host != localHost && edit.DocGroup != doc.DocGroupName() {
+		return false, fmt.Errorf("the group of %s/%s is given by the catalog", host, docid)
+	}
+	obj, err := loadObject(doc.ConfPath())
+	if err != nil {
+		return false, err
+	}
+	before := map[string]string{}
+	if types, ok := json.QueryElemObject(obj, docKeyContentType); ok {
+		for _, ext := range types.Keys() {
+			before[ext] = types.Child(ext).Text()
+		}
+	}
+	reload := childText(obj, docKeyDocRoot) != edit.DocRoot || edit.DocGroup != doc.DocGroupName() || len(before) != len(edit.ContentTypes)
+	for ext, typ := range edit.ContentTypes {
+		reload = reload || before[ext] != typ
+	}
+
+	// 変更する項目以外はそのまま残す
+	after := json.NewElemObject()
+	for _, key := range obj.Keys() {
+		switch key {
+		case docKeyTitle, docKeyDescription, docKeyDocRoot, docKeyContentType, docKeyDocGroup:
+			continue
+		}
+		after.Put(key, obj.Child(key))
+	}
+	putText := func(key, value string) {
+		if value != "" {
+			after.Put(key, json.NewElemString(value))
+		}
+	}
+	putText(docKeyTitle, edit.Title)
+	putText(docKeyDescription, edit.Description)
+	putText(docKeyDocRoot, edit.DocRoot)
+	if len(edit.ContentTypes) > 0 {
+		types := json.NewElemObject()
+		for ext, typ := range edit.ContentTypes {
+			types.Put(ext, json.NewElemString(typ))
+		}
+		after.Put(docKeyContentType, types)
+	}
+	if host == localHost {
+		putText(docKeyDocGroup, edit.DocGroup)
+	} else if group, ok := json.QueryElemString(obj, docKeyDocGroup); ok {
+		after.Put(docKeyDocGroup, group)
+	}
+	if err := json.SaveToJSONFile(doc.ConfPath(), after, true); err != nil {
+		return false, fmt.Errorf("error write %s : %v", doc.ConfPath(), err)
+	}
+	doc.SetTitleInfo(edit.Title, edit.Description)
+	a.conf.log.Infof("admin: update document %s/%s", host, docid)
+	return reload, nil
+}
+
+// MaxUpload はアップロードできるアーカイブの大きさの上限 (バイト) です。
+func (a *adminMan) MaxUpload() int64 {
+	return a.maxUpload
+}
+
+// findLocalDoc は docs フォルダのドキュメントとそのグループを探します。無ければ nil です。
+func (a *adminMan) findLocalDoc(docid common.DocID) (common.DocGroup, common.DocData) {
+	docHost := a.conf.DocHost(localHost)
+	if docHost == nil {
+		return nil, nil
+	}
+	for _, groupName := range docHost.Ids() {
+		if docGroup := docHost.Get(groupName); docGroup != nil {
+			if doc := docGroup.Get(docid); doc != nil {
+				return docGroup, doc
+			}
+		}
+	}
+	return nil, nil
+}
+
+// unpublish はドキュメントをグループから取り除きます。空になったグループはホストから取り除きます。
+func (a *adminMan) unpublish(docGroup common.DocGroup, docid common.DocID) {
+	docGroup.Remove(docid)
+	if len(docGroup.Ids()) == 0 {
+		if docHost := a.conf.DocHost(localHost); docHost != nil {
+			docHost.Remove(docGroup.Name())
+		}
+	}
+}
+
+// AddDoc は docs フォルダにアーカイブを追加して公開します。
+// アーカイブは一時ファイルに書いて zip として開けることを確かめてから置き換え、設定ファイルを作り直します。
+// 置き換える場合は、管理画面で変更できる項目は以前の設定ファイルから引き継ぎます。
+// 古いドキュメントは公開をやめて、処理中の要求が終わってクローズしてからファイルを置き換えます。
+// docs フォルダのドキュメントが一つも無かった場合は、待ち受けるために再読み込みが必要です。
+func (a *adminMan) AddDoc(filename string, archive io.Reader, title string, group common.DocGroupName, replace bool) (common.DocID, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.conf
+
+	// ブラウザによってはフォルダ付きのファイル名が来る
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	ext := strings.ToLower(fpath.Ext(name))
+	if strings.HasPrefix(name, ".") || (ext != ".zip" && ext != ".jar" && ext != ".zhd") {
+		return "", false, fmt.Errorf("invalid archive name %q", filename)
+	}
+	group = strings.TrimSpace(group)
+	if strings.ContainsAny(group, "/\\?#%") {
+		return "", false, fmt.Errorf("invalid group name %q", group)
+	}
+	basename := common.BaseName(name)
+	target := fpath.Join(c.docPath, name)
+	confPath := fpath.Join(c.docPath, basename+extConf)
+	exists := common.FileExists(target)
+	if exists && false == replace { // nolint:gosimple
+		return "", false, fmt.Errorf("%s already exists", name)
+	}
+
+	// 一時ファイルに書いて zip として開けるか確かめる
+	tmp, err := os.CreateTemp(c.docPath, "."+basename+"-*.upload")
+	if err != nil {
+		return "", false, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	_, err = io.Copy(tmp, archive)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error write %s : %v", tmpName, err)
+	}
+	dic, err := zip.OpenDictionary(tmpName, false)
+	if err != nil {
+		return "", false, fmt.Errorf("%s is not an archive : %v", name, err)
+	}
+	dic.Close()
+
+	// 設定ファイルの内容
+	defelem, err := model.NewDocConfig(c, tmpName, basename)
+	if err != nil {
+		return "", false, fmt.Errorf("%s is not an archive : %v", name, err)
+	}
+	obj, ok := defelem.AsObject()
+	if false == ok { // nolint:gosimple
+		return "", false, fmt.Errorf("%s : invalid document config", name)
+	}
+	rel, err := fpath.Rel(c.configPath, target)
+	if err != nil {
+		rel = target
+	}
+	obj.Put("path", json.NewElemString(rel))
+	if exists {
+		if oldObj, err := loadObject(confPath); err == nil {
+			for _, key := range oldObj.Keys() {
+				switch key {
+				case docKeyTitle, docKeyDescription, docKeyDocRoot, docKeyContentType, docKeyDocGroup:
+					obj.Put(key, oldObj.Child(key))
+				}
+			}
+		}
+	}
+	if title != "" {
+		obj.Put(docKeyTitle, json.NewElemString(title))
+	}
+	if group != "" {
+		obj.Put(docKeyDocGroup, json.NewElemString(group))
+	}
+	docid := strings.ToLower(childText(obj, "name"))
+	if docid == "" {
+		docid = strings.ToLower(basename)
+	}
+	oldGroup, old := a.findLocalDoc(docid)
+	if old != nil && fpath.Clean(old.ZipPath()) != fpath.Clean(target) {
+		return "", false, fmt.Errorf("document %s is already published from %s", docid, old.ZipPath())
+	}
+
+	// 公開するドキュメントを先に作り、作れなければ古いドキュメントの公開を続ける
+	var docdata common.DocData
+	if c.DocHost(localHost) != nil {
+		if docdata, err = newLocalDoc(c, confPath, obj); err != nil {
+			return "", false, fmt.Errorf("%s : invalid document config : %v", name, err)
+		}
+	}
+
+	// 開いたままのアーカイブを置き換えないよう、公開をやめて処理中の要求が終わるのを待つ
+	if old != nil {
+		a.unpublish(oldGroup, docid)
+		old.Retire()
+		a.waitReleased(old)
+	}
+
+	// 置き換え
+	if err := os.Rename(tmpName, target); err != nil {
+		if old != nil {
+			// 置き換えられなかったので、古いドキュメントを開き直して公開する
+			if reopened, rerr := model.OpenDocConfig(c, old.ConfPath(), localHost, "", ""); rerr == nil {
+				a.publish(reopened, old.ConfPath(), basename)
+			} else {
+				c.log.Warnf("admin: reopen %s : %v", docid, rerr)
+			}
+		}
+		return "", false, fmt.Errorf("error rename %s : %v", target, err)
+	}
+	if err := json.SaveToJSONFile(confPath, obj, true); err != nil {
+		// アーカイブは置き換えたので、設定ファイルを書けなくても新しいドキュメントを公開する
+		if docdata != nil {
+			a.publish(docdata, confPath, basename)
+		}
+		return "", false, fmt.Errorf("error write %s : %v", confPath, err)
+	}
+	c.log.Infof("admin: upload %s as %s", name, docid)
+
+	// 公開
+	if docdata == nil {
+		return docid, true, nil
+	}
+	a.publish(docdata, confPath, basename)
+	return docid, false, nil
+}
+
+// newLocalDoc は設定ファイル confPath に書き出す内容 obj から docs フォルダのドキュメントを作ります。
+// ドキュメントはホスト名などを書き加えるので、obj の複製から作ります。
+func newLocalDoc(c *conf, confPath string, obj json.ElemObject) (common.DocData, error) {
+	elem, err := json.LoadFromJSONByte([]byte(json.ToJSON(obj, false)))
+	if err != nil {
+		return nil, err
+	}
+	return model.OpenDocElement(c, confPath, elem, localHost, "", ""), nil
+}
+
+// publish はドキュメントをグループに加えて公開します。グループが無ければ作ります。
+func (a *adminMan) publish(docdata common.DocData, confPath, basename string) {
+	c := a.conf
+	docHost := c.DocHost(localHost)
+	if docHost == nil {
+		return
+	}
+	groupName := docdata.DocGroupName()
+	docGroup := docHost.Get(groupName)
+	if docGroup == nil {
+		docGroup = model.NewDocGroup(localHost, groupName)
+		docGroup.SetTitleInfo(strings.ToUpper(groupName), "localhost document")
+		docHost.Put(groupName, docGroup)
+	}
+	docTitle, description := model.DocTitleInfo(confPath)
+	if docTitle == "" {
+		docTitle = basename
+	}
+	docdata.SetTitleInfo(docTitle, description)
+	if docdata.UseStaticFiles() {
+		os.MkdirAll(fpath.Join(c.configPath, "static", localHost, groupName, docdata.DocID()), 0755)
+	}
+	docGroup.Put(docdata.DocID(), docdata)
+}
+
+// waitReleased は公開をやめたドキュメントの処理中の要求が終わってクローズするのを待ちます。
+// 時間切れの場合は警告を出力して戻ります。その後のファイルの操作は OS によっては失敗します。
+func (a *adminMan) waitReleased(doc common.DocData) {
+	select {
+	case <-doc.Released():
+	case <-time.After(adminReleaseTimeout):
+		a.conf.log.Warnf("admin: %s is still in use after %v", doc.DocID(), adminReleaseTimeout)
+	}
+}
+
+// RemoveDoc は docs フォルダのドキュメントの公開をやめて、アーカイブと設定ファイルを削除します。
+// 処理中の要求が終わってアーカイブをクローズしてから削除します。docs フォルダの外のアーカイブは削除しません。
+func (a *adminMan) RemoveDoc(docid common.DocID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	docGroup, doc := a.findLocalDoc(docid)
+	if doc == nil {
+		return fmt.Errorf("unknown document %s/%s", localHost, docid)
+	}
+	a.unpublish(docGroup, docid)
+	doc.Retire()
+	a.waitReleased(doc)
+	a.conf.log.Infof("admin: remove %s", docid)
+
+	files := []string{doc.ConfPath()}
+	if fpath.Dir(fpath.Clean(doc.ZipPath())) == fpath.Clean(a.conf.docPath) {
+		files = append(files, doc.ZipPath())
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && false == os.IsNotExist(err) { // nolint:gosimple
+			return fmt.Errorf("%s is unpublished, but %v", docid, err)
+		}
+	}
+	return nil
+}
+
+// SetPassword はホストのパスワードを変更します。password が空文字列であれば削除します。
+func (a *adminMan) SetPassword(host common.HostName, password string, localStorage bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conf.DocHost(host) == nil {
+		return fmt.Errorf("unknown host %s", host)
+	}
+	if enc, ok := a.conf.APIEncryption(host); ok && enc.KeyFile == "" {
+		// 鍵をパスワードから導出しているので、変えると保存済みのデータが読めなくなる
+		return fmt.Errorf("api data of %s is encrypted with the password; stop the server and use rekey", host)
+	}
+	passwordfile := fpath.Join(a.conf.configPath, passwordConf)
+	obj, err := loadObject(passwordfile)
+	if err != nil {
+		obj = json.NewElemObject()
+	}
+	after := json.NewElemObject()
+	for _, key := range obj.Keys() {
+		if key != host {
+			after.Put(key, obj.Child(key))
+		}
+	}
+	if password != "" {
+		entry := json.NewElemObject()
+		entry.Put("password", json.NewElemString(password))
+		entry.Put("localstorage", json.NewElemBool(localStorage))
+		after.Put(host, entry)
+	}
+	if err := json.SaveToJSONFile(passwordfile, after, true); err != nil {
+		return fmt.Errorf("error write %s : %v", passwordfile, err)
+	}
+	a.conf.securityMan.LoadPassword(passwordfile)
+	a.conf.log.Infof("admin: change password of %s", host)
+	return nil
+}
+
+// LockIns はポートロックインファイルに記録されたポートを返します。
+func (a *adminMan) LockIns() map[common.HostName]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lockIns()
+}
+
+// lockIns はポートロックインファイルを読みます。呼び出し元でロックします。
+func (a *adminMan) lockIns() map[common.HostName]int {
+	ports := map[common.HostName]int{}
+	obj, err := loadObject(fpath.Join(a.conf.configPath, portConf))
+	if err != nil {
+		return ports
+	}
+	for _, host := range obj.Keys() {
+		if port, ok := obj.Child(host).AsFloat(); ok {
+			ports[host] = int(port.Float())
+		}
+	}
+	return ports
+}
+
+// SetLockIn はホストのポートロックインを変更します。port が 0 であれば削除します。
+// 待ち受け中のポートは変わらず、再読み込みで反映します。
+func (a *adminMan) SetLockIn(host common.HostName, port int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if host == systemHost || a.conf.DocHost(host) == nil {
+		return fmt.Errorf("unknown host %s", host)
+	}
+	if port < 0 || 65535 < port || (port != 0 && (port == a.conf.listenPort || port == a.conf.metrics.Port)) {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	ports := a.lockIns()
+	obj := json.NewElemObject()
+	for name, p := range ports {
+		if name == host {
+			continue
+		}
+		if p == port {
+			return fmt.Errorf("port %d is locked in by %s", port, name)
+		}
+		obj.Put(name, json.NewElemFloat(float64(p)))
+	}
+	if port != 0 {
+		obj.Put(host, json.NewElemFloat(float64(port)))
+	}
+	portsfile := fpath.Join(a.conf.configPath, portConf)
+	if err := json.SaveToJSONFile(portsfile, obj, true); err != nil {
+		return fmt.Errorf("error write %s : %v", portsfile, err)
+	}
+	a.conf.log.Infof("admin: lock in %s to port %d", host, port)
+	return nil
+}
+
+// Reload は設定の再読み込みを要求します。要求中であれば何もしません。
+func (a *adminMan) Reload() error {
+	// 今のサーバを止めてから読み込めないと分かっても戻せないので、先に待ち受けずに読み込んでみる
+	next, err := openConfig(a.conf.util, true, true)
+	if err == nil {
+		err = next.strictError()
+		next.Close()
+	}
+	if err != nil {
+		a.conf.log.Errorf("reload canceled, keep the current configuration : %v", err)
+		return fmt.Errorf("reload canceled : %v", err)
+	}
+	select {
+	case a.reload <- struct{}{}:
+		a.conf.log.Info("reload requested")
+	default:
+	}
+	return nil
+}
+
+// ReloadRequest は再読み込みの要求を受け取るチャネルです。
+func (a *adminMan) ReloadRequest() <-chan struct{} {
+	return a.reload
+}
+
+// loadObject は JSON オブジェクトのファイルを読みます。
+func loadObject(filename string) (json.ElemObject, error) {
+	elem, err := json.LoadFromJSONFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error read %s : %v", filename, err)
+	}
+	obj, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("error read %s : not an object", filename)
+	}
+	return obj, nil
+}
+
+// childText はオブジェクトの文字列の子要素を返します。無ければ空文字列です。
+func childText(obj json.ElemObject, key string) string {
+	if str, ok := json.QueryElemString(obj, json.PathJSON(key)); ok {
+		return str.Text()
+	}
+	return ""
+}
@@ -15,14 +15,17 @@ import (
 
 const (
 	extConf               = ".json"
-	fileConf              = "ziphttpd" + extConf
+	fileConf              = common.ConfigFile
 	portConf              = "portlockins" + extConf
+	passwordConf          = "password" + extConf
 	defaultDocument       = "docs"
 	defaultAPIRootPath    = "api"
 	defaultStaticRootPath = "static"
+	defaultPublishPath    = "dist"
+	defaultAPIStorage     = "file"
 	systemHost            = "system"
 	//commonHost            = "common"
-	localHost = "localhost"
+	localHost = common.LocalHostName
 )
 
 const (
@@ -30,12 +33,22 @@ const (
 	docpathDocument = json.PathJSON("document")
 	// Apiデータの置き場
 	docpathAPIData = json.PathJSON("apidata")
+	// Apiデータの格納方式 ("file" / "single")
+	docpathAPIStorage = json.PathJSON("apistorage")
 	// 拡張子別の Content-Type の定義
 	docpathContentType = json.PathJSON("contenttype")
 	// バージョン表示するかの指定
 	docpathShowVersion = json.PathJSON("showversion")
 	// favicon.ico の指定
 	docpathFavicon = json.PathJSON("favicon")
+	// 配布するホスト名
+	docpathPublishHost = json.PathJSON("publish/host")
+	// 配布物の出力先
+	docpathPublishOutput = json.PathJSON("publish/output")
+	// 配布物を /dist/ で公開するか
+	docpathPublishServe = json.PathJSON("publish/serve")
+	// store のドキュメントで残す過去のバージョン数
+	docpathStoreHistory = json.PathJSON("storehistory")
 )
 
 type conf struct {
@@ -45,8 +58,44 @@ type conf struct {
 	docPath string
 	// apiファイルの基準ディレクトリ
 	apiRootPath string
+	// apiデータの格納方式
+	apiStorage string
+	// apiデータの上限
+	apiLimits common.APILimits
+	// ホスト別の apiデータの上限
+	hostAPILimits map[common.HostName]common.APILimits
+	// ホスト別の外部コマンドの api
+	apiExtensions map[common.HostName]map[string]common.APIExtension
+	// ホスト別の apiデータの暗号化
+	apiEncryption map[common.HostName]common.APIEncryption
+	// 同期の識別子
+	syncID string
+	// 同期の共有の秘密
+	syncSecret string
+	// 同期の相手
+	syncPeers []common.SyncPeer
+	// 同期の要求と応答の本文の上限
+	syncMaxBody int64
 	// ログ
-	log common.Logger
+	log *common.LoggerInst
+	// 起動時の引数で指定したログの出力レベル、空文字列は設定ファイルに従う
+	logLevel string
+	// アクセスログ
+	accessLog *common.AccessLoggerInst
+	// ログの出力の指定
+	logPolicy common.LogPolicy
+	// ホスト別のログの出力の指定
+	hostLogPolicy map[common.HostName]common.LogPolicy
+	// 計測値の公開
+	metrics common.MetricsConfig
+	// store のドキュメントを読めなかった理由
+	storeErrors []string
+	// 設定ファイルの誤り
+	configErrors []common.ConfigError
+	// 設定ファイルに誤りがあれば起動しない
+	strict bool
+	// 起動時の引数
+	util common.ZipHttpdUtil
 	// 設定ファイルのエレメント
 	element json.Element
 	// ポート番号
@@ -63,38 +112,99 @@ type conf struct {
 	favicon []byte
 	// トークン管理
 	securityMan common.SecurityMan
+	// 管理画面からの変更
+	adminMan *adminMan
 	// タイトル情報
 	titleMan *model.TitleMan
+	// 配布するホスト名
+	publishHost string
+	// 配布物の出力先
+	publishPath string
+	// 配布物を公開するか
+	publishServe bool
+	// 待ち受けを行わない
+	offline bool
+	// 設定の置き場に書き出さない
+	readOnly bool
+	// store のドキュメントで残す過去のバージョン数
+	storeHistory int
+	// store のドキュメントのバージョン履歴
+	historyMan *historyMan
 }
 
 // newConf はコンストラクタです。
 // わざわざOpenConfigと分離したのは単体テストのため。
-func newConf(u common.ZipHttpdUtil) *conf {
+func newConf(u common.ZipHttpdUtil, offline bool) *conf {
+	var portMan common.PortMan
+	if offline {
+		portMan = model.NewOfflinePortMan(u.FirstDocPort())
+	} else {
+		portMan = model.NewPortMan(u.FirstDocPort())
+	}
 	ret := &conf{
 		hostDic:      map[common.HostName]common.DocHost{},
 		contentTypes: map[string]string{},
 		listenPort:   u.ListenPort(),
 		version:      "",
 		favicon:      nil,
-		portMan:      model.NewPortMan(u.FirstDocPort()),
-		securityMan:  model.NewSecurityMan(fpath.Join(u.ConfigDir(), "password.json")),
+		portMan:      portMan,
+		securityMan:  model.NewSecurityMan(fpath.Join(u.ConfigDir(), passwordConf)),
 		titleMan:     model.NewTitleMan(),
+		offline:      offline,
+		storeHistory: defaultStoreHistory,
+		apiLimits:    defaultAPILimits(),
+		logLevel:     u.LogLevel(),
+		strict:       u.Strict(),
+		util:         u,
 	}
+	ret.adminMan = newAdminMan(ret)
 	return ret
 }
 
 // OpenConfig は設定を読みだします。
 func OpenConfig(u common.ZipHttpdUtil) (common.Config, error) {
+	c, err := openConfig(u, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenOffline はポートの待ち受けを行わずに設定を読みだします。
+// サーバを起動しないサブコマンドで使用します。
+func OpenOffline(u common.ZipHttpdUtil) (common.Config, error) {
+	c, err := openConfig(u, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenReadOnly はポートの待ち受けを行わず、設定の置き場に何も書き出さずに設定を読みだします。
+// 設定を変更しないサブコマンドで使用するので、起動中のサーバと同時に使えます。
+// 設定ファイルの無いアーカイブは設定ファイルを作らずに既定の内容で読み、store の履歴も記録しません。
+func OpenReadOnly(u common.ZipHttpdUtil) (common.Config, error) {
+	c, err := openConfig(u, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func openConfig(u common.ZipHttpdUtil, offline, readOnly bool) (*conf, error) {
 	var err error
 
-	c := newConf(u)
+	c := newConf(u, offline)
+	c.readOnly = readOnly
 
 	// 設定ファイルの置き場
 	c.configPath = u.ConfigDir()
 	// 無ければ作っておく
-	err = os.MkdirAll(c.configPath, 0755)
-	if err != nil {
-		return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", c.configPath, err)
+	if false == c.readOnly { // nolint:gosimple
+		err = os.MkdirAll(c.configPath, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", c.configPath, err)
+		}
 	}
 
 	logDir := u.LogDir()
@@ -105,27 +215,36 @@ func OpenConfig(u common.ZipHttpdUtil) (common.Config, error) {
 	}
 	// ログの出力先
 	c.log = common.NewLogger(logDir)
+	c.accessLog = common.NewAccessLogger(logDir)
 
 	// 設定ファイル
 	configfile := fpath.Join(c.configPath, fileConf)
 	_, err = os.Stat(configfile)
-	if os.IsNotExist(err) {
-		// 標準の設定ファイルを配置
-		defaultZiphttpdconf := u.DefaultConfig()
-		err := os.WriteFile(configfile, []byte(defaultZiphttpdconf), os.ModePerm)
-		if err != nil {
-			return nil, fmt.Errorf("error write %s : %v", configfile, err)
+	if os.IsNotExist(err) && c.readOnly {
+		// 配置せずに標準の設定を読み込み
+		c.element, err = json.LoadFromJSONByte([]byte(u.DefaultConfig()))
+	} else {
+		if os.IsNotExist(err) {
+			// 標準の設定ファイルを配置
+			defaultZiphttpdconf := u.DefaultConfig()
+			err := os.WriteFile(configfile, []byte(defaultZiphttpdconf), os.ModePerm)
+			if err != nil {
+				return nil, fmt.Errorf("error write %s : %v", configfile, err)
+			}
 		}
-	}
 
-	// 設定ファイルを読み込み
-	c.element, err = json.LoadFromJSONFile(configfile)
+		// 設定ファイルを読み込み
+		c.element, err = json.LoadFromJSONFile(configfile)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error read %s : %v", configfile, err)
 	}
+	c.checkElem(configfile, c.element, configSchema)
+	c.checkFile(fpath.Join(c.configPath, passwordConf), passwordSchema)
 
 	// ret.element -> conf
 	c.setup()
+	c.historyMan = newHistoryMan(c.configPath, c.storeHistory)
 
 	// デフォルトのポート
 	err = c.portMan.Put(systemHost, c.listenPort)
@@ -137,13 +256,16 @@ func OpenConfig(u common.ZipHttpdUtil) (common.Config, error) {
 	//c.groupsDic[systemHost] = model.NewDocGroup(c.PortMan(), systemHost, c.securityMan, apiPath)
 
 	// ドキュメント設定ディレクトリが無ければ作っておく
-	err = os.MkdirAll(c.docPath, 0755)
-	if err != nil {
-		return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", c.docPath, err)
+	if false == c.readOnly { // nolint:gosimple
+		err = os.MkdirAll(c.docPath, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", c.docPath, err)
+		}
 	}
 
 	// ポートロックインファイル読み込み
 	portsfile := fpath.Join(c.configPath, portConf)
+	c.checkFile(portsfile, portSchema)
 	c.portMan.Load(portsfile)
 
 	// ドキュメントのファイル jar, zip, zhd を全てチェックして対応する設定ファイルが無い場合には作成する
@@ -152,15 +274,38 @@ func OpenConfig(u common.ZipHttpdUtil) (common.Config, error) {
 	// docpath から設定ファイルを作る
 	c.readDocs()
 
+	// 厳格な指定では誤りがあれば起動しない。サブコマンドでは誤りを報告できるよう読み込む。
+	if false == c.offline { // nolint:gosimple
+		if err := c.strictError(); err != nil {
+			c.portMan.Close()
+			c.Close()
+			return nil, err
+		}
+	}
+
 	// タイトルのコピー
 	c.titleFit()
 
 	// ポートロックインファイル書き出し
-	c.portMan.Save(portsfile)
+	if false == c.offline {
+		c.portMan.Save(portsfile)
+	}
 
 	return c, nil
 }
 
+// strictError は厳格な指定で設定ファイルに誤りがあればエラーを返します。
+func (c *conf) strictError() error {
+	if false == c.strict || len(c.configErrors) == 0 { // nolint:gosimple
+		return nil
+	}
+	mes := make([]string, 0, len(c.configErrors))
+	for _, e := range c.configErrors {
+		mes = append(mes, e.Error())
+	}
+	return fmt.Errorf("%d configuration errors (strict)\n%s", len(mes), strings.Join(mes, "\n"))
+}
+
 // readStore は zhget でダウンロードした ./store 以下のドキュメントのファイルから設定ファイルを作成します
 func (c *conf) readStore() {
 	store := fpath.Join(c.ConfigPath(), "store")
@@ -180,6 +325,7 @@ func (c *conf) readStore() {
 		// ホストのカタログを読む
 		cat, err := zhsig.ReadCatalog(host.CatalogFile())
 		if err != nil {
+			c.storeErrors = append(c.storeErrors, fmt.Sprintf("%s : catalog %v", hostname, err))
 			continue
 		}
 
@@ -201,6 +347,7 @@ func (c *conf) readStore() {
 				// 署名ファイル読み出し
 				sig, err := zhsig.ReadSig(host, docname)
 				if err != nil {
+					c.storeErrors = append(c.storeErrors, fmt.Sprintf("%s/%s : signature %v", hostname, docname, err))
 					continue
 				}
 				// 実ファイル名
@@ -210,41 +357,106 @@ func (c *conf) readStore() {
 				if checkext != ".zip" && checkext != ".jar" && checkext != ".zhd" {
 					continue
 				}
+				if false == common.FileExists(zipFileName) { // nolint:gosimple
+					c.storeErrors = append(c.storeErrors, fmt.Sprintf("%s/%s : no file %s", hostname, docname, zipFileName))
+					continue
+				}
 
-				// ドキュメントのタイトル情報を収集
-				groupTitle.AddDoc(docname, doc.Title, doc.Description)
+				// バージョン履歴を記録
+				var entries []*historyEntry
+				replaced := false
+				if c.readOnly {
+					// 記録せずに今までの履歴を読む
+					entries = c.historyMan.load(hostname, docname)
+				} else {
+					entries, replaced, err = c.historyMan.record(hostname, docname, zipFileName)
+					if err != nil {
+						c.log.Warnf("history error docname:%s : %+v", docname, err)
+					}
+				}
 
 				// 設定ファイルを作る
 				basename := common.BaseName(zipFileName)
 				basePath := fpath.Dir(zipFileName)
 				confName, _ := fpath.Abs(fpath.Join(basePath, basename+extConf))
-				if false == common.FileExists(confName) {
-					defelem, err := model.NewDocConfig(c, zipFileName, basename)
-					if err != nil {
-						//return nil, fmt.Errorf("error NewDocData(%s,...) : %v", zipName, err)
-						continue
-					}
-					// 保存する
-					err = json.SaveToJSONFile(confName, defelem, true)
-					if err != nil {
-						//return nil, fmt.Errorf("error json.SaveToJSONFile(%s, %+v, true) : %v", docConfName, defelem, err)
-					}
+				if replaced {
+					// アーカイブが置き換わったので設定ファイルを作り直す
+					c.log.Infof("replaced docname:%s", docname)
+					os.Remove(confName)
+				}
+				defelem, ok := c.newDocConf(confName, zipFileName, basename)
+				if false == ok { // nolint:gosimple
+					continue
 				}
 
+				// ドキュメントのタイトル情報を収集、設定ファイルに記述があればカタログより優先する
+				title, description := model.DocTitleInfo(confName)
+				if title == "" {
+					title = doc.Title
+				}
+				if description == "" {
+					description = doc.Description
+				}
+				groupTitle.AddDoc(docname, title, description)
+
 				// 設定ファイル読み出し
-				c.readConf(confName, hostname, groupname, docname)
+				c.readConf(confName, defelem, hostname, groupname, docname)
+				// 過去のバージョン
+				c.readVersions(hostname, groupname, docname, entries)
 			}
 		}
 	}
 }
 
+// readVersions は store のドキュメントのバージョン履歴を読みます
+func (c *conf) readVersions(hostname, groupname, docname string, entries []*historyEntry) {
+	docHost := c.hostDic[hostname]
+	for _, e := range entries {
+		zipFileName := c.historyMan.archive(hostname, docname, e)
+		confName := fpath.Join(c.historyMan.versionDir(hostname, docname, e.version), docname+extConf)
+		defelem, ok := c.newDocConf(confName, zipFileName, docname)
+		if false == ok { // nolint:gosimple
+			continue
+		}
+		var docdata common.DocData
+		if defelem != nil {
+			// 書き出していない設定ファイル
+			docdata = model.OpenDocElement(c, confName, defelem, hostname, groupname, docname)
+		} else {
+			if false == c.checkDocConf(confName) { // nolint:gosimple
+				continue
+			}
+			var err error
+			docdata, err = model.OpenDocConfig(c, confName, hostname, groupname, docname)
+			if err != nil {
+				c.addConfigError(confName, "", "version %s@%s : %v", docname, e.version, err)
+				continue
+			}
+		}
+		docGroup := docHost.Get(docdata.DocGroupName())
+		if docGroup == nil {
+			continue
+		}
+		docGroup.PutVersion(docname, e.version, docdata)
+		if e.version == c.historyMan.pin(hostname, docname) {
+			// 固定されたバージョンを公開する。置き換えた最新のものは閉じる。
+			if old := docGroup.Get(docname); old != nil && old != docdata {
+				old.Retire()
+			}
+			docGroup.Put(docname, docdata)
+		}
+	}
+}
+
 // readDocs は docpath に存在しているドキュメントのファイルから設定ファイルを作成します
 func (c *conf) readDocs() {
 	// グループの書誌情報を収集
 	hostTitle := c.titleMan.AddHost(localHost, nil)
-	groupTitle := hostTitle.AddGroup(localHost, "COMMON", "localhost document")
+	groupTitles := map[common.DocGroupName]common.GroupTitle{}
 	// ./docs のファイルを検索
 	files, _ := os.ReadDir(c.docPath)
+	// アーカイブに対応する設定ファイル
+	confs := map[string]bool{}
 	for _, f := range files {
 		// ディレクトリは除く
 		if f.IsDir() {
@@ -260,39 +472,89 @@ func (c *conf) readDocs() {
 
 		basename := common.BaseName(zipFileName)
 
-		// ドキュメントのタイトル情報を収集
-		groupTitle.AddDoc(basename, basename, "")
-
 		// 設定ファイルを作る
 		docConfName := basename + extConf
 		confPath := fpath.Join(c.docPath, docConfName)
-		if false == common.FileExists(confPath) {
-			zipFilePath := fpath.Join(c.docPath, zipFileName)
-			defelem, err := model.NewDocConfig(c, zipFilePath, basename)
-			if err != nil {
-				//return nil, fmt.Errorf("error NewDocData(%s,...) : %v", zipName, err)
-				continue
-			}
-			// 保存する
-			err = json.SaveToJSONFile(confPath, defelem, true)
-			if err != nil {
-				//return nil, fmt.Errorf("error json.SaveToJSONFile(%s, %+v, true) : %v", docConfName, defelem, err)
-			}
+		defelem, ok := c.newDocConf(confPath, fpath.Join(c.docPath, zipFileName), basename)
+		if false == ok { // nolint:gosimple
+			continue
 		}
 
 		// 設定ファイル読み出し
-		c.readConf(confPath, localHost, "", "")
+		confs[docConfName] = true
+		docdata := c.readConf(confPath, defelem, localHost, "", "")
+		if docdata == nil {
+			continue
+		}
+
+		// ドキュメントのタイトル情報を収集
+		groupName := docdata.DocGroupName()
+		groupTitle, ok := groupTitles[groupName]
+		if false == ok {
+			groupTitle = hostTitle.AddGroup(groupName, strings.ToUpper(groupName), "localhost document")
+			groupTitles[groupName] = groupTitle
+		}
+		title, description := model.DocTitleInfo(confPath)
+		if title == "" {
+			title = basename
+		}
+		groupTitle.AddDoc(docdata.DocID(), title, description)
+	}
+	// アーカイブの無い設定ファイル
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || confs[name] || strings.ToLower(fpath.Ext(name)) != extConf {
+			continue
+		}
+		if name == fileConf || name == portConf || name == passwordConf {
+			// 設定ファイルの置き場と同じフォルダを指定している
+			continue
+		}
+		c.checkDocConf(fpath.Join(c.docPath, name))
 	}
 }
 
-// readConf はドキュメントの設定ファイルを読みます
-func (c *conf) readConf(confFileName, hostname, groupname, docname string) {
-	// ドキュメントの設定ファイルを読む
-	docdata, err := model.OpenDocConfig(c, confFileName, hostname, groupname, docname)
+// newDocConf はアーカイブ zipPath の設定ファイル confPath が無ければ作ります。
+// 読み取り専用では書き出さずに作った内容を返します。それ以外は nil を返します。
+// アーカイブが読めなければ ok が偽です。
+func (c *conf) newDocConf(confPath, zipPath, basename string) (elem json.Element, ok bool) {
+	if common.FileExists(confPath) {
+		return nil, true
+	}
+	defelem, err := model.NewDocConfig(c, zipPath, basename)
 	if err != nil {
-		// ドキュメントが読み込めない
-		c.log.Warn(fmt.Sprintf("read error docname:%s : %+v", confFileName, err))
-		return
+		c.addConfigError(zipPath, "", "can not read the archive : %v", err)
+		return nil, false
+	}
+	if c.readOnly {
+		return defelem, true
+	}
+	// 保存する
+	if err := json.SaveToJSONFile(confPath, defelem, true); err != nil {
+		c.log.Warnf("error json.SaveToJSONFile(%s) : %v", confPath, err)
+	}
+	return nil, true
+}
+
+// readConf はドキュメントの設定ファイルを読みます
+// elem が nil でなければ、書き出していない設定ファイルの内容です。
+func (c *conf) readConf(confFileName string, elem json.Element, hostname, groupname, docname string) common.DocData {
+	var docdata common.DocData
+	if elem != nil {
+		docdata = model.OpenDocElement(c, confFileName, elem, hostname, groupname, docname)
+	} else {
+		// ドキュメントの設定ファイルを読む
+		if false == c.checkDocConf(confFileName) { // nolint:gosimple
+			// ドキュメントの設定ファイルが読み込めない
+			return nil
+		}
+		var err error
+		docdata, err = model.OpenDocConfig(c, confFileName, hostname, groupname, docname)
+		if err != nil {
+			// ドキュメントが読み込めない
+			c.addConfigError(confFileName, "", "%v", err)
+			return nil
+		}
 	}
 	docid := docdata.DocID()
 	// ホスト追加
@@ -311,16 +573,27 @@ func (c *conf) readConf(confFileName, hostname, groupname, docname string) {
 		docGroup = model.NewDocGroup(hostname, docGroupName)
 		docHost.Put(docGroupName, docGroup)
 	}
+	if prev := docGroup.Get(docid); prev != nil {
+		// 先に読んだものを公開したままにして、後のものは閉じる
+		c.addConfigError(confFileName, "", "duplicate docid %s/%s/%s, ignored in favor of %s", hostname, docGroupName, docid, prev.ConfPath())
+		docdata.Retire()
+		return nil
+	}
 	docGroup.Put(docid, docdata)
 	// 静的ファイル
-	if docdata.UseStaticFiles() {
+	if docdata.UseStaticFiles() && false == c.readOnly { // nolint:gosimple
 		folder := fpath.Join(c.configPath, "static", hostname, docGroupName, docid)
 		os.MkdirAll(folder, 0755)
 	}
+	return docdata
 }
 
 // setup は conf.element の内容を読みだします。
 func (c *conf) setup() {
+	// ログの出力
+	c.setupLog()
+	c.setupAccessLog()
+
 	// 拡張子 - Content-Type 辞書
 	if elem, ok := json.QueryElemObject(c.element, docpathContentType); ok {
 		keys := elem.Keys()
@@ -335,6 +608,11 @@ func (c *conf) setup() {
 		}
 	}
 
+	// 誤りがあれば起動しない
+	if elem, ok := json.QueryElemBool(c.element, docpathStrict); ok && elem.Bool() {
+		c.strict = true
+	}
+
 	// ドキュメント設定ファイルのディレクトリ
 	var docPath string
 	if elem, ok := json.QueryElemString(c.element, docpathDocument); ok {
@@ -359,6 +637,20 @@ func (c *conf) setup() {
 	}
 	c.apiRootPath = apiRootPath
 
+	// apiデータの格納方式
+	c.apiStorage = defaultAPIStorage
+	if elem, ok := json.QueryElemString(c.element, docpathAPIStorage); ok {
+		c.apiStorage = strings.ToLower(elem.Text())
+	}
+
+	// apiデータの上限
+	c.setupAPILimits()
+	c.setupAPIExtensions()
+	c.setupAPIEncryption()
+	c.setupSync()
+	c.setupMetrics()
+	c.setupAdmin()
+
 	// バージョン
 	if elem, ok := json.QueryElemBool(c.element, docpathShowVersion); ok {
 		if elem.Bool() {
@@ -376,6 +668,32 @@ func (c *conf) setup() {
 			c.favicon = buf
 		}
 	}
+
+	// store のドキュメントで残す過去のバージョン数
+	if elem, ok := json.QueryElemFloat(c.element, docpathStoreHistory); ok {
+		if keep := int(elem.Float()); keep >= 0 {
+			c.storeHistory = keep
+		} else {
+			c.addConfigError(fpath.Join(c.configPath, fileConf), "/"+docpathStoreHistory, "invalid number %v", elem.Float())
+		}
+	}
+
+	// 配布
+	c.publishHost = localHost
+	if elem, ok := json.QueryElemString(c.element, docpathPublishHost); ok {
+		c.publishHost = elem.Text()
+	}
+	publishPath := defaultPublishPath
+	if elem, ok := json.QueryElemString(c.element, docpathPublishOutput); ok {
+		publishPath = elem.Text()
+	}
+	if false == fpath.IsAbs(publishPath) { // nolint:gosimple
+		publishPath = fpath.Join(c.configPath, publishPath)
+	}
+	c.publishPath = publishPath
+	if elem, ok := json.QueryElemBool(c.element, docpathPublishServe); ok {
+		c.publishServe = elem.Bool()
+	}
 }
 
 // titleFit は TitleMan に集めたタイトル情報をドキュメントツリーに設定します。
@@ -409,6 +727,16 @@ func (c *conf) Logger() common.Logger {
 	return c.log
 }
 
+// StoreErrors は store のドキュメントのうち、カタログや署名を読めずに公開していないものの理由を返します。
+func (c *conf) StoreErrors() []string {
+	return c.storeErrors
+}
+
+// AccessLogger はアクセスログを取得します。
+func (c *conf) AccessLogger() common.AccessLogger {
+	return c.accessLog
+}
+
 // DocPath はドキュメントの基準フォルダを取得します。
 func (c *conf) DocPath() string {
 	return c.docPath
@@ -444,12 +772,14 @@ func (c *conf) Version() string {
 	return c.version
 }
 
-// Close はドキュメントをクローズします。
+// Close はドキュメントとログファイルをクローズします。
 func (c *conf) Close() {
 	for _, hs := range c.hostDic {
 		hs.Close()
 	}
 	c.hostDic = map[string]common.DocHost{}
+	c.accessLog.Close()
+	c.log.Close()
 }
 
 // PortMan はポートマネージャを取得します。
@@ -472,6 +802,11 @@ func (c *conf) APIPath(host common.HostName) string {
 	return fpath.Join(c.apiRootPath, host)
 }
 
+// APIStorage はAPIのストレージの格納方式を返します。
+func (c *conf) APIStorage() string {
+	return c.apiStorage
+}
+
 // タイトル管理
 func (c *conf) HostTitle(name string) common.HostTitle {
 	return c.titleMan.Host(name)
@@ -487,6 +822,95 @@ func (c *conf) HostNames() []common.HostName {
 	return keys
 }
 
+// PublishHost は配布するホスト名を取得します。
+func (c *conf) PublishHost() common.HostName {
+	return c.publishHost
+}
+
+// PublishPath は配布物の出力先を取得します。
+func (c *conf) PublishPath() string {
+	return c.publishPath
+}
+
+// DistPath は /dist/ で公開する配布物のフォルダを取得します。公開しない場合は空文字列です。
+func (c *conf) DistPath() string {
+	if c.publishServe {
+		return c.publishPath
+	}
+	return ""
+}
+
+// Rollback はドキュメントの公開するバージョンを切り替えて、切り替えたバージョン名を返します。
+// version が空文字列の場合は公開中の一つ前のバージョン、"latest" の場合は最新のバージョンにして固定を解除します。
+// 置き換えたドキュメントは処理中の要求が終わってから閉じます。
+func (c *conf) Rollback(hostname common.HostName, docid common.DocID, version string) (string, error) {
+	docHost := c.DocHost(hostname)
+	if docHost == nil {
+		return "", fmt.Errorf("unknown host %s", hostname)
+	}
+	for _, groupName := range docHost.Ids() {
+		docGroup := docHost.Get(groupName)
+		versions := docGroup.Versions(docid)
+		if len(versions) == 0 {
+			continue
+		}
+		latest := versions[len(versions)-1]
+		pin := version
+		switch version {
+		case "":
+			// 公開中の一つ前
+			current := c.historyMan.pin(hostname, docid)
+			if current == "" {
+				current = latest
+			}
+			for i, v := range versions {
+				if v == current && i > 0 {
+					pin = versions[i-1]
+				}
+			}
+			if pin == "" {
+				return "", fmt.Errorf("no previous version of %s", docid)
+			}
+		case "latest":
+			pin = latest
+		}
+		doc := docGroup.Get(docid + common.VersionSeparator + pin)
+		if doc == nil {
+			return "", fmt.Errorf("unknown version %s%s%s", docid, common.VersionSeparator, pin)
+		}
+		// 最新版は固定しない
+		savePin := pin
+		if pin == latest {
+			savePin = ""
+		}
+		if err := c.historyMan.setPin(hostname, docid, savePin); err != nil {
+			return "", err
+		}
+		old := docGroup.Get(docid)
+		if old != nil {
+			doc.SetTitleInfo(old.Title(), old.Description())
+		}
+		// 切り替えてから、置き換えたドキュメントを処理中の要求が終わったら閉じる
+		// 過去のバージョンとしても公開しているものは閉じない
+		docGroup.Put(docid, doc)
+		if old != nil && old != doc && false == isVersionDoc(docGroup, docid, versions, old) { // nolint:gosimple
+			old.Retire()
+		}
+		return pin, nil
+	}
+	return "", fmt.Errorf("no version of %s/%s", hostname, docid)
+}
+
+// isVersionDoc は doc が docid のバージョン versions のいずれかとして公開されていれば真を返します。
+func isVersionDoc(docGroup common.DocGroup, docid common.DocID, versions []string, doc common.DocData) bool {
+	for _, v := range versions {
+		if docGroup.Get(docid+common.VersionSeparator+v) == doc {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *conf) String() string {
 	return fmt.Sprintf("directory : %s", c.configPath)
 }
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	fpath "path/filepath"
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// newTestUtil は設定ファイルの置き場 dir と別のログの置き場を使う ZipHttpdUtil を返します。
+func newTestUtil(t *testing.T, dir string) common.ZipHttpdUtil {
+	u := common.NewUtil()
+	u.SetConfigDir(dir)
+	u.SetLogDir(t.TempDir())
+	u.SetListenPort(common.DefaultListenPort)
+	u.SetFirstDocPort(common.DefaultFirstDocPort)
+	return u
+}
+
+// listFiles は dir の下のファイルとフォルダの一覧を返します。
+func listFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := fpath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			rel, _ := fpath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil && false == os.IsNotExist(err) { // nolint:gosimple
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "no folder"},
+		{name: "empty folder", files: map[string]string{}},
+		{name: "config", files: map[string]string{
+			common.ConfigFile: `{"document": "docs", "strict": false}`,
+			"docs/memo.json":  `{"path": "docs/memo.zip"}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := fpath.Join(t.TempDir(), "conf")
+			if tt.files != nil {
+				for name, text := range tt.files {
+					file := fpath.Join(dir, fpath.FromSlash(name))
+					if err := os.MkdirAll(fpath.Dir(file), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := listFiles(t, dir)
+			conf, err := OpenReadOnly(newTestUtil(t, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Close()
+			after := listFiles(t, dir)
+			if len(before) != len(after) {
+				t.Errorf("files changed %v -> %v", before, after)
+			}
+		})
+	}
+}
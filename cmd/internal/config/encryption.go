@@ -0,0 +1,61 @@
+package config
+
+import (
+	fpath "path/filepath"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// ホスト別の Apiデータの暗号化
+	//	"apiencryption": {
+	//	  "ホスト名": { "key": "password" },
+	//	  "ホスト名2": { "key": "keyfile", "keyfile": "keys/host2.key" }
+	//	}
+	// password は password.json のホストのパスワードから、keyfile は鍵ファイルの内容から鍵を導出します。
+	docpathAPIEncryption = json.PathJSON("apiencryption")
+)
+
+// setupAPIEncryption は Apiデータの暗号化の指定を読みだします。
+// keyfile が相対パスであれば設定ファイルのフォルダからのパスです。
+func (c *conf) setupAPIEncryption() {
+	c.apiEncryption = map[common.HostName]common.APIEncryption{}
+	hosts, ok := json.QueryElemObject(c.element, docpathAPIEncryption)
+	if false == ok { // nolint:gosimple
+		return
+	}
+	for _, host := range hosts.Keys() {
+		elem := hosts.Child(host)
+		enc := common.APIEncryption{}
+		key := "password"
+		if k, ok := json.QueryElemString(elem, "key"); ok {
+			key = k.Text()
+		}
+		switch key {
+		case "password":
+		case "keyfile":
+			if keyfile, ok := json.QueryElemString(elem, "keyfile"); ok && keyfile.Text() != "" {
+				enc.KeyFile = keyfile.Text()
+				if false == fpath.IsAbs(enc.KeyFile) { // nolint:gosimple
+					enc.KeyFile = fpath.Join(c.configPath, enc.KeyFile)
+				}
+			} else {
+				enc.Invalid = "no keyfile"
+			}
+		default:
+			enc.Invalid = "unknown key " + key
+		}
+		if enc.Invalid != "" {
+			// 誤った指定で平文のまま保存しないよう、暗号化の指定としては残す
+			c.log.Warnf("apiencryption %s : %s", host, enc.Invalid)
+		}
+		c.apiEncryption[host] = enc
+	}
+}
+
+// APIEncryption はホストの Apiデータの暗号化の指定を返します。
+func (c *conf) APIEncryption(host common.HostName) (common.APIEncryption, bool) {
+	enc, ok := c.apiEncryption[host]
+	return enc, ok
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	fpath "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 外部コマンドの既定の実行時間の上限
+	defaultAPIExtensionTimeout = 30 * time.Second
+)
+
+const (
+	// ホスト別の外部コマンドの Api
+	//	"apiextensions": {
+	//	  "ホスト名": {
+	//	    "export": { "command": "tools/export.exe", "args": ["--csv"], "timeout": 30, "dir": "export", "data": true }
+	//	  }
+	//	}
+	docpathAPIExtensions = json.PathJSON("apiextensions")
+)
+
+// setupAPIExtensions は外部コマンドの Api を読みだします。
+// command が相対パスであれば設定ファイルのフォルダからのパスです。
+// 組み込みの Api と同じ名前は設定の誤りとして使いません。
+func (c *conf) setupAPIExtensions() {
+	c.apiExtensions = map[common.HostName]map[string]common.APIExtension{}
+	hosts, ok := json.QueryElemObject(c.element, docpathAPIExtensions)
+	if false == ok { // nolint:gosimple
+		return
+	}
+	for _, host := range hosts.Keys() {
+		apis, ok := hosts.Child(host).AsObject()
+		if false == ok { // nolint:gosimple
+			c.log.Warnf("apiextensions %s must object", host)
+			continue
+		}
+		exts := map[string]common.APIExtension{}
+		for _, name := range apis.Keys() {
+			if common.IsBuiltinAPI(name) {
+				c.addConfigError(fpath.Join(c.configPath, fileConf), "/"+docpathAPIExtensions+"/"+host+"/"+name, "%s is a built-in api", name)
+				continue
+			}
+			ext, err := c.readAPIExtension(name, apis.Child(name))
+			if err != "" {
+				c.log.Warnf("apiextensions %s/%s : %s", host, name, err)
+				continue
+			}
+			// api 名は小文字で照合する
+			exts[strings.ToLower(name)] = ext
+		}
+		c.apiExtensions[host] = exts
+	}
+}
+
+// readAPIExtension は外部コマンドの Api の指定を一つ読みだします。
+func (c *conf) readAPIExtension(name string, elem json.Element) (common.APIExtension, string) {
+	ext := common.APIExtension{
+		Dir:     name,
+		Timeout: defaultAPIExtensionTimeout,
+	}
+	command, ok := json.QueryElemString(elem, "command")
+	if false == ok || command.Text() == "" { // nolint:gosimple
+		return ext, "no command"
+	}
+	ext.Command = command.Text()
+	if false == fpath.IsAbs(ext.Command) && fpath.Base(ext.Command) != ext.Command { // nolint:gosimple
+		// パス区切りを含む相対パスは設定ファイルのフォルダから
+		ext.Command = fpath.Join(c.configPath, ext.Command)
+	}
+	if args, ok := json.QueryElemArray(elem, "args"); ok {
+		for i := 0; i < args.Size(); i++ {
+			ext.Args = append(ext.Args, args.Child(i).Text())
+		}
+	}
+	if timeout, ok := json.QueryElemFloat(elem, "timeout"); ok && timeout.Float() > 0 {
+		ext.Timeout = time.Duration(timeout.Float() * float64(time.Second))
+	}
+	if dir, ok := json.QueryElemString(elem, "dir"); ok {
+		ext.Dir = dir.Text()
+	}
+	// 作業フォルダは _ext の直下だけを認める
+	if ext.Dir == "" || ext.Dir == "." || ext.Dir == ".." || fpath.Base(ext.Dir) != ext.Dir {
+		return ext, "invalid dir " + ext.Dir
+	}
+	if data, ok := json.QueryElemBool(elem, "data"); ok {
+		ext.Data = data.Bool()
+	}
+	return ext, ""
+}
+
+// APIExtension はホストで外部コマンドに割り当てられた Api を返します。
+func (c *conf) APIExtension(host common.HostName, name string) (common.APIExtension, bool) {
+	ext, ok := c.apiExtensions[host][name]
+	return ext, ok
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	fpath "path/filepath"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 履歴の置き場
+	historyFolder = "history"
+	// ドキュメントごとの履歴の目録
+	historyManifest = "history" + extConf
+	// 固定するバージョンの記録
+	pinConf = "versions" + extConf
+	// 標準で残す過去のバージョン数
+	defaultStoreHistory = 3
+	// バージョン名の長さ (SHA-256 の先頭)
+	versionLength = 12
+)
+
+// historyEntry はドキュメントの一つのバージョンです。
+type historyEntry struct {
+	// バージョン名
+	version string
+	// アーカイブのファイル名
+	file string
+	// アーカイブのサイズ
+	size int64
+	// アーカイブの更新時刻 (unix)
+	modtime int64
+}
+
+// historyMan は store のドキュメントのバージョン履歴を管理します。
+type historyMan struct {
+	// 履歴の置き場
+	root string
+	// 残す過去のバージョン数
+	keep int
+	// 固定するバージョンの記録ファイル
+	pinfile string
+	// ホスト -> ドキュメント -> 固定するバージョン
+	pins map[common.HostName]map[common.DocID]string
+}
+
+// newHistoryMan はコンストラクタです。
+func newHistoryMan(configPath string, keep int) *historyMan {
+	h := &historyMan{
+		root:    fpath.Join(configPath, historyFolder),
+		keep:    keep,
+		pinfile: fpath.Join(configPath, pinConf),
+		pins:    map[common.HostName]map[common.DocID]string{},
+	}
+	h.loadPins()
+	return h
+}
+
+// docDir はドキュメントの履歴フォルダを返します。
+func (h *historyMan) docDir(host common.HostName, docid common.DocID) string {
+	return fpath.Join(h.root, host, docid)
+}
+
+// versionDir はバージョンのフォルダを返します。
+func (h *historyMan) versionDir(host common.HostName, docid common.DocID, version string) string {
+	return fpath.Join(h.docDir(host, docid), version)
+}
+
+// archive はバージョンのアーカイブのパスを返します。
+func (h *historyMan) archive(host common.HostName, docid common.DocID, e *historyEntry) string {
+	return fpath.Join(h.versionDir(host, docid, e.version), e.file)
+}
+
+// record は現在のアーカイブを履歴に記録して、古いものから並んだ履歴を返します。
+// 以前に記録したアーカイブから置き換わっていた場合に replaced が真になります。
+func (h *historyMan) record(host common.HostName, docid common.DocID, zipFile string) (entries []*historyEntry, replaced bool, err error) {
+	entries = h.load(host, docid)
+	fi, err := os.Stat(zipFile)
+	if err != nil {
+		return entries, false, err
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		if last.size == fi.Size() && last.modtime == fi.ModTime().Unix() {
+			// 変化なし
+			return entries, false, nil
+		}
+	}
+
+	// アーカイブのハッシュからバージョン名を決める
+	sum, err := common.HashFile(zipFile)
+	if err != nil {
+		return entries, false, err
+	}
+	hash := hex.EncodeToString(sum)
+	_, fileName := fpath.Split(zipFile)
+	entry := &historyEntry{
+		version: hash[:versionLength],
+		file:    fileName,
+		size:    fi.Size(),
+		modtime: fi.ModTime().Unix(),
+	}
+	for i, e := range entries {
+		if e.version == entry.version {
+			// 同じ内容が既にある (更新時刻だけ変わった) ので最新として扱う
+			entries = append(entries[:i], entries[i+1:]...)
+			entries = append(entries, entry)
+			return entries, false, h.save(host, docid, entries)
+		}
+	}
+
+	// 履歴にアーカイブを複写
+	dir := h.versionDir(host, docid, entry.version)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return entries, false, fmt.Errorf("error os.MkdirAll(%s) : %v", dir, err)
+	}
+	_, _, err = common.CopyFile(zipFile, h.archive(host, docid, entry))
+	if err != nil {
+		return entries, false, err
+	}
+	replaced = len(entries) > 0
+	entries = append(entries, entry)
+
+	// 古いものを間引く (固定されたバージョンと今回記録した最新のものは残す)
+	pinned := h.pin(host, docid)
+	for len(entries) > h.keep+1 {
+		idx := -1
+		for i, e := range entries[:len(entries)-1] {
+			if e.version != pinned {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			break
+		}
+		os.RemoveAll(h.versionDir(host, docid, entries[idx].version))
+		entries = append(entries[:idx], entries[idx+1:]...)
+	}
+	return entries, replaced, h.save(host, docid, entries)
+}
+
+// load は履歴の目録を読みだします。
+func (h *historyMan) load(host common.HostName, docid common.DocID) []*historyEntry {
+	entries := []*historyEntry{}
+	elem, err := json.LoadFromJSONFile(fpath.Join(h.docDir(host, docid), historyManifest))
+	if err != nil {
+		return entries
+	}
+	arr, ok := elem.AsArray()
+	if false == ok { // nolint:gosimple
+		return entries
+	}
+	for i := 0; i < arr.Size(); i++ {
+		obj, ok := arr.Child(i).AsObject()
+		if false == ok { // nolint:gosimple
+			continue
+		}
+		e := &historyEntry{}
+		if str, ok := obj.Child("version").AsString(); ok {
+			e.version = str.Text()
+		}
+		if str, ok := obj.Child("file").AsString(); ok {
+			e.file = str.Text()
+		}
+		if flo, ok := obj.Child("size").AsFloat(); ok {
+			e.size = int64(flo.Float())
+		}
+		if flo, ok := obj.Child("modtime").AsFloat(); ok {
+			e.modtime = int64(flo.Float())
+		}
+		if e.version != "" && e.file != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// save は履歴の目録を書き出します。
+func (h *historyMan) save(host common.HostName, docid common.DocID, entries []*historyEntry) error {
+	arr := json.NewElemArray()
+	for _, e := range entries {
+		obj := json.NewElemObject()
+		obj.Put("version", json.NewElemString(e.version))
+		obj.Put("file", json.NewElemString(e.file))
+		obj.Put("size", json.NewElemFloat(float64(e.size)))
+		obj.Put("modtime", json.NewElemFloat(float64(e.modtime)))
+		arr.Append(obj)
+	}
+	return json.SaveToJSONFile(fpath.Join(h.docDir(host, docid), historyManifest), arr, true)
+}
+
+// pin はドキュメントで固定されているバージョンを返します。固定されていなければ空文字列です。
+func (h *historyMan) pin(host common.HostName, docid common.DocID) string {
+	if docs, ok := h.pins[host]; ok {
+		return docs[docid]
+	}
+	return ""
+}
+
+// setPin はドキュメントのバージョンを固定します。空文字列の場合は固定を解除します。
+func (h *historyMan) setPin(host common.HostName, docid common.DocID, version string) error {
+	docs, ok := h.pins[host]
+	if false == ok { // nolint:gosimple
+		docs = map[common.DocID]string{}
+		h.pins[host] = docs
+	}
+	if version == "" {
+		delete(docs, docid)
+	} else {
+		docs[docid] = version
+	}
+	return h.savePins()
+}
+
+// loadPins は固定するバージョンの記録を読みだします。
+func (h *historyMan) loadPins() {
+	elem, err := json.LoadFromJSONFile(h.pinfile)
+	if err != nil {
+		return
+	}
+	hosts, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		return
+	}
+	for _, host := range hosts.Keys() {
+		if docs, ok := hosts.Child(host).AsObject(); ok {
+			pins := map[common.DocID]string{}
+			for _, docid := range docs.Keys() {
+				if str, ok := docs.Child(docid).AsString(); ok {
+					pins[docid] = str.Text()
+				}
+			}
+			h.pins[host] = pins
+		}
+	}
+}
+
+// savePins は固定するバージョンの記録を書き出します。
+func (h *historyMan) savePins() error {
+	hosts := json.NewElemObject()
+	for host, pins := range h.pins {
+		if len(pins) == 0 {
+			continue
+		}
+		docs := json.NewElemObject()
+		for docid, version := range pins {
+			docs.Put(docid, json.NewElemString(version))
+		}
+		hosts.Put(host, docs)
+	}
+	return json.SaveToJSONFile(h.pinfile, hosts, true)
+}
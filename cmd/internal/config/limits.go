@@ -0,0 +1,76 @@
+package config
+
+import (
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 一つの値の既定の上限
+	defaultAPIValueSize = 1024 * 1024
+	// 要求の本文の既定の上限
+	defaultAPIBodySize = 8 * 1024 * 1024
+)
+
+const (
+	// Apiデータの上限
+	//	"apilimits": {
+	//	  "hostbytes": 0, "hostkeys": 0, "nsbytes": 0, "nskeys": 0,
+	//	  "keylength": 0, "valuesize": 1048576, "bodysize": 8388608,
+	//	  "hosts": { "ホスト名": { "nskeys": 100 } }
+	//	}
+	docpathAPILimits = json.PathJSON("apilimits")
+	// ホスト別の Apiデータの上限
+	docpathAPILimitsHosts = json.PathJSON("apilimits/hosts")
+)
+
+// defaultAPILimits は既定の上限です。
+func defaultAPILimits() common.APILimits {
+	return common.APILimits{
+		ValueSize: defaultAPIValueSize,
+		BodySize:  defaultAPIBodySize,
+	}
+}
+
+// readAPILimits は elem に指定された上限で base を上書きします。
+func readAPILimits(elem json.Element, base common.APILimits) common.APILimits {
+	items := map[json.PathJSON]*int64{
+		"hostbytes": &base.HostBytes,
+		"hostkeys":  &base.HostKeys,
+		"nsbytes":   &base.NamespaceBytes,
+		"nskeys":    &base.NamespaceKeys,
+		"keylength": &base.KeyLength,
+		"valuesize": &base.ValueSize,
+		"bodysize":  &base.BodySize,
+	}
+	for path, value := range items {
+		if num, ok := json.QueryElemFloat(elem, path); ok {
+			*value = int64(num.Float())
+		}
+	}
+	return base
+}
+
+// setupAPILimits は Apiデータの上限を読みだします。
+func (c *conf) setupAPILimits() {
+	c.apiLimits = defaultAPILimits()
+	c.hostAPILimits = map[common.HostName]common.APILimits{}
+	elem, ok := json.QueryElemObject(c.element, docpathAPILimits)
+	if false == ok { // nolint:gosimple
+		return
+	}
+	c.apiLimits = readAPILimits(elem, c.apiLimits)
+	if hosts, ok := json.QueryElemObject(c.element, docpathAPILimitsHosts); ok {
+		for _, host := range hosts.Keys() {
+			c.hostAPILimits[host] = readAPILimits(hosts.Child(host), c.apiLimits)
+		}
+	}
+}
+
+// APILimits はホストのAPIのデータの上限を返します。
+func (c *conf) APILimits(host common.HostName) common.APILimits {
+	if limits, ok := c.hostAPILimits[host]; ok {
+		return limits
+	}
+	return c.apiLimits
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// ログの出力
+	//	"log": {
+	//	  "level": "info",
+	//	  "format": "text",
+	//	  "maxsize": 10,
+	//	  "daily": true,
+	//	  "maxbackups": 7,
+	//	  "maxage": 30,
+	//	  "body": true,
+	//	  "redact": ["value"],
+	//	  "hosts": {
+	//	    "ホスト名": { "body": false, "redact": ["data"] }
+	//	  }
+	//	}
+	// level は debug / info / warn / error、format は text / json です。
+	// maxsize はログファイルを切り替える大きさ (MB)、maxage は古いログファイルを残す日数です。
+	// body が偽であれば要求の本文を出力しません。
+	// redact はパスワードやトークンに加えて値を伏せる項目名で、ホストの redact は全体の redact に追加します。
+	docpathLog = json.PathJSON("log")
+)
+
+// setupLog はログの出力の指定を読みだします。
+// 起動時の引数でレベルを指定していれば、設定ファイルのレベルより優先します。
+func (c *conf) setupLog() {
+	opt := common.LogOptions{Level: common.LogInfo, Format: common.LogFormatText}
+	c.logPolicy = common.LogPolicy{Body: true, Redact: []string{}}
+	c.hostLogPolicy = map[common.HostName]common.LogPolicy{}
+	if elem, ok := json.QueryElemObject(c.element, docpathLog); ok {
+		if level, ok := json.QueryElemString(elem, "level"); ok {
+			if l, ok := common.ParseLogLevel(level.Text()); ok {
+				opt.Level = l
+			} else {
+				c.log.Warnf("log : unknown level %s", level.Text())
+			}
+		}
+		if format, ok := json.QueryElemString(elem, "format"); ok {
+			switch strings.ToLower(format.Text()) {
+			case common.LogFormatText, common.LogFormatJSON:
+				opt.Format = strings.ToLower(format.Text())
+			default:
+				c.log.Warnf("log : unknown format %s", format.Text())
+			}
+		}
+		if size, ok := json.QueryElemFloat(elem, "maxsize"); ok && size.Float() > 0 {
+			opt.MaxSize = int64(size.Float() * 1024 * 1024)
+		}
+		if daily, ok := json.QueryElemBool(elem, "daily"); ok {
+			opt.Daily = daily.Bool()
+		}
+		if backups, ok := json.QueryElemFloat(elem, "maxbackups"); ok && backups.Float() > 0 {
+			opt.MaxBackups = int(backups.Float())
+		}
+		if age, ok := json.QueryElemFloat(elem, "maxage"); ok && age.Float() > 0 {
+			opt.MaxAge = time.Duration(age.Float() * float64(24*time.Hour))
+		}
+		c.logPolicy = readLogPolicy(elem, c.logPolicy)
+		opt.Redact = c.logPolicy.Redact
+		if hosts, ok := json.QueryElemObject(elem, "hosts"); ok {
+			for _, host := range hosts.Keys() {
+				c.hostLogPolicy[host] = readLogPolicy(hosts.Child(host), c.logPolicy)
+			}
+		}
+	}
+	if c.logLevel != "" {
+		if l, ok := common.ParseLogLevel(c.logLevel); ok {
+			opt.Level = l
+		}
+	}
+	c.log.Configure(opt)
+}
+
+// readLogPolicy は elem の body と redact を読みだします。
+// 指定が無ければ base に従い、redact は base に追加します。
+func readLogPolicy(elem json.Element, base common.LogPolicy) common.LogPolicy {
+	policy := common.LogPolicy{Body: base.Body, Redact: append([]string{}, base.Redact...)}
+	if body, ok := json.QueryElemBool(elem, "body"); ok {
+		policy.Body = body.Bool()
+	}
+	if redact, ok := json.QueryElemArray(elem, "redact"); ok {
+		for i := 0; i < redact.Size(); i++ {
+			if name := redact.Child(i).Text(); name != "" {
+				policy.Redact = append(policy.Redact, name)
+			}
+		}
+	}
+	return policy
+}
+
+// LogPolicy はホストのログの出力の指定を返します。
+func (c *conf) LogPolicy(host common.HostName) common.LogPolicy {
+	if policy, ok := c.hostLogPolicy[host]; ok {
+		return policy
+	}
+	return c.logPolicy
+}
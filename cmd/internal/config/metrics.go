@@ -0,0 +1,37 @@
+package config
+
+import (
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 計測値の公開
+	//	"metrics": { "enabled": true, "port": 9823 }
+	// port を省略すると待ち受けポートの /metrics で公開します。
+	docpathMetrics = json.PathJSON("metrics")
+)
+
+// setupMetrics は計測値の公開の指定を読みだします。指定が無ければ待ち受けポートで公開します。
+func (c *conf) setupMetrics() {
+	c.metrics = common.MetricsConfig{Enabled: true}
+	elem, ok := json.QueryElemObject(c.element, docpathMetrics)
+	if false == ok { // nolint:gosimple
+		return
+	}
+	if enabled, ok := json.QueryElemBool(elem, "enabled"); ok {
+		c.metrics.Enabled = enabled.Bool()
+	}
+	if port, ok := json.QueryElemFloat(elem, "port"); ok {
+		if p := int(port.Float()); 0 < p && p < 65536 && p != c.listenPort {
+			c.metrics.Port = p
+		} else {
+			c.log.Warnf("metrics : invalid port %v", port.Float())
+		}
+	}
+}
+
+// Metrics は計測値の公開の指定を返します。
+func (c *conf) Metrics() common.MetricsConfig {
+	return c.metrics
+}
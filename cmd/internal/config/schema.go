@@ -0,0 +1,389 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	fpath "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 誤りがあれば起動しない
+	//	"strict": true
+	docpathStrict = json.PathJSON("strict")
+	// ドキュメントの設定ファイルのアーカイブのパス
+	docpathDocPath = json.PathJSON("path")
+	// 末尾のカンマを避けるための番兵のキー。どのオブジェクトにも書けます。
+	keyEndTerminator = "endterminator"
+)
+
+// schemaKind は JSON の値の種類です。
+type schemaKind int
+
+const (
+	// kindObject は既知のキーを持つオブジェクトです。
+	kindObject schemaKind = iota
+	// kindMap は任意のキーに同じ形の値を持つオブジェクトです。
+	kindMap
+	// kindArray は同じ形の値の配列です。
+	kindArray
+	kindString
+	kindNumber
+	kindBool
+	// kindPort は 1 から 65535 までのポート番号です。
+	kindPort
+)
+
+// schema は設定ファイルの JSON の形です。
+// null は省略と同じ扱いで、どの形にも当てはまります。
+type schema struct {
+	kind schemaKind
+	// kindObject のキーごとの形
+	keys map[string]*schema
+	// kindMap の値、kindArray の要素の形
+	elem *schema
+	// kindString で許す値 (大文字小文字は区別しない)。空であれば任意です。
+	values []string
+}
+
+func objectOf(keys map[string]*schema) *schema {
+	return &schema{kind: kindObject, keys: keys}
+}
+
+func mapOf(elem *schema) *schema {
+	return &schema{kind: kindMap, elem: elem}
+}
+
+func arrayOf(elem *schema) *schema {
+	return &schema{kind: kindArray, elem: elem}
+}
+
+func oneOf(values ...string) *schema {
+	return &schema{kind: kindString, values: values}
+}
+
+var (
+	schemaString = &schema{kind: kindString}
+	schemaNumber = &schema{kind: kindNumber}
+	schemaBool   = &schema{kind: kindBool}
+	schemaPort   = &schema{kind: kindPort}
+)
+
+// ファイルの出力の指定 (log, accesslog)
+var logFileKeys = map[string]*schema{
+	"maxsize":    schemaNumber,
+	"daily":      schemaBool,
+	"maxbackups": schemaNumber,
+	"maxage":     schemaNumber,
+}
+
+// Apiデータの上限 (apilimits, apilimits/hosts)
+var apiLimitsKeys = map[string]*schema{
+	"hostbytes": schemaNumber,
+	"hostkeys":  schemaNumber,
+	"nsbytes":   schemaNumber,
+	"nskeys":    schemaNumber,
+	"keylength": schemaNumber,
+	"valuesize": schemaNumber,
+	"bodysize":  schemaNumber,
+}
+
+// withKeys は base に keys を加えたキーの形を返します。
+func withKeys(base map[string]*schema, keys map[string]*schema) map[string]*schema {
+	ret := map[string]*schema{}
+	for k, v := range base {
+		ret[k] = v
+	}
+	for k, v := range keys {
+		ret[k] = v
+	}
+	return ret
+}
+
+// configSchema は ziphttpd.json の形です。
+var configSchema = objectOf(map[string]*schema{
+	"document":     schemaString,
+	"apidata":      schemaString,
+	"apistorage":   oneOf("file", "single"),
+	"contenttype":  mapOf(schemaString),
+	"showversion":  schemaBool,
+	"favicon":      schemaString,
+	"storehistory": schemaNumber,
+	"strict":       schemaBool,
+	"publish": objectOf(map[string]*schema{
+		"host":   schemaString,
+		"output": schemaString,
+		"serve":  schemaBool,
+	}),
+	"log": objectOf(withKeys(logFileKeys, map[string]*schema{
+		"level":  oneOf("debug", "info", "warn", "warning", "error"),
+		"format": oneOf(common.LogFormatText, common.LogFormatJSON),
+		"body":   schemaBool,
+		"redact": arrayOf(schemaString),
+		"hosts": mapOf(objectOf(map[string]*schema{
+			"body":   schemaBool,
+			"redact": arrayOf(schemaString),
+		})),
+	})),
+	"accesslog": objectOf(withKeys(logFileKeys, map[string]*schema{
+		"enabled": schemaBool,
+		"format":  oneOf(common.AccessLogCommon, common.AccessLogCombined, common.AccessLogJSON),
+	})),
+	"apilimits": objectOf(withKeys(apiLimitsKeys, map[string]*schema{
+		"hosts": mapOf(objectOf(apiLimitsKeys)),
+	})),
+	"apiextensions": mapOf(mapOf(objectOf(map[string]*schema{
+		"command": schemaString,
+		"args":    arrayOf(schemaString),
+		"timeout": schemaNumber,
+		"dir":     schemaString,
+		"data":    schemaBool,
+	}))),
+	"apiencryption": mapOf(objectOf(map[string]*schema{
+		"key":     oneOf("password", "keyfile"),
+		"keyfile": schemaString,
+	})),
+	"sync": objectOf(map[string]*schema{
+		"secret":  schemaString,
+		"maxbody": schemaNumber,
+		"peers": mapOf(objectOf(map[string]*schema{
+			"url":      schemaString,
+			"hosts":    arrayOf(schemaString),
+			"interval": schemaNumber,
+			"policy":   oneOf(common.SyncLastWriterWins, common.SyncKeepBoth),
+		})),
+	}),
+	"metrics": objectOf(map[string]*schema{
+		"enabled": schemaBool,
+		"port":    schemaPort,
+	}),
+	"admin": objectOf(map[string]*schema{
+		"password":  schemaString,
+		"maxupload": schemaNumber,
+	}),
+})
+
+// docSchema はドキュメントの設定ファイルの形です。
+var docSchema = objectOf(map[string]*schema{
+	"path":            schemaString,
+	"host":            schemaString,
+	"name":            schemaString,
+	"contentencoding": schemaString,
+	"docroot":         schemaString,
+	"docgroup":        schemaString,
+	"contenttype":     mapOf(schemaString),
+	"usestaticfiles":  schemaBool,
+	"title":           schemaString,
+	"description":     schemaString,
+})
+
+// passwordSchema は password.json の形です。
+var passwordSchema = mapOf(objectOf(map[string]*schema{
+	"password":     schemaString,
+	"localstorage": schemaBool,
+}))
+
+// portSchema は portlockins.json の形です。
+var portSchema = mapOf(schemaPort)
+
+// typeName は JSON の値の種類の名前を返します。
+func typeName(elem json.Element) string {
+	if _, ok := elem.AsObject(); ok {
+		return "object"
+	}
+	if _, ok := elem.AsArray(); ok {
+		return "array"
+	}
+	if _, ok := elem.AsString(); ok {
+		return "string"
+	}
+	if _, ok := elem.AsBool(); ok {
+		return "bool"
+	}
+	if _, ok := elem.AsFloat(); ok {
+		return "number"
+	}
+	return "null"
+}
+
+// validate は elem が s の形であるかを検査して、誤りを report に渡します。
+// path は elem の JSON のパスです。
+func (s *schema) validate(elem json.Element, path string, report func(path, message string)) {
+	if elem == nil || typeName(elem) == "null" {
+		return
+	}
+	switch s.kind {
+	case kindObject, kindMap:
+		obj, ok := elem.AsObject()
+		if false == ok { // nolint:gosimple
+			report(path, fmt.Sprintf("expected object, got %s", typeName(elem)))
+			return
+		}
+		keys := obj.Keys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == keyEndTerminator {
+				continue
+			}
+			child := s.elem
+			if s.kind == kindObject {
+				if child, ok = s.keys[key]; false == ok { // nolint:gosimple
+					report(path+"/"+key, "unknown key"+s.suggest(key))
+					continue
+				}
+			}
+			child.validate(obj.Child(key), path+"/"+key, report)
+		}
+	case kindArray:
+		arr, ok := elem.AsArray()
+		if false == ok { // nolint:gosimple
+			report(path, fmt.Sprintf("expected array, got %s", typeName(elem)))
+			return
+		}
+		for i := 0; i < arr.Size(); i++ {
+			s.elem.validate(arr.Child(i), path+"/"+strconv.Itoa(i), report)
+		}
+	case kindString:
+		str, ok := elem.AsString()
+		if false == ok { // nolint:gosimple
+			report(path, fmt.Sprintf("expected string, got %s", typeName(elem)))
+			return
+		}
+		if len(s.values) == 0 {
+			return
+		}
+		for _, v := range s.values {
+			if strings.EqualFold(v, str.Text()) {
+				return
+			}
+		}
+		report(path, fmt.Sprintf("unknown value %q (expected %s)", str.Text(), strings.Join(s.values, ", ")))
+	case kindNumber, kindPort:
+		num, ok := elem.AsFloat()
+		if false == ok { // nolint:gosimple
+			report(path, fmt.Sprintf("expected number, got %s", typeName(elem)))
+			return
+		}
+		if s.kind == kindPort {
+			if p := num.Float(); p != float64(int(p)) || p < 1 || 65535 < p {
+				report(path, fmt.Sprintf("invalid port %v", p))
+			}
+		}
+	case kindBool:
+		if _, ok := elem.AsBool(); false == ok { // nolint:gosimple
+			report(path, fmt.Sprintf("expected bool, got %s", typeName(elem)))
+		}
+	}
+}
+
+// suggest は綴りの近い既知のキーがあれば、その案内を返します。
+func (s *schema) suggest(key string) string {
+	best, bestDist := "", 3
+	for known := range s.keys {
+		if d := editDistance(strings.ToLower(key), known); d < bestDist || (d == bestDist && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance は a と b の編集距離を返します。
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// addConfigError は設定ファイルの誤りを記録します。
+func (c *conf) addConfigError(file, path, format string, args ...interface{}) {
+	e := common.ConfigError{File: file, Path: path, Message: fmt.Sprintf(format, args...)}
+	c.configErrors = append(c.configErrors, e)
+	c.log.Warnf("config %v", e)
+}
+
+// checkElem は設定ファイルの内容が s の形であるかを検査します。
+func (c *conf) checkElem(file string, elem json.Element, s *schema) {
+	s.validate(elem, "", func(path, message string) {
+		if path == "" {
+			path = "/"
+		}
+		c.addConfigError(file, path, "%s", message)
+	})
+}
+
+// checkFile は設定ファイルを読んで s の形であるかを検査します。
+// ファイルが無ければ検査しません。読めなければ誤りを記録して nil を返します。
+func (c *conf) checkFile(file string, s *schema) json.Element {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	elem, err := json.LoadFromJSONFile(file)
+	if err != nil {
+		c.addConfigError(file, "", "can not read : %v", err)
+		return nil
+	}
+	c.checkElem(file, elem, s)
+	return elem
+}
+
+// checkDocConf はドキュメントの設定ファイルの形とアーカイブがあるかを検査します。
+// 読めなければ偽を返します。
+func (c *conf) checkDocConf(confFile string) bool {
+	elem := c.checkFile(confFile, docSchema)
+	if elem == nil {
+		if false == common.FileExists(confFile) { // nolint:gosimple
+			c.addConfigError(confFile, "", "not found")
+		}
+		return false
+	}
+	path, ok := json.QueryElemString(elem, docpathDocPath)
+	if false == ok || path.Text() == "" { // nolint:gosimple
+		c.addConfigError(confFile, "/"+docpathDocPath, "no archive path")
+		return true
+	}
+	zipfile := path.Text()
+	if false == fpath.IsAbs(zipfile) { // nolint:gosimple
+		zipfile = fpath.Join(c.configPath, zipfile)
+	}
+	if false == common.FileExists(zipfile) { // nolint:gosimple
+		c.addConfigError(confFile, "/"+docpathDocPath, "archive %s not found", zipfile)
+	}
+	return true
+}
+
+// ConfigErrors は設定ファイルの誤りを返します。
+func (c *conf) ConfigErrors() []common.ConfigError {
+	return c.configErrors
+}
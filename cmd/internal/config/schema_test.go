@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	fpath "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// validateText は text を s で検査して、誤りを "パス : 内容" の一覧で返します。
+func validateText(t *testing.T, s *schema, text string) []string {
+	elem, err := json.LoadFromJSONByte([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := []string{}
+	s.validate(elem, "", func(path, message string) {
+		errs = append(errs, path+" : "+message)
+	})
+	return errs
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *schema
+		text   string
+		want   []string
+	}{
+		{name: "valid", schema: configSchema, text: `{"document": "docs", "log": {"level": "DEBUG", "redact": ["pin"]}, "metrics": {"port": 9100}, "endterminator": null}`},
+		{name: "null", schema: configSchema, text: `{"log": null, "strict": null}`},
+		{name: "unknown key", schema: configSchema, text: `{"stirct": true}`, want: []string{`/stirct : unknown key (did you mean "strict"?)`}},
+		{name: "unknown key case", schema: configSchema, text: `{"Strict": true}`, want: []string{`/Strict : unknown key (did you mean "strict"?)`}},
+		{name: "no suggestion", schema: configSchema, text: `{"foobarbaz": 1}`, want: []string{"/foobarbaz : unknown key"}},
+		{name: "nested unknown key", schema: configSchema, text: `{"sync": {"peers": {"b": {"urll": "x"}}}}`, want: []string{`/sync/peers/b/urll : unknown key (did you mean "url"?)`}},
+		{name: "type object", schema: configSchema, text: `{"log": "debug"}`, want: []string{"/log : expected object, got string"}},
+		{name: "type string", schema: configSchema, text: `{"document": 1}`, want: []string{"/document : expected string, got number"}},
+		{name: "type number", schema: configSchema, text: `{"storehistory": "3"}`, want: []string{"/storehistory : expected number, got string"}},
+		{name: "type bool", schema: configSchema, text: `{"strict": "true"}`, want: []string{"/strict : expected bool, got string"}},
+		{name: "type array", schema: configSchema, text: `{"log": {"redact": "pin"}}`, want: []string{"/log/redact : expected array, got string"}},
+		{name: "array element", schema: configSchema, text: `{"log": {"redact": ["pin", 1]}}`, want: []string{"/log/redact/1 : expected string, got number"}},
+		{name: "one of", schema: configSchema, text: `{"apistorage": "sql"}`, want: []string{`/apistorage : unknown value "sql" (expected file, single)`}},
+		{name: "port", schema: portSchema, text: `{"a": 8080, "b": 0, "c": 70000, "d": 80.5}`, want: []string{"/b : invalid port 0", "/c : invalid port 70000", "/d : invalid port 80.5"}},
+		{name: "map", schema: passwordSchema, text: `{"h": {"password": "p", "localstorage": 1}}`, want: []string{"/h/localstorage : expected bool, got number"}},
+		{name: "not object", schema: docSchema, text: `[]`, want: []string{" : expected object, got array"}},
+		{name: "doc", schema: docSchema, text: `{"path": "a.zip", "titel": "x"}`, want: []string{`/titel : unknown key (did you mean "title"?)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateText(t, tt.schema, tt.text)
+			if fmt.Sprint(got) != fmt.Sprint(append([]string{}, tt.want...)) {
+				t.Errorf("validate(%s)\n got  %q\n want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"strict", "strict", 0},
+		{"stirct", "strict", 2},
+		{"strct", "strict", 1},
+		{"stricts", "strict", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateDocID(t *testing.T) {
+	dir := t.TempDir()
+	c := newConf(newTestUtil(t, dir), true)
+	c.configPath = dir
+	logDir := t.TempDir()
+	c.log = common.NewLogger(logDir)
+	c.accessLog = common.NewAccessLogger(logDir)
+	defer c.Close()
+
+	// 同じ名前のドキュメントを二つの設定ファイルから読む
+	first := c.readConf(fpath.Join(dir, "a.json"), loadElem(t, `{"path": "a.zip", "name": "memo"}`), localHost, "", "")
+	second := c.readConf(fpath.Join(dir, "b.json"), loadElem(t, `{"path": "b.zip", "name": "memo"}`), localHost, "", "")
+	if first == nil {
+		t.Fatal("first document is not read")
+	}
+	if second != nil {
+		t.Errorf("duplicate document is read")
+	}
+	group := c.DocHost(localHost).Get(first.DocGroupName())
+	if got := group.Get("memo"); got != first {
+		t.Errorf("published %v, want the first document", got)
+	}
+	errs := c.ConfigErrors()
+	if len(errs) != 1 || false == strings.Contains(errs[0].Message, "duplicate docid") || errs[0].File != fpath.Join(dir, "b.json") { // nolint:gosimple
+		t.Errorf("config errors %v", errs)
+	}
+}
+
+// loadElem は JSON の文字列を読みます。
+func loadElem(t *testing.T, text string) json.Element {
+	elem, err := json.LoadFromJSONByte([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return elem
+}
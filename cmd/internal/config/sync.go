@@ -0,0 +1,126 @@
+package config
+
+import (
+	srand "crypto/rand"
+	"encoding/hex"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 同期の識別子を保存するファイル
+	syncIDFile = "syncid"
+	// 同期の要求と応答の本文の既定の上限
+	defaultSyncMaxBody = 256 * 1024 * 1024
+)
+
+const (
+	// Apiデータの同期
+	//	"sync": {
+	//	  "secret": "共有の秘密",
+	//	  "maxbody": 268435456,
+	//	  "peers": {
+	//	    "desktop": { "url": "http://192.168.0.2:8822", "hosts": ["memo"], "interval": 300, "policy": "lww" }
+	//	  }
+	//	}
+	// interval は秒で、省略すると手動でのみ同期します。policy は lww か keepboth です。
+	// maxbody は同期の要求と応答の本文の上限 (バイト) で、Api の bodysize とは別に指定します。
+	docpathSyncSecret  = json.PathJSON("sync/secret")
+	docpathSyncMaxBody = json.PathJSON("sync/maxbody")
+	docpathSyncPeers   = json.PathJSON("sync/peers")
+)
+
+// setupSync は同期の指定を読みだします。
+func (c *conf) setupSync() {
+	c.syncID = c.loadSyncID()
+	c.syncSecret = ""
+	c.syncPeers = []common.SyncPeer{}
+	c.syncMaxBody = defaultSyncMaxBody
+	if secret, ok := json.QueryElemString(c.element, docpathSyncSecret); ok {
+		c.syncSecret = secret.Text()
+	}
+	if maxBody, ok := json.QueryElemFloat(c.element, docpathSyncMaxBody); ok && maxBody.Float() > 0 {
+		c.syncMaxBody = int64(maxBody.Float())
+	}
+	peers, ok := json.QueryElemObject(c.element, docpathSyncPeers)
+	if false == ok { // nolint:gosimple
+		return
+	}
+	for _, name := range peers.Keys() {
+		elem := peers.Child(name)
+		peer := common.SyncPeer{Name: name, Policy: common.SyncLastWriterWins}
+		if url, ok := json.QueryElemString(elem, "url"); ok {
+			peer.URL = strings.TrimRight(url.Text(), "/")
+		}
+		if peer.URL == "" {
+			c.log.Warnf("sync peer %s : no url", name)
+			continue
+		}
+		if hosts, ok := json.QueryElemArray(elem, "hosts"); ok {
+			for i := 0; i < hosts.Size(); i++ {
+				peer.Hosts = append(peer.Hosts, hosts.Child(i).Text())
+			}
+		}
+		if interval, ok := json.QueryElemFloat(elem, "interval"); ok && interval.Float() > 0 {
+			peer.Interval = time.Duration(interval.Float() * float64(time.Second))
+		}
+		if policy, ok := json.QueryElemString(elem, "policy"); ok {
+			switch strings.ToLower(policy.Text()) {
+			case common.SyncLastWriterWins, common.SyncKeepBoth:
+				peer.Policy = strings.ToLower(policy.Text())
+			default:
+				c.log.Warnf("sync peer %s : unknown policy %s", name, policy.Text())
+				continue
+			}
+		}
+		c.syncPeers = append(c.syncPeers, peer)
+	}
+}
+
+// loadSyncID は同期の識別子を読みだします。無ければ作って保存します。
+func (c *conf) loadSyncID() string {
+	filename := fpath.Join(c.configPath, syncIDFile)
+	if data, err := os.ReadFile(filename); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	r := make([]byte, 16)
+	if _, err := srand.Read(r); err != nil {
+		c.log.Warnf("sync id error : %+v", err)
+	}
+	id := hex.EncodeToString(r)
+	if c.readOnly {
+		// 保存しないので、この識別子は読み取り専用の間だけのもの
+		return id
+	}
+	if err := os.WriteFile(filename, []byte(id+"\n"), 0644); err != nil {
+		c.log.Warnf("sync id error : %+v", err)
+	}
+	return id
+}
+
+// SyncID は同期で使うこのインスタンスの識別子を返します。
+func (c *conf) SyncID() string {
+	return c.syncID
+}
+
+// SyncSecret は同期の共有の秘密を返します。
+func (c *conf) SyncSecret() string {
+	return c.syncSecret
+}
+
+// SyncPeers は同期の相手の一覧を返します。
+func (c *conf) SyncPeers() []common.SyncPeer {
+	return c.syncPeers
+}
+
+// SyncMaxBody は同期の要求と応答の本文の上限を返します。
+func (c *conf) SyncMaxBody() int64 {
+	return c.syncMaxBody
+}
@@ -0,0 +1,602 @@
+package handler
+
+import (
+	srand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
+)
+
+const (
+	// 管理画面のセッションのクッキー名
+	adminCookie = "ziphttpd_admin"
+	// 管理画面のセッションの有効期間 (最後の操作から)
+	adminSessionTTL = 30 * time.Minute
+	// 管理画面に表示するログの行数
+	adminLogLines = 200
+	// 管理者のパスワードを間違えた接続元を待たせる時間
+	adminLoginDelay = 5 * time.Second
+)
+
+var admintmpl *template.Template
+
+// adminSession は管理画面のセッションです。
+type adminSession struct {
+	// フォームに埋め込む CSRF トークン
+	csrf string
+	// 有効期限
+	expires time.Time
+}
+
+// 管理画面のセッション (セッションID -> セッション)
+var adminSessions = struct {
+	mu  sync.Mutex
+	dic map[string]*adminSession
+}{dic: map[string]*adminSession{}}
+
+// 管理者のパスワードの検査 (接続元 -> 次に検査できる時刻)
+// 検査は同時には一個しか処理せず、間違えた接続元は adminLoginDelay の間は検査しない
+var adminLogins = struct {
+	mu   sync.Mutex
+	wait map[string]time.Time
+}{wait: map[string]time.Time{}}
+
+// admindoc はテンプレートに渡すドキュメントの情報です。
+type admindoc struct {
+	Host         string
+	ID           string
+	Title        string
+	Description  string
+	DocRoot      string
+	ContentTypes string
+	DocGroup     string
+	// store のドキュメントはグループを変更できない
+	Store  bool
+	Detail string
+}
+
+// admingroup はテンプレートに渡すドキュメントグループの情報です。
+type admingroup struct {
+	Name   string
+	Title  string
+	Detail string
+	Docs   []*admindoc
+}
+
+// adminhost はテンプレートに渡すホストの情報です。
+type adminhost struct {
+	Name         string
+	Port         int
+	LockIn       int
+	Listening    bool
+	HasPassword  bool
+	LocalStorage bool
+	Detail       string
+	Groups       []*admingroup
+}
+
+// adminparam はテンプレートに渡す情報です。
+type adminparam struct {
+	// ログイン済み
+	LoggedIn bool
+	// CSRF トークン
+	CSRF string
+	// 操作の結果
+	Message string
+	// バージョン
+	Version string
+	// アップロードできるアーカイブの上限 (MB)
+	MaxUpload int64
+	Hosts     []*adminhost
+	Logs      []string
+}
+
+func init() {
+	tplStr := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<meta name="viewport" content="width=device-width">
+		<meta name="description" content="administration view">
+		<title>ZipHttpd - admin</title>
+		<style type="text/css">
+<!--
+body {
+	margin: 12px;
+}
+h1 {
+	border: #C0C0C0 1px solid;
+	background-color: beige;
+	padding-left: 10px;
+	margin-top: 2px;
+	margin-bottom: 2px;
+}
+h2 {
+	color: blue;
+	font-weight: bold;
+	margin-bottom: 2px;
+}
+h3 {
+	color: green;
+	font-weight: bold;
+	margin-bottom: 2px;
+}
+.indent {
+	margin-left: 2em;
+}
+.message {
+	border: #C0C0C0 1px solid;
+	background-color: wheat;
+	padding: 8px;
+}
+.doc {
+	border: #C0C0C0 1px solid;
+	padding: 8px;
+	margin-bottom: 8px;
+}
+.doc input[type=text], .doc textarea {
+	width: 40em;
+}
+#drop {
+	border: #C0C0C0 2px dashed;
+	padding: 16px;
+	margin-bottom: 8px;
+}
+#drop.over {
+	background-color: beige;
+}
+pre {
+	font-size: x-small;
+	background-color: #F8F8F8;
+	overflow-x: auto;
+}
+#logs {
+	max-height: 400px;
+	overflow-y: scroll;
+}
+#copyright {
+	padding: 12px;
+	text-align: center;
+}
+-->
+		</style>
+	</head>
+	<body>
+		<h1>ZipHttpd administration</h1>
+		{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+		{{if not .LoggedIn}}
+		<form method="POST" action="/admin/login">
+			password: <input type="password" autocomplete="current-password" name="password" required/>
+			<input type="submit" value="login"/>
+		</form>
+		{{else}}
+		{{$csrf := .CSRF}}
+		<form method="POST" action="/admin/reload" style="display:inline">
+			<input type="hidden" name="csrf" value="{{$csrf}}"/>
+			<input type="submit" value="reload"/>
+		</form>
+		<form method="POST" action="/admin/logout" style="display:inline">
+			<input type="hidden" name="csrf" value="{{$csrf}}"/>
+			<input type="submit" value="logout"/>
+		</form>
+		<h2>Upload</h2>
+		<form id="upload" class="doc" method="POST" action="/admin/upload" enctype="multipart/form-data">
+			<input type="hidden" name="csrf" value="{{$csrf}}"/>
+			<div id="drop">drop a zip, jar or zhd archive here, or choose: <input type="file" name="file" accept=".zip,.jar,.zhd" required/></div>
+			title: <input type="text" name="title"/> (optional)<br/>
+			group: <input type="text" name="docgroup"/> (optional)<br/>
+			<label><input type="checkbox" name="replace" value="true"/>replace the document of the same name</label><br/>
+			<input type="submit" value="upload"/> (up to {{.MaxUpload}} MB)
+		</form>
+		{{range .Hosts}}
+		<h2>{{.Name}}</h2>
+		<div class="indent">
+			port: {{.Port}}{{if not .Listening}} (not listening){{end}}
+			<form method="POST" action="/admin/port">
+				<input type="hidden" name="csrf" value="{{$csrf}}"/>
+				<input type="hidden" name="host" value="{{.Name}}"/>
+				lock-in port: <input type="number" name="port" min="0" max="65535" value="{{.LockIn}}"/>
+				<input type="submit" value="save"/> (0 removes the lock-in, applied on reload)
+			</form>
+			<form method="POST" action="/admin/password">
+				<input type="hidden" name="csrf" value="{{$csrf}}"/>
+				<input type="hidden" name="host" value="{{.Name}}"/>
+				password: <input type="password" autocomplete="new-password" name="password"/>
+				<label><input type="checkbox" name="localstorage" value="true"{{if .LocalStorage}} checked{{end}}/>localStorage</label>
+				<input type="submit" value="save"/> ({{if .HasPassword}}set, empty removes{{else}}not set{{end}})
+			</form>
+			<details><summary>JSON</summary><pre>{{.Detail}}</pre></details>
+			{{range .Groups}}
+			<h3>{{.Name}} : {{.Title}}</h3>
+			<div class="indent">
+				<details><summary>JSON</summary><pre>{{.Detail}}</pre></details>
+				{{range .Docs}}
+				<form class="doc" method="POST" action="/admin/doc">
+					<input type="hidden" name="csrf" value="{{$csrf}}"/>
+					<input type="hidden" name="host" value="{{.Host}}"/>
+					<input type="hidden" name="doc" value="{{.ID}}"/>
+					<b>{{.ID}}</b><br/>
+					title: <input type="text" name="title" value="{{.Title}}"/><br/>
+					description: <input type="text" name="description" value="{{.Description}}"/><br/>
+					docroot: <input type="text" name="docroot" value="{{.DocRoot}}"/><br/>
+					group: <input type="text" name="docgroup" value="{{.DocGroup}}"{{if .Store}} readonly{{end}}/><br/>
+					content types (ext=type per line):<br/>
+					<textarea name="contenttype" rows="3">{{.ContentTypes}}</textarea><br/>
+					<input type="submit" value="save"/>
+					{{if not .Store}}<input type="submit" value="remove" formaction="/admin/remove" onclick="return confirm('remove {{.ID}} ?')"/>{{end}}
+					<details><summary>JSON</summary><pre>{{.Detail}}</pre></details>
+				</form>
+				{{end}}
+			</div>
+			{{end}}
+		</div>
+		{{end}}
+		<h2>Recent logs</h2>
+		<pre id="logs">{{range .Logs}}{{.}}
+{{end}}</pre>
+		{{end}}
+		<hr/>
+		<div id="copyright">Powered by <a href="https://ziphttpd.com/">ZipHttpd</a>.{{.Version}}</div>
+		<script>
+document.addEventListener("DOMContentLoaded", function() {
+	let logs = document.getElementById("logs");
+	if (logs) {
+		logs.scrollTop = logs.scrollHeight;
+	}
+	// アーカイブのドラッグ＆ドロップ
+	let drop = document.getElementById("drop");
+	let upload = document.getElementById("upload");
+	if (drop && upload) {
+		drop.addEventListener("dragover", function(e) {
+			e.preventDefault();
+			drop.classList.add("over");
+		});
+		drop.addEventListener("dragleave", function() {
+			drop.classList.remove("over");
+		});
+		drop.addEventListener("drop", function(e) {
+			e.preventDefault();
+			drop.classList.remove("over");
+			if (e.dataTransfer.files.length > 0) {
+				upload.elements["file"].files = e.dataTransfer.files;
+				upload.submit();
+			}
+		});
+	}
+});
+		</script>
+	</body>
+</html>
+`
+	tmpl, err := template.New("admin").Parse(tplStr)
+	if err != nil {
+		panic(err)
+	}
+	admintmpl = tmpl
+}
+
+// AdminHandler は管理画面のハンドラです。
+// 管理者のパスワードを設定した場合に、待ち受けポートでのみ公開します。
+//
+//	GET  /admin/          一覧と編集のフォーム、ログ
+//	POST /admin/login     ログイン
+//	POST /admin/logout    ログアウト
+//	POST /admin/doc       ドキュメントの設定の変更
+//	POST /admin/upload    docs フォルダへのアーカイブの追加、置き換え (multipart/form-data)
+//	POST /admin/remove    docs フォルダのドキュメントの削除
+//	POST /admin/password  ホストのパスワードの変更
+//	POST /admin/port      ホストのポートロックインの変更
+//	POST /admin/reload    設定の再読み込み
+func AdminHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	admin := param.Config().AdminMan()
+	if false == admin.Enabled() || param.Server().Port() != param.ListenPort() { // nolint:gosimple
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	writer.SetHeader("Cache-Control", "no-store")
+	writer.SetHeader("X-Frame-Options", "DENY")
+
+	paths := param.Paths()
+	action := ""
+	if len(paths) > 2 {
+		action = strings.ToLower(paths[2])
+	}
+	post := request.Method() == http.MethodPost
+	if post && action == "login" {
+		adminLogin(writer, request, param)
+		return
+	}
+	sessionID, session := adminSessionOf(request)
+	if session == nil {
+		if post {
+			ErrorHandler(writer, request, param, http.StatusForbidden)
+			return
+		}
+		adminPage(writer, request, param, nil, request.GetForm("msg"))
+		return
+	}
+	if false == post { // nolint:gosimple
+		adminPage(writer, request, param, session, request.GetForm("msg"))
+		return
+	}
+	if request.GetPostForm("csrf") != session.csrf {
+		ErrorHandler(writer, request, param, http.StatusForbidden)
+		return
+	}
+
+	var mes string
+	var err error
+	host := request.GetPostForm("host")
+	switch action {
+	case "logout":
+		adminSessions.mu.Lock()
+		delete(adminSessions.dic, sessionID)
+		adminSessions.mu.Unlock()
+		writer.SetHeader("Set-Cookie", adminCookie+"=; Path=/admin; Max-Age=0; HttpOnly; SameSite=Strict")
+		mes = "logged out"
+	case "doc":
+		mes, err = adminUpdateDoc(admin, request)
+	case "upload":
+		mes, err = adminUpload(admin, request)
+	case "remove":
+		docid := request.GetPostForm("doc")
+		if err = admin.RemoveDoc(docid); err == nil {
+			mes = docid + " removed"
+		}
+	case "password":
+		localStorage := request.GetPostForm("localstorage") == "true"
+		if err = admin.SetPassword(host, request.GetPostForm("password"), localStorage); err == nil {
+			mes = "password of " + host + " saved"
+		}
+	case "port":
+		var port int
+		if port, err = strconv.Atoi(request.GetPostForm("port")); err == nil {
+			if err = admin.SetLockIn(host, port); err == nil {
+				mes = fmt.Sprintf("lock-in port of %s saved, reload to apply", host)
+			}
+		}
+	case "reload":
+		if err = admin.Reload(); err == nil {
+			mes = "reload requested"
+		}
+	default:
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		mes = "error: " + err.Error()
+	}
+	writer.Redirect(request, "/admin/?msg="+url.QueryEscape(mes), http.StatusSeeOther)
+}
+
+// adminLogin は管理者のパスワードを検査してセッションを開始します。
+func adminLogin(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	if false == adminCheckPassword(request.RemoteAddr(), func() bool { // nolint:gosimple
+		return param.Config().AdminMan().IsValid(request.GetPostForm("password"))
+	}) {
+		metrics.LoginFailures.Inc(param.PortMan().HostName(param.ListenPort()))
+		param.Logger().Warnf("admin: login failed from %s", request.RemoteAddr())
+		// 第三者によってパスワードが試行されている可能性があるので、間違ったパスワードにはディレイを入れる
+		// 他の接続元のログインは待たせない
+		time.Sleep(adminLoginDelay)
+		writer.Redirect(request, "/admin/?msg="+url.QueryEscape("login failed"), http.StatusSeeOther)
+		return
+	}
+	sessionID := adminRandom()
+	adminSessions.mu.Lock()
+	now := time.Now()
+	for id, s := range adminSessions.dic {
+		if now.After(s.expires) {
+			delete(adminSessions.dic, id)
+		}
+	}
+	adminSessions.dic[sessionID] = &adminSession{csrf: adminRandom(), expires: now.Add(adminSessionTTL)}
+	adminSessions.mu.Unlock()
+	param.Logger().Infof("admin: login from %s", request.RemoteAddr())
+	writer.SetHeader("Set-Cookie", adminCookie+"="+sessionID+"; Path=/admin; HttpOnly; SameSite=Strict")
+	writer.Redirect(request, "/admin/", http.StatusSeeOther)
+}
+
+// adminCheckPassword は接続元 remoteAddr のパスワードを valid で検査します。
+// 間違えた接続元は adminLoginDelay の間、検査せずに失敗させます。
+func adminCheckPassword(remoteAddr string, valid func() bool) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	adminLogins.mu.Lock()
+	defer adminLogins.mu.Unlock()
+	now := time.Now()
+	for addr, until := range adminLogins.wait {
+		if false == now.Before(until) { // nolint:gosimple
+			delete(adminLogins.wait, addr)
+		}
+	}
+	if _, ok := adminLogins.wait[host]; ok {
+		return false
+	}
+	if false == valid() { // nolint:gosimple
+		adminLogins.wait[host] = now.Add(adminLoginDelay)
+		return false
+	}
+	return true
+}
+
+// adminSessionOf はクッキーのセッションを返します。無いか期限切れであれば nil です。
+func adminSessionOf(request common.RequestProxy) (string, *adminSession) {
+	cookie, err := request.Request().Cookie(adminCookie)
+	if err != nil {
+		return "", nil
+	}
+	adminSessions.mu.Lock()
+	defer adminSessions.mu.Unlock()
+	session, ok := adminSessions.dic[cookie.Value]
+	if false == ok { // nolint:gosimple
+		return "", nil
+	}
+	now := time.Now()
+	if now.After(session.expires) {
+		delete(adminSessions.dic, cookie.Value)
+		return "", nil
+	}
+	session.expires = now.Add(adminSessionTTL)
+	return cookie.Value, session
+}
+
+// adminRandom はセッションIDなどに使う推測できない文字列を返します。
+func adminRandom() string {
+	r := make([]byte, 256/8)
+	if _, err := srand.Read(r); err != nil {
+		panic(fmt.Errorf("%+v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(r)
+}
+
+// adminUpdateDoc はフォームの内容でドキュメントの設定を変更します。
+func adminUpdateDoc(admin common.AdminMan, request common.RequestProxy) (string, error) {
+	host := request.GetPostForm("host")
+	docid := request.GetPostForm("doc")
+	types, err := parseContentTypes(request.GetPostForm("contenttype"))
+	if err != nil {
+		return "", err
+	}
+	edit := common.DocEdit{
+		Title:        strings.TrimSpace(request.GetPostForm("title")),
+		Description:  strings.TrimSpace(request.GetPostForm("description")),
+		DocRoot:      strings.TrimSpace(request.GetPostForm("docroot")),
+		ContentTypes: types,
+		DocGroup:     request.GetPostForm("docgroup"),
+	}
+	reload, err := admin.UpdateDoc(host, docid, edit)
+	if err != nil {
+		return "", err
+	}
+	if reload {
+		return fmt.Sprintf("%s/%s saved, reload to apply", host, docid), nil
+	}
+	return fmt.Sprintf("%s/%s saved", host, docid), nil
+}
+
+// adminUpload はアップロードされたアーカイブを docs フォルダに追加します。
+func adminUpload(admin common.AdminMan, request common.RequestProxy) (string, error) {
+	file, header, err := request.Request().FormFile("file")
+	if err != nil {
+		return "", fmt.Errorf("no archive : %v", err)
+	}
+	defer file.Close()
+	title := strings.TrimSpace(request.GetPostForm("title"))
+	group := strings.TrimSpace(request.GetPostForm("docgroup"))
+	replace := request.GetPostForm("replace") == "true"
+	docid, reload, err := admin.AddDoc(header.Filename, file, title, group, replace)
+	if err != nil {
+		return "", err
+	}
+	if reload {
+		return fmt.Sprintf("%s/%s uploaded, reload to publish", common.LocalHostName, docid), nil
+	}
+	return fmt.Sprintf("%s/%s uploaded", common.LocalHostName, docid), nil
+}
+
+// parseContentTypes は一行に一つの "拡張子=Content-Type" を読みます。
+func parseContentTypes(text string) (map[string]string, error) {
+	types := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 || strings.TrimSpace(line[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid content type %q", line)
+		}
+		types[strings.ToLower(strings.TrimSpace(line[:i]))] = strings.TrimSpace(line[i+1:])
+	}
+	return types, nil
+}
+
+// adminPage は管理画面を返します。session が nil であればログインのフォームです。
+func adminPage(writer common.ResponseProxy, request common.RequestProxy, param common.Param, session *adminSession, mes string) {
+	tmplParam := &adminparam{Message: mes, Version: param.Version()}
+	if session != nil {
+		tmplParam.LoggedIn = true
+		tmplParam.CSRF = session.csrf
+		tmplParam.MaxUpload = param.Config().AdminMan().MaxUpload() / (1024 * 1024)
+		tmplParam.Hosts = adminHosts(param.Config())
+		tmplParam.Logs = param.Logger().Recent(adminLogLines)
+	}
+	writer.SetHeader("Content-Type", "text/html; charset=utf-8")
+	if err := writer.ParseContents(admintmpl, tmplParam); err != nil {
+		param.Logger().Warnf("writer.ParseContents error : %+v", err)
+		// エラーの時に、http.Server の ConnState ハンドルが呼ばれず現接続数の計算でミスする
+		param.Server().ConnDone()
+	}
+}
+
+// adminHosts は管理画面に表示するホストの一覧を返します。
+func adminHosts(conf common.Config) []*adminhost {
+	admin := conf.AdminMan()
+	sec := conf.SecurityMan()
+	lockIns := admin.LockIns()
+	hosts := []*adminhost{}
+	for _, hostName := range conf.PortMan().HostNames() {
+		docHost := conf.DocHost(hostName)
+		if docHost == nil {
+			continue
+		}
+		_, hasPassword := sec.Password(hostName)
+		host := &adminhost{
+			Name:         hostName,
+			Port:         conf.PortMan().Port(hostName),
+			LockIn:       lockIns[hostName],
+			Listening:    conf.PortMan().Listening(hostName),
+			HasPassword:  hasPassword,
+			LocalStorage: sec.UseLocalStorage(hostName),
+			Detail:       json.ToJSON(docHost.JSON(), true),
+		}
+		for _, groupName := range docHost.Ids() {
+			docGroup := docHost.Get(groupName)
+			group := &admingroup{Name: groupName, Title: docGroup.Title(), Detail: json.ToJSON(docGroup.JSON(), true)}
+			for _, docid := range docGroup.Ids() {
+				doc := &admindoc{
+					Host:   hostName,
+					ID:     docid,
+					Store:  hostName != common.LocalHostName,
+					Detail: json.ToJSON(docGroup.Get(docid).JSON(), true),
+				}
+				if edit, err := admin.Doc(hostName, docid); err == nil {
+					doc.Title = edit.Title
+					doc.Description = edit.Description
+					doc.DocRoot = edit.DocRoot
+					doc.DocGroup = edit.DocGroup
+					exts := []string{}
+					for ext := range edit.ContentTypes {
+						exts = append(exts, ext)
+					}
+					sort.Strings(exts)
+					lines := []string{}
+					for _, ext := range exts {
+						lines = append(lines, ext+"="+edit.ContentTypes[ext])
+					}
+					doc.ContentTypes = strings.Join(lines, "\n")
+				}
+				group.Docs = append(group.Docs, doc)
+			}
+			host.Groups = append(host.Groups, group)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
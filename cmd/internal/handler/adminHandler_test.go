@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdminCheckPassword(t *testing.T) {
+	adminLogins.mu.Lock()
+	adminLogins.wait = map[string]time.Time{}
+	adminLogins.mu.Unlock()
+
+	checked := 0
+	check := func(ok bool) func() bool {
+		return func() bool {
+			checked++
+			return ok
+		}
+	}
+	steps := []struct {
+		name    string
+		remote  string
+		valid   bool
+		want    bool
+		checked bool
+	}{
+		{name: "wrong password", remote: "192.0.2.1:1000", valid: false, want: false, checked: true},
+		{name: "same host waits", remote: "192.0.2.1:1001", valid: true, want: false, checked: false},
+		{name: "other host is not blocked", remote: "192.0.2.2:1000", valid: true, want: true, checked: true},
+		{name: "address without port", remote: "192.0.2.3", valid: false, want: false, checked: true},
+		{name: "ipv6", remote: "[2001:db8::1]:1000", valid: true, want: true, checked: true},
+	}
+	for _, st := range steps {
+		before := checked
+		if got := adminCheckPassword(st.remote, check(st.valid)); got != st.want {
+			t.Errorf("%s: adminCheckPassword(%s) = %v, want %v", st.name, st.remote, got, st.want)
+		}
+		if got := checked > before; got != st.checked {
+			t.Errorf("%s: password checked = %v, want %v", st.name, got, st.checked)
+		}
+	}
+
+	// 待ち時間を過ぎれば、また検査する
+	adminLogins.mu.Lock()
+	adminLogins.wait["192.0.2.1"] = time.Now().Add(-time.Millisecond)
+	adminLogins.mu.Unlock()
+	if false == adminCheckPassword("192.0.2.1:1002", check(true)) { // nolint:gosimple
+		t.Error("login is refused after the delay")
+	}
+	adminLogins.mu.Lock()
+	defer adminLogins.mu.Unlock()
+	if _, ok := adminLogins.wait["192.0.2.1"]; ok {
+		t.Error("expired wait is left")
+	}
+}
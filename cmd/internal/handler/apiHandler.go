@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 
+	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 )
 
@@ -13,13 +14,14 @@ const (
 
 // APIHandler はwebapiリクエストを処理するハンドラです。
 // api は /api/ で始まるurlです。
+// 失敗した場合は状態コードと共に APIErrorHandler の JSON を返します。エラーコードの一覧は /api/errors です。
 // TODO まずは作り込み優先で検討はあと
 func APIHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
 	log := param.Logger()
 
 	// まずは POST であることが必須
 	if request.Method() != http.MethodPost {
-		ErrorHandler(writer, request, param, http.StatusForbidden)
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrForbidden, "post only"))
 		return
 	}
 
@@ -29,10 +31,10 @@ func APIHandler(writer common.ResponseProxy, request common.RequestProxy, param
 	// OSRF (Own Site Request Forgeries) トークン
 	token := request.GetHeader(CSRFTOKEN)
 	docHost := param.DocHost()
-	log.Infof("[%s] token from client:%s", docHost.Name(), token)
+	log.Debugf("[%s] token from client:%s", docHost.Name(), common.Redacted(token))
 	if docHost.Token() != token {
 		// 認証エラー
-		ErrorHandler(writer, request, param, http.StatusUnauthorized)
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrUnauthorized, "invalid token"))
 		return
 	}
 
@@ -43,10 +45,27 @@ func APIHandler(writer common.ResponseProxy, request common.RequestProxy, param
 	api := docHost.GetAPI()
 	res, err := api.Execute(jsonRequestStr)
 	if err != nil {
-		// 不正なリクエスト
-		ErrorHandler(writer, request, param, http.StatusBadRequest)
+		// 失敗した理由をエラーコードと共に返す
+		apiErr, ok := err.(*common.APIError)
+		if false == ok { // nolint:gosimple
+			apiErr = common.NewAPIError(common.ErrInternal, err.Error())
+		}
+		APIErrorHandler(writer, request, param, apiErr)
 		return
 	}
 	writer.WriteHeader(http.StatusOK)
 	writer.WriteContentsByte([]byte(res))
 }
+
+// APIErrorsHandler は webapi のエラーコードの一覧を返すハンドラです。
+// /api/errors は {"errors":[{"code":"コード", "status":状態コード, "description":"説明"}]} を返します。
+// ドキュメントの作者が参照するための情報なので、トークンは不要です。
+func APIErrorsHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	if request.Method() != http.MethodGet {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrForbidden, "get only"))
+		return
+	}
+	writer.SetHeader("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.WriteContentsByte([]byte(json.ToJSON(common.APIErrorCatalog(), true)))
+}
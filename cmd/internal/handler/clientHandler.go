@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// clientAPIVersion はクライアントライブラリが使う webapi のバージョンです。
+	clientAPIVersion = "2"
+)
+
+// clientScript は /api/client.js で配布するクライアントライブラリです。
+// __ZIPHTTPD_VERSION__ と __API_VERSION__ は配布時に置き換えます。
+//
+//	<script src="/api/client.js"></script>
+//	<script>
+//	const client = ZipHttpd.client({name: "memo"});
+//	client.write({key: "value"}).then(res => client.read(["key"]));
+//	client.subscribe(ev => console.log(ev.kind, ev.keys));
+//	</script>
+const clientScript = `/*
+ * ZipHttpd WebAPI client __ZIPHTTPD_VERSION__ (api version __API_VERSION__)
+ * generated by ziphttpd, served at /api/client.js
+ */
+(function (root) {
+	"use strict";
+
+	var VERSION = "__ZIPHTTPD_VERSION__";
+	var API_VERSION = "__API_VERSION__";
+	var TOKEN_KEY = "token";
+	var TOKEN_HEADER = "X-Requested-With";
+
+	// ZipHttpdError は webapi のエラー応答 {"error":{code,status,message,detail}} です。
+	function ZipHttpdError(code, status, message, detail) {
+		this.name = "ZipHttpdError";
+		this.code = code;
+		this.status = status;
+		this.message = message || code;
+		this.detail = detail;
+	}
+	ZipHttpdError.prototype = Object.create(Error.prototype);
+	ZipHttpdError.prototype.constructor = ZipHttpdError;
+
+	// ログインで保存されたトークンを返します。
+	function token() {
+		var names = ["sessionStorage", "localStorage"];
+		for (var i = 0; i < names.length; i++) {
+			try {
+				var value = root[names[i]] && root[names[i]].getItem(TOKEN_KEY);
+				if (value) {
+					return value;
+				}
+			} catch (e) {
+				// ストレージが使えない
+			}
+		}
+		return "";
+	}
+
+	// ログイン画面へ移動します。ログイン後は redirectto に戻ります。
+	function login(redirectTo) {
+		var form = document.createElement("form");
+		form.method = "POST";
+		form.action = "/login";
+		var input = document.createElement("input");
+		input.type = "hidden";
+		input.name = "redirectto";
+		input.value = redirectTo || location.href;
+		form.appendChild(input);
+		document.body.appendChild(form);
+		form.submit();
+	}
+
+	function sleep(ms) {
+		return new Promise(function (resolve) { setTimeout(resolve, ms); });
+	}
+
+	function assign(dst) {
+		for (var i = 1; i < arguments.length; i++) {
+			var src = arguments[i] || {};
+			for (var key in src) {
+				if (Object.prototype.hasOwnProperty.call(src, key) && src[key] !== undefined) {
+					dst[key] = src[key];
+				}
+			}
+		}
+		return dst;
+	}
+
+	// 応答を ZipHttpdError にします。
+	function toError(status, text) {
+		try {
+			var body = JSON.parse(text);
+			if (body && body.error) {
+				return new ZipHttpdError(body.error.code, body.error.status || status, body.error.message, body.error.detail);
+			}
+		} catch (e) {
+			// JSON でない応答
+		}
+		return new ZipHttpdError(status === 401 ? "unauthorized" : "internal_error", status, text || ("http " + status));
+	}
+
+	// 再試行してよいエラーであれば真を返します。
+	function retryable(err) {
+		return err.code === "network_error" || err.status >= 500;
+	}
+
+	// client はクライアントを作ります。
+	//	options.name       : 既定の名前空間
+	//	options.retries    : 通信エラーとサーバのエラーを再試行する回数 (既定 2)
+	//	options.retryDelay : 最初の再試行までのミリ秒 (既定 500、以降は倍)
+	//	options.autoLogin  : トークンが無いか一致しなければログイン画面へ移動する (既定 true)
+	function client(options) {
+		var opts = assign({ name: undefined, retries: 2, retryDelay: 500, autoLogin: true, endpoint: "/api/" }, options);
+
+		function send(request) {
+			var body = new URLSearchParams();
+			body.append("data", JSON.stringify(request));
+			var headers = {};
+			headers[TOKEN_HEADER] = token();
+			return fetch(opts.endpoint, {
+				method: "POST",
+				credentials: "same-origin",
+				headers: headers,
+				body: body
+			}).then(function (res) {
+				return res.text().then(function (text) {
+					if (res.ok) {
+						return text === "" ? null : JSON.parse(text);
+					}
+					throw toError(res.status, text);
+				});
+			}, function (e) {
+				throw new ZipHttpdError("network_error", 0, String(e && e.message || e));
+			});
+		}
+
+		// call は api を実行して結果を返す Promise です。失敗すると ZipHttpdError で reject します。
+		function call(api, params, callOptions) {
+			var co = assign({}, opts, callOptions);
+			var request = assign({ version: co.version || API_VERSION, api: api }, co.name !== undefined ? { name: co.name } : {}, params);
+			var attempt = 0;
+			function run() {
+				return send(request).catch(function (err) {
+					if (err.code === "unauthorized" && co.autoLogin) {
+						login();
+					}
+					if (retryable(err) && attempt < co.retries) {
+						var delay = co.retryDelay * Math.pow(2, attempt);
+						attempt++;
+						return sleep(delay).then(run);
+					}
+					throw err;
+				});
+			}
+			return run();
+		}
+
+		// subscribe は変更通知を購読します。EventSource が使えなければ null を返します。
+		//	handler(event)    : {id, kind ("write"/"delete"/"expire"/"reset"), name, keys}
+		//	subOptions.names  : 名前空間の配列 (既定は options.name)
+		//	subOptions.prefix : キーの接頭辞
+		function subscribe(handler, subOptions) {
+			if (typeof root.EventSource === "undefined") {
+				return null;
+			}
+			var so = assign({}, subOptions);
+			var names = so.names || (opts.name !== undefined ? [opts.name] : []);
+			// EventSource はヘッダを付けられないので、トークンは通知の要求だけに送るクッキーで渡す
+			root.document.cookie = "ziphttpd_token=" + encodeURIComponent(token()) + "; path=" + opts.endpoint + "events; SameSite=Strict";
+			var query = new URLSearchParams();
+			for (var i = 0; i < names.length; i++) {
+				query.append("name", names[i]);
+			}
+			if (so.prefix) {
+				query.append("prefix", so.prefix);
+			}
+			if (so.lastEventId) {
+				query.append("lastEventId", so.lastEventId);
+			}
+			var source = new root.EventSource(opts.endpoint + "events?" + query.toString());
+			source.onmessage = function (ev) {
+				handler(JSON.parse(ev.data));
+			};
+			if (so.onerror) {
+				source.onerror = so.onerror;
+			}
+			return { close: function () { source.close(); } };
+		}
+
+		return {
+			version: VERSION,
+			apiVersion: API_VERSION,
+			call: call,
+			subscribe: subscribe,
+			login: login,
+			token: token,
+			noop: function (o) { return call("noop", {}, o); },
+			// list はキーを返します。paging を指定すると {keys, cursor} を返します。
+			list: function (paging, o) { return call("list", paging, o); },
+			// dirs は名前空間を返します。paging.name を指定するとその直下の名前空間を返します。
+			dirs: function (paging, o) { return call("dirs", paging, o); },
+			// read は {key:{value, rev, time, expires}} を返します。無いキーは null です。
+			read: function (keys, o) { return call("read", { items: keys }, o); },
+			// write は {key:値} か {key:{value, rev, ttl}} を書き込みます。o.ttl は全体の有効期限 (秒) です。
+			write: function (items, o) { return call("write", assign({ items: items }, o && o.ttl !== undefined ? { ttl: o.ttl } : {}), o); },
+			// remove はキーの配列か {key:期待する改訂番号} を削除します。
+			remove: function (keys, o) { return call("delete", { items: keys }, o); },
+			query: function (q, o) { return call("query", q, o); },
+			batch: function (ops, o) { return call("batch", { ops: ops }, o); },
+			backup: function (o) { return call("backup", {}, o); },
+			restore: function (data, mode, o) { return call("restore", { data: data, mode: mode || "merge" }, o); },
+			stats: function (o) { return call("stats", {}, o); },
+			// errors はエラーコードの一覧を返します。
+			errors: function () {
+				return fetch(opts.endpoint + "errors", { credentials: "same-origin" }).then(function (res) { return res.json(); });
+			}
+		};
+	}
+
+	root.ZipHttpd = {
+		version: VERSION,
+		apiVersion: API_VERSION,
+		Error: ZipHttpdError,
+		client: client,
+		login: login,
+		token: token
+	};
+})(typeof window !== "undefined" ? window : this);
+`
+
+// ClientHandler は webapi のクライアントライブラリを返すハンドラです。
+// /api/client.js はドキュメントから読み込むためのものなので、トークンは不要です。
+// ドキュメントのポートでのみ受け付けます。
+// ライブラリにはサーバのバージョンと webapi のバージョンが埋め込まれます。
+func ClientHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	if param.Server().Port() == param.ListenPort() {
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	if request.Method() != http.MethodGet {
+		ErrorHandler(writer, request, param, http.StatusForbidden)
+		return
+	}
+	script := strings.NewReplacer(
+		"__ZIPHTTPD_VERSION__", param.Version(),
+		"__API_VERSION__", clientAPIVersion,
+	).Replace(clientScript)
+
+	writer.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+	// バージョンアップに追従できるよう毎回確認させる
+	writer.SetHeader("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	writer.WriteContentsByte([]byte(script))
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"html/template"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
@@ -192,6 +193,12 @@ func DirHandler(writer common.ResponseProxy, request common.RequestProxy, param
 			}
 		} else {
 			// ■ ファイルのリスト
+			if false == doc.Acquire() { // nolint:gosimple
+				// 公開をやめたところだった
+				ErrorHandler(writer, request, param, http.StatusNotFound)
+				return
+			}
+			defer doc.Release()
 			// url上の親 (/docname/zip内のパス)
 			hostName := docHost.Name()
 			docGroupName := docGroup.Name()
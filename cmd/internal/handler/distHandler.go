@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	fpath "path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// DistHandler は publish で書き出した配布物に対するリクエストを処理するハンドラです。
+// dist は代表ポートの /dist/ で始まるurlです。
+func DistHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	distPath := param.Config().DistPath()
+	if distPath == "" {
+		// 公開しない設定
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	// 代表ポートでのみ公開
+	_, reqPort := SplitHost(request.Host())
+	if reqPort != strconv.Itoa(param.ListenPort()) {
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	if request.Method() != http.MethodGet {
+		ErrorHandler(writer, request, param, http.StatusForbidden)
+		return
+	}
+
+	// paths : [0]:"" / [1]:"dist" / [2]:{ホスト} / ...
+	filepath := strings.Join(param.Paths()[2:], "/")
+	base, _ := fpath.Abs(distPath)
+	file, _ := fpath.Abs(fpath.Join(base, filepath))
+	if false == strings.HasPrefix(file, base+string(fpath.Separator)) || false == common.FileExists(file) {
+		// トラバーサルされているか存在しない
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	reader, err := os.Open(file)
+	if err != nil {
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+
+	ct := param.Config().ContentType(file)
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	writer.SetHeader("Content-Type", ct)
+	writer.WriteHeader(http.StatusOK)
+	_, err = writer.WriteContents(reader)
+	if err != nil {
+		param.Logger().Warnf("io.Copy error : %+v", err)
+		// エラーの時に、http.Server の ConnState ハンドルが呼ばれず現接続数の計算でミスする
+		param.Server().ConnDone()
+	}
+}
@@ -37,6 +37,12 @@ func DocHandler(writer common.ResponseProxy, request common.RequestProxy, param
 	docHostName := param.DocHost().Name()
 	docGroupName := param.DocGroup().Name()
 	doc := param.DocData()
+	if false == doc.Acquire() { // nolint:gosimple
+		// 公開をやめたところだった
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	defer doc.Release()
 	docID := doc.DocID()
 	// ファイルのパス
 	filepath := strings.Join(param.Paths()[4:], "/")
@@ -56,7 +62,7 @@ func DocHandler(writer common.ResponseProxy, request common.RequestProxy, param
 	if reader == nil {
 		// 通常にzipから取得
 		zipdic := doc.ZipDic()
-		if !zipdic.Contains(filepath) {
+		if zipdic == nil || !zipdic.Contains(filepath) {
 			ErrorHandler(writer, request, param, http.StatusNotFound)
 			return
 		}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"github.com/xorvercom/util/pkg/calledcheck"
+	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 )
 
@@ -10,3 +11,12 @@ func ErrorHandler(writer common.ResponseProxy, request common.RequestProxy, para
 	param.Logger().Warnf("errorcode: %d (calledby:%s)", errorcode, calledcheck.GetCallerPC().String())
 	writer.WriteHeader(errorcode)
 }
+
+// APIErrorHandler は webapi のエラーを JSON で返すハンドラです。
+// 本文は {"error":{"code":"コード", "status":状態コード, "message":"理由", "detail":{...}}} です。
+func APIErrorHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param, apiErr *common.APIError) {
+	param.Logger().Warnf("errorcode: %d %s (calledby:%s)", apiErr.Status, apiErr.Error(), calledcheck.GetCallerPC().String())
+	writer.SetHeader("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(apiErr.Status)
+	writer.WriteContentsByte([]byte(json.ToJSON(apiErr.JSON(), false)))
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 接続を保つためのコメントを送る間隔
+	eventsKeepAlive = 30 * time.Second
+)
+
+// EventsHandler は webapi の変更通知を Server-Sent Events で送るハンドラです。
+// /api/events?name={名前空間}&prefix={キーの接頭辞}
+// EventSource はヘッダを付けられないので、トークンは ziphttpd_token クッキーで送ります。
+// 古いクライアントのために token パラメータも認めますが、ログでは値を伏せます。
+// name は複数指定でき、指定しなければ全ての名前空間を通知します。
+// 再接続時は Last-Event-ID (または lastEventId パラメータ) の続きから通知し、
+// 続きが無ければ reset を通知するので、クライアントは全て読み直します。
+func EventsHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	log := param.Logger()
+
+	// EventSource は GET しかできない
+	if request.Method() != http.MethodGet {
+		ErrorHandler(writer, request, param, http.StatusForbidden)
+		return
+	}
+
+	token, _ := streamToken(request)
+	docHost := param.DocHost()
+	if docHost.Token() != token {
+		// 認証エラー
+		ErrorHandler(writer, request, param, http.StatusUnauthorized)
+		return
+	}
+
+	// 絞り込み
+	names := request.Request().Form["name"]
+	prefix := request.GetForm("prefix")
+	lastID := request.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = request.GetForm("lastEventId")
+	}
+	lastEventID, _ := strconv.ParseInt(lastID, 10, 64)
+
+	sub := docHost.GetAPI().Subscribe(names, prefix, lastEventID)
+	defer sub.Close()
+	log.Infof("[%s] events start %v %s %d", docHost.Name(), names, prefix, lastEventID)
+
+	writer.SetHeader("Content-Type", "text/event-stream")
+	writer.SetHeader("Cache-Control", "no-cache")
+	writer.SetHeader("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	writer.WriteContentsByte([]byte(": connected\n\n"))
+	writer.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	done := request.Request().Context().Done()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if false == ok { // nolint:gosimple
+				// 受け取りが遅れて打ち切られたか終了した。クライアントは続きから再接続する。
+				log.Infof("[%s] events closed", docHost.Name())
+				return
+			}
+			if _, err := writer.WriteContentsByte(eventBytes(ev)); err != nil {
+				return
+			}
+			writer.Flush()
+		case <-ticker.C:
+			if _, err := writer.WriteContentsByte([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			writer.Flush()
+		case <-done:
+			// クライアントが切断した
+			log.Infof("[%s] events disconnected", docHost.Name())
+			return
+		}
+	}
+}
+
+// eventBytes は通知を SSE の形式にします。
+func eventBytes(ev *common.APIEvent) []byte {
+	return []byte(fmt.Sprintf("id: %d\ndata: %s\n\n", ev.ID, json.ToJSON(eventElem(ev), false)))
+}
+
+// eventElem は通知を JSON にします。ID は数値の精度を超えないよう文字列にします。
+func eventElem(ev *common.APIEvent) json.ElemObject {
+	id := strconv.FormatInt(ev.ID, 10)
+	keys := json.NewElemArray()
+	for _, key := range ev.Keys {
+		keys.Append(json.NewElemString(key))
+	}
+	data := json.NewElemObject()
+	data.Put("id", json.NewElemString(id))
+	data.Put("kind", json.NewElemString(ev.Kind))
+	data.Put("name", json.NewElemString(ev.Name))
+	data.Put("keys", keys)
+	return data
+}
@@ -69,6 +69,11 @@ func FilesHandler(writer common.ResponseProxy, request common.RequestProxy, para
 		docHost := conf.DocHost(paths[2])
 		docGroup := docHost.Get(paths[3])
 		doc := docGroup.Get(paths[4])
+		if doc == nil || false == doc.Acquire() { // nolint:gosimple
+			ErrorHandler(writer, request, param, http.StatusNotFound)
+			return
+		}
+		defer doc.Release()
 		hostName := docHost.Name()
 		docGroupName := docGroup.Name()
 		docName := doc.DocID()
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// 起動した時刻
+var startTime = time.Now()
+
+// HealthzHandler はプロセスが応答できることを返すハンドラです。
+// GET /healthz
+// 待ち受けポートでのみ受け付けます。
+func HealthzHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	if param.Server().Port() != param.ListenPort() {
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	res := json.NewElemObject()
+	res.Put("status", json.NewElemString("ok"))
+	res.Put("pid", json.NewElemFloat(float64(os.Getpid())))
+	res.Put("version", json.NewElemString(param.Version()))
+	res.Put("uptime", json.NewElemFloat(time.Since(startTime).Truncate(time.Second).Seconds()))
+	writeHealth(writer, http.StatusOK, res)
+}
+
+// ReadyzHandler は要求を処理できる状態かを検査して返すハンドラです。
+// GET /readyz
+// 待ち受けポートでのみ受け付けます。全ての検査に通れば 200、そうでなければ 503 を返します。
+//
+//	{"status":"ok"|"fail", "checks":{"config":{...}, "listeners":{...}, "store":{...}, "api":{...}}}
+func ReadyzHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	if param.Server().Port() != param.ListenPort() {
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	conf := param.Config()
+	ready := true
+	checks := json.NewElemObject()
+	check := func(name string, ok bool, detail json.ElemObject) {
+		detail.Put("ok", json.NewElemBool(ok))
+		checks.Put(name, detail)
+		ready = ready && ok
+	}
+
+	// 設定
+	detail := json.NewElemObject()
+	detail.Put("path", json.NewElemString(conf.ConfigPath()))
+	detail.Put("hosts", json.NewElemFloat(float64(len(conf.PortMan().HostNames()))))
+	check("config", true, detail)
+
+	// ホストごとの待ち受け
+	ok := true
+	detail = json.NewElemObject()
+	for _, host := range conf.PortMan().HostNames() {
+		listening := conf.PortMan().Listening(host)
+		detail.Put(host, json.NewElemBool(listening))
+		ok = ok && listening
+	}
+	check("listeners", ok, detail)
+
+	// store のドキュメントのカタログと署名
+	detail = json.NewElemObject()
+	errs := json.NewElemArray()
+	for _, mes := range conf.StoreErrors() {
+		errs.Append(json.NewElemString(mes))
+	}
+	detail.Put("errors", errs)
+	check("store", errs.Size() == 0, detail)
+
+	// WebAPI のバックグラウンド処理
+	ok = true
+	detail = json.NewElemObject()
+	for _, host := range conf.PortMan().HostNames() {
+		docHost := conf.DocHost(host)
+		if docHost == nil || docHost.GetAPI() == nil {
+			continue
+		}
+		alive := docHost.GetAPI().Alive()
+		detail.Put(host, json.NewElemBool(alive))
+		ok = ok && alive
+	}
+	check("api", ok, detail)
+
+	res := json.NewElemObject()
+	status := http.StatusOK
+	if ready {
+		res.Put("status", json.NewElemString("ok"))
+	} else {
+		res.Put("status", json.NewElemString("fail"))
+		status = http.StatusServiceUnavailable
+	}
+	res.Put("checks", checks)
+	writeHealth(writer, status, res)
+}
+
+// writeHealth は検査の結果を JSON で返します。
+func writeHealth(writer common.ResponseProxy, status int, res json.Element) {
+	writer.SetHeader("Content-Type", "application/json; charset=utf-8")
+	writer.SetHeader("Cache-Control", "no-cache")
+	writer.WriteHeader(status)
+	writer.WriteContentsByte([]byte(json.ToJSON(res, false)))
+}
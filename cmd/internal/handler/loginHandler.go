@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
 )
 
 var logintmpl *template.Template
@@ -149,6 +150,7 @@ func LoginHandler(writer common.ResponseProxy, request common.RequestProxy, para
 		if sec.IsValid(hostName, password) {
 			token = sec.Token(hostName)
 		} else {
+			metrics.LoginFailures.Inc(hostName)
 			// 第三者によってパスワードが試行されている可能性があるので、間違ったパスワードにはディレイを入れる
 			time.Sleep(time.Duration(5) * time.Second)
 		}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
+)
+
+// MetricsContentType は Prometheus のテキスト形式の Content-Type です。
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler は計測値を Prometheus のテキスト形式で返すハンドラです。
+// GET /metrics
+// 管理用のポートを指定していなければ、待ち受けポートでのみ公開します。
+func MetricsHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	conf := param.Config().Metrics()
+	if false == conf.Enabled || conf.Port != 0 || param.Server().Port() != param.ListenPort() { // nolint:gosimple
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	if request.Method() != http.MethodGet {
+		ErrorHandler(writer, request, param, http.StatusMethodNotAllowed)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := metrics.Default.WriteText(buf); err != nil {
+		ErrorHandler(writer, request, param, http.StatusInternalServerError)
+		return
+	}
+	writer.SetHeader("Content-Type", MetricsContentType)
+	writer.SetHeader("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	writer.WriteContentsByte(buf.Bytes())
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// syncNonces は受け付けた同期の要求の使い捨ての値です。設定を読み直しても引き継ぎます。
+var syncNonces = common.NewSyncNonces()
+
+// SyncHandler は同期の相手からの要求を処理するハンドラです。
+// POST /sync/{ホスト名}
+// 待ち受けポートでのみ、共有の秘密が設定されている場合だけ受け付けます。
+// 要求と応答には共有の秘密で署名します。同じ使い捨ての値の要求は再送として拒みます。
+func SyncHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	conf := param.Config()
+	if param.Server().Port() != param.ListenPort() || conf.SyncSecret() == "" {
+		// 同期を受け付けていない
+		ErrorHandler(writer, request, param, http.StatusNotFound)
+		return
+	}
+	if request.Method() != http.MethodPost {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrForbidden, "post only"))
+		return
+	}
+	paths := param.Paths()
+	if len(paths) < 3 {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrUnknownAPI, "no host"))
+		return
+	}
+	host := paths[2]
+	docHost := conf.DocHost(host)
+	if docHost == nil || docHost.GetAPI() == nil {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrUnknownAPI, "unknown host"))
+		return
+	}
+
+	body, err := io.ReadAll(request.Request().Body)
+	if err != nil {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrBodyTooLarge, err.Error()))
+		return
+	}
+	nonce := request.GetHeader(common.SyncNonceHeader)
+	now := time.Now()
+	if false == common.SyncVerify(conf.SyncSecret(), request.GetHeader(common.SyncTimeHeader), nonce, request.GetHeader(common.SyncSignatureHeader), host, body, now) { // nolint:gosimple
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrUnauthorized, "invalid signature"))
+		return
+	}
+	if false == syncNonces.Use(nonce, now) { // nolint:gosimple
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrUnauthorized, "replayed request"))
+		return
+	}
+	elem, err := json.LoadFromJSONByte(body)
+	if err != nil {
+		APIErrorHandler(writer, request, param, common.NewAPIError(common.ErrInvalidRequest, "invalid json"))
+		return
+	}
+
+	res, err := docHost.GetAPI().Sync(elem)
+	if err != nil {
+		apiErr, ok := err.(*common.APIError)
+		if false == ok { // nolint:gosimple
+			apiErr = common.NewAPIError(common.ErrInternal, err.Error())
+		}
+		APIErrorHandler(writer, request, param, apiErr)
+		return
+	}
+
+	// 応答にも要求の使い捨ての値で署名する
+	data := []byte(json.ToJSON(res, false))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	writer.SetHeader("Content-Type", "application/json; charset=utf-8")
+	writer.SetHeader(common.SyncTimeHeader, timestamp)
+	writer.SetHeader(common.SyncSignatureHeader, common.SyncSign(conf.SyncSecret(), timestamp, nonce, host, data))
+	writer.WriteHeader(http.StatusOK)
+	writer.WriteContentsByte(data)
+}
@@ -13,6 +13,20 @@ import (
 
 var toptpl *template.Template
 
+type topversion struct {
+	// バージョン名
+	Name string
+	// URL
+	URL string
+}
+
+func (d *topversion) JSON() json.Element {
+	elem := json.NewElemObject()
+	elem.Put("Name", json.NewElemString(d.Name))
+	elem.Put("URL", json.NewElemString(d.URL))
+	return elem
+}
+
 type topdoc struct {
 	// ドキュメント名
 	Name string
@@ -24,6 +38,8 @@ type topdoc struct {
 	Path string
 	// URL
 	URL string
+	// 過去のバージョン
+	Versions []*topversion
 }
 
 func (d *topdoc) JSON() json.Element {
@@ -33,6 +49,11 @@ func (d *topdoc) JSON() json.Element {
 	elem.Put("Description", json.NewElemString(d.Description))
 	elem.Put("Path", json.NewElemString(d.Path))
 	elem.Put("URL", json.NewElemString(d.URL))
+	arr := json.NewElemArray()
+	for _, v := range d.Versions {
+		arr.Append(v.JSON())
+	}
+	elem.Put("Versions", arr)
 	return elem
 }
 
@@ -184,6 +205,10 @@ h3 {
 .indent {
 	margin-left: 2em;
 }
+.version {
+	font-size: x-small;
+	margin-left: 4px;
+}
 .description {
 	border: #C0C0C0 1px solid;
 	background-color: beige;
@@ -206,7 +231,7 @@ h3 {
 					{{if .Description}}<div class="description">{{.Description}}</div>{{end}}
 					<div class="indent">
 					{{range .Documents}}
-						<a href="{{.URL}}" title="{{.Path}}">{{.Title}}</a><br>
+						<a href="{{.URL}}" title="{{.Path}}">{{.Title}}</a>{{range .Versions}}<a class="version" href="{{.URL}}">@{{.Name}}</a>{{end}}<br>
 						{{if .Description}}<div class="description">{{.Description}}</div>{{end}}
 					{{end}}
 					</div>
@@ -337,6 +362,20 @@ func TopHandler(writer common.ResponseProxy, request common.RequestProxy, param
 					Title:       docTitle,
 					Description: docData.Description(),
 					Path:        docData.ZipPath(),
+					Versions:    []*topversion{},
+				}
+				if versions := docGroup.Versions(docid); len(versions) > 1 {
+					// 過去のバージョンがある
+					for _, v := range versions {
+						vid := docid + common.VersionSeparator + v
+						vdoc := docGroup.Get(vid)
+						if vdoc == nil {
+							continue
+						}
+						vstr := hostName + "/" + docGroupName + "/" + url.PathEscape(vid) + "/" + vdoc.DocRoot()
+						vurl, _ := url.Parse(vstr)
+						td.Versions = append(td.Versions, &topversion{Name: v, URL: baseurl.ResolveReference(vurl).String()})
+					}
 				}
 				logger.Info(td.String())
 				tmpParamPortGroup.Documents = append(tmpParamPortGroup.Documents, td)
@@ -1,7 +1,11 @@
 package handler
 
 import (
+	"encoding/base64"
 	"net/url"
+	"strings"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 )
 
 // SplitHost は Host をアドレスとポートに分割します。
@@ -14,3 +18,38 @@ func SplitHost(host string) (hostname, port string) {
 	}
 	return hostname, port
 }
+
+const (
+	// tokenCookie はヘッダを付けられない EventSource がトークンを送るクッキーの名前です。
+	tokenCookie = "ziphttpd_token"
+	// tokenProtocol は WebSocket がトークンを送るサブプロトコルの接頭辞です。
+	// サブプロトコルに使えない文字を避けるため、トークンは base64url (パディング無し) にします。
+	tokenProtocol = "ziphttpd.token."
+)
+
+// streamToken は通知と WebSocket の要求のトークンを返します。
+// ヘッダ、クッキー、Sec-WebSocket-Protocol、token パラメータの順に探します。
+// token パラメータは URI に残るので、ログでは common.RedactURI で伏せます。
+// protocol はトークンを受け取ったサブプロトコルで、ハンドシェイクの応答で返します。
+func streamToken(request common.RequestProxy) (token common.Token, protocol string) {
+	if token = request.GetHeader(CSRFTOKEN); token != "" {
+		return token, ""
+	}
+	if cookie, err := request.Request().Cookie(tokenCookie); err == nil && cookie.Value != "" {
+		if value, err := url.QueryUnescape(cookie.Value); err == nil {
+			return value, ""
+		}
+	}
+	for _, p := range strings.Split(request.GetHeader("Sec-WebSocket-Protocol"), ",") {
+		p = strings.TrimSpace(p)
+		if false == strings.HasPrefix(p, tokenProtocol) { // nolint:gosimple
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(p[len(tokenProtocol):])
+		if err != nil {
+			continue
+		}
+		return base64.StdEncoding.EncodeToString(raw), p
+	}
+	return request.GetForm("token"), ""
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha1" // nolint:gosec
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// RFC 6455 の WebSocket のうち、サーバ側で必要な部分だけを実装します。
+
+const (
+	// ハンドシェイクでキーに連結する GUID
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// 受け付けるメッセージの既定の上限
+	wsMaxMessage = 4 * 1024 * 1024
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	// 正常終了
+	wsCloseNormal = 1000
+	// サーバの終了
+	wsCloseGoingAway = 1001
+	// 受け付けられないメッセージ
+	wsCloseProtocol = 1002
+	// 大きすぎるメッセージ
+	wsCloseTooBig = 1009
+)
+
+// wsConn は WebSocket の接続です。
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	// 受け付けるメッセージの上限
+	maxMessage uint64
+	// 書き込みは複数のゴルーチンから行うので排他する
+	wmu sync.Mutex
+}
+
+// wsAcceptKey は Sec-WebSocket-Key から Sec-WebSocket-Accept を作ります。
+func wsAcceptKey(key string) string {
+	h := sha1.New() // nolint:gosec
+	io.WriteString(h, key+wsGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains はカンマ区切りのヘッダ値に token が含まれれば真を返します。
+func headerContains(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// readMessage はテキストかバイナリのメッセージを一つ読みます。
+// 途中の ping には pong を返し、close を受け取ると io.EOF を返します。
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	message := []byte{}
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			// 相手の終了に応える
+			c.writeFrame(wsOpClose, payload)
+			return 0, nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if started {
+				c.writeClose(wsCloseProtocol, "unexpected data frame")
+				return 0, nil, fmt.Errorf("unexpected data frame")
+			}
+			started = true
+			opcode = op
+		case wsOpContinuation:
+			if false == started { // nolint:gosimple
+				c.writeClose(wsCloseProtocol, "unexpected continuation frame")
+				return 0, nil, fmt.Errorf("unexpected continuation frame")
+			}
+		default:
+			c.writeClose(wsCloseProtocol, "unknown opcode")
+			return 0, nil, fmt.Errorf("unknown opcode %d", op)
+		}
+		if uint64(len(message)+len(payload)) > c.maxMessage {
+			c.writeClose(wsCloseTooBig, "message too big")
+			return 0, nil, fmt.Errorf("message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame はフレームを一つ読みます。クライアントからのフレームはマスクされている必要があります。
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.rw, head); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	op := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if false == masked { // nolint:gosimple
+		c.writeClose(wsCloseProtocol, "frame must be masked")
+		return false, 0, nil, fmt.Errorf("frame not masked")
+	}
+	if length > c.maxMessage {
+		c.writeClose(wsCloseTooBig, "message too big")
+		return false, 0, nil, fmt.Errorf("frame too big %d", length)
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.rw, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame はフレームを一つ書きます。サーバからのフレームはマスクしません。
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	head := []byte{0x80 | op}
+	switch length := len(payload); {
+	case length < 126:
+		head = append(head, byte(length))
+	case length <= 0xffff:
+		head = append(head, 126, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+	}
+	if _, err := c.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// writeText はテキストメッセージを書きます。
+func (c *wsConn) writeText(text string) error {
+	return c.writeFrame(wsOpText, []byte(text))
+}
+
+// writeClose は終了コードと理由を付けて close フレームを書きます。
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(wsOpClose, payload)
+}
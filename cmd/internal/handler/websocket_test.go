@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// clientFrame はクライアントからのマスクしたフレームを返します。
+func clientFrame(fin bool, op byte, payload []byte) []byte {
+	head := op
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// newTestConn は in を読み、書いたフレームを out に残す wsConn を返します。
+func newTestConn(in []byte, maxMessage uint64) (*wsConn, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	rw := bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(in)), bufio.NewWriter(out))
+	return &wsConn{rw: rw, maxMessage: maxMessage}, out
+}
+
+func TestWsAcceptKey(t *testing.T) {
+	// RFC 6455 1.3 の例
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAcceptKey = %s", got)
+	}
+}
+
+func TestHeaderContains(t *testing.T) {
+	tests := []struct {
+		value, token string
+		want         bool
+	}{
+		{"Upgrade", "upgrade", true},
+		{"keep-alive, Upgrade", "upgrade", true},
+		{"keep-alive", "upgrade", false},
+		{"", "upgrade", false},
+		{"upgrades", "upgrade", false},
+	}
+	for _, tt := range tests {
+		if got := headerContains(tt.value, tt.token); got != tt.want {
+			t.Errorf("headerContains(%q, %q) = %v", tt.value, tt.token, got)
+		}
+	}
+}
+
+func TestWsReadMessage(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	huge := strings.Repeat("b", 70000)
+	tests := []struct {
+		name    string
+		frames  [][]byte
+		max     uint64
+		op      byte
+		message string
+		err     bool
+		eof     bool
+		// 応答のフレームの先頭 2 バイトと、close の場合の終了コード
+		reply     []byte
+		closeCode uint16
+	}{
+		{name: "text", frames: [][]byte{clientFrame(true, wsOpText, []byte("hello"))}, op: wsOpText, message: "hello"},
+		{name: "binary", frames: [][]byte{clientFrame(true, wsOpBinary, []byte{1, 2})}, op: wsOpBinary, message: "\x01\x02"},
+		{name: "empty", frames: [][]byte{clientFrame(true, wsOpText, nil)}, op: wsOpText, message: ""},
+		{name: "16 bit length", frames: [][]byte{clientFrame(true, wsOpText, []byte(long))}, op: wsOpText, message: long},
+		{name: "64 bit length", frames: [][]byte{clientFrame(true, wsOpText, []byte(huge))}, op: wsOpText, message: huge},
+		{name: "fragmented", frames: [][]byte{
+			clientFrame(false, wsOpText, []byte("hel")),
+			clientFrame(false, wsOpContinuation, []byte("l")),
+			clientFrame(true, wsOpContinuation, []byte("o")),
+		}, op: wsOpText, message: "hello"},
+		{name: "ping between fragments", frames: [][]byte{
+			clientFrame(false, wsOpText, []byte("hel")),
+			clientFrame(true, wsOpPing, []byte("p")),
+			clientFrame(true, wsOpContinuation, []byte("lo")),
+		}, op: wsOpText, message: "hello", reply: []byte{0x80 | wsOpPong, 1}},
+		{name: "pong ignored", frames: [][]byte{
+			clientFrame(true, wsOpPong, nil),
+			clientFrame(true, wsOpText, []byte("x")),
+		}, op: wsOpText, message: "x"},
+		{name: "close", frames: [][]byte{clientFrame(true, wsOpClose, []byte{0x03, 0xe8})}, eof: true, reply: []byte{0x80 | wsOpClose, 2}, closeCode: wsCloseNormal},
+		{name: "unmasked", frames: [][]byte{{0x80 | wsOpText, 1, 'a'}}, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseProtocol},
+		{name: "unexpected continuation", frames: [][]byte{clientFrame(true, wsOpContinuation, []byte("a"))}, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseProtocol},
+		{name: "data in fragments", frames: [][]byte{
+			clientFrame(false, wsOpText, []byte("a")),
+			clientFrame(true, wsOpText, []byte("b")),
+		}, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseProtocol},
+		{name: "unknown opcode", frames: [][]byte{clientFrame(true, 0x3, nil)}, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseProtocol},
+		{name: "frame too big", frames: [][]byte{clientFrame(true, wsOpText, []byte(long))}, max: 100, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseTooBig},
+		{name: "message too big", frames: [][]byte{
+			clientFrame(false, wsOpText, []byte(long[:80])),
+			clientFrame(true, wsOpContinuation, []byte(long[:80])),
+		}, max: 100, err: true, reply: []byte{0x80 | wsOpClose}, closeCode: wsCloseTooBig},
+		{name: "truncated", frames: [][]byte{clientFrame(true, wsOpText, []byte("hello"))[:8]}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			max := tt.max
+			if max == 0 {
+				max = wsMaxMessage
+			}
+			conn, out := newTestConn(bytes.Join(tt.frames, nil), max)
+			op, message, err := conn.readMessage()
+			switch {
+			case tt.eof:
+				if err != io.EOF {
+					t.Errorf("err = %v, want EOF", err)
+				}
+			case tt.err:
+				if err == nil || err == io.EOF {
+					t.Errorf("err = %v, want an error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if op != tt.op || string(message) != tt.message {
+					t.Errorf("readMessage = %d %q, want %d %q", op, message, tt.op, tt.message)
+				}
+			}
+			reply := out.Bytes()
+			if len(tt.reply) == 0 {
+				if len(reply) != 0 {
+					t.Errorf("unexpected reply % x", reply)
+				}
+				return
+			}
+			if false == bytes.HasPrefix(reply, tt.reply) { // nolint:gosimple
+				t.Errorf("reply % x, want prefix % x", reply, tt.reply)
+			}
+			if tt.closeCode != 0 && (len(reply) < 4 || binary.BigEndian.Uint16(reply[2:4]) != tt.closeCode) {
+				t.Errorf("reply % x, want close code %d", reply, tt.closeCode)
+			}
+		})
+	}
+}
+
+func TestWsWriteFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		head   []byte
+	}{
+		{name: "short", length: 5, head: []byte{0x80 | wsOpText, 5}},
+		{name: "125", length: 125, head: []byte{0x80 | wsOpText, 125}},
+		{name: "16 bit", length: 300, head: []byte{0x80 | wsOpText, 126, 0x01, 0x2c}},
+		{name: "64 bit", length: 70000, head: []byte{0x80 | wsOpText, 127, 0, 0, 0, 0, 0, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, out := newTestConn(nil, wsMaxMessage)
+			text := strings.Repeat("x", tt.length)
+			if err := conn.writeText(text); err != nil {
+				t.Fatal(err)
+			}
+			got := out.Bytes()
+			if false == bytes.Equal(got[:len(tt.head)], tt.head) { // nolint:gosimple
+				t.Errorf("head % x, want % x", got[:len(tt.head)], tt.head)
+			}
+			if string(got[len(tt.head):]) != text {
+				t.Errorf("payload is not written as is")
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 処理待ちにできる要求の数
+	wsQueueSize = 64
+)
+
+// WebSocketHandler は webapi を WebSocket で受け付けるハンドラです。
+// /api/ws?name={名前空間}&prefix={キーの接頭辞}&lastEventId={ID}
+// WebSocket はヘッダを付けられないので、トークンはサブプロトコル ziphttpd.token.{base64url のトークン} で送ります。
+//
+//	new WebSocket(url, ["ziphttpd.token." + btoa(token).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "")])
+//
+// 古いクライアントのために token パラメータも認めますが、ログでは値を伏せます。
+//
+// 要求は api の JSON に任意の id を付けたもので、受け取った順に処理して同じ id で応答します。
+//
+//	-> {"id":"1", "version":"1", "api":"read", "items":["key"]}
+//	<- {"id":"1", "ok":true, "result":{"key":"value"}}
+//	<- {"id":"2", "ok":false, "error":{"code":"unknown_api", "status":404, "message":"unknown api"}}
+//
+// 変更通知は /api/events と同じ絞り込みで {"event":{"id":"...", "kind":"write", "name":"", "keys":[...]}} を送ります。
+// トークンと Origin は APIHandler と同じく同じホストからの要求だけを認めます。
+func WebSocketHandler(writer common.ResponseProxy, request common.RequestProxy, param common.Param) {
+	log := param.Logger()
+
+	// ハンドシェイクの検査
+	if request.Method() != http.MethodGet ||
+		false == headerContains(request.GetHeader("Connection"), "upgrade") || // nolint:gosimple
+		false == headerContains(request.GetHeader("Upgrade"), "websocket") || // nolint:gosimple
+		request.GetHeader("Sec-WebSocket-Version") != "13" ||
+		request.GetHeader("Sec-WebSocket-Key") == "" {
+		ErrorHandler(writer, request, param, http.StatusBadRequest)
+		return
+	}
+
+	// クロスオリジンの禁止
+	if origin := request.GetHeader("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != request.Host() {
+			ErrorHandler(writer, request, param, http.StatusForbidden)
+			return
+		}
+	}
+
+	token, protocol := streamToken(request)
+	docHost := param.DocHost()
+	if docHost.Token() != token {
+		// 認証エラー
+		ErrorHandler(writer, request, param, http.StatusUnauthorized)
+		return
+	}
+
+	conn, rw, err := writer.Hijack()
+	if err != nil {
+		log.Warnf("[%s] websocket hijack : %v", docHost.Name(), err)
+		ErrorHandler(writer, request, param, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	ws := &wsConn{conn: conn, rw: rw, maxMessage: wsMaxMessage}
+	if limit := param.Config().APILimits(docHost.Name()).BodySize; limit > 0 {
+		// 要求の本文と同じ上限
+		ws.maxMessage = uint64(limit)
+	}
+
+	// ハンドシェイクの応答 (サブプロトコルを受け取ったら、選んだものを返さないとブラウザが切断する)
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n",
+		wsAcceptKey(request.GetHeader("Sec-WebSocket-Key")))
+	if protocol != "" {
+		fmt.Fprintf(rw, "Sec-WebSocket-Protocol: %s\r\n", protocol)
+	}
+	fmt.Fprint(rw, "\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	log.Infof("[%s] websocket start %s", docHost.Name(), request.RemoteAddr())
+
+	api := docHost.GetAPI()
+
+	// 変更通知
+	names := request.Request().Form["name"]
+	lastEventID, _ := strconv.ParseInt(request.GetForm("lastEventId"), 10, 64)
+	sub := api.Subscribe(names, request.GetForm("prefix"), lastEventID)
+	defer sub.Close()
+	go func() {
+		for ev := range sub.Events() {
+			msg := json.NewElemObject()
+			msg.Put("event", eventElem(ev))
+			if err := ws.writeText(json.ToJSON(msg, false)); err != nil {
+				conn.Close()
+				return
+			}
+		}
+		// 受け取りが遅れて打ち切られたか終了した。クライアントは lastEventId を付けて再接続する。
+		ws.writeClose(wsCloseGoingAway, "events closed")
+		conn.Close()
+	}()
+
+	// 要求は受け取った順に処理する
+	queue := make(chan []byte, wsQueueSize)
+	defer close(queue)
+	go func() {
+		for data := range queue {
+			if err := ws.writeText(wsExecute(api, data)); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		op, data, err := ws.readMessage()
+		if err != nil {
+			// close を受け取った場合は readMessage で応答済み
+			break
+		}
+		if op != wsOpText {
+			ws.writeClose(wsCloseProtocol, "text only")
+			break
+		}
+		queue <- data
+	}
+	log.Infof("[%s] websocket end %s", docHost.Name(), request.RemoteAddr())
+}
+
+// wsExecute は一つの要求を実行して応答を返します。
+func wsExecute(api common.API, data []byte) string {
+	ret := json.NewElemObject()
+	elem, err := json.LoadFromJSONByte(data)
+	if err != nil {
+		ret.Put("ok", json.NewElemBool(false))
+		ret.Put("error", common.NewAPIError(common.ErrInvalidRequest, "invalid json").JSON().Child("error"))
+		return json.ToJSON(ret, false)
+	}
+	if obj, ok := elem.AsObject(); ok && hasChild(obj, "id") {
+		ret.Put("id", obj.Child("id"))
+	}
+
+	res, err := api.Execute(string(data))
+	if err != nil {
+		// 失敗した理由をエラーコードと共に返す
+		apiErr, ok := err.(*common.APIError)
+		if false == ok { // nolint:gosimple
+			apiErr = common.NewAPIError(common.ErrInternal, err.Error())
+		}
+		ret.Put("ok", json.NewElemBool(false))
+		ret.Put("error", apiErr.JSON().Child("error"))
+		return json.ToJSON(ret, false)
+	}
+	result, err := json.LoadFromJSONByte([]byte(res))
+	if err != nil {
+		result = json.NewElemString(res)
+	}
+	ret.Put("ok", json.NewElemBool(true))
+	ret.Put("result", result)
+	return json.ToJSON(ret, false)
+}
+
+// hasChild はオブジェクトがキーを持てば真を返します。
+func hasChild(obj json.ElemObject, key string) bool {
+	for _, k := range obj.Keys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package httpd
+
+import (
+	"bufio"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// accessRecorder はアクセスログのために、応答の状態コードとバイト数を記録する ResponseProxy です。
+type accessRecorder struct {
+	writer common.ResponseProxy
+	// 要求を受け付けた時刻
+	start time.Time
+	// 状態コード、0 は未送信
+	status int
+	// 本文のバイト数
+	bytes int64
+}
+
+// newAccessRecorder はコンストラクタです。
+func newAccessRecorder(writer common.ResponseProxy) *accessRecorder {
+	return &accessRecorder{writer: writer, start: time.Now()}
+}
+
+// setStatus は最初に送った状態コードを記録します。
+func (r *accessRecorder) setStatus(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Redirect はリダイレクトを記録します。
+func (r *accessRecorder) Redirect(req common.RequestProxy, url string, code int) {
+	r.setStatus(code)
+	r.writer.Redirect(req, url, code)
+}
+
+// Error はエラーの応答を記録します。
+func (r *accessRecorder) Error(error string, code int) {
+	r.setStatus(code)
+	r.bytes += int64(len(error) + 1)
+	r.writer.Error(error, code)
+}
+
+// SetHeader はヘッダを設定します。
+func (r *accessRecorder) SetHeader(key string, value string) {
+	r.writer.SetHeader(key, value)
+}
+
+// WriteHeader は状態コードを記録します。
+func (r *accessRecorder) WriteHeader(statusCode int) {
+	r.setStatus(statusCode)
+	r.writer.WriteHeader(statusCode)
+}
+
+// WriteContents は本文のバイト数を記録します。
+func (r *accessRecorder) WriteContents(src io.Reader) (written int64, err error) {
+	r.setStatus(http.StatusOK)
+	written, err = r.writer.WriteContents(src)
+	r.bytes += written
+	return written, err
+}
+
+// WriteContentsByte は本文のバイト数を記録します。
+func (r *accessRecorder) WriteContentsByte(bytes []byte) (written int, err error) {
+	r.setStatus(http.StatusOK)
+	written, err = r.writer.WriteContentsByte(bytes)
+	r.bytes += int64(written)
+	return written, err
+}
+
+// ParseContents はテンプレートの出力のバイト数を記録します。
+func (r *accessRecorder) ParseContents(template *template.Template, data interface{}) error {
+	return template.Execute(writerFunc(r.WriteContentsByte), data)
+}
+
+// Flush は送信します。
+func (r *accessRecorder) Flush() {
+	r.writer.Flush()
+}
+
+// Hijack は接続を引き取ります。以降の通信は記録しません。
+func (r *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.setStatus(http.StatusSwitchingProtocols)
+	return r.writer.Hijack()
+}
+
+// entry はアクセスログの一行を返します。
+func (r *accessRecorder) entry(request common.RequestProxy, host common.HostName, doc string) *common.AccessEntry {
+	req := request.Request()
+	status := r.status
+	if status == 0 {
+		// 何も送らなければ net/http が 200 を返す
+		status = http.StatusOK
+	}
+	return &common.AccessEntry{
+		Time:       r.start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.Method,
+		URI:        req.RequestURI,
+		Proto:      req.Proto,
+		Status:     status,
+		Bytes:      r.bytes,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		Host:       host,
+		Doc:        doc,
+		Duration:   time.Since(r.start),
+	}
+}
+
+// writerFunc は関数を io.Writer にします。
+type writerFunc func(p []byte) (int, error)
+
+// Write は関数を呼び出します。
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,124 @@
+package httpd
+
+import (
+	"bufio"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// fakeResponse は送った内容を記録する ResponseProxy です。
+type fakeResponse struct {
+	status   int
+	body     []byte
+	redirect string
+}
+
+func (f *fakeResponse) Redirect(r common.RequestProxy, url string, code int) {
+	f.status, f.redirect = code, url
+}
+func (f *fakeResponse) Error(error string, code int) {
+	f.status = code
+	f.body = append(f.body, error+"\n"...)
+}
+func (f *fakeResponse) SetHeader(key string, value string) {}
+func (f *fakeResponse) WriteHeader(statusCode int)         { f.status = statusCode }
+func (f *fakeResponse) WriteContents(src io.Reader) (int64, error) {
+	b, err := io.ReadAll(src)
+	f.body = append(f.body, b...)
+	return int64(len(b)), err
+}
+func (f *fakeResponse) WriteContentsByte(bytes []byte) (int, error) {
+	f.body = append(f.body, bytes...)
+	return len(bytes), nil
+}
+func (f *fakeResponse) ParseContents(template *template.Template, data interface{}) error {
+	panic("accessRecorder must not delegate ParseContents")
+}
+func (f *fakeResponse) Flush() {}
+func (f *fakeResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func TestAccessRecorder(t *testing.T) {
+	page := template.Must(template.New("page").Parse("<p>{{.}}</p>"))
+	tests := []struct {
+		name   string
+		send   func(t *testing.T, rec *accessRecorder, req common.RequestProxy)
+		status int
+		bytes  int64
+	}{
+		{
+			name:   "default",
+			send:   func(t *testing.T, rec *accessRecorder, req common.RequestProxy) {},
+			status: http.StatusOK,
+		},
+		{
+			name: "error",
+			send: func(t *testing.T, rec *accessRecorder, req common.RequestProxy) {
+				rec.Error("not found", http.StatusNotFound)
+			},
+			status: http.StatusNotFound,
+			bytes:  int64(len("not found\n")),
+		},
+		{
+			name: "redirect",
+			send: func(t *testing.T, rec *accessRecorder, req common.RequestProxy) {
+				rec.Redirect(req, "/login", http.StatusFound)
+			},
+			status: http.StatusFound,
+		},
+		{
+			name: "parse contents",
+			send: func(t *testing.T, rec *accessRecorder, req common.RequestProxy) {
+				if err := rec.ParseContents(page, "a&b"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusOK,
+			bytes:  int64(len("<p>a&amp;b</p>")),
+		},
+		{
+			name: "first status wins",
+			send: func(t *testing.T, rec *accessRecorder, req common.RequestProxy) {
+				rec.WriteHeader(http.StatusTeapot)
+				rec.WriteContentsByte([]byte("abc"))
+				rec.Error("late", http.StatusInternalServerError)
+			},
+			status: http.StatusTeapot,
+			bytes:  int64(len("abc") + len("late\n")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &fakeResponse{}
+			rec := newAccessRecorder(w)
+			rec.start = time.Now().Add(-time.Second)
+			req := NewRequestProxy(httptest.NewRequest(http.MethodGet, "/docs/a?x=1", nil))
+			tt.send(t, rec, req)
+
+			entry := rec.entry(req, "localhost", "group/doc")
+			if entry.Status != tt.status {
+				t.Errorf("Status = %d, want %d", entry.Status, tt.status)
+			}
+			if entry.Bytes != tt.bytes {
+				t.Errorf("Bytes = %d, want %d", entry.Bytes, tt.bytes)
+			}
+			if int64(len(w.body)) != tt.bytes {
+				t.Errorf("sent %d bytes, recorded %d", len(w.body), tt.bytes)
+			}
+			if entry.Duration < time.Second {
+				t.Errorf("Duration = %v, want at least 1s", entry.Duration)
+			}
+			if entry.URI != "/docs/a?x=1" || entry.Host != "localhost" || entry.Doc != "group/doc" {
+				t.Errorf("entry = %+v", entry)
+			}
+		})
+	}
+}
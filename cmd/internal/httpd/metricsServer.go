@@ -0,0 +1,64 @@
+package httpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/handler"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
+)
+
+// MetricsServer は管理用のポートで計測値だけを公開するサーバです。
+type MetricsServer struct {
+	conf     common.Config
+	listener net.Listener
+	srv      *http.Server
+}
+
+// NewMetricsServer は管理用のポートを待ち受けます。
+func NewMetricsServer(conf common.Config) (*MetricsServer, error) {
+	port := conf.Metrics().Port
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("metrics port %d : %v", port, err)
+	}
+	m := &MetricsServer{conf: conf, listener: listener}
+	m.srv = &http.Server{Handler: m}
+	return m, nil
+}
+
+// Run はサーバを開始します。Close するまで戻りません。
+func (m *MetricsServer) Run() {
+	m.conf.Logger().Infof("run metrics server, port:%d", m.conf.Metrics().Port)
+	if err := m.srv.Serve(m.listener); err != nil && err != http.ErrServerClosed {
+		m.conf.Logger().Errorf("metrics server : %+v", err)
+	}
+}
+
+// Close はサーバを停止します。
+func (m *MetricsServer) Close() {
+	m.srv.Close()
+}
+
+// ServeHTTP は /metrics だけを返します。
+func (m *MetricsServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/metrics" {
+		http.NotFound(writer, request)
+		return
+	}
+	if request.Method != http.MethodGet {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := metrics.Default.WriteText(buf); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", handler.MetricsContentType)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Write(buf.Bytes())
+}
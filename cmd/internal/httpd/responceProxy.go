@@ -1,8 +1,11 @@
 package httpd
 
 import (
+	"bufio"
+	"fmt"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
 
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
@@ -51,3 +54,19 @@ func (i *wInst) WriteContentsByte(bytes []byte) (written int, err error) {
 func (i *wInst) ParseContents(template *template.Template, data interface{}) error {
 	return template.Execute(i.writer, data)
 }
+
+// (http.Flusher).Flush()
+func (i *wInst) Flush() {
+	if flusher, ok := i.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// (http.Hijacker).Hijack() (net.Conn, *bufio.ReadWriter, error)
+func (i *wInst) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := i.writer.(http.Hijacker)
+	if false == ok { // nolint:gosimple
+		return nil, nil, fmt.Errorf("hijack not supported")
+	}
+	return hijacker.Hijack()
+}
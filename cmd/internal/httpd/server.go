@@ -1,15 +1,18 @@
 package httpd
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/xorvercom/util/pkg/easywork"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 	"github.com/xorvercom/ziphttpd/cmd/internal/handler"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
 )
 
 type serv struct {
@@ -55,16 +58,18 @@ func (s *serv) Run() {
 		} else if st == http.StateIdle || st == http.StateHijacked {
 			s.ConnDone()
 		}
-		s.conf.Logger().Infof("ConState: %+v :  %+v : %d -> %d", s, st, pre, s.numberOfActive)
+		s.conf.Logger().Debugf("ConState: %+v :  %+v : %d -> %d", s, st, pre, s.numberOfActive)
 	}}
 
 	// ループ開始
 	var err error
 	err = srv.Serve(s.listener)
 	if err != nil {
-		s.conf.Logger().Warnf("server:%s : %+v", s.hostName, err)
+		s.conf.Logger().Errorf("server:%s : %+v", s.hostName, err)
 		//		panic(fmt.Errorf("web server error : %+v", err))
 	}
+	// 再読み込みの後に古い設定で応答しないように、待機中の接続を閉じる
+	srv.SetKeepAlivesEnabled(false)
 
 	// 抜けてきたら接続数が無くなるまで待つ
 	s.activeConWg.Wait()
@@ -74,12 +79,14 @@ func (s *serv) Run() {
 func (s *serv) ConnAdd() {
 	s.activeConWg.Add(1)
 	s.numberOfActive++
+	metrics.ActiveConnections.Set(float64(s.numberOfActive), s.hostName, strconv.Itoa(s.port))
 }
 
 // 接続数を減らします
 func (s *serv) ConnDone() {
 	s.activeConWg.Done()
 	s.numberOfActive--
+	metrics.ActiveConnections.Set(float64(s.numberOfActive), s.hostName, strconv.Itoa(s.port))
 }
 
 // ポート番号を返します
@@ -93,19 +100,68 @@ func (s *serv) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Access-Control-Allow-Headers", "*")
 	writer.Header().Set("Access-Control-Allow-Origin", "*")
 	writer.Header().Set("Access-Control-Allow-Methods", "GET")
+	// 要求の本文の上限、管理画面のアップロードはアーカイブの上限
+	limit := s.conf.APILimits(s.limitHost(request.URL.Path)).BodySize
+	if s.port == s.conf.ListenPort() && strings.EqualFold(request.URL.Path, "/admin/upload") {
+		limit = s.conf.AdminMan().MaxUpload()
+	}
+	if s.port == s.conf.ListenPort() && strings.HasPrefix(strings.ToLower(request.URL.Path), "/sync/") {
+		// 同期は全ての値を一度に送るので、Api とは別の上限
+		limit = s.conf.SyncMaxBody()
+	}
+	if limit > 0 {
+		request.Body = http.MaxBytesReader(writer, request.Body, limit)
+	}
 	s.ServeHTTPinner(NewResponseProxy(writer), NewRequestProxy(request))
 }
 
+// limitHost は要求の本文の上限を決めるホストを返します。
+// 代表ポートでは /{ホスト}/... の要求先のホストの上限を使います。
+func (s *serv) limitHost(urlpath string) common.HostName {
+	if s.port != s.conf.ListenPort() {
+		return s.hostName
+	}
+	host := strings.SplitN(strings.TrimPrefix(urlpath, "/"), "/", 2)[0]
+	if host != "" && s.conf.DocHost(host) != nil {
+		return host
+	}
+	return s.hostName
+}
+
 // テストの利便性から http.ResponseWriter などは隠ぺいした
 func (s *serv) ServeHTTPinner(writer common.ResponseProxy, request common.RequestProxy) {
 	conf := s.conf
 	log := conf.Logger()
-	log.Infof("%+v", request.Request())
+	if log.Level() <= common.LogDebug {
+		// 要求の全体はトークンやクッキーのヘッダを含むので、メソッドと値を伏せた URI だけを出力する
+		log.Debugf("request %s %s", request.Method(), common.RedactURI(request.RequestURI(), conf.LogPolicy(s.hostName).Redact))
+	}
 
-	request.ParseForm()
+	// アクセスログ
+	rec := newAccessRecorder(writer)
+	writer = rec
+	var p *param
+	defer func() {
+		s.accessLog(rec, request, p)
+	}()
+
+	var tooLarge *http.MaxBytesError
+	if err := request.ParseForm(); errors.As(err, &tooLarge) {
+		// 本文が上限を超えた
+		// 413 Request Entity Too Large
+		p := &param{conf: conf, paths: nil, server: s}
+		if strings.HasPrefix(strings.ToLower(request.URLPath()), "/api/") {
+			handler.APIErrorHandler(writer, request, p, common.NewAPIError(common.ErrBodyTooLarge, err.Error()))
+			return
+		}
+		handler.ErrorHandler(writer, request, p, http.StatusRequestEntityTooLarge)
+		return
+	}
 	switch request.Method() {
 	case "POST":
-		log.Infof("body:%+v", request.PostForm())
+		if policy := conf.LogPolicy(s.hostName); policy.Body {
+			log.Debugf("body:%s", common.RedactForm(request.PostForm(), policy.Redact))
+		}
 	case "OPTIONS":
 		// CORS プリフライト禁止、つまりクロスオリジンのアクセスは禁止
 		// 400 Bad Request
@@ -116,9 +172,9 @@ func (s *serv) ServeHTTPinner(writer common.ResponseProxy, request common.Reques
 
 	// パスのurlエンコーディング(%xxとか)の解除
 	urlpath, _ := url.QueryUnescape(request.URLPath())
-	log.Infof("access %s : %s", s.hostName, urlpath)
+	log.Debugf("access %s : %s", s.hostName, urlpath)
 	// パラメータ
-	p := &param{
+	p = &param{
 		conf:   conf,
 		paths:  strings.Split(strings.TrimSpace(urlpath), "/"),
 		server: s,
@@ -136,9 +192,49 @@ func (s *serv) ServeHTTPinner(writer common.ResponseProxy, request common.Reques
 		handler.AdHandler(writer, request, p)
 		return
 	case "api":
+		if len(p.paths) > 2 && strings.ToLower(p.paths[2]) == "events" {
+			// リクエストされたのはwebapiの変更通知だった
+			handler.EventsHandler(writer, request, p)
+			return
+		}
+		if len(p.paths) > 2 && strings.ToLower(p.paths[2]) == "client.js" {
+			// リクエストされたのはwebapiのクライアントライブラリだった
+			handler.ClientHandler(writer, request, p)
+			return
+		}
+		if len(p.paths) > 2 && strings.ToLower(p.paths[2]) == "errors" {
+			// リクエストされたのはwebapiのエラーコードの一覧だった
+			handler.APIErrorsHandler(writer, request, p)
+			return
+		}
+		if len(p.paths) > 2 && strings.ToLower(p.paths[2]) == "ws" {
+			// リクエストされたのはwebapiのWebSocketだった
+			handler.WebSocketHandler(writer, request, p)
+			return
+		}
 		// リクエストされたのはwebapiだった
 		handler.APIHandler(writer, request, p)
 		return
+	case "admin":
+		// リクエストされたのは管理画面だった
+		handler.AdminHandler(writer, request, p)
+		return
+	case "healthz":
+		// リクエストされたのはプロセスの死活だった
+		handler.HealthzHandler(writer, request, p)
+		return
+	case "readyz":
+		// リクエストされたのは要求を処理できるかだった
+		handler.ReadyzHandler(writer, request, p)
+		return
+	case "metrics":
+		// リクエストされたのは計測値だった
+		handler.MetricsHandler(writer, request, p)
+		return
+	case "sync":
+		// リクエストされたのは同期の相手からの要求だった
+		handler.SyncHandler(writer, request, p)
+		return
 	case "files":
 		// リクエストされたのはファイル一覧
 		handler.FilesHandler(writer, request, p)
@@ -150,6 +246,10 @@ func (s *serv) ServeHTTPinner(writer common.ResponseProxy, request common.Reques
 		// リクエストされたのはloginだった
 		handler.LoginHandler(writer, request, p)
 		return
+	case "dist":
+		// リクエストされたのは配布物だった
+		handler.DistHandler(writer, request, p)
+		return
 	case "favicon.ico":
 		// リクエストされたのはfavicon.icoだった
 		handler.FaviconHandler(writer, request, p)
@@ -183,3 +283,29 @@ func (s *serv) ServeHTTPinner(writer common.ResponseProxy, request common.Reques
 		handler.DocHandler(writer, request, p)
 	}
 }
+
+// accessLog はアクセスログを出力して、要求の計測値を集計します。
+// ドキュメントへの要求であれば、ホストとドキュメントも記録します。
+func (s *serv) accessLog(rec *accessRecorder, request common.RequestProxy, p *param) {
+	host := s.hostName
+	group, docID := "", ""
+	if p != nil {
+		if p.docHost != nil {
+			host = p.docHost.Name()
+		}
+		if p.docData != nil {
+			group, docID = p.docData.DocGroupName(), p.docData.DocID()
+		}
+	}
+	doc := ""
+	if docID != "" {
+		doc = group + "/" + docID
+	}
+	entry := rec.entry(request, host, doc)
+	entry.Redact = s.conf.LogPolicy(host).Redact
+	s.conf.AccessLogger().Access(entry)
+
+	metrics.HTTPRequests.Inc(host, group, docID, strconv.Itoa(entry.Status))
+	metrics.HTTPDuration.Observe(entry.Duration.Seconds(), host, group, docID)
+	metrics.HTTPBytes.Add(float64(entry.Bytes), host, group, docID)
+}
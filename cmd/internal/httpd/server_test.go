@@ -0,0 +1,89 @@
+package httpd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	testListenPort = 8823
+	testDocPort    = 8824
+	testVersion    = "9.8.7"
+)
+
+// testConfig は要求の振り分けが参照する設定だけを持つ設定です。
+type testConfig struct {
+	common.Config
+	log    common.Logger
+	access common.AccessLogger
+}
+
+func newTestConfig(t *testing.T) *testConfig {
+	dir := t.TempDir()
+	return &testConfig{log: common.NewLogger(dir), access: common.NewAccessLogger(dir)}
+}
+
+func (c *testConfig) Logger() common.Logger             { return c.log }
+func (c *testConfig) AccessLogger() common.AccessLogger { return c.access }
+func (c *testConfig) LogPolicy(host common.HostName) common.LogPolicy {
+	return common.LogPolicy{}
+}
+func (c *testConfig) ListenPort() int { return testListenPort }
+func (c *testConfig) Version() string { return testVersion }
+
+// serve は port で待ち受けるサーバに要求を渡して、応答を返します。
+func serve(t *testing.T, port int, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	s := &serv{conf: newTestConfig(t), port: port, hostName: "test"}
+	rec := httptest.NewRecorder()
+	s.ServeHTTPinner(NewResponseProxy(rec), NewRequestProxy(httptest.NewRequest(method, target, nil)))
+	return rec
+}
+
+func TestClientScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   int
+		method string
+		status int
+	}{
+		{name: "document port", port: testDocPort, method: http.MethodGet, status: http.StatusOK},
+		{name: "system port", port: testListenPort, method: http.MethodGet, status: http.StatusNotFound},
+		{name: "post", port: testDocPort, method: http.MethodPost, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.port, tt.method, "/api/client.js")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if rec.Body.Len() != 0 {
+					t.Errorf("body = %q, want empty", rec.Body.String())
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/javascript; charset=utf-8" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			body := rec.Body.String()
+			header := strings.SplitN(body, "\n", 3)
+			if len(header) < 2 || false == strings.Contains(header[1], "ZipHttpd WebAPI client "+testVersion+" (api version 2)") { // nolint:gosimple
+				t.Errorf("script header = %q", header)
+			}
+			if strings.Contains(body, "__ZIPHTTPD_VERSION__") || strings.Contains(body, "__API_VERSION__") {
+				t.Error("placeholder is left in the script")
+			}
+			if false == strings.Contains(body, `var VERSION = "`+testVersion+`";`) { // nolint:gosimple
+				t.Error("version is not embedded in the script")
+			}
+		})
+	}
+}
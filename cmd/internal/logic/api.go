@@ -6,6 +6,7 @@ import (
 
 	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
 )
 
 type api struct {
@@ -16,6 +17,12 @@ type api struct {
 	docGroupName string
 	// ストレージのパス
 	storagePath string
+	// ストレージ
+	storage storage
+	// 次に有効期限が切れる時刻 (UNIX ミリ秒)、0 は期限付きの項目なし
+	nextExpire int64
+	// 名前空間ごとの使用量、nil は未集計
+	usage map[string]*usage
 	// 要求のキュー先頭
 	first *apiParam
 	// 要求のキュー末尾
@@ -24,11 +31,14 @@ type api struct {
 	kick chan int
 	// 停止要求でキックされるチャネル
 	done chan int
-	// 状態通知
-	// TODO: デタッチできるように再検討
-	att []chan json.ElemObject
+	// 変更通知
+	events *eventHub
 	// 停止
 	terminated bool
+	// バックグラウンド処理が動いている
+	running bool
+	// 相手から同期されたことがある
+	synced bool
 }
 
 // apiParam は単一のAPI処理です。
@@ -37,9 +47,18 @@ type apiParam struct {
 	next   *apiParam
 	done   chan int
 	result json.Element
+	// 失敗した理由
+	err *common.APIError
+	// キューの中で実行する内部の処理、nil であれば elem の要求を実行します。
+	internal func() (json.Element, error)
+	// internal の計測値のラベル
+	method string
 }
 
-var apiinstance map[string]*api
+var (
+	apiinstance   map[string]*api
+	apiinstanceMu sync.Mutex
+)
 
 func init() {
 	apiinstance = map[string]*api{}
@@ -47,21 +66,32 @@ func init() {
 
 // GetApi は保存領域別の Api のシングルトンです。
 func GetApi(docGroupName, storagePath string, config common.Config) *api {
+	apiinstanceMu.Lock()
+	defer apiinstanceMu.Unlock()
 	if a, ok := apiinstance[storagePath]; ok {
 		return a
 	}
 
+	st, err := openHostStorage(config, docGroupName, storagePath)
+	if err != nil {
+		// 別の格納方式や平文で読み書きするとデータが分かれるので、全ての要求を失敗させる
+		config.Logger().Errorf("[%s] storage error, all API requests will fail : %+v", docGroupName, err)
+		st = &lockedStorage{err: err}
+	}
+
 	a := &api{
 		config:       config,
 		mu:           &sync.Mutex{},
 		docGroupName: docGroupName,
 		storagePath:  storagePath,
+		storage:      st,
 		first:        nil,
 		last:         nil,
 		kick:         make(chan int, 100),
 		done:         make(chan int),
-		att:          []chan json.ElemObject{},
+		events:       newEventHub(),
 		terminated:   false,
+		synced:       hasSyncBases(st),
 	}
 	apiinstance[storagePath] = a
 
@@ -72,15 +102,20 @@ func GetApi(docGroupName, storagePath string, config common.Config) *api {
 
 // Execute は API ロジックを同期実行する
 func (a *api) Execute(jsonRequestStr string) (string, error) {
-	log := a.config.Logger()
-	log.Infof("[%s] json:%s", a.docGroupName, jsonRequestStr)
 	if jsonRequestStr == "" {
 		// ログインチェックの空打ち時
 		return "", nil
 	}
 	requestElem, err := json.LoadFromJSONByte([]byte(jsonRequestStr))
 	if err != nil {
-		return "", err
+		a.config.Logger().Debugf("[%s] invalid json: %d bytes", a.docGroupName, len(jsonRequestStr))
+		return "", common.NewAPIError(common.ErrInvalidRequest, "invalid json")
+	}
+	a.config.Logger().Debugf("[%s] json:%s", a.docGroupName, a.logBody(requestElem))
+
+	// 外部コマンドは作業フォルダを共有するので、他の要求と同じくキューで順に実行する
+	if ext, apiMethod, ns, jsonObj, ok := a.extensionRequest(requestElem); ok {
+		return a.executeExtension(requestElem, ext, apiMethod, ns, jsonObj)
 	}
 
 	// 非同期実行
@@ -88,41 +123,108 @@ func (a *api) Execute(jsonRequestStr string) (string, error) {
 		elem: requestElem,
 		done: make(chan int),
 	}
-	a.push(param)
+	if false == a.push(param) { // nolint:gosimple
+		return "", param.err
+	}
 
 	// API 完了待ち
 	ret := <-param.done
 	if ret != 0 {
-		return "", fmt.Errorf("err")
+		if param.err != nil {
+			return "", param.err
+		}
+		return "", common.NewAPIError(common.ErrInternal, "api failed")
 	}
 
 	// 終了
 	return param.result.Text(), nil
 }
 
+// run は内部の処理 fn を要求のキューで実行します。
+// 他の要求と同時には実行しないので、fn の中ではストレージを読み書きできます。
+func (a *api) run(fn func() (json.Element, error)) (json.Element, error) {
+	return a.runParam(&apiParam{
+		elem:     json.NewElemNull(),
+		internal: fn,
+		method:   "internal",
+	})
+}
+
+// runParam は内部の処理の param を要求のキューで実行して、完了を待ちます。
+func (a *api) runParam(param *apiParam) (json.Element, error) {
+	param.done = make(chan int)
+	if false == a.push(param) { // nolint:gosimple
+		return nil, param.err
+	}
+
+	// 完了待ち
+	if ret := <-param.done; ret != 0 {
+		if param.err != nil {
+			return nil, param.err
+		}
+		return nil, common.NewAPIError(common.ErrInternal, "api failed")
+	}
+	return param.result, nil
+}
+
+// Alive はバックグラウンド処理が動いていれば真を返します。
+func (a *api) Alive() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running && false == a.terminated // nolint:gosimple
+}
+
 // Terminate はバックグラウンド処理を強制停止させます。
+// キューに残った要求は失敗させ、以降の要求は受け付けません。
+// ストレージを閉じてシングルトンから外すので、再読み込みの後の GetApi は開きなおします。
 func (a *api) Terminate() {
 	a.mu.Lock()
-	defer a.mu.Unlock()
+	if a.terminated {
+		a.mu.Unlock()
+		return
+	}
+	a.terminated = true
+	// バックグラウンド処理は pop でロックを取るので、解放してから停止を通知する
+	a.mu.Unlock()
 
-	if false == a.terminated {
-		a.terminated = true
-		a.done <- 0
+	// 受け取った後のバックグラウンド処理はストレージに触れない
+	a.done <- 0
+	// 停止までに積まれて実行されなかった要求を失敗させる
+	for param := a.pop(); param != nil; param = a.pop() {
+		failTerminated(param)
+		param.done <- -1
+	}
+	if err := a.storage.Close(); err != nil {
+		a.config.Logger().Warnf("[%s] storage close error : %v", a.docGroupName, err)
 	}
+	apiinstanceMu.Lock()
+	if apiinstance[a.storagePath] == a {
+		delete(apiinstance, a.storagePath)
+	}
+	apiinstanceMu.Unlock()
+	// 変更通知の購読を終了
+	a.events.close()
 }
 
 // push は要求を待ちキューにプッシュします。
-func (a *api) push(param *apiParam) {
+// 停止した後は積まずに param を失敗させて偽を返します。
+func (a *api) push(param *apiParam) bool {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.terminated {
+		failTerminated(param)
+		return false
+	}
 	if nil == a.first {
 		a.first = param
 	} else {
 		a.last.next = param
 	}
 	a.last = param
+	metrics.APIQueueDepth.Inc(a.docGroupName)
 	a.kick <- 1
+	return true
 }
 
 // pop は要求を待ちキューからポップします。
@@ -133,43 +235,132 @@ func (a *api) pop() *apiParam {
 	res := a.first
 	if nil != res {
 		a.first = res.next
+		metrics.APIQueueDepth.Dec(a.docGroupName)
 	}
 	return res
 }
 
-func (a *api) Attach() <-chan json.ElemObject {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// Subscribe はデータの変更通知を購読します。
+func (a *api) Subscribe(names []string, prefix string, lastEventID int64) common.APISubscription {
+	return a.events.subscribe(names, prefix, lastEventID)
+}
 
-	ch := make(chan json.ElemObject)
-	a.att = append(a.att, ch)
-	return ch
+// エラーログ
+// 要求を失敗させる理由をエラーコード code と共に記録します。
+func (a *api) sendError(param *apiParam, code, mes string) {
+	a.config.Logger().Warnf("[%s] %s %s : %s", a.docGroupName, code, mes, a.logBody(param.elem))
+	param.err = common.NewAPIError(code, mes)
 }
 
-func (a *api) fireEvent(data json.ElemObject) {
-	for _, att := range a.att {
-		att <- data
+// logBody はログに出力する要求の本文を返します。
+// 値を伏せる項目は伏せて、本文を出力しないホストでは大きさだけを返します。
+func (a *api) logBody(elem json.Element) string {
+	policy := a.config.LogPolicy(a.docGroupName)
+	if false == policy.Body { // nolint:gosimple
+		return fmt.Sprintf("(%d bytes)", len(elem.Text()))
 	}
+	return common.RedactJSON(elem, policy.Redact)
 }
 
-func (a *api) sendMessage(kind string, param *apiParam, mes string) {
-	event := json.NewElemObject()
-	event.Put("kind", json.NewElemString(kind))
-	event.Put("param", param.elem)
-	event.Put("data", json.NewElemString(mes))
-	a.fireEvent(event)
+// commit はトランザクションを反映して、次に有効期限が切れる時刻を更新します。
+func (a *api) commit(tx *transaction) error {
+	return a.commitEvent(tx, "")
 }
 
-func (a *api) sendArray(kind string, param *apiParam, arr []interface{}) {
-	event := json.NewElemObject()
-	event.Put("kind", json.NewElemString(kind))
-	event.Put("param", param.elem)
-	keyArray := json.Parse(arr)
-	event.Put("data", keyArray)
-	a.fireEvent(event)
+// commitEvent はトランザクションを反映して、変更を通知します。
+// kind が空文字列であれば操作ごとに write / delete を通知します。
+func (a *api) commitEvent(tx *transaction, kind string) error {
+	current, err := a.currentUsage()
+	if err != nil {
+		return err
+	}
+	delta, err := checkLimits(tx, current, a.config.APILimits(a.docGroupName))
+	if err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		// 反映できたか分からないので集計し直す
+		a.usage = nil
+		return err
+	}
+	applyUsage(current, delta)
+	if tx.nextExpire != 0 && (a.nextExpire == 0 || tx.nextExpire < a.nextExpire) {
+		a.nextExpire = tx.nextExpire
+	}
+
+	// 種類と名前空間ごとにまとめて通知
+	type group struct {
+		kind, ns string
+		keys     []string
+	}
+	groups := []*group{}
+	index := map[string]*group{}
+	for _, op := range tx.ops {
+		if isReservedNs(op.ns) {
+			continue
+		}
+		k := kind
+		if k == "" {
+			k = "write"
+			if op.op == "del" {
+				k = "delete"
+			}
+		}
+		g, ok := index[k+"\x00"+op.ns]
+		if false == ok { // nolint:gosimple
+			g = &group{kind: k, ns: op.ns, keys: []string{}}
+			index[k+"\x00"+op.ns] = g
+			groups = append(groups, g)
+		}
+		g.keys = append(g.keys, op.key)
+	}
+	for _, g := range groups {
+		a.events.publish(g.kind, g.ns, g.keys)
+	}
+	return nil
 }
 
-// エラーログ
-func (a *api) sendError(param *apiParam, mes string) {
-	a.sendMessage("error", param, mes)
+// currentUsage は使用量を返します。未集計であれば集計します。
+func (a *api) currentUsage() (map[string]*usage, error) {
+	if a.usage == nil {
+		current, err := loadUsage(a.storage)
+		if err != nil {
+			return nil, err
+		}
+		a.usage = current
+	}
+	return a.usage, nil
+}
+
+// failCommit は反映できなかった要求を終了させます。
+// 上限を超えた場合は、どの上限を超えたかを detail に返します。
+// それ以外は err が *common.APIError であればそのまま、そうでなければ code で失敗させます。
+func (a *api) failCommit(param *apiParam, code, mes string, err error) bool {
+	switch e := err.(type) {
+	case *limitError:
+		a.sendError(param, common.ErrLimitExceeded, e.Error())
+		param.err.Detail = e.elem()
+	case *common.APIError:
+		a.sendError(param, e.Code, e.Message)
+		param.err = e
+	default:
+		a.sendError(param, code, mes)
+	}
+	param.result = json.NewElemNull()
+	param.done <- -1
+	return false
+}
+
+// sweep は有効期限の過ぎた項目を削除します。
+func (a *api) sweep() {
+	count, next, err := sweepExpired(a)
+	if err != nil {
+		a.config.Logger().Errorf("[%s] expire error : %+v", a.docGroupName, err)
+		// 次の確認で再試行
+		return
+	}
+	if count != 0 {
+		a.config.Logger().Infof("[%s] expired %d items", a.docGroupName, count)
+	}
+	a.nextExpire = next
 }
@@ -0,0 +1,122 @@
+package logic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// testConfig は WebAPI が参照する設定だけを持つ設定です。
+type testConfig struct {
+	common.Config
+	log        common.Logger
+	limits     common.APILimits
+	extensions map[string]common.APIExtension
+	syncSecret string
+}
+
+func newTestConfig(t *testing.T) *testConfig {
+	return &testConfig{log: common.NewLogger(t.TempDir())}
+}
+
+func (c *testConfig) Logger() common.Logger { return c.log }
+func (c *testConfig) LogPolicy(host common.HostName) common.LogPolicy {
+	return common.LogPolicy{Body: true}
+}
+func (c *testConfig) APIStorage() string                              { return StorageSingle }
+func (c *testConfig) APILimits(host common.HostName) common.APILimits { return c.limits }
+func (c *testConfig) APIExtension(host common.HostName, name string) (common.APIExtension, bool) {
+	ext, ok := c.extensions[name]
+	return ext, ok
+}
+func (c *testConfig) APIEncryption(host common.HostName) (common.APIEncryption, bool) {
+	return common.APIEncryption{}, false
+}
+func (c *testConfig) SyncID() string               { return "test" }
+func (c *testConfig) SyncSecret() string           { return c.syncSecret }
+func (c *testConfig) SyncPeers() []common.SyncPeer { return nil }
+
+// newTestApi は一時フォルダを格納先とする WebAPI を開始します。
+func newTestApi(t *testing.T, conf *testConfig) *api {
+	t.Helper()
+	a := GetApi("test", t.TempDir(), conf)
+	t.Cleanup(a.Terminate)
+	return a
+}
+
+// execute は要求 request を実行して、結果の JSON を返します。
+func execute(t *testing.T, a *api, request string) string {
+	t.Helper()
+	res, err := a.Execute(request)
+	if err != nil {
+		t.Fatalf("Execute(%s) = %v", request, err)
+	}
+	return res
+}
+
+// apiErrorCode は err の WebAPI のエラーコードを返します。
+func apiErrorCode(err error) string {
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+func TestTerminate(t *testing.T) {
+	// バックグラウンド処理が停止の通知だけを受け取るようにして、要求をキューに残す
+	st, err := openSingleStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &api{
+		config:      newTestConfig(t),
+		mu:          &sync.Mutex{},
+		storagePath: t.TempDir(),
+		storage:     st,
+		kick:        make(chan int, 100),
+		done:        make(chan int),
+		events:      newEventHub(),
+	}
+	go func() { <-a.done }()
+
+	queued := make(chan error)
+	go func() {
+		_, err := a.Execute(`{"version": "1", "api": "keys"}`)
+		queued <- err
+	}()
+	for {
+		a.mu.Lock()
+		waiting := a.first != nil
+		a.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.Terminate()
+
+	select {
+	case err := <-queued:
+		if code := apiErrorCode(err); code != common.ErrUnavailable {
+			t.Errorf("queued request = %v, want %s", err, common.ErrUnavailable)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued request is not failed")
+	}
+
+	// 停止した後の要求はキューに積まずに失敗する
+	if _, err := a.Execute(`{"version": "1", "api": "keys"}`); apiErrorCode(err) != common.ErrUnavailable {
+		t.Errorf("Execute() = %v, want %s", err, common.ErrUnavailable)
+	}
+	if _, err := a.run(func() (json.Element, error) { return json.NewElemNull(), nil }); apiErrorCode(err) != common.ErrUnavailable {
+		t.Errorf("run() = %v, want %s", err, common.ErrUnavailable)
+	}
+	if a.first != nil {
+		t.Error("request is left in the queue")
+	}
+}
@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// StorageFile はキーごとに .txt ファイルを作る格納方式です。
+	StorageFile = "file"
+	// StorageSingle は単一ファイルにトランザクションを追記する格納方式です。
+	StorageSingle = "single"
+)
+
+// storage は WebAPI のデータの格納先です。
+// 名前空間 ns は api の name パラメータで、空文字列が既定の名前空間です。
+type storage interface {
+	// List は名前空間のキーの一覧を返します。
+	List(ns string) ([]string, error)
+	// Namespaces は名前空間の一覧を返します。
+	Namespaces() ([]string, error)
+	// Read はキーの値を返します。キーが無ければ ok が偽です。
+	Read(ns, key string) (value string, ok bool, err error)
+	// Commit は複数の名前空間にまたがる書き込みと削除を全てか無かで反映します。
+	Commit(ops []*storageOp) error
+	// Close は格納先を閉じます。
+	Close() error
+}
+
+// StorageKinds は格納方式の一覧を返します。
+func StorageKinds() []string {
+	return []string{StorageFile, StorageSingle}
+}
+
+// openStorage は格納方式 kind で folder を開きます。log は格納方式の内部の失敗を記録します (nil 可)。
+func openStorage(kind, folder string, log common.Logger) (storage, error) {
+	switch kind {
+	case StorageFile, "":
+		return openFileStorage(folder)
+	case StorageSingle:
+		s, err := openSingleStorage(folder)
+		if err != nil {
+			return nil, err
+		}
+		s.log = log
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown storage %s", kind)
+}
+
+// sortedKeys は items のキーを整列して返します。
+func sortedKeys(items map[string]string) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MigrateStorage は folder のデータを格納方式 from から to へ複写して、複写したキーの数を返します。
+// 複写元のデータは消去しません。
+func MigrateStorage(folder, from, to string) (int, error) {
+	if from == to {
+		return 0, fmt.Errorf("same storage %s", from)
+	}
+	src, err := openStorage(from, folder, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	dst, err := openStorage(to, folder, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	count := 0
+	namespaces, err := src.Namespaces()
+	if err != nil {
+		return count, err
+	}
+	for _, ns := range append([]string{""}, namespaces...) {
+		keys, err := src.List(ns)
+		if err != nil {
+			return count, err
+		}
+		ops := []*storageOp{}
+		for _, key := range keys {
+			value, ok, err := src.Read(ns, key)
+			if err != nil {
+				return count, err
+			}
+			if ok {
+				ops = append(ops, &storageOp{op: "put", ns: ns, key: key, value: value})
+			}
+		}
+		if err := dst.Commit(ops); err != nil {
+			return count, err
+		}
+		count += len(ops)
+	}
+	return count, nil
+}
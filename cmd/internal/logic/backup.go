@@ -0,0 +1,340 @@
+package logic
+
+import (
+	azip "archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// バックアップの目録
+	backupManifest = "manifest.json"
+	// バックアップの形式名
+	backupFormat = "ziphttpd-apidata"
+	// バックアップの形式の版
+	backupVersion = 1
+	// 値を格納するフォルダ
+	backupDataDir = "data/"
+	// 既定の名前空間のフォルダ名 (十六進にならない名前)
+	backupDefaultNs = "_"
+
+	// RestoreMerge は既存のデータを残してバックアップの値で上書きします。
+	RestoreMerge = "merge"
+	// RestoreReplace は既存のデータを全て消してバックアップの内容に置き換えます。
+	RestoreReplace = "replace"
+)
+
+// backupEntryName はアーカイブ内の値のパスを返します。
+func backupEntryName(ns, key string) string {
+	dir := backupDefaultNs
+	if ns != "" {
+		dir = key2filename(ns)
+	}
+	return backupDataDir + dir + "/" + key2filename(key)
+}
+
+// parseBackupEntryName はアーカイブ内の値のパスから名前空間とキーを戻します。
+func parseBackupEntryName(name string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(name, backupDataDir), "/")
+	if false == strings.HasPrefix(name, backupDataDir) || len(parts) != 2 { // nolint:gosimple
+		return "", "", fmt.Errorf("unknown entry %s", name)
+	}
+	ns, err := hex.DecodeString(parts[0])
+	if parts[0] == backupDefaultNs {
+		ns, err = []byte{}, nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("unknown entry %s", name)
+	}
+	key, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("unknown entry %s", name)
+	}
+	return string(ns), string(key), nil
+}
+
+// allNamespaces は既定の名前空間と付帯情報の名前空間を含む全ての名前空間を返します。
+func allNamespaces(st storage) ([]string, error) {
+	namespaces, err := st.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	res := []string{""}
+	for _, ns := range namespaces {
+		if ns != "" {
+			res = append(res, ns)
+		}
+	}
+	return res, nil
+}
+
+// writeBackup は格納先の全ての名前空間の値を目録付きの zip にして w に書き込み、値の数を返します。
+// 改訂番号や有効期限の付帯情報も名前空間として含めます。
+func writeBackup(st storage, host string, w io.Writer) (int, error) {
+	namespaces, err := allNamespaces(st)
+	if err != nil {
+		return 0, err
+	}
+
+	files := json.NewElemObject()
+	nsCounts := json.NewElemObject()
+	archive := azip.NewWriter(w)
+	count := 0
+	for _, ns := range namespaces {
+		keys, err := st.List(ns)
+		if err != nil {
+			return count, err
+		}
+		nsCount := 0
+		for _, key := range keys {
+			value, ok, err := st.Read(ns, key)
+			if err != nil {
+				return count, err
+			}
+			if false == ok { // nolint:gosimple
+				continue
+			}
+			name := backupEntryName(ns, key)
+			ent, err := archive.Create(name)
+			if err != nil {
+				return count, err
+			}
+			if _, err := ent.Write([]byte(value)); err != nil {
+				return count, err
+			}
+			sum := sha256.Sum256([]byte(value))
+			info := json.NewElemObject()
+			info.Put("size", json.NewElemFloat(float64(len(value))))
+			info.Put("sha256", json.NewElemString(hex.EncodeToString(sum[:])))
+			files.Put(name, info)
+			nsCount++
+			count++
+		}
+		if nsCount != 0 {
+			nsCounts.Put(ns, json.NewElemFloat(float64(nsCount)))
+		}
+	}
+
+	// 目録
+	manifest := json.NewElemObject()
+	manifest.Put("format", json.NewElemString(backupFormat))
+	manifest.Put("version", json.NewElemFloat(backupVersion))
+	manifest.Put("host", json.NewElemString(host))
+	manifest.Put("created", json.NewElemString(time.Now().Format(time.RFC3339)))
+	manifest.Put("namespaces", nsCounts)
+	manifest.Put("files", files)
+	ent, err := archive.Create(backupManifest)
+	if err != nil {
+		return count, err
+	}
+	if _, err := ent.Write([]byte(json.ToJSON(manifest, true))); err != nil {
+		return count, err
+	}
+	return count, archive.Close()
+}
+
+// readBackup はバックアップを検証して、名前空間 -> キー -> 値 を返します。
+// 目録が無い、形式が違う、目録と内容が一致しないアーカイブはエラーにします。
+// アーカイブ全体は読み込まず、目録を読んでから値を一つずつ読みます。
+func readBackup(r io.ReaderAt, size int64) (map[string]map[string]string, error) {
+	archive, err := azip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("broken backup : %v", err)
+	}
+	entries := map[string]*azip.File{}
+	for _, file := range archive.File {
+		entries[file.Name] = file
+	}
+
+	// 目録の確認
+	mfile, ok := entries[backupManifest]
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("no manifest in backup")
+	}
+	body, err := readBackupEntry(mfile, -1)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.LoadFromJSONByte(body)
+	if err != nil {
+		return nil, fmt.Errorf("broken manifest : %v", err)
+	}
+	if format, ok := json.QueryElemString(manifest, "format"); false == ok || format.Text() != backupFormat { // nolint:gosimple
+		return nil, fmt.Errorf("not api backup")
+	}
+	if version, ok := json.QueryElemFloat(manifest, "version"); false == ok || int(version.Float()) > backupVersion { // nolint:gosimple
+		return nil, fmt.Errorf("unsupported backup version")
+	}
+	files, ok := json.QueryElemObject(manifest, "files")
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("broken manifest : no files")
+	}
+	delete(entries, backupManifest)
+	listed := map[string]bool{}
+	for _, name := range files.Keys() {
+		if _, ok := entries[name]; false == ok { // nolint:gosimple
+			return nil, fmt.Errorf("missing %s in backup", name)
+		}
+		listed[name] = true
+	}
+	for name := range entries {
+		if false == listed[name] { // nolint:gosimple
+			return nil, fmt.Errorf("unlisted %s in backup", name)
+		}
+	}
+
+	// 目録と内容の照合
+	res := map[string]map[string]string{}
+	for _, name := range files.Keys() {
+		ns, key, err := parseBackupEntryName(name)
+		if err != nil {
+			return nil, err
+		}
+		limit := int64(-1)
+		if size, ok := json.QueryElemFloat(files.Child(name), "size"); ok {
+			limit = int64(size.Float())
+		}
+		body, err := readBackupEntry(entries[name], limit)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(body)
+		expect, _ := json.QueryElemString(files.Child(name), "sha256")
+		if expect == nil || expect.Text() != hex.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("checksum mismatch %s", name)
+		}
+		if _, ok := res[ns]; false == ok { // nolint:gosimple
+			res[ns] = map[string]string{}
+		}
+		res[ns][key] = string(body)
+	}
+	return res, nil
+}
+
+// readBackupEntry はアーカイブの一つのファイルを読みます。
+// limit が負でなければ、目録の大きさを超えて読まずにエラーにします。
+func readBackupEntry(file *azip.File, limit int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("broken backup %s : %v", file.Name, err)
+	}
+	defer reader.Close()
+	var src io.Reader = reader
+	if limit >= 0 {
+		src = io.LimitReader(reader, limit+1)
+	}
+	body, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("broken backup %s : %v", file.Name, err)
+	}
+	if limit >= 0 && int64(len(body)) > limit {
+		return nil, fmt.Errorf("checksum mismatch %s", file.Name)
+	}
+	return body, nil
+}
+
+// restoreBackup はバックアップを検証してトランザクションに復元の操作を積み、書き込む数と消す数を返します。
+func restoreBackup(tx *transaction, r io.ReaderAt, size int64, mode string) (int, int, error) {
+	if mode == "" {
+		mode = RestoreMerge
+	}
+	if mode != RestoreMerge && mode != RestoreReplace {
+		return 0, 0, fmt.Errorf("unknown restore mode %s", mode)
+	}
+	values, err := readBackup(r, size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleted := 0
+	if mode == RestoreReplace {
+		// バックアップに無い値を消す、同期するホストでは利用者の値に墓標を残す
+		namespaces, err := allNamespaces(tx.st)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, ns := range namespaces {
+			keys, err := tx.st.List(ns)
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, key := range keys {
+				if _, ok := values[ns][key]; ok {
+					continue
+				}
+				switch {
+				case false == isReservedNs(ns): // nolint:gosimple
+					tx.delItem(ns, key)
+					deleted++
+				case tx.tombstones && isMetaNs(ns):
+					// 消した値の墓標と以前からの墓標は残し、バックアップの値の古い付帯情報だけを消す
+					if _, ok := values[strings.TrimPrefix(ns, metaPrefix)][key]; ok {
+						tx.del(ns, key)
+					}
+				default:
+					tx.del(ns, key)
+				}
+			}
+		}
+	} else {
+		// 付帯情報の無い値で上書きする場合は古い付帯情報を消す
+		for ns, items := range values {
+			if isReservedNs(ns) {
+				continue
+			}
+			for key := range items {
+				if _, ok := values[metaNs(ns)][key]; false == ok { // nolint:gosimple
+					tx.del(metaNs(ns), key)
+				}
+			}
+		}
+	}
+
+	written := 0
+	for _, ns := range sortedNamespaces(values) {
+		for _, key := range sortedKeys(values[ns]) {
+			tx.put(ns, key, values[ns][key])
+			if false == isReservedNs(ns) { // nolint:gosimple
+				written++
+			}
+		}
+	}
+	// 有効期限は次の確認で反映させる
+	tx.nextExpire = tx.now
+	return written, deleted, nil
+}
+
+// BackupStorage はホストのデータをバックアップして w に書き込みます。
+// 暗号化したホストのデータも復号してバックアップします。
+func BackupStorage(config common.Config, host string, w io.Writer) (int, error) {
+	st, err := openHostStorage(config, host, config.APIPath(host))
+	if err != nil {
+		return 0, err
+	}
+	defer st.Close()
+	return writeBackup(st, host, w)
+}
+
+// RestoreStorage はホストのデータにバックアップを復元して、書き込んだ数と消した数を返します。
+// 復元は全てか無かで反映します。r はバックアップのアーカイブで、size はその大きさです。
+func RestoreStorage(config common.Config, host string, r io.ReaderAt, size int64, mode string) (int, int, error) {
+	st, err := openHostStorage(config, host, config.APIPath(host))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer st.Close()
+	tx := newTransaction(st)
+	tx.tombstones = syncingHost(config, host, hasSyncBases(st))
+	written, deleted, err := restoreBackup(tx, r, size, mode)
+	if err != nil {
+		return 0, 0, err
+	}
+	return written, deleted, tx.commit()
+}
@@ -0,0 +1,167 @@
+package logic
+
+import (
+	azip "archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	src, err := openFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Commit([]*storageOp{
+		{op: "put", ns: "", key: "k", value: "v"},
+		{op: "put", ns: "a/b", key: "x/y", value: "1"},
+		{op: "put", ns: metaNs("a/b"), key: "x/y", value: "{}"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	count, err := writeBackup(src, "host", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+
+	dst, err := openFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Commit([]*storageOp{{op: "put", ns: "old", key: "k", value: "gone"}}); err != nil {
+		t.Fatal(err)
+	}
+	tx := newTransaction(dst)
+	written, deleted, err := restoreBackup(tx, bytes.NewReader(buf.Bytes()), int64(buf.Len()), RestoreReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2 || deleted != 1 {
+		t.Errorf("written, deleted = %d, %d, want 2, 1", written, deleted)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+	assertStorage(t, dst, map[string]map[string]string{"": {"k": "v"}, "a/b": {"x/y": "1"}, "old": {}})
+}
+
+func TestReadBackupBroken(t *testing.T) {
+	var good bytes.Buffer
+	st, err := openFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "value"}})
+	if _, err := writeBackup(st, "host", &good); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite は good の各ファイルを change で書き換えたアーカイブを返します。
+	// change が偽を返したファイルは含めません。
+	rewrite := func(change func(name string, body []byte) ([]byte, bool), extra string) []byte {
+		r, _ := azip.NewReader(bytes.NewReader(good.Bytes()), int64(good.Len()))
+		var out bytes.Buffer
+		w := azip.NewWriter(&out)
+		for _, f := range r.File {
+			body, _ := readBackupEntry(f, -1)
+			body, ok := change(f.Name, body)
+			if false == ok { // nolint:gosimple
+				continue
+			}
+			ent, _ := w.Create(f.Name)
+			ent.Write(body)
+		}
+		if extra != "" {
+			ent, _ := w.Create(extra)
+			ent.Write([]byte("x"))
+		}
+		w.Close()
+		return out.Bytes()
+	}
+	valueName := backupEntryName("a", "k")
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "not zip", data: []byte("not a zip"), want: "broken backup"},
+		{name: "no manifest", data: rewrite(func(n string, b []byte) ([]byte, bool) { return b, n != backupManifest }, ""), want: "no manifest"},
+		{name: "missing", data: rewrite(func(n string, b []byte) ([]byte, bool) { return b, n != valueName }, ""), want: "missing"},
+		{name: "unlisted", data: rewrite(func(n string, b []byte) ([]byte, bool) { return b, true }, backupDataDir+"_/00"), want: "unlisted"},
+		{name: "tampered", data: rewrite(func(n string, b []byte) ([]byte, bool) {
+			if n == valueName {
+				return []byte("VALUE"), true
+			}
+			return b, true
+		}, ""), want: "checksum mismatch"},
+		{name: "longer", data: rewrite(func(n string, b []byte) ([]byte, bool) {
+			if n == valueName {
+				return append(b, "more"...), true
+			}
+			return b, true
+		}, ""), want: "checksum mismatch"},
+		{name: "format", data: rewrite(func(n string, b []byte) ([]byte, bool) {
+			if n == backupManifest {
+				return bytes.Replace(b, []byte(backupFormat), []byte("other"), 1), true
+			}
+			return b, true
+		}, ""), want: "not api backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readBackup(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err == nil || false == strings.Contains(err.Error(), tt.want) { // nolint:gosimple
+				t.Errorf("readBackup() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreReplaceTombstones(t *testing.T) {
+	tests := []struct {
+		name   string
+		synced bool
+	}{
+		{name: "synced", synced: true},
+		{name: "not synced", synced: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.syncSecret = "secret"
+			a := newTestApi(t, conf)
+			a.run(func() (json.Element, error) {
+				a.synced = tt.synced
+				return json.NewElemNull(), nil
+			})
+
+			execute(t, a, `{"version": "2", "api": "write", "name": "a", "items": {"k1": "v1"}}`)
+			backup := loadObject(t, execute(t, a, `{"version": "2", "api": "backup"}`))
+			execute(t, a, `{"version": "2", "api": "write", "name": "a", "items": {"k2": "v2"}}`)
+			res := loadObject(t, execute(t, a, `{"version": "2", "api": "restore", "mode": "replace", "data": "`+backup.Child("data").Text()+`"}`))
+			if got := res.Child("deleted").Text(); got != "1" {
+				t.Errorf("deleted = %s, want 1", got)
+			}
+
+			a.run(func() (json.Element, error) {
+				tx := newTransaction(a.storage)
+				if _, _, exists, _ := tx.getItem("a", "k2"); exists {
+					t.Error("k2 left after replace")
+				}
+				if _, _, exists, _ := tx.getItem("a", "k1"); false == exists { // nolint:gosimple
+					t.Error("k1 is not restored")
+				}
+				if got := tx.tombstone("a", "k2") != nil; got != tt.synced {
+					t.Errorf("tombstone = %v, want %v", got, tt.synced)
+				}
+				return json.NewElemNull(), nil
+			})
+		})
+	}
+}
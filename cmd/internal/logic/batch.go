@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// execBatch は batch API の ops を一つのトランザクションで実行します。
+//
+//	{"version":"1", "api":"batch", "name":"既定の名前空間", "ops":[
+//	  {"op":"check",  "name":"名前空間", "items":{"key":"期待する値", "key2":null, "key3":{"rev":3}}},
+//	  {"op":"read",   "items":["key"]},
+//	  {"op":"write",  "items":{"key":"value", "key2":{"json":["値"]}}},
+//	  {"op":"delete", "items":["key"]}
+//	]}
+//
+// check は値が期待する値と一致するか確認します。期待する値が {"rev":改訂番号} であれば改訂番号を、
+// それ以外の文字列でない値であればキーが無いことを確認します。
+// 操作は順に実行され、後の操作は前の書き込みと削除を反映した値を読みます。
+// check が一つでも失敗すると何も書き込まずに、エラーコード check_failed で detail に
+// {"failed":失敗した操作の番号} を返し、
+// 全て成功すると反映してから {"ok":true, "results":[操作ごとの結果]} を返します。
+func execBatch(a *api, defaultNs string, ops json.ElemArray) (json.Element, error) {
+	tx := a.newTx()
+	results := json.NewElemArray()
+	for idx := 0; idx < ops.Size(); idx++ {
+		opObj, ok := ops.Child(idx).AsObject()
+		if false == ok { // nolint:gosimple
+			return nil, fmt.Errorf("batch op %d must object", idx)
+		}
+		ns := defaultNs
+		if nameElem, ok := opObj.Child("name").AsString(); ok {
+			ns = nameElem.Text()
+		}
+		if isReservedNs(ns) {
+			return nil, common.NewAPIError(common.ErrInvalidParameter, fmt.Sprintf("batch op %d reserved name", idx))
+		}
+		opName := strings.ToLower(childText(opObj, "op"))
+		switch opName {
+		case "check":
+			items, ok := opObj.Child("items").AsObject()
+			if false == ok { // nolint:gosimple
+				return nil, fmt.Errorf("batch op %d check items must object", idx)
+			}
+			for _, key := range items.Keys() {
+				value, meta, exists, err := tx.getItem(ns, key)
+				if err != nil {
+					return nil, fmt.Errorf("batch op %d dont read", idx)
+				}
+				if expect, isString := items.Child(key).AsString(); isString {
+					ok = exists && false == meta.json && value == expect.Text() // nolint:gosimple
+				} else if rev, isRev := json.QueryElemFloat(items.Child(key), "rev"); isRev {
+					ok = revMatches(meta, exists, int64(rev.Float()))
+				} else {
+					ok = false == exists // nolint:gosimple
+				}
+				if false == ok { // nolint:gosimple
+					// 条件を満たさないので何も書き込まない
+					detail := json.NewElemObject()
+					detail.Put("failed", json.NewElemFloat(float64(idx)))
+					apiErr := common.NewAPIError(common.ErrCheckFailed, fmt.Sprintf("batch op %d check failed", idx))
+					apiErr.Detail = detail
+					return nil, apiErr
+				}
+			}
+			results.Append(json.NewElemBool(true))
+
+		case "read":
+			items, ok := opObj.Child("items").AsArray()
+			if false == ok { // nolint:gosimple
+				return nil, fmt.Errorf("batch op %d read items must array", idx)
+			}
+			ret := json.NewElemObject()
+			for i := 0; i < items.Size(); i++ {
+				key := items.Child(i).Text()
+				value, meta, _, err := tx.getItem(ns, key)
+				if err != nil {
+					return nil, fmt.Errorf("batch op %d dont read", idx)
+				}
+				ret.Put(key, valueElem(value, meta))
+			}
+			results.Append(ret)
+
+		case "write":
+			items, ok := opObj.Child("items").AsObject()
+			if false == ok { // nolint:gosimple
+				return nil, fmt.Errorf("batch op %d write items must object", idx)
+			}
+			ret := json.NewElemArray()
+			for _, key := range items.Keys() {
+				if _, err := tx.putItem(ns, key, items.Child(key), 0); err != nil {
+					return nil, fmt.Errorf("batch op %d dont write %v", idx, err)
+				}
+				ret.Append(json.NewElemString(key))
+			}
+			results.Append(ret)
+
+		case "delete":
+			items, ok := opObj.Child("items").AsArray()
+			if false == ok { // nolint:gosimple
+				return nil, fmt.Errorf("batch op %d delete items must array", idx)
+			}
+			ret := json.NewElemArray()
+			for i := 0; i < items.Size(); i++ {
+				key := items.Child(i).Text()
+				_, _, exists, err := tx.getItem(ns, key)
+				if err != nil {
+					return nil, fmt.Errorf("batch op %d dont delete", idx)
+				}
+				if exists {
+					tx.delItem(ns, key)
+					ret.Append(json.NewElemString(key))
+				}
+			}
+			results.Append(ret)
+
+		default:
+			return nil, fmt.Errorf("batch op %d unknown op %s", idx, opName)
+		}
+	}
+
+	// 全ての操作を反映
+	if err := a.commit(tx); err != nil {
+		if _, ok := err.(*limitError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("dont commit")
+	}
+	ret := json.NewElemObject()
+	ret.Put("ok", json.NewElemBool(true))
+	ret.Put("results", results)
+	return ret, nil
+}
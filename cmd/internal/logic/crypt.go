@@ -0,0 +1,517 @@
+package logic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	srand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 暗号化の情報を平文で置く名前空間
+	cryptNs = "\x00crypt"
+	// 暗号化の情報のキー
+	cryptParamsKey = "params"
+	// 暗号化の情報の形式
+	cryptFormat = "ziphttpd-apicrypt"
+	// 暗号化の情報の版
+	cryptVersion = 1
+	// 鍵の導出の繰り返し回数
+	cryptIterations = 100000
+	// 鍵の確認のために暗号化する文字列
+	cryptCheck = "ziphttpd"
+	// 導出する鍵の長さ (値の鍵、名前の鍵、名前の MAC の鍵)
+	cryptKeyLen = 32 * 3
+	// 名前の合成 IV の長さ
+	cryptNameIV = aes.BlockSize
+)
+
+// errKeyUnavailable は暗号化の鍵が使えないことを表します。
+var errKeyUnavailable = errors.New("encryption key unavailable")
+
+// cryptRand は塩と nonce を作る乱数です。
+var cryptRand io.Reader = srand.Reader
+
+// cryptStorage は名前空間とキーと値を暗号化して格納先に保存する格納方式です。
+//
+// 値は AES-GCM で暗号化し、名前空間とキーを追加データにして他の項目への付け替えを防ぎます。
+// 名前空間とキーは List と Read で引けるよう、平文の HMAC を IV とする AES-CTR で決定的に暗号化します。
+// 鍵はホストのパスワードか鍵ファイルから PBKDF2-SHA256 で導出し、塩と鍵の確認用の値を
+// 暗号化の情報として平文の名前空間に保存します。
+type cryptStorage struct {
+	base storage
+	// 塩
+	salt []byte
+	// 値の暗号
+	aead cipher.AEAD
+	// 名前の暗号
+	nameBlock cipher.Block
+	// 名前の MAC の鍵
+	nameMac []byte
+}
+
+// newCryptStorage は secret と salt から鍵を導出します。
+func newCryptStorage(base storage, secret, salt []byte) (*cryptStorage, error) {
+	dk := pbkdf2SHA256(secret, salt, cryptIterations, cryptKeyLen)
+	valueBlock, err := aes.NewCipher(dk[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(valueBlock)
+	if err != nil {
+		return nil, err
+	}
+	nameBlock, err := aes.NewCipher(dk[32:64])
+	if err != nil {
+		return nil, err
+	}
+	return &cryptStorage{base: base, salt: salt, aead: aead, nameBlock: nameBlock, nameMac: dk[64:]}, nil
+}
+
+// openCryptStorage は暗号化の情報を読みだして鍵を確認します。
+// 暗号化の情報が無ければ、既存の平文のデータを暗号化してから開きます。
+func openCryptStorage(base storage, secret []byte) (*cryptStorage, error) {
+	value, ok, err := base.Read(cryptNs, cryptParamsKey)
+	if err != nil {
+		return nil, err
+	}
+	if false == ok { // nolint:gosimple
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(cryptRand, salt); err != nil {
+			return nil, err
+		}
+		c, err := newCryptStorage(base, secret, salt)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := reencrypt(base, base, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	elem, err := json.LoadFromJSONByte([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("broken crypt params : %v", err)
+	}
+	params, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("broken crypt params")
+	}
+	if format, ok := json.QueryElemString(params, "format"); false == ok || format.Text() != cryptFormat { // nolint:gosimple
+		return nil, fmt.Errorf("broken crypt params")
+	}
+	if version, ok := json.QueryElemFloat(params, "version"); false == ok || int(version.Float()) != cryptVersion { // nolint:gosimple
+		return nil, fmt.Errorf("unsupported crypt version")
+	}
+	salt, err := base64.StdEncoding.DecodeString(childText(params, "salt"))
+	if err != nil {
+		return nil, fmt.Errorf("broken crypt params : %v", err)
+	}
+	c, err := newCryptStorage(base, secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := c.decValue(cryptNs, cryptParamsKey, childText(params, "check")); err != nil || check != cryptCheck {
+		return nil, fmt.Errorf("%w : key does not match", errKeyUnavailable)
+	}
+	return c, nil
+}
+
+// params は暗号化の情報を返します。
+func (c *cryptStorage) params() (string, error) {
+	check, err := c.encValue(cryptNs, cryptParamsKey, cryptCheck)
+	if err != nil {
+		return "", err
+	}
+	params := json.NewElemObject()
+	params.Put("format", json.NewElemString(cryptFormat))
+	params.Put("version", json.NewElemFloat(cryptVersion))
+	params.Put("kdf", json.NewElemString("pbkdf2-sha256"))
+	params.Put("iterations", json.NewElemFloat(cryptIterations))
+	params.Put("salt", json.NewElemString(base64.StdEncoding.EncodeToString(c.salt)))
+	params.Put("check", json.NewElemString(check))
+	return json.ToJSON(params, false), nil
+}
+
+// encName は名前空間かキーを暗号化します。既定の名前空間は空文字列のままにします。
+func (c *cryptStorage) encName(name string) string {
+	if name == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.nameMac)
+	mac.Write([]byte(name))
+	iv := mac.Sum(nil)[:cryptNameIV]
+	out := make([]byte, cryptNameIV+len(name))
+	copy(out, iv)
+	cipher.NewCTR(c.nameBlock, iv).XORKeyStream(out[cryptNameIV:], []byte(name))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// decName は名前空間かキーを復号します。
+func (c *cryptStorage) decName(enc string) (string, error) {
+	if enc == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || len(data) < cryptNameIV {
+		return "", fmt.Errorf("broken name")
+	}
+	iv := data[:cryptNameIV]
+	name := make([]byte, len(data)-cryptNameIV)
+	cipher.NewCTR(c.nameBlock, iv).XORKeyStream(name, data[cryptNameIV:])
+	mac := hmac.New(sha256.New, c.nameMac)
+	mac.Write(name)
+	if false == hmac.Equal(mac.Sum(nil)[:cryptNameIV], iv) { // nolint:gosimple
+		return "", fmt.Errorf("%w : name does not match", errKeyUnavailable)
+	}
+	return string(name), nil
+}
+
+// encValue は値を暗号化します。乱数を得られなければ失敗します。
+func (c *cryptStorage) encValue(ns, key, value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(cryptRand, nonce); err != nil {
+		return "", fmt.Errorf("error crypto/rand : %v", err)
+	}
+	out := c.aead.Seal(nonce, nonce, []byte(value), []byte(ns+"\x00"+key))
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// decValue は値を復号します。
+func (c *cryptStorage) decValue(ns, key, enc string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return "", fmt.Errorf("broken value %s", key)
+	}
+	size := c.aead.NonceSize()
+	value, err := c.aead.Open(nil, data[:size], data[size:], []byte(ns+"\x00"+key))
+	if err != nil {
+		return "", fmt.Errorf("%w : value does not match %s", errKeyUnavailable, key)
+	}
+	return string(value), nil
+}
+
+// List は名前空間のキーの一覧を返します。
+func (c *cryptStorage) List(ns string) ([]string, error) {
+	encKeys, err := c.base.List(c.encName(ns))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(encKeys))
+	for _, encKey := range encKeys {
+		key, err := c.decName(encKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Namespaces は名前空間の一覧を返します。暗号化の情報の名前空間は含めません。
+func (c *cryptStorage) Namespaces() ([]string, error) {
+	encNames, err := c.base.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, encName := range encNames {
+		if encName == "" || encName == cryptNs {
+			continue
+		}
+		name, err := c.decName(encName)
+		if err != nil {
+			// 名前空間でないフォルダ
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Read はキーの値を復号して返します。
+func (c *cryptStorage) Read(ns, key string) (string, bool, error) {
+	enc, ok, err := c.base.Read(c.encName(ns), c.encName(key))
+	if err != nil || false == ok { // nolint:gosimple
+		return "", ok, err
+	}
+	value, err := c.decValue(ns, key, enc)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Commit は操作を暗号化して反映します。
+func (c *cryptStorage) Commit(ops []*storageOp) error {
+	encOps := make([]*storageOp, 0, len(ops))
+	for _, op := range ops {
+		encOp := &storageOp{op: op.op, ns: c.encName(op.ns), key: c.encName(op.key)}
+		if op.op == "put" {
+			value, err := c.encValue(op.ns, op.key, op.value)
+			if err != nil {
+				return err
+			}
+			encOp.value = value
+		}
+		encOps = append(encOps, encOp)
+	}
+	return c.base.Commit(encOps)
+}
+
+// Close は格納先を閉じます。
+func (c *cryptStorage) Close() error {
+	return c.base.Close()
+}
+
+// reencrypt は from で読める全ての値を to で暗号化し直して base に書き込み、値の数を返します。
+// to が nil であれば平文で書き込みます。元の項目と暗号化の情報は一つのトランザクションで置き換えます。
+func reencrypt(base, from storage, to *cryptStorage) (int, error) {
+	namespaces, err := allNamespaces(from)
+	if err != nil {
+		return 0, err
+	}
+	puts := []*storageOp{}
+	for _, ns := range namespaces {
+		if ns == cryptNs {
+			continue
+		}
+		keys, err := from.List(ns)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			value, ok, err := from.Read(ns, key)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				puts = append(puts, &storageOp{op: "put", ns: ns, key: key, value: value})
+			}
+		}
+	}
+
+	// 書き込む先の名前
+	written := map[string]bool{}
+	for _, op := range puts {
+		ns, key := op.ns, op.key
+		if to != nil {
+			ns, key = to.encName(ns), to.encName(key)
+		}
+		written[ns+"\x00"+key] = true
+	}
+
+	// 元の項目を消す
+	ops := []*storageOp{}
+	rawNamespaces, err := allNamespaces(base)
+	if err != nil {
+		return 0, err
+	}
+	for _, ns := range rawNamespaces {
+		if ns == cryptNs {
+			continue
+		}
+		keys, err := base.List(ns)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			if false == written[ns+"\x00"+key] { // nolint:gosimple
+				ops = append(ops, &storageOp{op: "del", ns: ns, key: key})
+			}
+		}
+	}
+	// 新しい項目を書く
+	for _, op := range puts {
+		ns, key, value := op.ns, op.key, op.value
+		if to != nil {
+			enc, err := to.encValue(op.ns, op.key, op.value)
+			if err != nil {
+				return 0, err
+			}
+			ns, key, value = to.encName(op.ns), to.encName(op.key), enc
+		}
+		ops = append(ops, &storageOp{op: "put", ns: ns, key: key, value: value})
+	}
+	if to != nil {
+		params, err := to.params()
+		if err != nil {
+			return 0, err
+		}
+		ops = append(ops, &storageOp{op: "put", ns: cryptNs, key: cryptParamsKey, value: params})
+	} else {
+		ops = append(ops, &storageOp{op: "del", ns: cryptNs, key: cryptParamsKey})
+	}
+	return len(puts), base.Commit(ops)
+}
+
+// lockedStorage は鍵が使えないホストの格納先です。全ての操作が失敗します。
+type lockedStorage struct {
+	err error
+}
+
+// List は失敗します。
+func (l *lockedStorage) List(ns string) ([]string, error) {
+	return nil, l.err
+}
+
+// Namespaces は失敗します。
+func (l *lockedStorage) Namespaces() ([]string, error) {
+	return nil, l.err
+}
+
+// Read は失敗します。
+func (l *lockedStorage) Read(ns, key string) (string, bool, error) {
+	return "", false, l.err
+}
+
+// Commit は失敗します。
+func (l *lockedStorage) Commit(ops []*storageOp) error {
+	return l.err
+}
+
+// Close は何もしません。
+func (l *lockedStorage) Close() error {
+	return nil
+}
+
+// hostSecret はホストの暗号化の指定から鍵の元を返します。暗号化しないホストは enabled が偽です。
+func hostSecret(config common.Config, host string) (secret []byte, enabled bool, err error) {
+	enc, ok := config.APIEncryption(host)
+	if false == ok { // nolint:gosimple
+		return nil, false, nil
+	}
+	if enc.Invalid != "" {
+		return nil, true, fmt.Errorf("%w : %s", errKeyUnavailable, enc.Invalid)
+	}
+	if enc.KeyFile != "" {
+		data, err := os.ReadFile(enc.KeyFile)
+		if err != nil {
+			return nil, true, fmt.Errorf("%w : %v", errKeyUnavailable, err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return nil, true, fmt.Errorf("%w : empty keyfile %s", errKeyUnavailable, enc.KeyFile)
+		}
+		return data, true, nil
+	}
+	pass, ok := config.SecurityMan().Password(host)
+	if false == ok || pass == "" { // nolint:gosimple
+		return nil, true, fmt.Errorf("%w : no password for %s", errKeyUnavailable, host)
+	}
+	return []byte(pass), true, nil
+}
+
+// openHostStorage はホストの格納先を開きます。暗号化を指定したホストは復号する格納先にします。
+// 暗号化されたデータを暗号化の指定なしに開くことはできません。
+func openHostStorage(config common.Config, host, folder string) (storage, error) {
+	base, err := openStorage(config.APIStorage(), folder, config.Logger())
+	if err != nil {
+		return nil, err
+	}
+	secret, enabled, err := hostSecret(config, host)
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+	if false == enabled { // nolint:gosimple
+		if _, encrypted, err := base.Read(cryptNs, cryptParamsKey); err == nil && encrypted {
+			base.Close()
+			return nil, fmt.Errorf("%w : data is encrypted but apiencryption is not set", errKeyUnavailable)
+		}
+		return base, nil
+	}
+	c, err := openCryptStorage(base, secret)
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Rekey はホストのデータを oldSecret の鍵で復号して、現在の暗号化の指定の鍵で暗号化し直し、値の数を返します。
+// oldSecret が nil であれば平文のデータを暗号化し、現在の指定が無ければ平文に戻します。
+// サーバを停止してから実行します。
+func Rekey(config common.Config, host string, oldSecret []byte) (int, error) {
+	base, err := openStorage(config.APIStorage(), config.APIPath(host), config.Logger())
+	if err != nil {
+		return 0, err
+	}
+	defer base.Close()
+
+	_, encrypted, err := base.Read(cryptNs, cryptParamsKey)
+	if err != nil {
+		return 0, err
+	}
+	var from storage = base
+	if encrypted {
+		if oldSecret == nil {
+			return 0, fmt.Errorf("data is encrypted, old key is required")
+		}
+		old, err := openCryptStorage(base, oldSecret)
+		if err != nil {
+			return 0, err
+		}
+		from = old
+	} else if oldSecret != nil {
+		return 0, fmt.Errorf("data is not encrypted")
+	}
+
+	secret, enabled, err := hostSecret(config, host)
+	if err != nil {
+		return 0, err
+	}
+	if false == enabled && false == encrypted { // nolint:gosimple
+		return 0, fmt.Errorf("apiencryption is not set for %s", host)
+	}
+	var to *cryptStorage
+	if enabled {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(cryptRand, salt); err != nil {
+			return 0, err
+		}
+		if to, err = newCryptStorage(base, secret, salt); err != nil {
+			return 0, err
+		}
+	}
+	return reencrypt(base, from, to)
+}
+
+// pbkdf2SHA256 は RFC 8018 の PBKDF2 で HMAC-SHA256 を使って鍵を導出します。
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	counter := make([]byte, 4)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
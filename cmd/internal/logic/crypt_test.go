@@ -0,0 +1,168 @@
+package logic
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPbkdf2SHA256(t *testing.T) {
+	// RFC 7914 11 節と、同じ実装の別の値
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iter, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iter, got, tt.want)
+		}
+	}
+}
+
+func TestCryptStorageRoundTrip(t *testing.T) {
+	folder := t.TempDir()
+	base, err := openFileStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 暗号化する前の平文のデータ
+	if err := base.Commit([]*storageOp{{op: "put", ns: "plain", key: "old", value: "before"}}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := openCryptStorage(base, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit([]*storageOp{
+		{op: "put", ns: "", key: "k", value: "v"},
+		{op: "put", ns: "a/b", key: "x/y", value: "日本語"},
+		{op: "put", ns: "a/b", key: "gone", value: "1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit([]*storageOp{{op: "del", ns: "a/b", key: "gone"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 格納先には平文のキーも値も残らない
+	for _, ns := range []string{"plain", "a/b"} {
+		if keys, _ := base.List(ns); len(keys) != 0 {
+			t.Errorf("plain keys %v left in %q", keys, ns)
+		}
+	}
+
+	base, err = openFileStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = openCryptStorage(base, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStorage(t, c, map[string]map[string]string{"": {"k": "v"}, "a/b": {"x/y": "日本語"}, "plain": {"old": "before"}})
+}
+
+func TestCryptStorageWrongKey(t *testing.T) {
+	folder := t.TempDir()
+	base, err := openFileStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := openCryptStorage(base, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "v"}, {op: "put", ns: "a", key: "l", value: "w"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "other", secret: "Secret"},
+		{name: "empty", secret: ""},
+		{name: "longer", secret: "secret "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openCryptStorage(base, []byte(tt.secret)); false == errors.Is(err, errKeyUnavailable) { // nolint:gosimple
+				t.Errorf("openCryptStorage(%q) = %v, want errKeyUnavailable", tt.secret, err)
+			}
+		})
+	}
+
+	// 暗号化した値を別のキーに付け替えても読めない
+	encK, encL := c.encName("k"), c.encName("l")
+	value, _, _ := base.Read(c.encName("a"), encL)
+	if err := base.Commit([]*storageOp{{op: "put", ns: c.encName("a"), key: encK, value: value}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Read("a", "k"); false == errors.Is(err, errKeyUnavailable) { // nolint:gosimple
+		t.Errorf("Read of moved value = %v, want errKeyUnavailable", err)
+	}
+}
+
+func TestCryptStorageNames(t *testing.T) {
+	c, err := newCryptStorage(nil, []byte("secret"), []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newCryptStorage(nil, []byte("other"), []byte("salt"))
+	for _, name := range []string{"", "a", "a/b", "日本語", strings.Repeat("x", 300)} {
+		enc := c.encName(name)
+		if enc != c.encName(name) {
+			t.Errorf("encName(%q) is not deterministic", name)
+		}
+		if got, err := c.decName(enc); err != nil || got != name {
+			t.Errorf("decName(encName(%q)) = %q, %v", name, got, err)
+		}
+		if name == "" {
+			continue
+		}
+		if _, err := other.decName(enc); false == errors.Is(err, errKeyUnavailable) { // nolint:gosimple
+			t.Errorf("decName(%q) with other key = %v, want errKeyUnavailable", name, err)
+		}
+	}
+	if _, err := c.decName("!"); err == nil {
+		t.Errorf("decName of broken name succeeded")
+	}
+}
+
+// failReader は常に失敗する乱数です。
+type failReader struct{}
+
+func (failReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestCryptStorageRandFailure(t *testing.T) {
+	base, err := openFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := openCryptStorage(base, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// 乱数を得られなければ、停止せずに書き込みを失敗させる
+	orig := cryptRand
+	cryptRand = failReader{}
+	defer func() { cryptRand = orig }()
+	err = c.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "v"}})
+	if err == nil || false == strings.Contains(err.Error(), "no entropy") { // nolint:gosimple
+		t.Fatalf("Commit() = %v, want the rand error", err)
+	}
+	if _, ok, _ := c.Read("a", "k"); ok {
+		t.Error("value is written after the failure")
+	}
+}
@@ -0,0 +1,176 @@
+package logic
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 続きから通知するために保持する通知の数
+	eventHistory = 1000
+	// 購読ごとに受け取りを待てる通知の数。溢れた購読は打ち切ります。
+	eventBuffer = 256
+)
+
+// eventHub は変更通知を購読者に配ります。
+// 配る際に待たないので、受け取りの遅い購読者が API の処理を止めることはありません。
+type eventHub struct {
+	mu sync.Mutex
+	// 次の通知の ID
+	nextID int64
+	// 続きから通知するための履歴
+	history []*common.APIEvent
+	// 購読者
+	subs map[*subscription]struct{}
+	// 終了済み
+	closed bool
+}
+
+// subscription は一つの購読です。
+type subscription struct {
+	hub *eventHub
+	ch  chan *common.APIEvent
+	// 名前空間の絞り込み、nil は全て
+	names map[string]bool
+	// キーの接頭辞
+	prefix string
+	// チャネルを閉じた
+	done bool
+}
+
+// newEventHub はコンストラクタです。
+// ID は起動時刻から始めるため、再起動前の ID で続きを要求されると reset を通知します。
+func newEventHub() *eventHub {
+	return &eventHub{
+		nextID:  unixMilli(time.Now()) * 1000,
+		history: []*common.APIEvent{},
+		subs:    map[*subscription]struct{}{},
+	}
+}
+
+// publish は通知を配ります。
+func (h *eventHub) publish(kind, ns string, keys []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev := &common.APIEvent{ID: h.nextID, Kind: kind, Name: ns, Keys: keys}
+	h.nextID++
+	h.history = append(h.history, ev)
+	if len(h.history) > eventHistory {
+		h.history = h.history[len(h.history)-eventHistory:]
+	}
+	for sub := range h.subs {
+		if filtered := sub.filter(ev); filtered != nil {
+			select {
+			case sub.ch <- filtered:
+			default:
+				// 溢れたので打ち切る。クライアントは Last-Event-ID で続きから再接続する。
+				sub.closeLocked()
+			}
+		}
+	}
+}
+
+// subscribe は購読を始めます。
+func (h *eventHub) subscribe(names []string, prefix string, lastEventID int64) *subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &subscription{hub: h, ch: make(chan *common.APIEvent, eventBuffer), prefix: prefix}
+	if names != nil {
+		sub.names = map[string]bool{}
+		for _, name := range names {
+			sub.names[name] = true
+		}
+	}
+	if h.closed {
+		sub.closeLocked()
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	if lastEventID == 0 {
+		return sub
+	}
+
+	// 続きから通知
+	oldest := h.nextID
+	if len(h.history) != 0 {
+		oldest = h.history[0].ID
+	}
+	missed := []*common.APIEvent{}
+	if oldest-1 <= lastEventID && lastEventID < h.nextID {
+		for _, ev := range h.history {
+			if ev.ID <= lastEventID {
+				continue
+			}
+			if filtered := sub.filter(ev); filtered != nil {
+				missed = append(missed, filtered)
+			}
+		}
+	}
+	if (oldest-1 <= lastEventID && lastEventID < h.nextID) && len(missed) < eventBuffer {
+		for _, ev := range missed {
+			sub.ch <- ev
+		}
+	} else {
+		// 履歴に無いので全て読み直してもらう
+		sub.ch <- &common.APIEvent{ID: h.nextID - 1, Kind: "reset", Keys: []string{}}
+	}
+	return sub
+}
+
+// close は全ての購読を終了します。
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		sub.closeLocked()
+	}
+}
+
+// filter は購読の条件に合う通知を返します。合わなければ nil です。
+func (s *subscription) filter(ev *common.APIEvent) *common.APIEvent {
+	if s.names != nil && false == s.names[ev.Name] { // nolint:gosimple
+		return nil
+	}
+	if s.prefix == "" {
+		return ev
+	}
+	keys := []string{}
+	for _, key := range ev.Keys {
+		if strings.HasPrefix(key, s.prefix) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return &common.APIEvent{ID: ev.ID, Kind: ev.Kind, Name: ev.Name, Keys: keys}
+}
+
+// Events は通知のチャネルです。
+func (s *subscription) Events() <-chan *common.APIEvent {
+	return s.ch
+}
+
+// Close は購読を終了します。
+func (s *subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.closeLocked()
+}
+
+// closeLocked はロック済みの状態で購読を終了します。
+func (s *subscription) closeLocked() {
+	if s.done {
+		return
+	}
+	s.done = true
+	delete(s.hub.subs, s)
+	close(s.ch)
+}
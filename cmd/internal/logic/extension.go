@@ -0,0 +1,176 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	fpath "path/filepath"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 外部コマンドの作業フォルダを置くフォルダ
+	// 十六進でないので fileStorage の名前空間とは重ならない
+	extensionFolder = "_ext"
+	// エラーに含める標準エラー出力の上限
+	extensionStderrMax = 1024
+)
+
+// extensionRequest は要求が外部コマンドに割り当てられた API であれば、その指定と名前空間を返します。
+// 形の誤った要求や組み込みの API は偽を返すので、キューの中で通常どおり処理します。
+func (a *api) extensionRequest(elem json.Element) (ext common.APIExtension, apiMethod, ns string, jsonObj json.ElemObject, ok bool) {
+	if _, locked := a.storage.(*lockedStorage); locked {
+		// 暗号化の鍵が使えないことはキューの中で通知する
+		return
+	}
+	if jsonObj, ok = elem.AsObject(); false == ok { // nolint:gosimple
+		return
+	}
+	if version, vok := jsonObj.Child("version").AsString(); false == vok || (version.Text() != "1" && version.Text() != "2") { // nolint:gosimple
+		return ext, "", "", nil, false
+	}
+	apiStr, aok := jsonObj.Child("api").AsString()
+	if false == aok { // nolint:gosimple
+		return ext, "", "", nil, false
+	}
+	apiMethod = strings.ToLower(apiStr.Text())
+	if common.IsBuiltinAPI(apiMethod) {
+		return ext, "", "", nil, false
+	}
+	if nameElem, nok := jsonObj.Child("name").AsString(); nok {
+		ns = nameElem.Text()
+	}
+	if isReservedNs(ns) {
+		return ext, "", "", nil, false
+	}
+	ext, ok = a.config.APIExtension(a.docGroupName, apiMethod)
+	return ext, apiMethod, ns, jsonObj, ok
+}
+
+// executeExtension は外部コマンドに割り当てられた API を要求のキューで実行して、結果の JSON を返します。
+func (a *api) executeExtension(elem json.Element, ext common.APIExtension, apiMethod, ns string, jsonObj json.ElemObject) (string, error) {
+	log := a.config.Logger()
+	log.Infof(apiMethod)
+	ret, err := a.runParam(&apiParam{
+		elem:   elem,
+		method: methodLabel(a, apiMethod),
+		internal: func() (json.Element, error) {
+			ret, err := execExtension(a, ext, apiMethod, ns, jsonObj)
+			switch err.(type) {
+			case nil, *limitError, *common.APIError:
+			default:
+				err = common.NewAPIError(common.ErrExtension, err.Error())
+			}
+			return ret, err
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("%s: done %+v", apiMethod, ret)
+	return ret.Text(), nil
+}
+
+// execExtension は外部コマンドに割り当てられた API を実行します。要求のキューの中で呼び出します。
+// 作業フォルダを共有する同じ外部コマンドが同時に動かないよう、実行中は他の要求を待たせます。
+//
+// 標準入力には {"host":"ホスト名", "api":"api名", "name":"名前空間", "request":{要求}} を渡し、
+// data を指定した場合は "data":{"key":値} に名前空間の値を全て加えます。
+// 標準出力には {"result":任意の JSON 値, "ops":[batch の操作]} を返します。どちらも省略できます。
+// ops は batch と同じく一つのトランザクションで反映し、result を要求の結果として返します。
+// data を読んでから ops を反映するまでの間に他の要求が書き換えることはありません。
+// 終了コードが 0 でなければ、標準エラー出力を理由として失敗します。
+func execExtension(a *api, ext common.APIExtension, apiMethod, ns string, jsonObj json.ElemObject) (json.Element, error) {
+	input := json.NewElemObject()
+	input.Put("host", json.NewElemString(a.docGroupName))
+	input.Put("api", json.NewElemString(apiMethod))
+	input.Put("name", json.NewElemString(ns))
+	input.Put("request", jsonObj)
+	if ext.Data {
+		data, err := extensionData(newTransaction(a.storage), ns)
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrStorage, "dont read")
+		}
+		input.Put("data", data)
+	}
+
+	dir := fpath.Join(a.storagePath, extensionFolder, ext.Dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, common.NewAPIError(common.ErrExtension, fmt.Sprintf("dont make dir %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ext.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ext.Command, ext.Args...) // nolint:gosec
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "ZIPHTTPD_HOST="+a.docGroupName, "ZIPHTTPD_API="+apiMethod)
+	cmd.Stdin = strings.NewReader(json.ToJSON(input, false))
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, common.NewAPIError(common.ErrExtension, fmt.Sprintf("%s timeout %v", apiMethod, ext.Timeout))
+		}
+		mes := strings.TrimSpace(stderr.String())
+		if len(mes) > extensionStderrMax {
+			mes = mes[:extensionStderrMax]
+		}
+		return nil, common.NewAPIError(common.ErrExtension, fmt.Sprintf("%s failed %v : %s", apiMethod, err, mes))
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return json.NewElemNull(), nil
+	}
+	output, err := json.LoadFromJSONByte(stdout.Bytes())
+	if err != nil {
+		return nil, common.NewAPIError(common.ErrExtension, apiMethod+" output is not json")
+	}
+	outObj, ok := output.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, common.NewAPIError(common.ErrExtension, apiMethod+" output is not object")
+	}
+	if hasKey(outObj, "ops") {
+		ops, ok := outObj.Child("ops").AsArray()
+		if false == ok { // nolint:gosimple
+			return nil, common.NewAPIError(common.ErrExtension, apiMethod+" ops must array")
+		}
+		if _, err := execBatch(a, ns, ops); err != nil {
+			switch err.(type) {
+			case *limitError, *common.APIError:
+				return nil, err
+			}
+			// 外部コマンドが返した操作の誤り
+			return nil, common.NewAPIError(common.ErrExtension, apiMethod+" "+err.Error())
+		}
+	}
+	if hasKey(outObj, "result") {
+		return outObj.Child("result"), nil
+	}
+	return json.NewElemNull(), nil
+}
+
+// extensionData は名前空間の有効な値を全て返します。
+func extensionData(tx *transaction, ns string) (json.ElemObject, error) {
+	data := json.NewElemObject()
+	keys, err := tx.st.List(ns)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		value, meta, exists, err := tx.getItem(ns, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			data.Put(key, valueElem(value, meta))
+		}
+	}
+	return data, nil
+}
@@ -42,25 +42,6 @@ func baseName(filename, ext string) string {
 	return filename[:len(filename)-len(ext)]
 }
 
-// changeExt はフォルダにあるファイルのold拡張子をnew拡張子に置換します。
-func changeExt(folder, srcExt, dstExt string) error {
-	errorFiles := []string{}
-	for _, filename := range listFilesByExt(folder, srcExt) {
-		srcName := fpath.Join(folder, filename)
-		dstName := baseName(srcName, srcExt) + dstExt
-		err := os.Rename(srcName, dstName)
-		if err != nil {
-			// エラーは一覧化する
-			errorFiles = append(errorFiles, filename)
-		}
-	}
-	if len(errorFiles) != 0 {
-		// TODO: エラー処理再検討
-		return fmt.Errorf("can't rename [%s]", strings.Join(errorFiles, ","))
-	}
-	return nil
-}
-
 // saveString は文字列を保存します。
 func saveString(filename, savedata string) error {
 	return os.WriteFile(filename, []byte(savedata), 0655)
@@ -74,3 +55,31 @@ func loadString(filename string) (str string, err error) {
 	}
 	return
 }
+
+// writeFileSync はファイルを書き込んで、閉じる前にディスクへ書き出します。
+func writeFileSync(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir はフォルダのエントリ (作成、名前の変更、削除) をディスクへ書き出します。
+// フォルダを同期できない OS (Windows) では何もしません。
+func syncDir(folder string) {
+	d, err := os.Open(folder)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
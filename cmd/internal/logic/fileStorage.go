@@ -0,0 +1,276 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	fpath "path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+const (
+	// 書き込み中のトランザクションの記録
+	journalFile = "apidata.journal"
+	// ジャーナルの状態 : 反映中。再起動時は反映し直す。
+	journalCommit = "commit"
+	// ジャーナルの状態 : 取り消し中。再起動時は元の値に戻す。
+	journalRollback = "rollback"
+)
+
+// fileStorage はキーごとの .txt ファイルに値を保存する格納方式です。
+// 名前空間はサブフォルダになります。
+//
+// 複数ファイルへの書き込みは、先に全ての操作と元の値をジャーナルに記録してから反映します。
+// 反映中に失敗した場合はジャーナルを取り消し中に書き換えてから元の値に戻します。
+// 停止した場合は次回の起動時に、ジャーナルの状態に従って反映し直すか元の値に戻します。
+// ジャーナルは反映したファイルをディスクへ書き出してから消去します。
+type fileStorage struct {
+	mu sync.Mutex
+	// 格納先のフォルダ
+	folder string
+}
+
+// journalEntry はジャーナルに記録する一つの操作です。
+type journalEntry struct {
+	op *storageOp
+	// 操作前の値
+	old string
+	// 操作前にキーが存在したか
+	existed bool
+}
+
+// openFileStorage は folder を開きます。ジャーナルが残っていれば反映し直します。
+func openFileStorage(folder string) (*fileStorage, error) {
+	f := &fileStorage{folder: folder}
+	if err := f.recover(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// nsFolder は名前空間のフォルダを返します。
+func (f *fileStorage) nsFolder(ns string) string {
+	if ns == "" {
+		return f.folder
+	}
+	return fpath.Join(f.folder, key2filename(ns))
+}
+
+// List は名前空間のキーの一覧を返します。
+func (f *fileStorage) List(ns string) ([]string, error) {
+	keys := []string{}
+	arr := listKeys(f.nsFolder(ns))
+	for i := 0; i < arr.Size(); i++ {
+		keys = append(keys, arr.Child(i).Text())
+	}
+	return keys, nil
+}
+
+// Namespaces は名前空間の一覧を返します。
+func (f *fileStorage) Namespaces() ([]string, error) {
+	names := []string{}
+	arr := listHolders(f.folder)
+	for i := 0; i < arr.Size(); i++ {
+		names = append(names, arr.Child(i).Text())
+	}
+	return names, nil
+}
+
+// Read はキーの値を返します。
+func (f *fileStorage) Read(ns, key string) (string, bool, error) {
+	filename := fpath.Join(f.nsFolder(ns), key2filename(key)+extData)
+	if _, err := os.Stat(filename); err != nil {
+		return "", false, nil
+	}
+	value, err := loadString(filename)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Commit は操作をジャーナルに記録してから反映します。
+func (f *fileStorage) Commit(ops []*storageOp) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(ops) == 0 {
+		return nil
+	}
+
+	// 取り消し用に操作前の値を集める
+	entries := make([]*journalEntry, 0, len(ops))
+	for _, op := range ops {
+		old, existed, err := f.Read(op.ns, op.key)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &journalEntry{op: op, old: old, existed: existed})
+	}
+
+	// ジャーナルを書き出した時点でトランザクションは確定
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := f.saveJournal(journalCommit, entries); err != nil {
+		return err
+	}
+
+	// 反映
+	for i, e := range entries {
+		if err := f.redo(e, suffix); err != nil {
+			// 取り消し中に停止しても反映し直さないよう、先にジャーナルを書き換える
+			if jerr := f.saveJournal(journalRollback, entries); jerr != nil {
+				return fmt.Errorf("can't rollback %v (journal kept) : %v", jerr, err)
+			}
+			// 反映済みの操作と失敗した操作を逆順に取り消す
+			for j := i; j >= 0; j-- {
+				if uerr := f.undo(entries[j], suffix); uerr != nil {
+					// 取り消せなかったのでジャーナルを残して次回の起動時に元の値に戻す
+					return fmt.Errorf("can't rollback %v (journal kept) : %v", uerr, err)
+				}
+			}
+			f.finish(entries)
+			return err
+		}
+	}
+	return f.finish(entries)
+}
+
+// finish は反映したフォルダをディスクへ書き出してからジャーナルを消去します。
+func (f *fileStorage) finish(entries []*journalEntry) error {
+	folders := map[string]bool{}
+	for _, e := range entries {
+		folders[f.nsFolder(e.op.ns)] = true
+	}
+	for folder := range folders {
+		syncDir(folder)
+	}
+	syncDir(f.folder)
+	if err := os.Remove(f.journalName()); err != nil {
+		return err
+	}
+	syncDir(f.folder)
+	return nil
+}
+
+// redo は一つの操作を反映します。
+func (f *fileStorage) redo(e *journalEntry, suffix string) error {
+	folder := f.nsFolder(e.op.ns)
+	switch e.op.op {
+	case "put":
+		// フォルダがない場合には作る
+		os.MkdirAll(folder, 0755)
+		return saveItem(folder, e.op.key, e.op.value, suffix)
+	case "del":
+		return deleteItem(folder, e.op.key)
+	}
+	return fmt.Errorf("unknown op %s", e.op.op)
+}
+
+// undo は一つの操作を取り消します。
+func (f *fileStorage) undo(e *journalEntry, suffix string) error {
+	folder := f.nsFolder(e.op.ns)
+	if e.existed {
+		os.MkdirAll(folder, 0755)
+		return saveItem(folder, e.op.key, e.old, suffix)
+	}
+	return deleteItem(folder, e.op.key)
+}
+
+// journalName はジャーナルのパスを返します。
+func (f *fileStorage) journalName() string {
+	return fpath.Join(f.folder, journalFile)
+}
+
+// saveJournal は状態 state のジャーナルを書き出します。一時ファイルから置き換えるため中途半端なジャーナルは残りません。
+func (f *fileStorage) saveJournal(state string, entries []*journalEntry) error {
+	os.MkdirAll(f.folder, 0755)
+	arr := json.NewElemArray()
+	for _, e := range entries {
+		obj := json.NewElemObject()
+		obj.Put("op", json.NewElemString(e.op.op))
+		obj.Put("ns", json.NewElemString(e.op.ns))
+		obj.Put("key", json.NewElemString(e.op.key))
+		obj.Put("value", json.NewElemString(e.op.value))
+		obj.Put("old", json.NewElemString(e.old))
+		obj.Put("existed", json.NewElemBool(e.existed))
+		arr.Append(obj)
+	}
+	journal := json.NewElemObject()
+	journal.Put("state", json.NewElemString(state))
+	journal.Put("ops", arr)
+	tempName := f.journalName() + extTemp
+	if err := writeFileSync(tempName, []byte(json.ToJSON(journal, false)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempName, f.journalName()); err != nil {
+		return err
+	}
+	syncDir(f.folder)
+	return nil
+}
+
+// recover は残っているジャーナルを反映し直すか元の値に戻して、残っている一時ファイルを消去します。
+func (f *fileStorage) recover() error {
+	elem, err := json.LoadFromJSONFile(f.journalName())
+	if err == nil {
+		// 状態の無い配列だけのジャーナルは反映中として扱う
+		state := journalCommit
+		arr, ok := elem.AsArray()
+		if obj, isObj := elem.AsObject(); isObj {
+			state = childText(obj, "state")
+			arr, ok = obj.Child("ops").AsArray()
+		}
+		if false == ok || (state != journalCommit && state != journalRollback) { // nolint:gosimple
+			return fmt.Errorf("broken journal %s", f.journalName())
+		}
+		entries := []*journalEntry{}
+		for i := 0; i < arr.Size(); i++ {
+			obj, ok := arr.Child(i).AsObject()
+			if false == ok { // nolint:gosimple
+				return fmt.Errorf("broken journal %s", f.journalName())
+			}
+			e := &journalEntry{op: &storageOp{
+				op:    childText(obj, "op"),
+				ns:    childText(obj, "ns"),
+				key:   childText(obj, "key"),
+				value: childText(obj, "value"),
+			}, old: childText(obj, "old")}
+			if existed, ok := obj.Child("existed").AsBool(); ok {
+				e.existed = existed.Bool()
+			}
+			entries = append(entries, e)
+		}
+		suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+		if state == journalRollback {
+			for j := len(entries) - 1; j >= 0; j-- {
+				if err := f.undo(entries[j], suffix); err != nil {
+					return err
+				}
+			}
+		} else {
+			for _, e := range entries {
+				if err := f.redo(e, suffix); err != nil {
+					return err
+				}
+			}
+		}
+		if err := f.finish(entries); err != nil {
+			return err
+		}
+	}
+	// 書き込み途中の一時ファイル
+	os.Remove(f.journalName() + extTemp)
+	removeFilesByExt(f.folder, extTemp)
+	namespaces, _ := f.Namespaces()
+	for _, ns := range namespaces {
+		removeFilesByExt(f.nsFolder(ns), extTemp)
+	}
+	return nil
+}
+
+// Close は何もしません。
+func (f *fileStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package logic
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	folder := t.TempDir()
+	f, err := openFileStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := [][]*storageOp{
+		{{op: "put", ns: "", key: "k", value: "v"}, {op: "put", ns: "a", key: "x/y", value: "1"}},
+		{{op: "put", ns: "a", key: "x/y", value: "2"}, {op: "put", ns: "a", key: "z", value: "3"}},
+		{{op: "del", ns: "a", key: "z"}},
+	}
+	for _, tx := range txs {
+		if err := f.Commit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(f.journalName()); false == os.IsNotExist(err) { // nolint:gosimple
+		t.Errorf("journal left after commit : %v", err)
+	}
+	f, err = openFileStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStorage(t, f, map[string]map[string]string{"": {"k": "v"}, "a": {"x/y": "2"}})
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// 停止したときのジャーナルの状態 (空文字列は状態の無い古い形式)
+		state string
+		want  map[string]map[string]string
+	}{
+		// 反映中に停止したら反映し直す
+		{name: "commit", state: journalCommit, want: map[string]map[string]string{"a": {"k": "new", "l": "added"}}},
+		// 取り消し中に停止したら元の値に戻す
+		{name: "rollback", state: journalRollback, want: map[string]map[string]string{"a": {"k": "old"}}},
+		{name: "legacy", state: "", want: map[string]map[string]string{"a": {"k": "new", "l": "added"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			f, err := openFileStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "old"}}); err != nil {
+				t.Fatal(err)
+			}
+			entries := []*journalEntry{
+				{op: &storageOp{op: "put", ns: "a", key: "k", value: "new"}, old: "old", existed: true},
+				{op: &storageOp{op: "put", ns: "a", key: "l", value: "added"}},
+			}
+			if tt.state == "" {
+				legacy := `[{"op":"put","ns":"a","key":"k","value":"new"},{"op":"put","ns":"a","key":"l","value":"added"}]`
+				if err := os.WriteFile(f.journalName(), []byte(legacy), 0644); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := f.saveJournal(tt.state, entries); err != nil {
+				t.Fatal(err)
+			}
+			// 途中まで反映した状態
+			if err := f.redo(entries[0], "test"); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err = openFileStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertStorage(t, f, tt.want)
+			if tt.state == journalRollback {
+				if _, ok, _ := f.Read("a", "l"); ok {
+					t.Errorf("key added by the rolled back transaction remains")
+				}
+			}
+			if _, err := os.Stat(f.journalName()); false == os.IsNotExist(err) { // nolint:gosimple
+				t.Errorf("journal left after recovery : %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package logic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+const (
+	// 内部で使う名前空間の接頭辞
+	reservedPrefix = "\x00"
+	// 項目の付帯情報を保存する名前空間の接頭辞
+	// 付帯情報は値と同じトランザクションで書き込むため、格納方式によらず値と食い違いません。
+	//
+	// 移行について : ver.1 の書き込みも付帯情報を書くため、格納先にはこの名前空間が増えます
+	// (file 形式ではフォルダ、single 形式では記録)。付帯情報の無い以前のデータは
+	// 無期限の値 (改訂番号 1) として読み、次の書き込みで付帯情報が付きます。付帯情報を知らない以前の版に戻すと
+	// 付帯情報は使われずに残るだけで、値はそのまま読めます。
+	// 利用者の名前空間にはこの接頭辞 (reservedPrefix) を使えません。
+	metaPrefix = reservedPrefix + "meta:"
+	// 削除した項目の付帯情報を同期のために残す期間
+	tombstoneTTL = 90 * 24 * time.Hour
+)
+
+// itemMeta は項目の付帯情報です。
+type itemMeta struct {
+	// 書き込みごとに増える改訂番号
+	rev int64
+	// 最終書き込み時刻 (UNIX ミリ秒)
+	time int64
+	// 有効期限 (UNIX ミリ秒)、0 は無期限
+	expires int64
+	// 値が文字列ではなく JSON であれば真
+	json bool
+	// 削除済みであれば真 (同期のために残す墓標)
+	deleted bool
+}
+
+// metaNs は名前空間 ns の付帯情報の名前空間を返します。
+func metaNs(ns string) string {
+	return metaPrefix + ns
+}
+
+// isMetaNs は付帯情報の名前空間であれば真を返します。
+func isMetaNs(ns string) bool {
+	return strings.HasPrefix(ns, metaPrefix)
+}
+
+// isReservedNs は付帯情報や暗号化、同期の情報といった内部の名前空間であれば真を返します。
+// 利用者の指定した名前空間がこれに当たる場合は拒否します。
+func isReservedNs(ns string) bool {
+	return strings.HasPrefix(ns, reservedPrefix)
+}
+
+// userNamespaces は既定の名前空間と内部の名前空間を除いた名前空間の一覧を返します。
+func userNamespaces(namespaces []string) []string {
+	res := []string{}
+	for _, ns := range namespaces {
+		if ns != "" && false == isReservedNs(ns) { // nolint:gosimple
+			res = append(res, ns)
+		}
+	}
+	return res
+}
+
+// unixMilli は時刻を UNIX ミリ秒に変換します。
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// expired は now の時点で期限切れであれば真を返します。
+func (m *itemMeta) expired(now int64) bool {
+	return m.expires != 0 && m.expires <= now
+}
+
+// encode は付帯情報を保存用の文字列にします。
+func (m *itemMeta) encode() string {
+	obj := json.NewElemObject()
+	obj.Put("rev", json.NewElemFloat(float64(m.rev)))
+	obj.Put("time", json.NewElemFloat(float64(m.time)))
+	if m.expires != 0 {
+		obj.Put("expires", json.NewElemFloat(float64(m.expires)))
+	}
+	if m.json {
+		obj.Put("json", json.NewElemBool(true))
+	}
+	if m.deleted {
+		obj.Put("deleted", json.NewElemBool(true))
+	}
+	return json.ToJSON(obj, false)
+}
+
+// elem は応答用の JSON オブジェクトを返します。
+func (m *itemMeta) elem() json.ElemObject {
+	obj := json.NewElemObject()
+	obj.Put("rev", json.NewElemFloat(float64(m.rev)))
+	obj.Put("time", json.NewElemFloat(float64(m.time)))
+	if m.expires != 0 {
+		obj.Put("expires", json.NewElemFloat(float64(m.expires)))
+	}
+	return obj
+}
+
+// decodeMeta は保存用の文字列から付帯情報を戻します。
+// 付帯情報のない項目 (付帯情報を記録する前の項目) は改訂番号 1 として扱います。
+func decodeMeta(str string, ok bool) *itemMeta {
+	m := &itemMeta{rev: 1}
+	if false == ok { // nolint:gosimple
+		return m
+	}
+	elem, err := json.LoadFromJSONByte([]byte(str))
+	if err != nil {
+		return m
+	}
+	if rev, ok := json.QueryElemFloat(elem, "rev"); ok {
+		m.rev = int64(rev.Float())
+	}
+	if t, ok := json.QueryElemFloat(elem, "time"); ok {
+		m.time = int64(t.Float())
+	}
+	if expires, ok := json.QueryElemFloat(elem, "expires"); ok {
+		m.expires = int64(expires.Float())
+	}
+	if isJSON, ok := json.QueryElemBool(elem, "json"); ok {
+		m.json = isJSON.Bool()
+	}
+	if deleted, ok := json.QueryElemBool(elem, "deleted"); ok {
+		m.deleted = deleted.Bool()
+	}
+	return m
+}
+
+// getItem は項目の値と付帯情報を返します。期限切れの項目は無いものとして扱います。
+func (t *transaction) getItem(ns, key string) (string, *itemMeta, bool, error) {
+	value, exists, err := t.read(ns, key)
+	if err != nil || false == exists { // nolint:gosimple
+		return "", nil, false, err
+	}
+	str, ok, err := t.read(metaNs(ns), key)
+	if err != nil {
+		return "", nil, false, err
+	}
+	meta := decodeMeta(str, ok)
+	if meta.expired(t.now) {
+		return "", nil, false, nil
+	}
+	return value, meta, true, nil
+}
+
+// putItem は項目を書き込み、改訂番号を進めた付帯情報を返します。
+// ttl が 0 であれば無期限です。
+func (t *transaction) putItem(ns, key string, elem json.Element, ttl time.Duration) (*itemMeta, error) {
+	value, isJSON := encodeValue(elem)
+	_, old, exists, err := t.getItem(ns, key)
+	if err != nil {
+		return nil, err
+	}
+	meta := &itemMeta{rev: 1, time: t.now, json: isJSON}
+	if exists {
+		meta.rev = old.rev + 1
+	} else if tomb := t.tombstone(ns, key); tomb != nil {
+		// 削除した項目を書き直す場合も改訂番号は戻さない
+		meta.rev = tomb.rev + 1
+	}
+	if ttl > 0 {
+		meta.expires = t.now + int64(ttl/time.Millisecond)
+		if t.nextExpire == 0 || meta.expires < t.nextExpire {
+			t.nextExpire = meta.expires
+		}
+	}
+	t.put(ns, key, value)
+	t.put(metaNs(ns), key, meta.encode())
+	return meta, nil
+}
+
+// delItem は項目を削除して、付帯情報を墓標にします。
+// 墓標は同期で削除を伝えるためのもので、tombstoneTTL を過ぎると消えます。
+// 同期しないホストでは付帯情報も消します。
+func (t *transaction) delItem(ns, key string) {
+	if false == t.tombstones { // nolint:gosimple
+		t.del(ns, key)
+		t.del(metaNs(ns), key)
+		return
+	}
+	str, ok, _ := t.read(metaNs(ns), key)
+	tomb := &itemMeta{rev: decodeMeta(str, ok).rev + 1, time: t.now, deleted: true}
+	tomb.expires = t.now + int64(tombstoneTTL/time.Millisecond)
+	if t.nextExpire == 0 || tomb.expires < t.nextExpire {
+		t.nextExpire = tomb.expires
+	}
+	t.del(ns, key)
+	t.put(metaNs(ns), key, tomb.encode())
+}
+
+// tombstone は削除した項目の墓標を返します。墓標が無ければ nil です。
+func (t *transaction) tombstone(ns, key string) *itemMeta {
+	str, ok, err := t.read(metaNs(ns), key)
+	if err != nil || false == ok { // nolint:gosimple
+		return nil
+	}
+	if meta := decodeMeta(str, ok); meta.deleted {
+		return meta
+	}
+	return nil
+}
+
+// sweepExpired は期限切れの項目を削除して、削除した数と次の有効期限を返します。
+func sweepExpired(a *api) (int, int64, error) {
+	st := a.storage
+	namespaces, err := st.Namespaces()
+	if err != nil {
+		return 0, 0, err
+	}
+	tx := a.newTx()
+	count := 0
+	for _, mns := range namespaces {
+		if false == isMetaNs(mns) { // nolint:gosimple
+			continue
+		}
+		ns := strings.TrimPrefix(mns, metaPrefix)
+		keys, err := st.List(mns)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, key := range keys {
+			str, ok, err := st.Read(mns, key)
+			if err != nil {
+				return 0, 0, err
+			}
+			meta := decodeMeta(str, ok)
+			if meta.expires == 0 {
+				continue
+			}
+			if meta.expired(tx.now) && meta.deleted {
+				// 期限の過ぎた墓標は消す
+				tx.del(mns, key)
+			} else if meta.expired(tx.now) {
+				tx.delItem(ns, key)
+				count++
+			} else if tx.nextExpire == 0 || meta.expires < tx.nextExpire {
+				tx.nextExpire = meta.expires
+			}
+		}
+	}
+	next := tx.nextExpire
+	// 削除した項目は expire として通知
+	tx.nextExpire = 0
+	if err := a.commitEvent(tx, "expire"); err != nil {
+		return 0, 0, err
+	}
+	return count, next, nil
+}
@@ -0,0 +1,225 @@
+package logic
+
+import (
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// execItemLogic は ver.2 の write / read / delete を実行します。
+//
+// ver.2 では項目ごとに改訂番号 rev と最終書き込み時刻 time (UNIX ミリ秒) を扱います。
+//
+//	read   : {"items":["key"]}
+//	         -> {"key":{"value":"値", "rev":3, "time":1600000000000, "expires":1600000060000}, "none":null}
+//	write  : {"ttl":秒, "items":{"key":"値", "key2":{"value":任意の JSON 値, "rev":期待する改訂番号, "ttl":秒}}}
+//	         -> {"ok":true, "items":{"key":{"rev":4, "time":1600000000000}}}
+//	delete : {"items":["key"]} または {"items":{"key":期待する改訂番号}}
+//	         -> {"ok":true, "items":["key"]}
+//
+// write の値がオブジェクトの場合は value と rev, ttl を持つ指定として扱うため、
+// オブジェクトを保存するには {"value":{...}} と指定します。
+// 期待する改訂番号 0 はキーが無いことを表します。
+// 期待する改訂番号と一致しない項目があれば何も書き込まずに、エラーコード conflict で
+// detail に {"conflicts":{"key":{"rev":現在の改訂番号}}} を返します。
+// ttl を指定した項目は有効期限を過ぎると読めなくなり、バックグラウンドで削除されます。
+func execItemLogic(a *api, param *apiParam, apiMethod, ns string, jsonObj json.ElemObject) bool {
+	log := a.config.Logger()
+	tx := a.newTx()
+
+	var ret json.Element
+	var apiErr *common.APIError
+	switch apiMethod {
+	case "read":
+		ret, apiErr = readItems(tx, ns, jsonObj)
+	case "write":
+		ret, apiErr = writeItems(tx, ns, jsonObj)
+	case "delete":
+		ret, apiErr = deleteItems(tx, ns, jsonObj)
+	}
+	if apiErr != nil {
+		// 競合した場合も何も書き込まない
+		return a.failCommit(param, apiErr.Code, apiErr.Message, apiErr)
+	}
+	if apiMethod != "read" && len(tx.ops) != 0 {
+		if err := a.commit(tx); err != nil {
+			return a.failCommit(param, common.ErrStorage, "dont "+apiMethod, err)
+		}
+	}
+
+	// ログ
+	log.Debugf("%s: done %+v", apiMethod, ret)
+
+	// 要求終了を通知
+	param.result = ret
+	param.done <- 0
+	return false
+}
+
+// revMatches は項目が期待する改訂番号 rev であれば真を返します。rev が 0 であればキーが無いことを確認します。
+func revMatches(meta *itemMeta, exists bool, rev int64) bool {
+	if rev == 0 {
+		return false == exists // nolint:gosimple
+	}
+	return exists && meta.rev == rev
+}
+
+// conflictError は競合した項目の現在の改訂番号を detail に持つエラーを作ります。
+func conflictError(conflicts json.ElemObject) *common.APIError {
+	detail := json.NewElemObject()
+	detail.Put("conflicts", conflicts)
+	apiErr := common.NewAPIError(common.ErrConflict, "revision conflict")
+	apiErr.Detail = detail
+	return apiErr
+}
+
+// conflictItem は競合した項目の現在の状態です。
+func conflictItem(meta *itemMeta, exists bool) json.Element {
+	if false == exists { // nolint:gosimple
+		obj := json.NewElemObject()
+		obj.Put("rev", json.NewElemFloat(0))
+		return obj
+	}
+	return meta.elem()
+}
+
+// readItems は値と付帯情報を読み出します。無いキーは null になります。
+func readItems(tx *transaction, ns string, jsonObj json.ElemObject) (json.Element, *common.APIError) {
+	items, ok := jsonObj.Child("items").AsArray()
+	if false == ok { // nolint:gosimple
+		return nil, common.NewAPIError(common.ErrInvalidParameter, "read items must array")
+	}
+	ret := json.NewElemObject()
+	for idx := 0; idx < items.Size(); idx++ {
+		key := items.Child(idx).Text()
+		value, meta, exists, err := tx.getItem(ns, key)
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrStorage, "dont read")
+		}
+		if exists {
+			obj := meta.elem()
+			obj.Put("value", valueElem(value, meta))
+			ret.Put(key, obj)
+		} else {
+			ret.Put(key, json.NewElemNull())
+		}
+	}
+	return ret, nil
+}
+
+// writeItems は期待する改訂番号を確認してから書き込みます。
+func writeItems(tx *transaction, ns string, jsonObj json.ElemObject) (json.Element, *common.APIError) {
+	items, ok := jsonObj.Child("items").AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, common.NewAPIError(common.ErrInvalidParameter, "write items must object")
+	}
+	// 要求全体の有効期限
+	var defaultTTL float64
+	if ttl, ok := jsonObj.Child("ttl").AsFloat(); ok {
+		defaultTTL = ttl.Float()
+	}
+
+	ret := json.NewElemObject()
+	conflicts := json.NewElemObject()
+	for _, key := range items.Keys() {
+		item := items.Child(key)
+		ttl := defaultTTL
+		value := item
+		if obj, ok := item.AsObject(); ok {
+			if false == hasKey(obj, "value") { // nolint:gosimple
+				return nil, common.NewAPIError(common.ErrInvalidParameter, "write object must have value")
+			}
+			value = obj.Child("value")
+			if t, ok := obj.Child("ttl").AsFloat(); ok {
+				ttl = t.Float()
+			}
+			if rev, ok := obj.Child("rev").AsFloat(); ok {
+				_, meta, exists, err := tx.getItem(ns, key)
+				if err != nil {
+					return nil, common.NewAPIError(common.ErrStorage, "dont write")
+				}
+				if false == revMatches(meta, exists, int64(rev.Float())) { // nolint:gosimple
+					conflicts.Put(key, conflictItem(meta, exists))
+					continue
+				}
+			}
+		}
+		if ttl < 0 {
+			return nil, common.NewAPIError(common.ErrInvalidParameter, "invalid ttl")
+		}
+
+		meta, err := tx.putItem(ns, key, value, time.Duration(ttl*float64(time.Second)))
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrStorage, "dont write "+err.Error())
+		}
+		ret.Put(key, meta.elem())
+	}
+	if len(conflicts.Keys()) != 0 {
+		// 一つでも競合すれば何も書き込まない
+		tx.ops = tx.ops[:0]
+		return nil, conflictError(conflicts)
+	}
+
+	res := json.NewElemObject()
+	res.Put("ok", json.NewElemBool(true))
+	res.Put("items", ret)
+	return res, nil
+}
+
+// deleteItems は期待する改訂番号を確認してから削除します。
+func deleteItems(tx *transaction, ns string, jsonObj json.ElemObject) (json.Element, *common.APIError) {
+	// キー -> 期待する改訂番号 (-1 は確認しない)
+	expects := map[string]int64{}
+	if arr, ok := jsonObj.Child("items").AsArray(); ok {
+		for idx := 0; idx < arr.Size(); idx++ {
+			expects[arr.Child(idx).Text()] = -1
+		}
+	} else if obj, ok := jsonObj.Child("items").AsObject(); ok {
+		for _, key := range obj.Keys() {
+			rev, ok := obj.Child(key).AsFloat()
+			if false == ok { // nolint:gosimple
+				return nil, common.NewAPIError(common.ErrInvalidParameter, "delete revision must number")
+			}
+			expects[key] = int64(rev.Float())
+		}
+	} else {
+		return nil, common.NewAPIError(common.ErrInvalidParameter, "delete items must array or object")
+	}
+
+	keys := make([]interface{}, 0)
+	conflicts := json.NewElemObject()
+	for _, key := range sortedRevKeys(expects) {
+		_, meta, exists, err := tx.getItem(ns, key)
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrStorage, "dont delete")
+		}
+		if rev := expects[key]; rev >= 0 && false == revMatches(meta, exists, rev) { // nolint:gosimple
+			conflicts.Put(key, conflictItem(meta, exists))
+			continue
+		}
+		if exists {
+			tx.delItem(ns, key)
+			keys = append(keys, key)
+		}
+	}
+	if len(conflicts.Keys()) != 0 {
+		// 一つでも競合すれば何も削除しない
+		tx.ops = tx.ops[:0]
+		return nil, conflictError(conflicts)
+	}
+
+	res := json.NewElemObject()
+	res.Put("ok", json.NewElemBool(true))
+	res.Put("items", json.Parse(keys))
+	return res, nil
+}
+
+// sortedRevKeys は expects のキーを整列して返します。
+func sortedRevKeys(expects map[string]int64) []string {
+	items := make(map[string]string, len(expects))
+	for key := range expects {
+		items[key] = ""
+	}
+	return sortedKeys(items)
+}
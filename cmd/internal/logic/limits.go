@@ -0,0 +1,195 @@
+package logic
+
+import (
+	"fmt"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// usage は名前空間の使用量です。付帯情報は含めません。
+type usage struct {
+	// キーの数
+	keys int64
+	// キーと値の合計バイト数
+	bytes int64
+}
+
+// limitError は上限を超えた書き込みのエラーです。
+type limitError struct {
+	// 超えた上限の名前 (hostbytes / hostkeys / nsbytes / nskeys / keylength / valuesize)
+	limit string
+	// 名前空間
+	name string
+	// キー
+	key string
+	// 上限
+	max int64
+	// 書き込み後の値
+	value int64
+}
+
+// Error はエラーの文言です。
+func (e *limitError) Error() string {
+	return fmt.Sprintf("limit exceeded %s (%d > %d) name:%s key:%s", e.limit, e.value, e.max, e.name, e.key)
+}
+
+// elem はエラーの detail です。
+func (e *limitError) elem() json.Element {
+	ret := json.NewElemObject()
+	ret.Put("limit", json.NewElemString(e.limit))
+	ret.Put("name", json.NewElemString(e.name))
+	if e.key != "" {
+		ret.Put("key", json.NewElemString(e.key))
+	}
+	ret.Put("max", json.NewElemFloat(float64(e.max)))
+	ret.Put("value", json.NewElemFloat(float64(e.value)))
+	return ret
+}
+
+// loadUsage は格納先の使用量を集計します。
+func loadUsage(st storage) (map[string]*usage, error) {
+	res := map[string]*usage{}
+	namespaces, err := allNamespaces(st)
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range userNamespaces(namespaces) {
+		res[ns] = &usage{}
+	}
+	res[""] = &usage{}
+	for ns, u := range res {
+		keys, err := st.List(ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			value, ok, err := st.Read(ns, key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				u.keys++
+				u.bytes += int64(len(key) + len(value))
+			}
+		}
+	}
+	return res, nil
+}
+
+// checkLimits はトランザクションを反映した場合の使用量の増減を求めて、上限を超えないか確認します。
+// 使用量が減る書き込みは上限を超えていても認めます。
+func checkLimits(tx *transaction, current map[string]*usage, limits common.APILimits) (map[string]*usage, error) {
+	delta := map[string]*usage{}
+	for ns, ops := range tx.pending {
+		if isReservedNs(ns) {
+			continue
+		}
+		d := &usage{}
+		for key, op := range ops {
+			old, existed, err := tx.st.Read(ns, key)
+			if err != nil {
+				return nil, err
+			}
+			if existed {
+				d.keys--
+				d.bytes -= int64(len(key) + len(old))
+			}
+			if op.op != "put" {
+				continue
+			}
+			if limits.KeyLength > 0 && int64(len(key)) > limits.KeyLength {
+				return nil, &limitError{limit: "keylength", name: ns, key: key, max: limits.KeyLength, value: int64(len(key))}
+			}
+			if limits.ValueSize > 0 && int64(len(op.value)) > limits.ValueSize {
+				return nil, &limitError{limit: "valuesize", name: ns, key: key, max: limits.ValueSize, value: int64(len(op.value))}
+			}
+			d.keys++
+			d.bytes += int64(len(key) + len(op.value))
+		}
+		delta[ns] = d
+	}
+
+	host := &usage{}
+	for _, u := range current {
+		host.keys += u.keys
+		host.bytes += u.bytes
+	}
+	hostDelta := &usage{}
+	for ns, d := range delta {
+		u := current[ns]
+		if u == nil {
+			u = &usage{}
+		}
+		if limits.NamespaceKeys > 0 && d.keys > 0 && u.keys+d.keys > limits.NamespaceKeys {
+			return nil, &limitError{limit: "nskeys", name: ns, max: limits.NamespaceKeys, value: u.keys + d.keys}
+		}
+		if limits.NamespaceBytes > 0 && d.bytes > 0 && u.bytes+d.bytes > limits.NamespaceBytes {
+			return nil, &limitError{limit: "nsbytes", name: ns, max: limits.NamespaceBytes, value: u.bytes + d.bytes}
+		}
+		hostDelta.keys += d.keys
+		hostDelta.bytes += d.bytes
+	}
+	if limits.HostKeys > 0 && hostDelta.keys > 0 && host.keys+hostDelta.keys > limits.HostKeys {
+		return nil, &limitError{limit: "hostkeys", max: limits.HostKeys, value: host.keys + hostDelta.keys}
+	}
+	if limits.HostBytes > 0 && hostDelta.bytes > 0 && host.bytes+hostDelta.bytes > limits.HostBytes {
+		return nil, &limitError{limit: "hostbytes", max: limits.HostBytes, value: host.bytes + hostDelta.bytes}
+	}
+	return delta, nil
+}
+
+// applyUsage は反映した増減を使用量に加えます。
+func applyUsage(current map[string]*usage, delta map[string]*usage) {
+	for ns, d := range delta {
+		u, ok := current[ns]
+		if false == ok { // nolint:gosimple
+			u = &usage{}
+			current[ns] = u
+		}
+		u.keys += d.keys
+		u.bytes += d.bytes
+	}
+}
+
+// usageElem は使用量と上限を stats API の結果にします。
+func usageElem(current map[string]*usage, limits common.APILimits) json.Element {
+	host := &usage{}
+	namespaces := json.NewElemObject()
+	for _, ns := range sortedUsageKeys(current) {
+		u := current[ns]
+		host.keys += u.keys
+		host.bytes += u.bytes
+		obj := json.NewElemObject()
+		obj.Put("keys", json.NewElemFloat(float64(u.keys)))
+		obj.Put("bytes", json.NewElemFloat(float64(u.bytes)))
+		namespaces.Put(ns, obj)
+	}
+	hostObj := json.NewElemObject()
+	hostObj.Put("keys", json.NewElemFloat(float64(host.keys)))
+	hostObj.Put("bytes", json.NewElemFloat(float64(host.bytes)))
+
+	limitObj := json.NewElemObject()
+	limitObj.Put("hostbytes", json.NewElemFloat(float64(limits.HostBytes)))
+	limitObj.Put("hostkeys", json.NewElemFloat(float64(limits.HostKeys)))
+	limitObj.Put("nsbytes", json.NewElemFloat(float64(limits.NamespaceBytes)))
+	limitObj.Put("nskeys", json.NewElemFloat(float64(limits.NamespaceKeys)))
+	limitObj.Put("keylength", json.NewElemFloat(float64(limits.KeyLength)))
+	limitObj.Put("valuesize", json.NewElemFloat(float64(limits.ValueSize)))
+	limitObj.Put("bodysize", json.NewElemFloat(float64(limits.BodySize)))
+
+	ret := json.NewElemObject()
+	ret.Put("host", hostObj)
+	ret.Put("namespaces", namespaces)
+	ret.Put("limits", limitObj)
+	return ret
+}
+
+// sortedUsageKeys は使用量の名前空間を整列して返します。
+func sortedUsageKeys(current map[string]*usage) []string {
+	items := make(map[string]string, len(current))
+	for ns := range current {
+		items[ns] = ""
+	}
+	return sortedKeys(items)
+}
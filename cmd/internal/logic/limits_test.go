@@ -0,0 +1,63 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits common.APILimits
+		ops    []*storageOp
+		// 超える上限の名前、空文字列は超えない
+		want string
+	}{
+		{name: "no limits", ops: []*storageOp{{op: "put", ns: "a", key: "new", value: "value"}}},
+		{name: "keylength", limits: common.APILimits{KeyLength: 3}, ops: []*storageOp{{op: "put", ns: "a", key: "long", value: "v"}}, want: "keylength"},
+		{name: "valuesize", limits: common.APILimits{ValueSize: 4}, ops: []*storageOp{{op: "put", ns: "a", key: "k", value: "12345"}}, want: "valuesize"},
+		{name: "nskeys", limits: common.APILimits{NamespaceKeys: 2}, ops: []*storageOp{{op: "put", ns: "a", key: "new", value: "v"}}, want: "nskeys"},
+		{name: "nskeys other ns", limits: common.APILimits{NamespaceKeys: 2}, ops: []*storageOp{{op: "put", ns: "b", key: "new", value: "v"}}},
+		// a/k=1 a/l=2 は 4 バイト
+		{name: "nsbytes", limits: common.APILimits{NamespaceBytes: 6}, ops: []*storageOp{{op: "put", ns: "a", key: "k", value: "1234"}}, want: "nsbytes"},
+		{name: "hostkeys", limits: common.APILimits{HostKeys: 3}, ops: []*storageOp{{op: "put", ns: "b", key: "x", value: "v"}, {op: "put", ns: "b", key: "y", value: "v"}}, want: "hostkeys"},
+		{name: "hostbytes", limits: common.APILimits{HostBytes: 6}, ops: []*storageOp{{op: "put", ns: "b", key: "x", value: "vv"}}, want: "hostbytes"},
+		// 使用量が減る書き込みは上限を超えていても認める
+		{name: "shrink over limit", limits: common.APILimits{NamespaceKeys: 1}, ops: []*storageOp{{op: "del", ns: "a", key: "k"}}},
+		{name: "overwrite", limits: common.APILimits{NamespaceKeys: 2}, ops: []*storageOp{{op: "put", ns: "a", key: "k", value: "2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := openFileStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "1"}, {op: "put", ns: "a", key: "l", value: "2"}}); err != nil {
+				t.Fatal(err)
+			}
+			current, err := loadUsage(st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx := newTransaction(st)
+			for _, op := range tt.ops {
+				if op.op == "put" {
+					tx.put(op.ns, op.key, op.value)
+				} else {
+					tx.del(op.ns, op.key)
+				}
+			}
+			_, err = checkLimits(tx, current, tt.limits)
+			got := ""
+			if lerr, ok := err.(*limitError); ok {
+				got = lerr.limit
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("checkLimits() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
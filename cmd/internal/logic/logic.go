@@ -1,15 +1,30 @@
 package logic
 
 import (
-	"os"
-	"path/filepath"
+	"bytes"
+	"encoding/base64"
 	"strings"
+	"time"
 
 	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
 )
 
 // backgroundLogic は窓口となるバックグラウンド処理です。
 func backgroundLogic(a *api) {
+	a.mu.Lock()
+	a.running = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running = false
+		a.mu.Unlock()
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// 前回までに書き込まれた有効期限を確認
+	a.sweep()
 	for {
 		var param *apiParam
 		select {
@@ -25,31 +40,60 @@ func backgroundLogic(a *api) {
 						chk = false
 					}
 				}
-				if a.terminated {
-					param.result = json.NewElemNull()
+				a.mu.Lock()
+				terminated := a.terminated
+				a.mu.Unlock()
+				if terminated {
+					failTerminated(param)
 					param.done <- -1
+				} else if param.internal != nil {
+					// 同期などの内部の処理を実行
+					start := time.Now()
+					execInternal(a, param)
+					observeAPI(a, param, param.method, start)
 				} else {
 					// APIのロジックを実行
+					start := time.Now()
 					execLogic(a, param)
+					observeAPI(a, param, apiMethodName(a, param), start)
 				}
 			}
 
+		case <-ticker.C:
+			// 有効期限の過ぎた項目を削除
+			if a.nextExpire != 0 && a.nextExpire <= unixMilli(time.Now()) {
+				a.sweep()
+			}
+
 		case <-a.done:
-			// 即時全終了
+			// 即時全終了、ストレージは Terminate が閉じる
 			return
 		}
 	}
 }
 
+// failTerminated は停止した後の要求を失敗させます。
+func failTerminated(param *apiParam) {
+	param.err = common.NewAPIError(common.ErrUnavailable, "api terminated")
+	param.result = json.NewElemNull()
+}
+
 // APIのロジックを実行
 func execLogic(a *api, param *apiParam) bool {
 	log := a.config.Logger()
+	if locked, ok := a.storage.(*lockedStorage); ok {
+		// 暗号化の鍵が使えない
+		a.sendError(param, common.ErrKeyUnavailable, locked.err.Error())
+		param.result = json.NewElemNull()
+		param.done <- -1
+		return false
+	}
 	// パラメータをJSONオブジェクトに
 	var jsonObj json.ElemObject
 	var ok bool
 	if jsonObj, ok = param.elem.AsObject(); false == ok {
 		// JSONオブジェクトでなかったのでエラー
-		a.sendError(param, "not object")
+		a.sendError(param, common.ErrInvalidRequest, "not object")
 		param.result = json.NewElemNull()
 		param.done <- -1
 		return false
@@ -59,15 +103,16 @@ func execLogic(a *api, param *apiParam) bool {
 	var version json.ElemString
 	if version, ok = jsonObj.Child("version").AsString(); false == ok {
 		// バージョン指定が異常なのでエラー
-		a.sendError(param, "invalid version")
+		a.sendError(param, common.ErrInvalidVersion, "invalid version")
 		param.result = json.NewElemNull()
 		param.done <- -1
 		return false
 	}
-	// 現在は ver.1
-	if version.Text() != "1" {
+	// ver.1 と、改訂番号と有効期限を扱う ver.2
+	apiVersion := version.Text()
+	if apiVersion != "1" && apiVersion != "2" {
 		// バージョン指定が異常なのでエラー
-		a.sendError(param, "unknown version")
+		a.sendError(param, common.ErrInvalidVersion, "unknown version")
 		param.result = json.NewElemNull()
 		param.done <- -1
 		return false
@@ -77,30 +122,42 @@ func execLogic(a *api, param *apiParam) bool {
 	var apiStr json.ElemString
 	if apiStr, ok = jsonObj.Child("api").AsString(); false == ok {
 		// APIが指定されていないのでエラー
-		a.sendError(param, "no api")
+		a.sendError(param, common.ErrNoAPI, "no api")
 		param.result = json.NewElemNull()
 		param.done <- -1
 		return false
 	}
 
-	// データ記録フォルダ
-	storagePath := a.storagePath
+	// データの名前空間
+	ns := ""
 	if nameElem, ok := jsonObj.Child("name").AsString(); ok {
 		// name 別の格納先
-		storagePath = filepath.Join(storagePath, key2filename(nameElem.Text()))
+		ns = nameElem.Text()
+	}
+	if isReservedNs(ns) {
+		// 付帯情報などの内部の名前空間と衝突する
+		a.sendError(param, common.ErrInvalidParameter, "reserved name")
+		param.result = json.NewElemNull()
+		param.done <- -1
+		return false
 	}
-	// フォルダがない場合には作る
-	os.MkdirAll(storagePath, 0755)
 
 	// API 別の処理
 	apiMethod := strings.ToLower(apiStr.Text())
-	a.sendMessage(apiMethod, param, "start")
 	log.Infof(apiMethod)
 
+	if apiVersion == "2" {
+		switch apiMethod {
+		case "write", "read", "delete":
+			// 改訂番号と有効期限を扱う
+			return execItemLogic(a, param, apiMethod, ns, jsonObj)
+		}
+	}
+
 	switch apiMethod {
 	case "noop":
 		// ログ
-		log.Infof("%s: done", apiMethod)
+		log.Debugf("%s: done", apiMethod)
 
 		// 要求終了を通知
 		param.result = json.NewElemNull()
@@ -108,38 +165,55 @@ func execLogic(a *api, param *apiParam) bool {
 		return false
 
 	case "list":
-		res := listKeys(storagePath)
-		keys := make([]interface{}, 0)
-		for i := 0; i < res.Size(); i++ {
-			if key, ok := res.Child(i).AsString(); ok {
-				keys = append(keys, key.String())
-			}
+		page, err := readPageParam(jsonObj)
+		if err != nil {
+			a.sendError(param, common.ErrInvalidParameter, err.Error())
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
 		}
-		// イベント通知
-		a.sendArray(apiMethod, param, keys)
+		res, err := a.storage.List(ns)
+		if err != nil {
+			a.sendError(param, common.ErrStorage, "dont list")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		keys, cursor := page.apply(res)
 		// ログ
-		log.Infof("%s: done %+v", apiMethod, keys)
+		log.Debugf("%s: done %d keys", apiMethod, len(keys))
 
 		// 要求終了を通知
-		param.result = res
+		param.result = page.result(keys, cursor)
 		param.done <- 0
 		return false
 
 	case "dirs":
-		res := listHolders(a.storagePath)
-		keys := make([]interface{}, 0)
-		for i := 0; i < res.Size(); i++ {
-			if key, ok := res.Child(i).AsString(); ok {
-				keys = append(keys, key.String())
-			}
+		page, err := readPageParam(jsonObj)
+		if err != nil {
+			a.sendError(param, common.ErrInvalidParameter, err.Error())
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		res, err := a.storage.Namespaces()
+		if err != nil {
+			a.sendError(param, common.ErrStorage, "dont list")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
 		}
-		// イベント通知
-		a.sendArray(apiMethod, param, keys)
+		namespaces := userNamespaces(res)
+		if hasKey(jsonObj, "name") {
+			// name の直下の名前空間
+			namespaces = childNamespaces(namespaces, ns)
+		}
+		keys, cursor := page.apply(namespaces)
 		// ログ
-		log.Infof("%s: done %+v", apiMethod, keys)
+		log.Debugf("%s: done %d namespaces", apiMethod, len(keys))
 
 		// 要求終了を通知
-		param.result = res
+		param.result = page.result(keys, cursor)
 		param.done <- 0
 		return false
 
@@ -147,32 +221,31 @@ func execLogic(a *api, param *apiParam) bool {
 		var items json.ElemObject
 		if items, ok = jsonObj.Child("items").AsObject(); false == ok {
 			// items がキーと値のオブジェクトでなかったのでエラー
-			a.sendError(param, "write items must object")
+			a.sendError(param, common.ErrInvalidParameter, "write items must object")
 			param.result = json.NewElemNull()
 			param.done <- -1
 			return false
 		}
+		keys := make([]interface{}, 0)
 
-		// 一時的に .temp に書き込み
-		keys, err := addData(storagePath, items)
-		if err != nil {
-			a.sendError(param, "dont write")
-			param.result = json.NewElemNull()
-			param.done <- -1
-			return false
+		// 書き込み
+		// 文字列以外の JSON 値もそのまま保存する
+		// 値の大きさは JSON の文字列にしたもので commit が上限 (valuesize) と比べる
+		tx := newTransaction(a.storage)
+		for _, key := range items.Keys() {
+			keys = append(keys, key)
+			if _, err := tx.putItem(ns, key, items.Child(key), 0); err != nil {
+				a.sendError(param, common.ErrStorage, "dont write "+err.Error())
+				param.result = json.NewElemNull()
+				param.done <- -1
+				return false
+			}
 		}
-		// .temp を .txt に置き換え
-		err = commitData(storagePath)
-		if err != nil {
-			a.sendError(param, "dont rename")
-			param.result = json.NewElemNull()
-			param.done <- -1
-			return false
+		if err := a.commit(tx); err != nil {
+			return a.failCommit(param, common.ErrStorage, "dont write", err)
 		}
-		// イベント通知
-		a.sendArray(apiMethod, param, keys)
 		// ログ
-		log.Infof("%s: done %+v", apiMethod, keys)
+		log.Debugf("%s: done %+v", apiMethod, keys)
 
 		// 要求終了を通知
 		param.result = json.Parse(keys)
@@ -183,25 +256,31 @@ func execLogic(a *api, param *apiParam) bool {
 		var items json.ElemArray
 		if items, ok = jsonObj.Child("items").AsArray(); false == ok {
 			// items がキーの配列でなかったのでエラー
-			a.sendError(param, "read items must array")
+			a.sendError(param, common.ErrInvalidParameter, "read items must array")
 			param.result = json.NewElemNull()
 			param.done <- -1
 			return false
 		}
 
 		// データを読み出す
+		tx := newTransaction(a.storage)
 		keys := make([]interface{}, 0)
 		ret := json.NewElemObject()
 		for idx := 0; idx < items.Size(); idx++ {
 			item := items.Child(idx)
 			key := item.Text()
-			ret.Put(key, loadItemValue(storagePath, key))
+			value, meta, _, err := tx.getItem(ns, key)
+			if err != nil {
+				a.sendError(param, common.ErrStorage, "dont read")
+				param.result = json.NewElemNull()
+				param.done <- -1
+				return false
+			}
+			ret.Put(key, valueElem(value, meta))
 			keys = append(keys, key)
 		}
-		// イベント通知
-		a.sendArray(apiMethod, param, keys)
 		// ログ
-		log.Infof("%s: done %+v", apiMethod, ret)
+		log.Debugf("%s: done %+v", apiMethod, ret)
 
 		// 要求終了を通知
 		param.result = ret
@@ -212,37 +291,217 @@ func execLogic(a *api, param *apiParam) bool {
 		var items json.ElemArray
 		if items, ok = jsonObj.Child("items").AsArray(); false == ok {
 			// items がキーの配列でなかったのでエラー
-			a.sendError(param, "read items must array")
+			a.sendError(param, common.ErrInvalidParameter, "read items must array")
 			param.result = json.NewElemNull()
 			param.done <- -1
 			return false
 		}
 
 		// データを削除
-		keys := make([]interface{}, 0)
+		tx := a.newTx()
+		deleted := []string{}
 		for idx := 0; idx < items.Size(); idx++ {
-			item := items.Child(idx)
-			key := item.Text()
-			if err := deleteItem(storagePath, key); err == nil {
-				keys = append(keys, key)
+			key := items.Child(idx).Text()
+			if _, _, exists, err := tx.getItem(ns, key); err != nil || false == exists { // nolint:gosimple
+				continue
 			}
+			tx.delItem(ns, key)
+			deleted = append(deleted, key)
+		}
+		if err := a.commit(tx); err != nil {
+			a.sendError(param, common.ErrStorage, "dont delete")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
 		}
-		// イベント通知
-		a.sendArray(apiMethod, param, keys)
+		keys := stringsToArray(deleted)
 		// ログ
-		log.Infof("%s: done %+v", apiMethod, keys)
+		log.Debugf("%s: done %+v", apiMethod, keys)
 
 		// 要求終了を通知
 		param.result = json.Parse(keys)
 		param.done <- 0
 		return false
 
+	case "query":
+		// 値を条件で絞り込んで読み出す
+		ret, keys, err := execQuery(newTransaction(a.storage), ns, jsonObj)
+		if err != nil {
+			a.sendError(param, common.ErrInvalidParameter, "dont query "+err.Error())
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		// ログ
+		log.Debugf("%s: done %+v", apiMethod, keys)
+
+		// 要求終了を通知
+		param.result = ret
+		param.done <- 0
+		return false
+
+	case "backup":
+		// 全ての名前空間のデータを zip にして base64 で返す
+		buffer := &bytes.Buffer{}
+		count, err := writeBackup(a.storage, a.docGroupName, buffer)
+		if err != nil {
+			a.sendError(param, common.ErrStorage, "dont backup "+err.Error())
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		ret := json.NewElemObject()
+		ret.Put("format", json.NewElemString("zip"))
+		ret.Put("encoding", json.NewElemString("base64"))
+		ret.Put("count", json.NewElemFloat(float64(count)))
+		ret.Put("data", json.NewElemString(base64.StdEncoding.EncodeToString(buffer.Bytes())))
+		// ログ
+		log.Debugf("%s: done %d items", apiMethod, count)
+
+		// 要求終了を通知
+		param.result = ret
+		param.done <- 0
+		return false
+
+	case "restore":
+		// backup で得た data を検証して全てか無かで復元する
+		data, err := base64.StdEncoding.DecodeString(jsonObj.Child("data").Text())
+		if err != nil {
+			a.sendError(param, common.ErrInvalidParameter, "restore data must base64")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		mode := ""
+		if modeElem, ok := jsonObj.Child("mode").AsString(); ok {
+			mode = strings.ToLower(modeElem.Text())
+		}
+		tx := a.newTx()
+		written, deleted, err := restoreBackup(tx, bytes.NewReader(data), int64(len(data)), mode)
+		if err == nil {
+			err = a.commit(tx)
+		}
+		if err != nil {
+			return a.failCommit(param, common.ErrInvalidParameter, "dont restore "+err.Error(), err)
+		}
+		ret := json.NewElemObject()
+		ret.Put("ok", json.NewElemBool(true))
+		ret.Put("written", json.NewElemFloat(float64(written)))
+		ret.Put("deleted", json.NewElemFloat(float64(deleted)))
+		// ログ
+		log.Debugf("%s: done %d written %d deleted", apiMethod, written, deleted)
+
+		// 要求終了を通知
+		param.result = ret
+		param.done <- 0
+		return false
+
+	case "stats":
+		// 使用量と上限
+		current, err := a.currentUsage()
+		if err != nil {
+			a.sendError(param, common.ErrStorage, "dont stats")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+		ret := usageElem(current, a.config.APILimits(a.docGroupName))
+		// ログ
+		log.Debugf("%s: done %+v", apiMethod, ret)
+
+		// 要求終了を通知
+		param.result = ret
+		param.done <- 0
+		return false
+
+	case "batch":
+		var ops json.ElemArray
+		if ops, ok = jsonObj.Child("ops").AsArray(); false == ok {
+			// ops が操作の配列でなかったのでエラー
+			a.sendError(param, common.ErrInvalidParameter, "batch ops must array")
+			param.result = json.NewElemNull()
+			param.done <- -1
+			return false
+		}
+
+		// 全ての操作を一つのトランザクションで実行
+		ret, err := execBatch(a, ns, ops)
+		if err != nil {
+			return a.failCommit(param, common.ErrInvalidParameter, err.Error(), err)
+		}
+		// ログ
+		log.Debugf("%s: done %+v", apiMethod, ret)
+
+		// 要求終了を通知
+		param.result = ret
+		param.done <- 0
+		return false
+
 	default:
+		// 外部コマンドに割り当てられた API は Execute が内部の処理としてキューに積む
 		// 未知のAPIが指定されていたのでエラー
-		a.sendError(param, "unknown api")
+		a.sendError(param, common.ErrUnknownAPI, "unknown api")
+		param.result = json.NewElemNull()
+		param.done <- -1
+		return false
+
+	}
+}
+
+// execInternal は内部の処理を実行します。
+func execInternal(a *api, param *apiParam) bool {
+	if locked, ok := a.storage.(*lockedStorage); ok {
+		// 暗号化の鍵が使えない
+		a.sendError(param, common.ErrKeyUnavailable, locked.err.Error())
 		param.result = json.NewElemNull()
 		param.done <- -1
 		return false
+	}
+	ret, err := param.internal()
+	if err != nil {
+		return a.failCommit(param, common.ErrStorage, err.Error(), err)
+	}
+	param.result = ret
+	param.done <- 0
+	return true
+}
+
+// apiMethodName は計測値のラベルにする API 名を返します。
+func apiMethodName(a *api, param *apiParam) string {
+	if str, ok := json.QueryElemString(param.elem, "api"); ok {
+		return methodLabel(a, str.Text())
+	}
+	return "unknown"
+}
+
+// methodLabel は API 名 name を計測値のラベルにします。
+// 組み込みの API と設定した外部コマンド以外は、要求が自由に決める名前で種類が増え続けないよう unknown にまとめます。
+func methodLabel(a *api, name string) string {
+	name = strings.ToLower(name)
+	if common.IsBuiltinAPI(name) {
+		return name
+	}
+	if _, ok := a.config.APIExtension(a.docGroupName, name); ok {
+		return name
+	}
+	return "unknown"
+}
+
+// observeAPI は API の実行時間と結果を集計します。
+func observeAPI(a *api, param *apiParam, method string, start time.Time) {
+	result := "ok"
+	if param.err != nil {
+		result = param.err.Code
+	}
+	metrics.APIRequests.Inc(a.docGroupName, method, result)
+	metrics.APIDuration.Observe(time.Since(start).Seconds(), a.docGroupName, method)
+}
 
+// stringsToArray は文字列の配列をイベント通知用の配列に変換します。
+func stringsToArray(strs []string) []interface{} {
+	arr := make([]interface{}, 0, len(strs))
+	for _, str := range strs {
+		arr = append(arr, str)
 	}
+	return arr
 }
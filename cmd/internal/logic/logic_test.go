@@ -0,0 +1,56 @@
+package logic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
+)
+
+func TestMethodLabel(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.extensions = map[string]common.APIExtension{"resize": {}}
+	a := &api{config: conf, docGroupName: "test"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"write", "write"},
+		{"Write", "write"},
+		{"resize", "resize"},
+		{"RESIZE", "resize"},
+		{"x7f3a", "unknown"},
+		{"", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := methodLabel(a, tt.name); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestObserveUnknownAPI(t *testing.T) {
+	a := newTestApi(t, newTestConfig(t))
+	// 未知の API 名は、API 名を確かめる前に失敗した要求でも unknown として数える
+	requests := []string{
+		`{"version": "9", "api": "junk-version"}`,
+		`{"version": "1", "api": "junk-api"}`,
+	}
+	for _, request := range requests {
+		if _, err := a.Execute(request); err == nil {
+			t.Fatalf("Execute(%s) succeeded", request)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := metrics.Default.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "junk") {
+		t.Errorf("request api name is used as a label:\n%s", buf.String())
+	}
+	if false == strings.Contains(buf.String(), `ziphttpd_api_requests_total{host="test",method="unknown",result="`+common.ErrInvalidVersion+`"}`) { // nolint:gosimple
+		t.Errorf("invalid version request is not counted as unknown:\n%s", buf.String())
+	}
+}
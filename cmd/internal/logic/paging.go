@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+const (
+	// 名前空間の階層の区切り
+	nsSeparator = "/"
+)
+
+// pageParam は list / dirs の絞り込みと分割の指定です。
+//
+//	{"prefix":"接頭辞", "start":"この値以上", "end":"この値未満", "limit":100, "cursor":"前回の cursor"}
+//
+// いずれかを指定すると結果は {"keys":[...], "cursor":"続きの cursor"} になり、
+// 続きが無ければ cursor を返しません。指定しなければ従来どおりキーの配列を返します。
+type pageParam struct {
+	prefix string
+	start  string
+	end    string
+	// 前回の最後のキー
+	after string
+	// 0 は無制限
+	limit int
+	// いずれかの指定があった
+	paged bool
+}
+
+// readPageParam は要求から絞り込みと分割の指定を読みだします。
+func readPageParam(obj json.ElemObject) (*pageParam, error) {
+	p := &pageParam{}
+	for _, item := range []struct {
+		key   string
+		value *string
+	}{{"prefix", &p.prefix}, {"start", &p.start}, {"end", &p.end}} {
+		if str, ok := obj.Child(item.key).AsString(); ok {
+			*item.value = str.Text()
+			p.paged = true
+		}
+	}
+	if num, ok := obj.Child("limit").AsFloat(); ok {
+		if num.Float() < 0 {
+			return nil, fmt.Errorf("invalid limit")
+		}
+		p.limit = int(num.Float())
+		p.paged = true
+	}
+	if str, ok := obj.Child("cursor").AsString(); ok {
+		after, err := base64.RawURLEncoding.DecodeString(str.Text())
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		p.after = string(after)
+		p.paged = true
+	}
+	return p, nil
+}
+
+// apply はキーを整列して絞り込み、一頁分と続きの cursor を返します。
+func (p *pageParam) apply(keys []string) ([]string, string) {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	page := []string{}
+	for _, key := range sorted {
+		if false == strings.HasPrefix(key, p.prefix) || key < p.start { // nolint:gosimple
+			continue
+		}
+		if p.end != "" && key >= p.end {
+			break
+		}
+		if p.after != "" && key <= p.after {
+			continue
+		}
+		if p.limit > 0 && len(page) == p.limit {
+			// 続きがある
+			return page, base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1]))
+		}
+		page = append(page, key)
+	}
+	return page, ""
+}
+
+// result は結果を返します。
+func (p *pageParam) result(page []string, cursor string) json.Element {
+	if false == p.paged { // nolint:gosimple
+		return json.Parse(stringsToArray(page))
+	}
+	ret := json.NewElemObject()
+	ret.Put("keys", json.Parse(stringsToArray(page)))
+	if cursor != "" {
+		ret.Put("cursor", json.NewElemString(cursor))
+	}
+	return ret
+}
+
+// childNamespaces は parent の直下の名前空間を返します。
+// 名前空間は / で階層化でき、"a" の直下は "a/b" です。
+// "a/b/c" しか無くても、その途中の "a/b" を直下の名前空間として返します。
+// parent が空文字列であれば最上位の名前空間を返します。
+func childNamespaces(namespaces []string, parent string) []string {
+	res := []string{}
+	found := map[string]bool{}
+	prefix := ""
+	if parent != "" {
+		prefix = parent + nsSeparator
+	}
+	for _, ns := range namespaces {
+		if false == strings.HasPrefix(ns, prefix) { // nolint:gosimple
+			continue
+		}
+		rest := ns[len(prefix):]
+		if i := strings.Index(rest, nsSeparator); i >= 0 {
+			rest = rest[:i]
+		}
+		if rest == "" || found[rest] {
+			continue
+		}
+		found[rest] = true
+		res = append(res, prefix+rest)
+	}
+	return res
+}
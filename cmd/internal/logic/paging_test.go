@@ -0,0 +1,99 @@
+package logic
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+func TestChildNamespaces(t *testing.T) {
+	namespaces := []string{"a/b/c", "a/d", "x", "x/y", "ab"}
+	tests := []struct {
+		parent string
+		want   []string
+	}{
+		{parent: "", want: []string{"a", "ab", "x"}},
+		{parent: "a", want: []string{"a/b", "a/d"}},
+		{parent: "a/b", want: []string{"a/b/c"}},
+		{parent: "x", want: []string{"x/y"}},
+		{parent: "a/b/c", want: []string{}},
+		{parent: "none", want: []string{}},
+	}
+	for _, tt := range tests {
+		got := childNamespaces(namespaces, tt.parent)
+		sort.Strings(got)
+		if false == reflect.DeepEqual(got, tt.want) { // nolint:gosimple
+			t.Errorf("childNamespaces(%q) = %v, want %v", tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestPageParam(t *testing.T) {
+	keys := []string{"d", "a", "c", "b", "ab", "e"}
+	tests := []struct {
+		name       string
+		param      string
+		want       []string
+		wantCursor bool
+	}{
+		{name: "all", param: `{}`, want: []string{"a", "ab", "b", "c", "d", "e"}},
+		{name: "prefix", param: `{"prefix":"a"}`, want: []string{"a", "ab"}},
+		{name: "range", param: `{"start":"b","end":"d"}`, want: []string{"b", "c"}},
+		{name: "limit", param: `{"limit":2}`, want: []string{"a", "ab"}, wantCursor: true},
+		{name: "exact limit", param: `{"limit":6}`, want: []string{"a", "ab", "b", "c", "d", "e"}},
+		{name: "cursor", param: `{"limit":2,"cursor":"YWI"}`, want: []string{"b", "c"}, wantCursor: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := loadObject(t, tt.param)
+			p, err := readPageParam(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, cursor := p.apply(keys)
+			if false == reflect.DeepEqual(page, tt.want) || (cursor != "") != tt.wantCursor { // nolint:gosimple
+				t.Errorf("apply() = %v, %q, want %v (cursor %v)", page, cursor, tt.want, tt.wantCursor)
+			}
+		})
+	}
+
+	// cursor で続きを全て読める
+	obj := loadObject(t, `{"limit":4}`)
+	p, _ := readPageParam(obj)
+	all := []string{}
+	for i := 0; i < 10; i++ {
+		page, cursor := p.apply(keys)
+		all = append(all, page...)
+		if cursor == "" {
+			break
+		}
+		obj.Put("cursor", json.NewElemString(cursor))
+		p, _ = readPageParam(obj)
+	}
+	if len(all) != len(keys) {
+		t.Errorf("paged %v, want %d keys", all, len(keys))
+	}
+
+	for _, bad := range []string{`{"limit":-1}`, `{"cursor":"!!"}`} {
+		obj := loadObject(t, bad)
+		if _, err := readPageParam(obj); err == nil {
+			t.Errorf("readPageParam(%s) succeeded", bad)
+		}
+	}
+}
+
+// loadObject は JSON のオブジェクトを読みます。
+func loadObject(t *testing.T, text string) json.ElemObject {
+	t.Helper()
+	elem, err := json.LoadFromJSONByte([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		t.Fatalf("not an object : %s", text)
+	}
+	return obj
+}
@@ -0,0 +1,212 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// queryFilter は query API の一つの条件です。
+type queryFilter struct {
+	// 値の中の位置 (JSON ポインタ)
+	tokens []string
+	// 比較方法
+	op string
+	// 比較する値
+	value json.Element
+}
+
+// execQuery は名前空間の値を条件で絞り込み、指定した位置だけを取り出します。
+//
+//	{"version":"1", "api":"query", "name":"名前空間",
+//	 "prefix":"キーの接頭辞",
+//	 "where":[{"path":"/status", "op":"eq", "value":"open"}, {"path":"/tags", "op":"contains", "value":"a"}],
+//	 "select":["/title", "/owner/name"],
+//	 "limit":10}
+//
+// 結果はキーと値のオブジェクトで、select を指定した場合の値は JSON ポインタと値のオブジェクトになります。
+// op は eq, ne, lt, le, gt, ge, exists, missing, prefix, contains です。
+// 条件は全て満たす項目だけを、キーの順に limit 件まで返します。
+func execQuery(tx *transaction, ns string, jsonObj json.ElemObject) (json.Element, []interface{}, error) {
+	filters := []*queryFilter{}
+	if where, ok := jsonObj.Child("where").AsArray(); ok {
+		for idx := 0; idx < where.Size(); idx++ {
+			cond, ok := where.Child(idx).AsObject()
+			if false == ok { // nolint:gosimple
+				return nil, nil, fmt.Errorf("where %d must object", idx)
+			}
+			tokens, err := splitPointer(childText(cond, "path"))
+			if err != nil {
+				return nil, nil, err
+			}
+			f := &queryFilter{tokens: tokens, op: strings.ToLower(childText(cond, "op")), value: cond.Child("value")}
+			if false == validQueryOp(f.op) { // nolint:gosimple
+				return nil, nil, fmt.Errorf("unknown op %s", f.op)
+			}
+			filters = append(filters, f)
+		}
+	}
+	var selects []string
+	if sel, ok := jsonObj.Child("select").AsArray(); ok {
+		for idx := 0; idx < sel.Size(); idx++ {
+			pointer := sel.Child(idx).Text()
+			if _, err := splitPointer(pointer); err != nil {
+				return nil, nil, err
+			}
+			selects = append(selects, pointer)
+		}
+	}
+	prefix := ""
+	if str, ok := jsonObj.Child("prefix").AsString(); ok {
+		prefix = str.Text()
+	}
+	limit := -1
+	if num, ok := jsonObj.Child("limit").AsFloat(); ok {
+		limit = int(num.Float())
+	}
+
+	list, err := tx.st.List(ns)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 暗号化したホストの List は暗号化した名前の順なので、list API と同じく整列してから絞り込む
+	list, _ = (&pageParam{prefix: prefix}).apply(list)
+	keys := make([]interface{}, 0)
+	ret := json.NewElemObject()
+	for _, key := range list {
+		if limit >= 0 && len(keys) >= limit {
+			break
+		}
+		str, meta, exists, err := tx.getItem(ns, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if false == exists { // nolint:gosimple
+			continue
+		}
+		value := valueElem(str, meta)
+		if false == matchFilters(value, filters) { // nolint:gosimple
+			continue
+		}
+		if selects == nil {
+			ret.Put(key, value)
+		} else {
+			obj := json.NewElemObject()
+			for _, pointer := range selects {
+				tokens, _ := splitPointer(pointer)
+				if elem, ok := lookupPointer(value, tokens); ok {
+					obj.Put(pointer, elem)
+				}
+			}
+			ret.Put(key, obj)
+		}
+		keys = append(keys, key)
+	}
+	return ret, keys, nil
+}
+
+// validQueryOp は比較方法が使えれば真を返します。
+func validQueryOp(op string) bool {
+	switch op {
+	case "eq", "ne", "lt", "le", "gt", "ge", "exists", "missing", "prefix", "contains":
+		return true
+	}
+	return false
+}
+
+// matchFilters は全ての条件を満たせば真を返します。
+func matchFilters(value json.Element, filters []*queryFilter) bool {
+	for _, f := range filters {
+		elem, found := lookupPointer(value, f.tokens)
+		if false == matchFilter(elem, found, f) { // nolint:gosimple
+			return false
+		}
+	}
+	return true
+}
+
+// matchFilter は一つの条件を満たせば真を返します。
+func matchFilter(elem json.Element, found bool, f *queryFilter) bool {
+	switch f.op {
+	case "exists":
+		return found
+	case "missing":
+		return false == found // nolint:gosimple
+	}
+	if false == found { // nolint:gosimple
+		return false
+	}
+	switch f.op {
+	case "eq":
+		return equalElem(elem, f.value)
+	case "ne":
+		return false == equalElem(elem, f.value) // nolint:gosimple
+	case "lt", "le", "gt", "ge":
+		cmp, ok := compareElem(elem, f.value)
+		if false == ok { // nolint:gosimple
+			return false
+		}
+		switch f.op {
+		case "lt":
+			return cmp < 0
+		case "le":
+			return cmp <= 0
+		case "gt":
+			return cmp > 0
+		}
+		return cmp >= 0
+	case "prefix":
+		str, ok := elem.AsString()
+		return ok && strings.HasPrefix(str.Text(), f.value.Text())
+	case "contains":
+		if str, ok := elem.AsString(); ok {
+			return strings.Contains(str.Text(), f.value.Text())
+		}
+		if arr, ok := elem.AsArray(); ok {
+			for i := 0; i < arr.Size(); i++ {
+				if equalElem(arr.Child(i), f.value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// equalElem は二つの値が等しければ真を返します。
+func equalElem(a, b json.Element) bool {
+	if cmp, ok := compareElem(a, b); ok {
+		return cmp == 0
+	}
+	if ab, ok := a.AsBool(); ok {
+		bb, ok := b.AsBool()
+		return ok && ab.Bool() == bb.Bool()
+	}
+	return json.ToJSON(a, false) == json.ToJSON(b, false)
+}
+
+// compareElem は数値同士か文字列同士の大小を比較します。比較できなければ ok が偽です。
+func compareElem(a, b json.Element) (int, bool) {
+	if af, ok := a.AsFloat(); ok {
+		bf, ok := b.AsFloat()
+		if false == ok { // nolint:gosimple
+			return 0, false
+		}
+		switch {
+		case af.Float() < bf.Float():
+			return -1, true
+		case af.Float() > bf.Float():
+			return 1, true
+		}
+		return 0, true
+	}
+	if as, ok := a.AsString(); ok {
+		bs, ok := b.AsString()
+		if false == ok { // nolint:gosimple
+			return 0, false
+		}
+		return strings.Compare(as.Text(), bs.Text()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,144 @@
+package logic
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func TestWriteReadJSONValues(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.limits = common.APILimits{ValueSize: 16}
+	a := newTestApi(t, conf)
+
+	items := `{"s": "text", "n": 1.5, "b": true, "z": null, "o": {"a": [1, "x"]}, "arr": [1, 2]}`
+	execute(t, a, `{"version": "1", "api": "write", "name": "a", "items": `+items+`}`)
+	got := loadObject(t, execute(t, a, `{"version": "1", "api": "read", "name": "a", "items": ["s", "n", "b", "z", "o", "arr"]}`))
+	want := loadObject(t, items)
+	for _, key := range want.Keys() {
+		if json.ToJSON(got.Child(key), false) != json.ToJSON(want.Child(key), false) {
+			t.Errorf("read %s = %s, want %s", key, json.ToJSON(got.Child(key), false), json.ToJSON(want.Child(key), false))
+		}
+	}
+
+	// JSON の値も JSON の文字列にした大きさで上限と比べる
+	_, err := a.Execute(`{"version": "1", "api": "write", "name": "a", "items": {"big": {"k": "0123456789"}}}`)
+	if code := apiErrorCode(err); code != common.ErrLimitExceeded {
+		t.Errorf("write large JSON value = %v, want %s", err, common.ErrLimitExceeded)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	a := newTestApi(t, newTestConfig(t))
+	execute(t, a, `{"version": "1", "api": "write", "name": "q", "items": {
+		"k3": {"status": "open", "n": 3, "title": "gamma"},
+		"k1": {"status": "open", "n": 1, "tags": ["a", "b"], "title": "alpha", "owner": {"name": "ann"}},
+		"x1": "plain",
+		"k2": {"status": "closed", "n": 2, "tags": ["b"], "title": "beta"}}}`)
+
+	tests := []struct {
+		name   string
+		params string
+		want   []string
+	}{
+		{name: "all", params: `"where": []`, want: []string{"k1", "k2", "k3", "x1"}},
+		{name: "eq", params: `"where": [{"path": "/status", "op": "eq", "value": "open"}]`, want: []string{"k1", "k3"}},
+		{name: "ne", params: `"where": [{"path": "/status", "op": "ne", "value": "open"}]`, want: []string{"k2"}},
+		{name: "lt", params: `"where": [{"path": "/n", "op": "lt", "value": 2}]`, want: []string{"k1"}},
+		{name: "le", params: `"where": [{"path": "/n", "op": "le", "value": 2}]`, want: []string{"k1", "k2"}},
+		{name: "gt", params: `"where": [{"path": "/n", "op": "gt", "value": 2}]`, want: []string{"k3"}},
+		{name: "ge", params: `"where": [{"path": "/n", "op": "ge", "value": 2}]`, want: []string{"k2", "k3"}},
+		{name: "exists", params: `"where": [{"path": "/owner", "op": "exists"}]`, want: []string{"k1"}},
+		{name: "missing", params: `"where": [{"path": "/tags", "op": "missing"}]`, want: []string{"k3", "x1"}},
+		{name: "prefix op", params: `"where": [{"path": "/title", "op": "prefix", "value": "al"}]`, want: []string{"k1"}},
+		{name: "contains string", params: `"where": [{"path": "/title", "op": "contains", "value": "amm"}]`, want: []string{"k3"}},
+		{name: "contains array", params: `"where": [{"path": "/tags", "op": "contains", "value": "b"}]`, want: []string{"k1", "k2"}},
+		{name: "whole value", params: `"where": [{"path": "", "op": "eq", "value": "plain"}]`, want: []string{"x1"}},
+		{name: "all conditions", params: `"where": [{"path": "/status", "op": "eq", "value": "open"}, {"path": "/n", "op": "gt", "value": 1}]`, want: []string{"k3"}},
+		{name: "prefix and limit in key order", params: `"prefix": "k", "limit": 2`, want: []string{"k1", "k2"}},
+		{name: "limit counts matches", params: `"where": [{"path": "/status", "op": "eq", "value": "open"}], "limit": 2`, want: []string{"k1", "k3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loadObject(t, execute(t, a, `{"version": "1", "api": "query", "name": "q", `+tt.params+`}`))
+			keys := got.Keys()
+			sort.Strings(keys)
+			if false == reflect.DeepEqual(keys, tt.want) { // nolint:gosimple
+				t.Errorf("keys = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+
+	// select は指定した位置だけを JSON ポインタと値のオブジェクトで返し、無い位置は省く
+	got := loadObject(t, execute(t, a, `{"version": "1", "api": "query", "name": "q",
+		"where": [{"path": "/status", "op": "eq", "value": "open"}], "select": ["/title", "/owner/name"]}`))
+	wants := map[string]string{
+		"k1": `{"/title": "alpha", "/owner/name": "ann"}`,
+		"k3": `{"/title": "gamma"}`,
+	}
+	for key, text := range wants {
+		want := loadObject(t, text)
+		obj, ok := got.Child(key).AsObject()
+		if false == ok { // nolint:gosimple
+			t.Fatalf("select %s = %s, want object", key, json.ToJSON(got.Child(key), false))
+		}
+		if len(obj.Keys()) != len(want.Keys()) {
+			t.Errorf("select %s = %s, want %s", key, json.ToJSON(obj, false), text)
+		}
+		for _, pointer := range want.Keys() {
+			if obj.Child(pointer).Text() != want.Child(pointer).Text() {
+				t.Errorf("select %s %s = %s, want %s", key, pointer, obj.Child(pointer).Text(), want.Child(pointer).Text())
+			}
+		}
+	}
+
+	// 未知の比較方法と JSON ポインタでない位置は失敗する
+	for _, params := range []string{
+		`"where": [{"path": "/n", "op": "like", "value": 1}]`,
+		`"where": [{"path": "n", "op": "eq", "value": 1}]`,
+		`"select": ["title"]`,
+	} {
+		if _, err := a.Execute(`{"version": "1", "api": "query", "name": "q", ` + params + `}`); apiErrorCode(err) != common.ErrInvalidParameter {
+			t.Errorf("query %s = %v, want %s", params, err, common.ErrInvalidParameter)
+		}
+	}
+}
+
+// reverseStorage は暗号化したホストのように、キーを整列せずに返す格納先です。
+type reverseStorage struct {
+	storage
+}
+
+func (s *reverseStorage) List(ns string) ([]string, error) {
+	keys, err := s.storage.List(ns)
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	return keys, err
+}
+
+func TestQueryKeyOrder(t *testing.T) {
+	st, err := openSingleStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	tx := newTransaction(st)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, err := tx.putItem("q", key, json.NewElemString(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, keys, err := execQuery(newTransaction(&reverseStorage{st}), "q", loadObject(t, `{"limit": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if false == reflect.DeepEqual(keys, []interface{}{"a", "b"}) { // nolint:gosimple
+		t.Errorf("keys = %v, want the first two keys in order", keys)
+	}
+}
@@ -0,0 +1,325 @@
+package logic
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	fpath "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 単一ファイルの名称
+	singleFileName = "apidata.db"
+	// これより小さいうちは詰め直さない
+	singleCompactMin = 1024 * 1024
+	// 一行ごとの CRC32、配列の括弧、改行の大きさ
+	singleLineOverhead = 8 + 1 + 2 + 1
+)
+
+// storageOp はトランザクション内の一つの操作です。
+type storageOp struct {
+	// "put" / "del"
+	op    string
+	ns    string
+	key   string
+	value string
+}
+
+// singleStorage は全ての名前空間を単一ファイルに保存する格納方式です。
+// ファイルは一行が一つのトランザクションの追記形式で、行ごとに CRC32 を持ちます。
+// 書き込み途中で停止した行は次回の起動時に捨てられるため、複数キーの書き込みも全てか無かになります。
+type singleStorage struct {
+	mu sync.Mutex
+	// ファイルのパス
+	filename string
+	// 追記するファイル
+	file *os.File
+	// 名前空間 -> キー -> 値
+	data map[string]map[string]string
+	// 名前空間 -> キー -> ファイル上の記録の大きさ
+	sizes map[string]map[string]int64
+	// ファイルの大きさ
+	fileSize int64
+	// 有効な記録の大きさ (詰め直しの目安)
+	liveSize int64
+	// 詰め直しの失敗を記録するロガー (nil なら記録しない)
+	log common.Logger
+}
+
+// openSingleStorage は folder の単一ファイルを開いて内容を読みだします。
+func openSingleStorage(folder string) (*singleStorage, error) {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", folder, err)
+	}
+	s := &singleStorage{
+		filename: fpath.Join(folder, singleFileName),
+		data:     map[string]map[string]string{},
+		sizes:    map[string]map[string]int64{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.file, err = os.OpenFile(s.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load はファイルのトランザクションを再生します。壊れた行以降は切り捨てます。
+func (s *singleStorage) load() error {
+	f, err := os.Open(s.filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return err
+		}
+		ops, ok := decodeTransaction(line)
+		if false == ok { // nolint:gosimple
+			break
+		}
+		_, sizes := encodeTransaction(ops)
+		s.apply(ops, sizes)
+		good += int64(len(line))
+	}
+	f.Close()
+	s.fileSize = good
+	// 書き込み途中の行を切り捨てる
+	if fi, err := os.Stat(s.filename); err == nil && fi.Size() != good {
+		return os.Truncate(s.filename, good)
+	}
+	return nil
+}
+
+// apply は操作をメモリ上の辞書に反映します。sizes は操作ごとのファイル上の記録の大きさです。
+func (s *singleStorage) apply(ops []*storageOp, sizes []int64) {
+	for i, op := range ops {
+		keys, ok := s.data[op.ns]
+		if false == ok { // nolint:gosimple
+			keys = map[string]string{}
+			s.data[op.ns] = keys
+			s.sizes[op.ns] = map[string]int64{}
+		}
+		if old, ok := s.sizes[op.ns][op.key]; ok {
+			s.liveSize -= old
+		}
+		switch op.op {
+		case "put":
+			keys[op.key] = op.value
+			s.sizes[op.ns][op.key] = sizes[i]
+			s.liveSize += sizes[i]
+		case "del":
+			delete(keys, op.key)
+			delete(s.sizes[op.ns], op.key)
+		}
+	}
+}
+
+// commit はトランザクションを追記してからメモリ上の辞書に反映します。
+// 書き込みが済んだ後の詰め直しの失敗は記録するだけで、エラーにしません。
+func (s *singleStorage) commit(ops []*storageOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if s.file == nil {
+		return fmt.Errorf("storage %s is closed", s.filename)
+	}
+	line, sizes := encodeTransaction(ops)
+	if _, err := s.file.Write(line); err != nil {
+		// 中途半端な行を切り捨てる (失敗しても次回の読み込みで捨てられる)
+		s.file.Truncate(s.fileSize)
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.fileSize += int64(len(line))
+	s.apply(ops, sizes)
+	if s.fileSize > singleCompactMin && s.fileSize > (s.liveSize+singleLineOverhead)*2 {
+		if err := s.compact(); err != nil && s.log != nil {
+			s.log.Warnf("storage : compact %s : %v", s.filename, err)
+		}
+	}
+	return nil
+}
+
+// compact は有効なデータだけのファイルに詰め直します。
+// 置き換えに失敗したときは元のファイルを開き直して追記を続けます。
+func (s *singleStorage) compact() error {
+	ops := []*storageOp{}
+	for _, ns := range sortedNamespaces(s.data) {
+		keys := s.data[ns]
+		for _, key := range sortedKeys(keys) {
+			ops = append(ops, &storageOp{op: "put", ns: ns, key: key, value: keys[key]})
+		}
+	}
+	line, _ := encodeTransaction(ops)
+	tempName := s.filename + extTemp
+	if err := writeFileSync(tempName, line, 0644); err != nil {
+		return err
+	}
+	s.file.Close()
+	if err := os.Rename(tempName, s.filename); err != nil {
+		os.Remove(tempName)
+		s.file, _ = s.reopen()
+		return err
+	}
+	syncDir(fpath.Dir(s.filename))
+	s.fileSize = int64(len(line))
+	var err error
+	s.file, err = s.reopen()
+	return err
+}
+
+// reopen は追記するファイルを開き直します。開けなければ nil を返し、以降の書き込みは失敗します。
+func (s *singleStorage) reopen() (*os.File, error) {
+	file, err := os.OpenFile(s.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// encodeTransaction はトランザクションを "CRC32 JSON\n" の一行にします。
+// 操作ごとの記録の大きさ (区切りのカンマを含む) も返します。
+func encodeTransaction(ops []*storageOp) ([]byte, []int64) {
+	parts := make([]string, len(ops))
+	sizes := make([]int64, len(ops))
+	for i, op := range ops {
+		obj := json.NewElemObject()
+		obj.Put("op", json.NewElemString(op.op))
+		obj.Put("ns", json.NewElemString(op.ns))
+		obj.Put("key", json.NewElemString(op.key))
+		if op.op == "put" {
+			obj.Put("value", json.NewElemString(op.value))
+		}
+		parts[i] = strings.ReplaceAll(json.ToJSON(obj, false), "\n", "")
+		sizes[i] = int64(len(parts[i]) + 1)
+	}
+	body := "[" + strings.Join(parts, ",") + "]"
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE([]byte(body)), body)), sizes
+}
+
+// decodeTransaction は一行のトランザクションを読みだします。壊れていれば ok が偽です。
+func decodeTransaction(line []byte) (ops []*storageOp, ok bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) < 10 || line[8] != ' ' {
+		return nil, false
+	}
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+		return nil, false
+	}
+	body := line[9:]
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, false
+	}
+	elem, err := json.LoadFromJSONByte(body)
+	if err != nil {
+		return nil, false
+	}
+	arr, ok := elem.AsArray()
+	if false == ok { // nolint:gosimple
+		return nil, false
+	}
+	ops = []*storageOp{}
+	for i := 0; i < arr.Size(); i++ {
+		obj, ok := arr.Child(i).AsObject()
+		if false == ok { // nolint:gosimple
+			return nil, false
+		}
+		op := &storageOp{
+			op:    childText(obj, "op"),
+			ns:    childText(obj, "ns"),
+			key:   childText(obj, "key"),
+			value: childText(obj, "value"),
+		}
+		ops = append(ops, op)
+	}
+	return ops, true
+}
+
+// childText はオブジェクトの文字列値を返します。文字列でなければ空文字列です。
+func childText(obj json.ElemObject, key string) string {
+	if str, ok := obj.Child(key).AsString(); ok {
+		return str.Text()
+	}
+	return ""
+}
+
+// sortedNamespaces は名前空間を整列して返します。
+func sortedNamespaces(data map[string]map[string]string) []string {
+	names := make([]string, 0, len(data))
+	for ns := range data {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List は名前空間のキーの一覧を返します。
+func (s *singleStorage) List(ns string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.data[ns]), nil
+}
+
+// Namespaces は名前空間の一覧を返します。
+func (s *singleStorage) Namespaces() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for _, ns := range sortedNamespaces(s.data) {
+		if ns != "" && len(s.data[ns]) > 0 {
+			names = append(names, ns)
+		}
+	}
+	return names, nil
+}
+
+// Read はキーの値を返します。
+func (s *singleStorage) Read(ns, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[ns][key]
+	return value, ok, nil
+}
+
+// Commit は操作を一つのトランザクションとして追記します。
+func (s *singleStorage) Commit(ops []*storageOp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(ops)
+}
+
+// Close はファイルを閉じます。
+func (s *singleStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
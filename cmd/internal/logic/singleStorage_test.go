@@ -0,0 +1,217 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func TestSingleStorageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		txs  [][]*storageOp
+		want map[string]map[string]string
+	}{
+		{
+			name: "put",
+			txs:  [][]*storageOp{{{op: "put", ns: "a", key: "k", value: "v"}}},
+			want: map[string]map[string]string{"a": {"k": "v"}},
+		},
+		{
+			name: "overwrite",
+			txs: [][]*storageOp{
+				{{op: "put", ns: "a", key: "k", value: "v1"}},
+				{{op: "put", ns: "a", key: "k", value: "v2"}},
+			},
+			want: map[string]map[string]string{"a": {"k": "v2"}},
+		},
+		{
+			name: "delete",
+			txs: [][]*storageOp{
+				{{op: "put", ns: "a", key: "k", value: "v"}, {op: "put", ns: "a", key: "l", value: "w"}},
+				{{op: "del", ns: "a", key: "k"}},
+			},
+			want: map[string]map[string]string{"a": {"l": "w"}},
+		},
+		{
+			name: "namespaces",
+			txs: [][]*storageOp{
+				{{op: "put", ns: "a", key: "k", value: "1"}, {op: "put", ns: "b/c", key: "k", value: "\"quoted\"\n"}},
+			},
+			want: map[string]map[string]string{"a": {"k": "1"}, "b/c": {"k": "\"quoted\"\n"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			s, err := openSingleStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tx := range tt.txs {
+				if err := s.Commit(tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Close()
+
+			// 開きなおしても同じ内容
+			s, err = openSingleStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			assertStorage(t, s, tt.want)
+		})
+	}
+}
+
+func TestSingleStorageRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{name: "partial line", tail: "0000"},
+		{name: "bad checksum", tail: "00000000 [{\"op\":\"put\",\"ns\":\"a\",\"key\":\"x\",\"value\":\"y\"}]\n"},
+		{name: "no newline", tail: "12345678 [{\"op\":\"put\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			s, err := openSingleStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: "v"}}); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			filename := fpath.Join(folder, singleFileName)
+			good, _ := os.Stat(filename)
+
+			// 書き込み途中で止まった行を付け足す
+			f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			s, err = openSingleStorage(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			assertStorage(t, s, map[string]map[string]string{"a": {"k": "v"}})
+			if fi, _ := os.Stat(filename); fi.Size() != good.Size() {
+				t.Errorf("size = %d, want truncated to %d", fi.Size(), good.Size())
+			}
+		})
+	}
+}
+
+func TestSingleStorageCompact(t *testing.T) {
+	folder := t.TempDir()
+	s, err := openSingleStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 3*singleCompactMin/len(value); i++ {
+		if err := s.Commit([]*storageOp{{op: "put", ns: "a", key: "k", value: value}}); err != nil {
+			t.Fatal(err)
+		}
+		// 詰め直した後は、有効な記録の二倍を超えない
+		if s.fileSize > singleCompactMin && s.fileSize > (s.liveSize+singleLineOverhead)*2 {
+			t.Fatalf("not compacted: fileSize %d, liveSize %d", s.fileSize, s.liveSize)
+		}
+	}
+	fi, err := os.Stat(fpath.Join(folder, singleFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != s.fileSize {
+		t.Errorf("file size %d, tracked %d", fi.Size(), s.fileSize)
+	}
+
+	// 有効なデータだけのファイルは、書き込むたびに詰め直さない
+	s.compact()
+	if s.fileSize > (s.liveSize+singleLineOverhead)*2 {
+		t.Errorf("compacted file %d is more than twice the live size %d", s.fileSize, s.liveSize)
+	}
+	if s.fileSize != s.liveSize+singleLineOverhead-1 {
+		t.Errorf("compacted file %d, live records %d", s.fileSize, s.liveSize)
+	}
+}
+
+// warnLogger は警告だけを記録するロガーです。
+type warnLogger struct {
+	common.Logger
+	warns []string
+}
+
+func (l *warnLogger) Warnf(format string, arg ...interface{}) {
+	l.warns = append(l.warns, fmt.Sprintf(format, arg...))
+}
+
+func TestSingleStorageCompactFailure(t *testing.T) {
+	folder := t.TempDir()
+	s, err := openSingleStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	log := &warnLogger{}
+	s.log = log
+
+	// 一時ファイルを書けなければ詰め直しは失敗するが、書き込み自体は成功する
+	if err := os.Mkdir(s.filename+extTemp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.fileSize = singleCompactMin * 3
+	if err := s.Commit([]*storageOp{{op: "put", ns: "a", key: "k1", value: "v1"}}); err != nil {
+		t.Fatalf("Commit() = %v, want nil after a failed compaction", err)
+	}
+	if len(log.warns) != 1 {
+		t.Errorf("warns = %v, want one", log.warns)
+	}
+	s.fileSize = 0
+	if err := s.Commit([]*storageOp{{op: "put", ns: "a", key: "k2", value: "v2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.filename + extTemp); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = openSingleStorage(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStorage(t, s, map[string]map[string]string{"a": {"k1": "v1", "k2": "v2"}})
+}
+
+// assertStorage は格納先の内容が want と同じかを確かめます。
+func assertStorage(t *testing.T, s storage, want map[string]map[string]string) {
+	t.Helper()
+	for ns, keys := range want {
+		list, err := s.List(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != len(keys) {
+			t.Errorf("List(%q) = %v, want %d keys", ns, list, len(keys))
+		}
+		for key, value := range keys {
+			got, ok, err := s.Read(ns, key)
+			if err != nil || false == ok || got != value { // nolint:gosimple
+				t.Errorf("Read(%q, %q) = %q, %v, %v, want %q", ns, key, got, ok, err, value)
+			}
+		}
+	}
+}
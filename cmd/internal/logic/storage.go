@@ -1,10 +1,6 @@
 package logic
 
 import (
-	azip "archive/zip"
-	"bytes"
-	"fmt"
-	"io"
 	"os"
 	fpath "path/filepath"
 
@@ -17,53 +13,26 @@ const (
 	extTemp = ".temp"
 )
 
-// addData は配列として渡ってきたオブジェクトのキーごとの文字列値を一時ファイルとして保存します
-func addData(folder string, items json.ElemObject) ([]interface{}, error) {
-	// .temp ファイルが残っていたら消去
-	removeFilesByExt(folder, extTemp)
-
-	keys := make([]interface{}, 0)
-	for _, key := range items.Keys() {
-		keys = append(keys, key)
-		var item json.ElemString
-		var ok bool
-		if item, ok = items.Child(key).AsString(); false == ok {
-			// 文字列以外の値が挿入されていたのでエラー
-			return keys, fmt.Errorf("err key=%s is not string", key)
-		}
-
-		// 格納先の基本名称
-		basename := key2filename(key)
-		filename := fpath.Join(folder, basename+extTemp)
-
-		// 値
-		value := item.Text()
-
-		// 保存
-		err := saveString(filename, value)
-		if err != nil {
-			return keys, err
-		}
+// saveItem はキーの値を一時ファイルに書き込んでからデータファイルに置き換えます。
+// 一時ファイルの名前には suffix を付けて、他の書き込みと衝突しないようにします。
+func saveItem(folder, key, value, suffix string) error {
+	basename := key2filename(key)
+	tempName := fpath.Join(folder, basename+"."+suffix+extTemp)
+	// 名前を変える前にディスクへ書き出す
+	err := writeFileSync(tempName, []byte(value), 0655)
+	if err != nil {
+		os.Remove(tempName)
+		return err
 	}
-	return keys, nil
-}
-
-// commitData は一時ファイルをデータファイルとしてコミットします
-// TODO: データファイルを削除extに変換しておいて、失敗事にはロールバックするようにする（大体は問題はないので保留
-func commitData(folder string) error {
-	return changeExt(folder, extTemp, extData)
-}
-
-// loadItemValue は特定キーに対する値を返します。
-func loadItemValue(folder, key string) json.ElemString {
-	filename := key2filename(key) + extData
-	value := ""
-	if res, err := loadString(fpath.Join(folder, filename)); err == nil {
-		value = res
+	err = os.Rename(tempName, fpath.Join(folder, basename+extData))
+	if err != nil {
+		os.Remove(tempName)
+		return err
 	}
-	return json.NewElemString(value)
+	return nil
 }
 
+// deleteItem はキーのデータファイルを削除します。
 func deleteItem(folder, key string) error {
 	filename := key2filename(key) + extData
 	return fileutil.FileIfDelete(fpath.Join(folder, filename))
@@ -88,24 +57,3 @@ func listHolders(folder string) json.ElemArray {
 	}
 	return arr
 }
-
-// backupData は全データファイルをzip化したリーダーを返します。
-// 実ファイル構成を意識しないでバックアップできるようにするための試験実装。
-// TODO: 未使用
-func backupData(folder string) *bytes.Buffer {
-	// TODO: エラーハンドリング
-	buffer := &bytes.Buffer{}
-	zip := azip.NewWriter(buffer)
-	for _, filename := range listFilesByExt(folder, extData) {
-		if reader, err := os.Open(fpath.Join(folder, filename)); err == nil {
-			if ent, err := zip.Create(filename); err == nil {
-				if bytes, err := io.ReadAll(reader); err == nil {
-					ent.Write(bytes)
-					zip.Flush()
-				}
-			}
-		}
-	}
-	zip.Close()
-	return buffer
-}
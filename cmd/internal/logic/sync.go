@@ -0,0 +1,825 @@
+package logic
+
+import (
+	"bytes"
+	srand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+// WebAPI のデータの同期
+//
+// 同期はキーごとの値のハッシュを比べて、異なるキーだけ値を送ります。
+// 相手ごとに前回一致したハッシュ (基準) を記録しておき、片方だけが基準から変わっていればその値を、
+// 両方が変わっていれば競合として相手ごとの policy で残す値を決めます。
+// 削除は墓標のハッシュ "-" として伝わります。
+//
+// 同期を始めた側は、次の二回の要求を相手の /sync/{ホスト名} に送ります。
+//	{"phase":"state", "instance":"識別子", "state":[{"ns","key","hash"}...]}
+//	  -> {"instance":"相手の識別子", "state":[{"ns","key","hash","rev","time",...,"value"}...]}
+//	     値はハッシュが異なるキーにだけ付きます。
+//	{"phase":"apply", "instance":"識別子", "apply":[{...,"value","expect"}...], "base":[{"ns","key","hash"}...]}
+//	  -> {"applied":反映した数, "skipped":[{"ns","key"}...]}
+//	     expect は相手の現在のハッシュで、その間に相手で変わったキーは反映せず次回に回します。
+
+const (
+	// 相手ごとの基準を保存する名前空間の接頭辞、続きは相手の識別子です。
+	syncPrefix = reservedPrefix + "sync:"
+	// 削除した項目のハッシュ
+	syncDeletedHash = "-"
+	// 要求の段階
+	syncPhaseState = "state"
+	syncPhaseApply = "apply"
+	// 競合した値を残すキーの接尾辞
+	syncConflictSuffix = "~conflict-"
+	// 相手への要求の時間切れ
+	syncTimeout = 2 * time.Minute
+	// 相手の識別子の長さの上限
+	syncInstanceMax = 64
+)
+
+// syncEntry は同期する一つのキーの状態です。
+type syncEntry struct {
+	ns   string
+	key  string
+	meta *itemMeta
+	// 値のハッシュ、削除済みであれば syncDeletedHash
+	hash string
+	// 値
+	value string
+	// 値を送受信するなら真
+	hasValue bool
+	// 反映する時に期待する相手の現在のハッシュ、空文字列は項目なし
+	expect string
+}
+
+// SyncResult は同期の結果です。
+type SyncResult struct {
+	// Pulled は相手から取り込んだキーの数です。
+	Pulled int
+	// Pushed は相手に反映したキーの数です。
+	Pushed int
+	// Conflicts は競合したキーの数です。
+	Conflicts int
+	// Skipped は同期中に変わったので次回に回したキーの数です。
+	Skipped int
+}
+
+// syncNs は相手 instance の基準を保存する名前空間を返します。
+func syncNs(instance string) string {
+	return syncPrefix + instance
+}
+
+// validSyncInstance は相手の識別子として使える文字列であれば真を返します。
+// 識別子は基準を保存する名前空間の名前になるので、英数字と - と _ だけを認めます。
+func validSyncInstance(instance string) bool {
+	if instance == "" || len(instance) > syncInstanceMax {
+		return false
+	}
+	for _, c := range instance {
+		if false == (c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') { // nolint:gosimple
+			return false
+		}
+	}
+	return true
+}
+
+// syncing は削除を同期で伝えるホストであれば真を返します。
+// 同期の相手と同期するホストか、相手から同期されたことのあるホストです。
+// 同期しないホストは墓標を残しません。
+func (a *api) syncing() bool {
+	return syncingHost(a.config, a.docGroupName, a.synced)
+}
+
+// syncingHost は host が削除を同期で伝えるホストであれば真を返します。synced は相手から同期されたことがあれば真です。
+func syncingHost(config common.Config, host string, synced bool) bool {
+	if config.SyncSecret() == "" {
+		return false
+	}
+	if synced {
+		return true
+	}
+	for _, peer := range config.SyncPeers() {
+		for _, name := range SyncHosts(config, peer) {
+			if name == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasSyncBases は相手ごとの基準が保存されていれば真を返します。
+func hasSyncBases(st storage) bool {
+	namespaces, err := st.Namespaces()
+	if err != nil {
+		return false
+	}
+	for _, ns := range namespaces {
+		if strings.HasPrefix(ns, syncPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncKey は名前空間とキーをまとめたキーです。
+func syncKey(ns, key string) string {
+	return ns + "\x00" + key
+}
+
+// syncHash は値のハッシュを返します。値が文字列か JSON かも区別します。
+func syncHash(value string, meta *itemMeta) string {
+	if meta.deleted {
+		return syncDeletedHash
+	}
+	kind := "s"
+	if meta.json {
+		kind = "j"
+	}
+	sum := sha256.Sum256([]byte(kind + "\n" + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// elem は送信用の JSON オブジェクトを返します。
+// 付帯情報の無いエントリはハッシュだけを送ります。
+func (e *syncEntry) elem() json.ElemObject {
+	obj := json.NewElemObject()
+	obj.Put("ns", json.NewElemString(e.ns))
+	obj.Put("key", json.NewElemString(e.key))
+	obj.Put("hash", json.NewElemString(e.hash))
+	if e.meta != nil {
+		obj.Put("rev", json.NewElemFloat(float64(e.meta.rev)))
+		obj.Put("time", json.NewElemFloat(float64(e.meta.time)))
+		if e.meta.expires != 0 {
+			obj.Put("expires", json.NewElemFloat(float64(e.meta.expires)))
+		}
+		if e.meta.json {
+			obj.Put("json", json.NewElemBool(true))
+		}
+		if e.meta.deleted {
+			obj.Put("deleted", json.NewElemBool(true))
+		}
+	}
+	if e.hasValue {
+		obj.Put("value", json.NewElemString(e.value))
+	}
+	if e.expect != "" {
+		obj.Put("expect", json.NewElemString(e.expect))
+	}
+	return obj
+}
+
+// hashOnly はハッシュだけのエントリを返します。
+func (e *syncEntry) hashOnly() *syncEntry {
+	return &syncEntry{ns: e.ns, key: e.key, hash: e.hash}
+}
+
+// decodeSyncEntry は受信した JSON オブジェクトからエントリを戻します。
+// 値が付いていれば、値とハッシュが一致するかを確認します。
+func decodeSyncEntry(elem json.Element) (*syncEntry, error) {
+	obj, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("entry is not object")
+	}
+	e := &syncEntry{
+		ns:     childText(obj, "ns"),
+		key:    childText(obj, "key"),
+		hash:   childText(obj, "hash"),
+		expect: childText(obj, "expect"),
+		meta:   &itemMeta{rev: 1},
+	}
+	if e.key == "" || e.hash == "" {
+		return nil, fmt.Errorf("entry without key or hash")
+	}
+	if isReservedNs(e.ns) {
+		return nil, fmt.Errorf("reserved namespace")
+	}
+	if rev, ok := json.QueryElemFloat(obj, "rev"); ok {
+		e.meta.rev = int64(rev.Float())
+	}
+	if t, ok := json.QueryElemFloat(obj, "time"); ok {
+		e.meta.time = int64(t.Float())
+	}
+	if expires, ok := json.QueryElemFloat(obj, "expires"); ok {
+		e.meta.expires = int64(expires.Float())
+	}
+	if isJSON, ok := json.QueryElemBool(obj, "json"); ok {
+		e.meta.json = isJSON.Bool()
+	}
+	if deleted, ok := json.QueryElemBool(obj, "deleted"); ok {
+		e.meta.deleted = deleted.Bool()
+	}
+	if value, ok := json.QueryElemString(obj, "value"); ok {
+		e.value = value.Text()
+		e.hasValue = true
+	}
+	if e.meta.deleted && e.hash != syncDeletedHash {
+		return nil, fmt.Errorf("%s : deleted entry with hash", e.key)
+	}
+	if e.hasValue && syncHash(e.value, e.meta) != e.hash {
+		return nil, fmt.Errorf("%s : hash mismatch", e.key)
+	}
+	return e, nil
+}
+
+// decodeSyncEntries は obj の name の配列からエントリを戻します。配列が無ければ空です。
+func decodeSyncEntries(obj json.ElemObject, name string) ([]*syncEntry, error) {
+	entries := []*syncEntry{}
+	arr, ok := obj.Child(name).AsArray()
+	if false == ok { // nolint:gosimple
+		return entries, nil
+	}
+	for i := 0; i < arr.Size(); i++ {
+		e, err := decodeSyncEntry(arr.Child(i))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// syncEntriesElem はエントリの配列を返します。
+func syncEntriesElem(entries []*syncEntry) json.ElemArray {
+	arr := json.NewElemArray()
+	for _, e := range entries {
+		arr.Append(e.elem())
+	}
+	return arr
+}
+
+// syncState は全てのキーの状態を返します。
+// 期限切れの項目と墓標は無いものとして扱います。
+func syncState(tx *transaction) (map[string]*syncEntry, error) {
+	namespaces, err := tx.st.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]*syncEntry{}
+	for _, ns := range namespaces {
+		if isMetaNs(ns) {
+			// 削除した項目は墓標から
+			uns := strings.TrimPrefix(ns, metaPrefix)
+			keys, err := tx.st.List(ns)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if tomb := tx.tombstone(uns, key); tomb != nil && false == tomb.expired(tx.now) { // nolint:gosimple
+					state[syncKey(uns, key)] = &syncEntry{ns: uns, key: key, meta: tomb, hash: syncDeletedHash}
+				}
+			}
+			continue
+		}
+		if isReservedNs(ns) {
+			continue
+		}
+		keys, err := tx.st.List(ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			value, meta, exists, err := tx.getItem(ns, key)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				state[syncKey(ns, key)] = &syncEntry{ns: ns, key: key, meta: meta, hash: syncHash(value, meta), value: value}
+			}
+		}
+	}
+	return state, nil
+}
+
+// syncCurrentHash はキーの現在のハッシュを返します。項目が無ければ空文字列です。
+func syncCurrentHash(tx *transaction, ns, key string) (string, error) {
+	value, meta, exists, err := tx.getItem(ns, key)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return syncHash(value, meta), nil
+	}
+	if tomb := tx.tombstone(ns, key); tomb != nil && false == tomb.expired(tx.now) { // nolint:gosimple
+		return syncDeletedHash, nil
+	}
+	return "", nil
+}
+
+// syncBases は相手 instance の基準を返します。
+func syncBases(tx *transaction, instance string) (map[string]string, error) {
+	bns := syncNs(instance)
+	keys, err := tx.st.List(bns)
+	if err != nil {
+		return nil, err
+	}
+	bases := map[string]string{}
+	for _, key := range keys {
+		hash, ok, err := tx.read(bns, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			bases[key] = hash
+		}
+	}
+	return bases, nil
+}
+
+// applySyncEntry は相手の値や墓標を書き込みます。
+// 時刻と有効期限は相手のものを使い、改訂番号は戻さないように進めます。
+func applySyncEntry(tx *transaction, e *syncEntry) {
+	meta := *e.meta
+	if str, ok, _ := tx.read(metaNs(e.ns), e.key); ok {
+		if rev := decodeMeta(str, ok).rev + 1; meta.rev < rev {
+			meta.rev = rev
+		}
+	}
+	if meta.expires != 0 && (tx.nextExpire == 0 || meta.expires < tx.nextExpire) {
+		tx.nextExpire = meta.expires
+	}
+	if meta.deleted {
+		tx.del(e.ns, e.key)
+	} else {
+		tx.put(e.ns, e.key, e.value)
+	}
+	tx.put(metaNs(e.ns), e.key, meta.encode())
+}
+
+// syncNewer は a が b より後に書き込まれていれば真を返します。
+// 時刻、改訂番号、ハッシュの順に比べるので、両方の側で同じ結果になります。
+func syncNewer(a, b *syncEntry) bool {
+	if a.meta.time != b.meta.time {
+		return a.meta.time > b.meta.time
+	}
+	if a.meta.rev != b.meta.rev {
+		return a.meta.rev > b.meta.rev
+	}
+	return a.hash > b.hash
+}
+
+// sortedSyncKeys は二つの状態のキーを整列して返します。
+func sortedSyncKeys(a, b map[string]*syncEntry) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; false == ok { // nolint:gosimple
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Sync は同期の相手からの要求を処理します。
+func (a *api) Sync(request json.Element) (json.Element, error) {
+	obj, ok := request.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, common.NewAPIError(common.ErrInvalidRequest, "not object")
+	}
+	instance := childText(obj, "instance")
+	if false == validSyncInstance(instance) || instance == a.config.SyncID() { // nolint:gosimple
+		return nil, common.NewAPIError(common.ErrInvalidParameter, "invalid instance")
+	}
+	switch childText(obj, "phase") {
+	case syncPhaseState:
+		remote, err := decodeSyncEntries(obj, "state")
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrInvalidParameter, err.Error())
+		}
+		hashes := map[string]string{}
+		for _, e := range remote {
+			hashes[syncKey(e.ns, e.key)] = e.hash
+		}
+		return a.run(func() (json.Element, error) {
+			local, err := syncState(newTransaction(a.storage))
+			if err != nil {
+				return nil, err
+			}
+			entries := []*syncEntry{}
+			for _, k := range sortedSyncKeys(local, nil) {
+				e := local[k]
+				// 相手と異なるキーにだけ値を付ける
+				e.hasValue = hashes[k] != e.hash && false == e.meta.deleted // nolint:gosimple
+				entries = append(entries, e)
+			}
+			res := json.NewElemObject()
+			res.Put("instance", json.NewElemString(a.config.SyncID()))
+			res.Put("state", syncEntriesElem(entries))
+			return res, nil
+		})
+
+	case syncPhaseApply:
+		entries, err := decodeSyncEntries(obj, "apply")
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrInvalidParameter, err.Error())
+		}
+		for _, e := range entries {
+			if false == e.hasValue && false == e.meta.deleted { // nolint:gosimple
+				return nil, common.NewAPIError(common.ErrInvalidParameter, e.key+" : no value")
+			}
+		}
+		bases, err := decodeSyncEntries(obj, "base")
+		if err != nil {
+			return nil, common.NewAPIError(common.ErrInvalidParameter, err.Error())
+		}
+		return a.run(func() (json.Element, error) {
+			// これからは削除を墓標で伝える
+			a.synced = true
+			return syncApply(a, instance, entries, bases)
+		})
+	}
+	return nil, common.NewAPIError(common.ErrInvalidParameter, "unknown phase")
+}
+
+// syncApply は相手 instance から送られた値を反映して、基準を記録します。
+// 相手が期待したハッシュと現在のハッシュが異なるキーは反映しません。
+func syncApply(a *api, instance string, entries, bases []*syncEntry) (json.Element, error) {
+	tx := newTransaction(a.storage)
+	bns := syncNs(instance)
+	applied := 0
+	skipped := json.NewElemArray()
+	for _, e := range entries {
+		current, err := syncCurrentHash(tx, e.ns, e.key)
+		if err != nil {
+			return nil, err
+		}
+		if current != e.expect {
+			key := json.NewElemObject()
+			key.Put("ns", json.NewElemString(e.ns))
+			key.Put("key", json.NewElemString(e.key))
+			skipped.Append(key)
+			continue
+		}
+		applySyncEntry(tx, e)
+		tx.put(bns, syncKey(e.ns, e.key), e.hash)
+		applied++
+	}
+	for _, b := range bases {
+		current, err := syncCurrentHash(tx, b.ns, b.key)
+		if err != nil {
+			return nil, err
+		}
+		if current == b.hash {
+			tx.put(bns, syncKey(b.ns, b.key), b.hash)
+		}
+	}
+	if err := a.commit(tx); err != nil {
+		return nil, err
+	}
+	a.config.Logger().Infof("[%s] sync from %s : applied %d, skipped %d", a.docGroupName, instance, applied, skipped.Size())
+	res := json.NewElemObject()
+	res.Put("applied", json.NewElemFloat(float64(applied)))
+	res.Put("skipped", skipped)
+	return res, nil
+}
+
+// syncPlan は相手の状態と比べて、取り込む値を書き込み、相手に送る値を返します。
+type syncPlan struct {
+	// 相手に反映する値
+	push []*syncEntry
+	// 一致したので相手にも基準として記録させるキー
+	base []*syncEntry
+	// 結果
+	result SyncResult
+}
+
+// planSync は相手の状態 remote と比べて、取り込む値と競合した値を書き込みます。
+func planSync(a *api, peer common.SyncPeer, instance string, remote map[string]*syncEntry) (*syncPlan, error) {
+	tx := newTransaction(a.storage)
+	local, err := syncState(tx)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := syncBases(tx, instance)
+	if err != nil {
+		return nil, err
+	}
+	bns := syncNs(instance)
+	plan := &syncPlan{push: []*syncEntry{}, base: []*syncEntry{}}
+
+	// 一致したキー
+	agree := func(e *syncEntry) {
+		if bases[syncKey(e.ns, e.key)] != e.hash {
+			tx.put(bns, syncKey(e.ns, e.key), e.hash)
+		}
+		plan.base = append(plan.base, e.hashOnly())
+	}
+	// 相手の値を取り込む
+	pull := func(r *syncEntry) {
+		if false == r.hasValue && false == r.meta.deleted { // nolint:gosimple
+			// 状態を送った後にこちらで変わったので、値が届いていない
+			plan.result.Skipped++
+			return
+		}
+		applySyncEntry(tx, r)
+		agree(r)
+		plan.result.Pulled++
+	}
+	// こちらの値を相手に送る
+	push := func(l *syncEntry, expect string) {
+		e := *l
+		e.hasValue = false == l.meta.deleted // nolint:gosimple
+		e.expect = expect
+		plan.push = append(plan.push, &e)
+	}
+
+	for _, k := range sortedSyncKeys(local, remote) {
+		l, r := local[k], remote[k]
+		base, hasBase := bases[k]
+		delete(bases, k)
+		switch {
+		case l != nil && r != nil && l.hash == r.hash:
+			agree(l)
+		case r == nil:
+			push(l, "")
+		case l == nil:
+			pull(r)
+		case hasBase && l.hash == base:
+			// こちらは変わっていない
+			pull(r)
+		case hasBase && r.hash == base:
+			// 相手は変わっていない
+			push(l, r.hash)
+		default:
+			// 両方で変わった
+			plan.result.Conflicts++
+			winner, loser := l, r
+			if syncNewer(r, l) {
+				winner, loser = r, l
+			}
+			if peer.Policy == common.SyncKeepBoth {
+				if winner.meta.deleted && false == loser.meta.deleted { // nolint:gosimple
+					// 削除よりも値を残す
+					winner, loser = loser, winner
+				}
+				if false == loser.meta.deleted && (loser == l || loser.hasValue) { // nolint:gosimple
+					// 負けた値を別のキーに残して、相手にも送る
+					from := a.config.SyncID()
+					if loser == r {
+						from = instance
+					}
+					if len(from) > 8 {
+						from = from[:8]
+					}
+					copied := &syncEntry{
+						ns:       loser.ns,
+						key:      loser.key + syncConflictSuffix + from + "-" + strconv.FormatInt(loser.meta.time, 10),
+						meta:     &itemMeta{rev: 1, time: tx.now, json: loser.meta.json},
+						value:    loser.value,
+						hasValue: true,
+					}
+					copied.hash = syncHash(copied.value, copied.meta)
+					if current, err := syncCurrentHash(tx, copied.ns, copied.key); err == nil && current == "" {
+						applySyncEntry(tx, copied)
+						push(copied, "")
+					}
+				}
+			}
+			a.config.Logger().Infof("[%s] sync conflict %s/%s : %s", a.docGroupName, l.ns, l.key, peer.Policy)
+			if winner == l {
+				push(l, r.hash)
+			} else {
+				pull(r)
+			}
+		}
+	}
+	// どちらにも無くなったキーの基準は消す
+	for k := range bases {
+		tx.del(bns, k)
+	}
+	if err := a.commit(tx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// recordPushed は相手に反映した値を基準として記録します。
+// 送った後にこちらで変わったキーは記録しません。
+func recordPushed(a *api, instance string, pushed []*syncEntry) error {
+	tx := newTransaction(a.storage)
+	bns := syncNs(instance)
+	for _, e := range pushed {
+		current, err := syncCurrentHash(tx, e.ns, e.key)
+		if err != nil {
+			return err
+		}
+		if current == e.hash {
+			tx.put(bns, syncKey(e.ns, e.key), e.hash)
+		}
+	}
+	return a.commit(tx)
+}
+
+// SyncHost はホストの WebAPI のデータを同期の相手 peer と同期します。
+func SyncHost(conf common.Config, host common.HostName, peer common.SyncPeer) (*SyncResult, error) {
+	docHost := conf.DocHost(host)
+	if docHost == nil {
+		return nil, fmt.Errorf("unknown host %s", host)
+	}
+	a, ok := docHost.GetAPI().(*api)
+	if false == ok || a == nil { // nolint:gosimple
+		return nil, fmt.Errorf("%s : api not started", host)
+	}
+	if conf.SyncSecret() == "" {
+		return nil, fmt.Errorf("no sync secret")
+	}
+
+	// こちらの状態を送って、相手の状態と異なる値を受け取る
+	ret, err := a.run(func() (json.Element, error) {
+		local, err := syncState(newTransaction(a.storage))
+		if err != nil {
+			return nil, err
+		}
+		arr := json.NewElemArray()
+		for _, k := range sortedSyncKeys(local, nil) {
+			arr.Append(local[k].hashOnly().elem())
+		}
+		return arr, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	req := json.NewElemObject()
+	req.Put("phase", json.NewElemString(syncPhaseState))
+	req.Put("instance", json.NewElemString(conf.SyncID()))
+	req.Put("state", ret)
+	res, err := syncPost(conf, peer, host, req)
+	if err != nil {
+		return nil, err
+	}
+	instance := childText(res, "instance")
+	if false == validSyncInstance(instance) || instance == conf.SyncID() { // nolint:gosimple
+		return nil, fmt.Errorf("%s : invalid instance", peer.Name)
+	}
+	entries, err := decodeSyncEntries(res, "state")
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", peer.Name, err)
+	}
+	remote := map[string]*syncEntry{}
+	for _, e := range entries {
+		remote[syncKey(e.ns, e.key)] = e
+	}
+
+	// 取り込む値と競合をこちらに反映する
+	var plan *syncPlan
+	if _, err := a.run(func() (json.Element, error) {
+		var err error
+		plan, err = planSync(a, peer, instance, remote)
+		return json.NewElemNull(), err
+	}); err != nil {
+		return nil, err
+	}
+	result := plan.result
+	if len(plan.push) == 0 && len(plan.base) == 0 {
+		return &result, nil
+	}
+
+	// こちらの値を相手に反映する
+	req = json.NewElemObject()
+	req.Put("phase", json.NewElemString(syncPhaseApply))
+	req.Put("instance", json.NewElemString(conf.SyncID()))
+	req.Put("apply", syncEntriesElem(plan.push))
+	req.Put("base", syncEntriesElem(plan.base))
+	res, err = syncPost(conf, peer, host, req)
+	if err != nil {
+		return nil, err
+	}
+	skipped := map[string]bool{}
+	if arr, ok := res.Child("skipped").AsArray(); ok {
+		for i := 0; i < arr.Size(); i++ {
+			if obj, ok := arr.Child(i).AsObject(); ok {
+				skipped[syncKey(childText(obj, "ns"), childText(obj, "key"))] = true
+			}
+		}
+	}
+	pushed := []*syncEntry{}
+	for _, e := range plan.push {
+		if skipped[syncKey(e.ns, e.key)] {
+			result.Skipped++
+		} else {
+			pushed = append(pushed, e)
+			result.Pushed++
+		}
+	}
+	if _, err := a.run(func() (json.Element, error) {
+		return json.NewElemNull(), recordPushed(a, instance, pushed)
+	}); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// syncPost は相手に署名した要求を送り、署名を検証した応答を返します。
+func syncPost(conf common.Config, peer common.SyncPeer, host common.HostName, body json.Element) (json.ElemObject, error) {
+	data := []byte(json.ToJSON(body, false))
+	request, err := http.NewRequest(http.MethodPost, peer.URL+"/sync/"+url.PathEscape(host), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	r := make([]byte, 16)
+	if _, err := srand.Read(r); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(r)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(common.SyncTimeHeader, timestamp)
+	request.Header.Set(common.SyncNonceHeader, nonce)
+	request.Header.Set(common.SyncSignatureHeader, common.SyncSign(conf.SyncSecret(), timestamp, nonce, host, data))
+
+	client := &http.Client{Timeout: syncTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", peer.Name, err)
+	}
+	defer response.Body.Close()
+	resData, err := io.ReadAll(io.LimitReader(response.Body, conf.SyncMaxBody()+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", peer.Name, err)
+	}
+	if int64(len(resData)) > conf.SyncMaxBody() {
+		return nil, fmt.Errorf("%s : response larger than %d bytes", peer.Name, conf.SyncMaxBody())
+	}
+	if response.StatusCode != http.StatusOK {
+		mes := response.Status
+		if elem, err := json.LoadFromJSONByte(resData); err == nil {
+			if m, ok := json.QueryElemString(elem, "error/message"); ok {
+				mes += " " + m.Text()
+			}
+		}
+		return nil, fmt.Errorf("%s : %s", peer.Name, mes)
+	}
+	if false == common.SyncVerify(conf.SyncSecret(), response.Header.Get(common.SyncTimeHeader), nonce, response.Header.Get(common.SyncSignatureHeader), host, resData, time.Now()) { // nolint:gosimple
+		return nil, fmt.Errorf("%s : invalid response signature", peer.Name)
+	}
+	elem, err := json.LoadFromJSONByte(resData)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %v", peer.Name, err)
+	}
+	obj, ok := elem.AsObject()
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("%s : invalid response", peer.Name)
+	}
+	return obj, nil
+}
+
+// SyncHosts は相手 peer と同期するホストの一覧を返します。
+func SyncHosts(conf common.Config, peer common.SyncPeer) []common.HostName {
+	if len(peer.Hosts) != 0 {
+		return peer.Hosts
+	}
+	return conf.PortMan().HostNames()
+}
+
+// StartSync は interval を指定した相手との定期的な同期を開始します。
+// 返した関数を呼ぶと同期を止めて、実行中の同期の終了を待ちます。
+func StartSync(conf common.Config) func() {
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for _, peer := range conf.SyncPeers() {
+		if peer.Interval <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(peer common.SyncPeer) {
+			defer wg.Done()
+			ticker := time.NewTicker(peer.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				for _, host := range SyncHosts(conf, peer) {
+					result, err := SyncHost(conf, host, peer)
+					if err != nil {
+						conf.Logger().Warnf("[%s] sync %s : %v", host, peer.Name, err)
+						continue
+					}
+					conf.Logger().Infof("[%s] sync %s : %+v", host, peer.Name, *result)
+				}
+			}
+		}(peer)
+	}
+	return func() {
+		close(stop)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,71 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+func TestValidSyncInstance(t *testing.T) {
+	tests := []struct {
+		instance string
+		want     bool
+	}{
+		{"0123456789abcdef0123456789abcdef", true},
+		{"Desktop_1-a", true},
+		{strings.Repeat("a", syncInstanceMax), true},
+		{"", false},
+		{strings.Repeat("a", syncInstanceMax+1), false},
+		{"a/b", false},
+		{"a\x00b", false},
+		{"..", false},
+		{"日本", false},
+	}
+	for _, tt := range tests {
+		if got := validSyncInstance(tt.instance); got != tt.want {
+			t.Errorf("validSyncInstance(%q) = %v, want %v", tt.instance, got, tt.want)
+		}
+	}
+}
+
+func TestDelItemTombstone(t *testing.T) {
+	tests := []struct {
+		name       string
+		tombstones bool
+	}{
+		{name: "synced", tombstones: true},
+		{name: "not synced", tombstones: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := openFileStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx := newTransaction(st)
+			if _, err := tx.putItem("a", "k", json.NewElemString("v"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.commit(); err != nil {
+				t.Fatal(err)
+			}
+			tx = newTransaction(st)
+			tx.tombstones = tt.tombstones
+			tx.delItem("a", "k")
+			if err := tx.commit(); err != nil {
+				t.Fatal(err)
+			}
+			tx = newTransaction(st)
+			if _, _, exists, _ := tx.getItem("a", "k"); exists {
+				t.Errorf("item left after delete")
+			}
+			if got := tx.tombstone("a", "k") != nil; got != tt.tombstones {
+				t.Errorf("tombstone = %v, want %v", got, tt.tombstones)
+			}
+			if _, ok, _ := st.Read(metaNs("a"), "k"); ok != tt.tombstones {
+				t.Errorf("metadata left = %v, want %v", ok, tt.tombstones)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package logic
+
+import "time"
+
+// transaction は一つの要求の中での書き込みと削除をまとめて、最後に全てか無かで反映します。
+// 反映前の読み出しには、トランザクション内の書き込みが反映された値を返します。
+type transaction struct {
+	st storage
+	// 反映する操作
+	ops []*storageOp
+	// 名前空間 -> キー -> 最後の操作
+	pending map[string]map[string]*storageOp
+	// トランザクションの時刻 (UNIX ミリ秒)
+	now int64
+	// トランザクションで書き込んだ最も早い有効期限
+	nextExpire int64
+	// 削除した項目の墓標を残す
+	tombstones bool
+}
+
+// newTransaction はコンストラクタです。
+func newTransaction(st storage) *transaction {
+	return &transaction{
+		st:      st,
+		ops:     []*storageOp{},
+		pending: map[string]map[string]*storageOp{},
+		now:     unixMilli(time.Now()),
+	}
+}
+
+// newTx は api の格納先のトランザクションを作ります。
+// 同期するホストでは削除した項目の墓標を残します。
+func (a *api) newTx() *transaction {
+	tx := newTransaction(a.storage)
+	tx.tombstones = a.syncing()
+	return tx
+}
+
+// push は操作を追加します。
+func (t *transaction) push(op *storageOp) {
+	keys, ok := t.pending[op.ns]
+	if false == ok { // nolint:gosimple
+		keys = map[string]*storageOp{}
+		t.pending[op.ns] = keys
+	}
+	keys[op.key] = op
+	t.ops = append(t.ops, op)
+}
+
+// put は書き込みを追加します。
+func (t *transaction) put(ns, key, value string) {
+	t.push(&storageOp{op: "put", ns: ns, key: key, value: value})
+}
+
+// del は削除を追加します。
+func (t *transaction) del(ns, key string) {
+	t.push(&storageOp{op: "del", ns: ns, key: key})
+}
+
+// read はトランザクション内の操作を反映した値を返します。
+func (t *transaction) read(ns, key string) (string, bool, error) {
+	if op, ok := t.pending[ns][key]; ok {
+		if op.op == "del" {
+			return "", false, nil
+		}
+		return op.value, true, nil
+	}
+	return t.st.Read(ns, key)
+}
+
+// commit は操作を反映します。
+func (t *transaction) commit() error {
+	return t.st.Commit(t.ops)
+}
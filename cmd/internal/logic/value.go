@@ -0,0 +1,76 @@
+package logic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xorvercom/util/pkg/json"
+)
+
+// encodeValue は値を保存用の文字列にします。
+// 文字列はそのまま保存し、それ以外の JSON 値は JSON として保存して isJSON を真にします。
+// 値の大きさの上限は反映時に確認します。
+func encodeValue(elem json.Element) (str string, isJSON bool) {
+	if s, ok := elem.AsString(); ok {
+		return s.Text(), false
+	}
+	return json.ToJSON(elem, false), true
+}
+
+// valueElem は保存用の文字列を値に戻します。付帯情報がなければ文字列です。
+func valueElem(str string, meta *itemMeta) json.Element {
+	if meta != nil && meta.json {
+		if elem, err := json.LoadFromJSONByte([]byte(str)); err == nil {
+			return elem
+		}
+	}
+	return json.NewElemString(str)
+}
+
+// splitPointer は JSON ポインタ (RFC 6901) を参照トークンに分けます。
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if false == strings.HasPrefix(pointer, "/") { // nolint:gosimple
+		return nil, fmt.Errorf("invalid pointer %s", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		tokens[i] = strings.Replace(token, "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// lookupPointer は JSON ポインタの指す値を返します。無ければ ok が偽です。
+func lookupPointer(elem json.Element, tokens []string) (json.Element, bool) {
+	for _, token := range tokens {
+		if obj, ok := elem.AsObject(); ok {
+			if false == hasKey(obj, token) { // nolint:gosimple
+				return nil, false
+			}
+			elem = obj.Child(token)
+		} else if arr, ok := elem.AsArray(); ok {
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || arr.Size() <= idx {
+				return nil, false
+			}
+			elem = arr.Child(idx)
+		} else {
+			return nil, false
+		}
+	}
+	return elem, true
+}
+
+// hasKey はオブジェクトがキーを持てば真を返します。
+func hasKey(obj json.ElemObject, key string) bool {
+	for _, k := range obj.Keys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
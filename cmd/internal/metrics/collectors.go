@@ -0,0 +1,35 @@
+package metrics
+
+// Default は /metrics で公開する計測値の一覧です。
+var Default = NewRegistry()
+
+// ziphttpd の計測値
+var (
+	// HTTPRequests はホスト、グループ、ドキュメント、状態コードごとの要求の数です。
+	HTTPRequests = Default.NewCounterVec("ziphttpd_http_requests_total",
+		"Number of HTTP requests by host, group, document and status code.", "host", "group", "doc", "code")
+	// HTTPDuration はホスト、グループ、ドキュメントごとの処理時間の分布です。
+	HTTPDuration = Default.NewHistogramVec("ziphttpd_http_request_duration_seconds",
+		"HTTP request latency in seconds by host, group and document.", DefaultBuckets, "host", "group", "doc")
+	// HTTPBytes はホスト、グループ、ドキュメントごとに送信した本文のバイト数です。
+	HTTPBytes = Default.NewCounterVec("ziphttpd_http_response_bytes_total",
+		"Bytes of HTTP response bodies by host, group and document.", "host", "group", "doc")
+	// ActiveConnections はサーバごとの接続中の数です。
+	ActiveConnections = Default.NewGaugeVec("ziphttpd_active_connections",
+		"Number of active connections by server.", "server", "port")
+	// ZipDictionaries は開いている zip ファイル辞書の数です。
+	ZipDictionaries = Default.NewGaugeVec("ziphttpd_zip_dictionaries_open",
+		"Number of open zip dictionaries.")
+	// APIQueueDepth はホストごとの WebAPI の待ち行列の長さです。
+	APIQueueDepth = Default.NewGaugeVec("ziphttpd_api_queue_depth",
+		"Number of WebAPI requests waiting in the queue by host.", "host")
+	// APIRequests はホスト、API、結果ごとの WebAPI の要求の数です。
+	APIRequests = Default.NewCounterVec("ziphttpd_api_requests_total",
+		"Number of WebAPI requests by host, api method and result.", "host", "method", "result")
+	// APIDuration はホスト、API ごとの WebAPI の実行時間の分布です。
+	APIDuration = Default.NewHistogramVec("ziphttpd_api_duration_seconds",
+		"WebAPI execution time in seconds by host and api method.", DefaultBuckets, "host", "method")
+	// LoginFailures はホストごとのログインの失敗の数です。
+	LoginFailures = Default.NewCounterVec("ziphttpd_login_failures_total",
+		"Number of failed logins by host.", "host")
+)
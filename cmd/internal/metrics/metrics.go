@@ -0,0 +1,285 @@
+// Package metrics は Prometheus のテキスト形式で公開する計測値を集計します。
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry は計測値の一覧です。
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// collector は計測値をテキスト形式で出力します。
+type collector interface {
+	write(w io.Writer)
+}
+
+// NewRegistry はコンストラクタです。
+func NewRegistry() *Registry {
+	return &Registry{collectors: []collector{}}
+}
+
+// register は計測値を追加します。
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText は全ての計測値を Prometheus のテキスト形式 (0.0.4) で出力します。
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+	buf := &bytes.Buffer{}
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// vec はラベルの値ごとの計測値の入れ物です。
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// ラベルの値を連結したキー -> 計測値
+	values map[string]interface{}
+	// キー -> ラベルの値
+	labelValues map[string][]string
+}
+
+// newVec はコンストラクタです。
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		name:        name,
+		help:        help,
+		kind:        kind,
+		labels:      labels,
+		values:      map[string]interface{}{},
+		labelValues: map[string][]string{},
+	}
+}
+
+// get はラベルの値の計測値を返します。無ければ create で作ります。
+// 呼び出し元でロックします。
+func (v *vec) get(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics %s : %d label values for %d labels", v.name, len(labelValues), len(v.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	value, ok := v.values[key]
+	if false == ok { // nolint:gosimple
+		value = create()
+		v.values[key] = value
+		v.labelValues[key] = append([]string{}, labelValues...)
+	}
+	return value
+}
+
+// sortedKeys はラベルの値のキーを整列して返します。呼び出し元でロックします。
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeHeader は HELP と TYPE を出力します。
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+}
+
+// labelText は {名前="値",...} を返します。extra は追加のラベル (le など) です。
+func (v *vec) labelText(values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range v.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec は増えるだけの計測値です。
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec は r に登録した CounterVec を返します。
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Add はラベルの値の計測値に delta を加えます。
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value := c.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*value += delta
+}
+
+// Inc はラベルの値の計測値に 1 を加えます。
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelText(c.labelValues[key]), formatFloat(*c.values[key].(*float64)))
+	}
+}
+
+// GaugeVec は増減する計測値です。
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec は r に登録した GaugeVec を返します。
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// Set はラベルの値の計測値を value にします。
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, func() interface{} { return new(float64) }).(*float64) = value
+}
+
+// Add はラベルの値の計測値に delta を加えます。
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, func() interface{} { return new(float64) }).(*float64) += delta
+}
+
+// Inc はラベルの値の計測値に 1 を加えます。
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec はラベルの値の計測値から 1 を引きます。
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelText(g.labelValues[key]), formatFloat(*g.values[key].(*float64)))
+	}
+}
+
+// DefaultBuckets は処理時間 (秒) の標準の区切りです。
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec は値の分布の計測値です。
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// histogram はラベルの値ごとの分布です。
+type histogram struct {
+	// 区切りごとの数 (累積ではない)
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec は r に登録した HistogramVec を返します。buckets は昇順の区切りです。
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// Observe はラベルの値の分布に value を加えます。
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := h.get(labelValues, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+	for i, le := range h.buckets {
+		if value <= le {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		values := h.labelValues[key]
+		hist := h.values[key].(*histogram)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(values), hist.count)
+	}
+}
+
+// formatFloat は値を出力用の文字列にします。
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeLabel はラベルの値をエスケープします。
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+// escapeHelp は説明をエスケープします。
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
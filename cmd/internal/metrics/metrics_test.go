@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Requests.\nSecond line with \\.", "host", "code")
+	g := r.NewGaugeVec("test_open", "Open things.")
+	h := r.NewHistogramVec("test_seconds", "Duration.", []float64{0.1, 1}, "host")
+
+	c.Inc("b", "200")
+	c.Add(2, "a", "404")
+	c.Inc("a", "404")
+	c.Inc(`q"x\y`+"\n", "500")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(5, "a")
+
+	want := `# HELP test_requests_total Requests.\nSecond line with \\.
+# TYPE test_requests_total counter
+test_requests_total{host="a",code="404"} 3
+test_requests_total{host="b",code="200"} 1
+test_requests_total{host="q\"x\\y\n",code="500"} 1
+# HELP test_open Open things.
+# TYPE test_open gauge
+test_open 1
+# HELP test_seconds Duration.
+# TYPE test_seconds histogram
+test_seconds_bucket{host="a",le="0.1"} 1
+test_seconds_bucket{host="a",le="1"} 2
+test_seconds_bucket{host="a",le="+Inf"} 3
+test_seconds_sum{host="a"} 5.55
+test_seconds_count{host="a"} 3
+`
+	buf := &bytes.Buffer{}
+	if err := r.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText\n got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{-2.5, "-2.5"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.value); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for a wrong number of label values")
+		}
+	}()
+	NewRegistry().NewCounterVec("test_total", "Test.", "host").Inc("a", "b")
+}
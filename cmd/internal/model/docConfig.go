@@ -27,6 +27,10 @@ const (
 	docpathContentType = json.PathJSON("contenttype")
 	// 静的ファイルを利用するか (ドキュメント開発時のデバッグ用)
 	docpathUseStaticFiles = json.PathJSON("usestaticfiles")
+	// ドキュメントのタイトル
+	docpathTitle = json.PathJSON("title")
+	// ドキュメントの説明
+	docpathDescription = json.PathJSON("description")
 )
 
 // NewDocConfig は簡易なドキュメント要素を構築します。
@@ -93,9 +97,33 @@ func NewDocConfig(c common.Config, zipPath, displayname string) (json.Element, e
 	if obj, ok := json.QueryElemBool(confElem, docpathUseStaticFiles); ok {
 		elem.Put(docpathUseStaticFiles, obj.Clone())
 	}
+
+	// タイトルと説明
+	if str, ok := json.QueryElemString(confElem, docpathTitle); ok {
+		elem.Put(docpathTitle, str.Clone())
+	}
+	if str, ok := json.QueryElemString(confElem, docpathDescription); ok {
+		elem.Put(docpathDescription, str.Clone())
+	}
 	return elem, nil
 }
 
+// DocTitleInfo はドキュメント設定ファイルに記述されたタイトルと説明を返します。
+// 記述が無い場合は空文字列です。
+func DocTitleInfo(confFile string) (title, description string) {
+	elem, err := json.LoadFromJSONFile(confFile)
+	if err != nil {
+		return "", ""
+	}
+	if str, ok := json.QueryElemString(elem, docpathTitle); ok {
+		title = str.Text()
+	}
+	if str, ok := json.QueryElemString(elem, docpathDescription); ok {
+		description = str.Text()
+	}
+	return title, description
+}
+
 func loadDefinedConfig(dic zip.Dictionary) (json.ElemObject, error) {
 	// 指定された設定があれば読み出す
 	if false == dic.Contains(documentConfigFile) {
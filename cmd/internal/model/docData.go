@@ -7,10 +7,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/util/pkg/zip"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/xorvercom/ziphttpd/cmd/internal/metrics"
 )
 
 const (
@@ -20,6 +22,8 @@ const (
 
 // docInst はホストしているzipを管理します。
 type docInst struct {
+	// 要求の処理と並行して公開をやめるため
+	mu   sync.Mutex
 	conf common.Config
 	// 親の設定
 	typeer common.ContentTypeer
@@ -49,6 +53,12 @@ type docInst struct {
 	title string
 	// 説明
 	description string
+	// 処理中の要求の数
+	refs int
+	// 公開をやめた
+	retired bool
+	// 公開をやめてクローズしたら閉じる、Released で作る
+	released chan struct{}
 }
 
 // JSON はJSONオブジェクトを返します。
@@ -69,7 +79,18 @@ func (d *docInst) JSON() json.ElemObject {
 
 // OpenDocConfig はドキュメントの設定を読みだします。
 func OpenDocConfig(conf common.Config, confFile, host, group, doc string) (common.DocData, error) {
-	var err error
+	// ドキュメント設定ファイル
+	elem, err := json.LoadFromJSONFile(confFile)
+	if err != nil {
+		// 定義ファイルがない
+		return nil, err
+	}
+	return OpenDocElement(conf, confFile, elem, host, group, doc), nil
+}
+
+// OpenDocElement は設定ファイル confFile の内容 elem からドキュメントを生成します。
+// 設定ファイルを書き出さずに扱う場合に使用します。
+func OpenDocElement(conf common.Config, confFile string, elem json.Element, host, group, doc string) common.DocData {
 	sfilePath := fpath.Join(conf.ConfigPath(), "static", host, group, doc)
 	// 生成
 	dd := &docInst{
@@ -81,13 +102,6 @@ func OpenDocConfig(conf common.Config, confFile, host, group, doc string) (commo
 		staticPath:   sfilePath,
 	}
 
-	// ドキュメント設定ファイル
-	elem, err := json.LoadFromJSONFile(confFile)
-	if err != nil {
-		// 定義ファイルがない
-		return nil, err
-	}
-
 	if o, ok := elem.AsObject(); ok {
 		if host != "" {
 			o.Put("host", json.NewElemString(host))
@@ -103,7 +117,7 @@ func OpenDocConfig(conf common.Config, confFile, host, group, doc string) (commo
 	dd.element = elem
 	dd.setup()
 
-	return dd, err
+	return dd
 }
 
 // ポートグループ名禁止文字の変換
@@ -212,7 +226,9 @@ func (d *docInst) UseStaticFiles() bool {
 
 // ZipDic はZipファイル辞書を返します。
 func (d *docInst) ZipDic() zip.Dictionary {
-	if d.zipDic == nil {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.zipDic == nil && false == d.retired { // nolint:gosimple
 		// 必要あるまでzipファイル読み込みは遅延
 		var err error
 		var file string
@@ -223,8 +239,10 @@ func (d *docInst) ZipDic() zip.Dictionary {
 		}
 		d.zipDic, err = zip.OpenDictionary(file, false)
 		if err != nil {
+			d.zipDic = nil
 			return nil
 		}
+		metrics.ZipDictionaries.Inc()
 	}
 	return d.zipDic
 }
@@ -324,11 +342,79 @@ func (d *docInst) ContentType(filepath string) string {
 	return d.typeer.ContentType(filepath)
 }
 
+// Acquire は要求の処理でドキュメントを使い始めます。公開をやめていれば偽を返します。
+// 真を返した場合は、処理を終えたら Release を呼びます。
+func (d *docInst) Acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.retired {
+		return false
+	}
+	d.refs++
+	return true
+}
+
+// Release は要求の処理でドキュメントを使い終えます。
+// 公開をやめていて処理中の要求が無くなればクローズします。
+func (d *docInst) Release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs--
+	if d.retired && d.refs == 0 {
+		d.close()
+		d.closeReleased()
+	}
+}
+
+// Retire は公開をやめて、処理中の要求が無くなったらクローズします。
+func (d *docInst) Retire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retired = true
+	if d.refs == 0 {
+		d.close()
+		d.closeReleased()
+	}
+}
+
+// Released は Retire の後、処理中の要求が無くなってクローズすると閉じるチャネルを返します。
+func (d *docInst) Released() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.released == nil {
+		d.released = make(chan struct{})
+		if d.retired && d.refs == 0 {
+			close(d.released)
+		}
+	}
+	return d.released
+}
+
+// closeReleased は Released のチャネルを閉じます。呼び出し元でロックします。
+func (d *docInst) closeReleased() {
+	if d.released == nil {
+		return
+	}
+	select {
+	case <-d.released:
+	default:
+		close(d.released)
+	}
+}
+
 // Close はドキュメントをクローズします。
 func (d *docInst) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.close()
+}
+
+// close はzipファイル辞書をクローズします。呼び出し元でロックします。
+func (d *docInst) close() {
 	if d.zipDic != nil {
 		d.zipDic.Close()
 		d.zipDic = nil
+		metrics.ZipDictionaries.Dec()
 	}
 }
 
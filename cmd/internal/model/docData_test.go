@@ -0,0 +1,55 @@
+package model
+
+import "testing"
+
+// released は Released のチャネルが閉じていれば真を返します。
+func released(d *docInst) bool {
+	select {
+	case <-d.Released():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDocReleased(t *testing.T) {
+	tests := []struct {
+		name     string
+		acquire  int
+		release  int
+		retire   bool
+		released bool
+	}{
+		{name: "published", acquire: 1, release: 1, retire: false, released: false},
+		{name: "idle", retire: true, released: true},
+		{name: "in use", acquire: 2, release: 1, retire: true, released: false},
+		{name: "last release", acquire: 2, release: 2, retire: true, released: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &docInst{docid: tt.name}
+			for i := 0; i < tt.acquire; i++ {
+				if false == d.Acquire() { // nolint:gosimple
+					t.Fatalf("Acquire failed")
+				}
+			}
+			// 要求の途中で公開をやめる
+			if tt.retire {
+				d.Retire()
+				if d.Acquire() {
+					t.Errorf("Acquire after Retire")
+				}
+			}
+			for i := 0; i < tt.release; i++ {
+				d.Release()
+			}
+			if got := released(d); got != tt.released {
+				t.Errorf("released = %v, want %v", got, tt.released)
+			}
+			// 二度目の Retire でも閉じたチャネルを閉じない
+			if tt.released {
+				d.Retire()
+			}
+		})
+	}
+}
@@ -2,18 +2,26 @@ package model
 
 import (
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 )
 
 type docGroupInst struct {
+	// コンソールからの切り替えと並行して参照されるため
+	mu sync.RWMutex
 	// ポートグループ名称
 	name common.DocGroupName
 	// ホスト名
 	host common.HostName
 	// ドキュメント
 	docsDic map[common.DocID]common.DocData
+	// ドキュメントのバージョン (docid -> version -> ドキュメント)
+	versionsDic map[common.DocID]map[string]common.DocData
+	// ドキュメントのバージョン名 (古い順)
+	versionNames map[common.DocID][]string
 	// タイトル
 	title string
 	// 説明
@@ -27,8 +35,16 @@ func (d *docGroupInst) JSON() json.ElemObject {
 	elem.Put("host", json.NewElemString(d.host))
 	docsDic := json.NewElemObject()
 	for _, id := range d.Ids() {
-		doc := d.docsDic[id]
-		docsDic.Put(doc.DocID(), doc.JSON())
+		doc := d.Get(id)
+		docJSON := doc.JSON()
+		if versions := d.Versions(id); len(versions) > 0 {
+			arr := json.NewElemArray()
+			for _, v := range versions {
+				arr.Append(json.NewElemString(v))
+			}
+			docJSON.Put("versions", arr)
+		}
+		docsDic.Put(doc.DocID(), docJSON)
 	}
 	elem.Put("docs", docsDic)
 	elem.Put("title", json.NewElemString(d.title))
@@ -39,9 +55,11 @@ func (d *docGroupInst) JSON() json.ElemObject {
 // NewDocGroup はドキュメントグループを作成します。
 func NewDocGroup(host common.HostName, group common.DocGroupName) common.DocGroup {
 	return &docGroupInst{
-		name:    group,
-		host:    host,
-		docsDic: map[common.DocID]common.DocData{},
+		name:         group,
+		host:         host,
+		docsDic:      map[common.DocID]common.DocData{},
+		versionsDic:  map[common.DocID]map[string]common.DocData{},
+		versionNames: map[common.DocID][]string{},
 	}
 }
 
@@ -52,16 +70,63 @@ func (d *docGroupInst) Name() common.DocGroupName {
 
 // Put はドキュメントを追加します。
 func (d *docGroupInst) Put(docid common.DocID, doc common.DocData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.docsDic[docid] = doc
 }
 
 // DocData は zip ドキュメントを取得します。
+// "docid@version" の場合は過去のバージョンを取得します。
 func (d *docGroupInst) Get(docid common.DocID) common.DocData {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if pos := strings.Index(docid, common.VersionSeparator); pos != -1 {
+		if versions, ok := d.versionsDic[docid[:pos]]; ok {
+			return versions[docid[pos+1:]]
+		}
+		return nil
+	}
 	return d.docsDic[docid]
 }
 
+// Remove は zip ドキュメントを取り除いて返します。無ければ nil です。
+func (d *docGroupInst) Remove(docid common.DocID) common.DocData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	doc, ok := d.docsDic[docid]
+	if false == ok { // nolint:gosimple
+		return nil
+	}
+	delete(d.docsDic, docid)
+	return doc
+}
+
+// PutVersion は zip ドキュメントのバージョンを追加します。
+func (d *docGroupInst) PutVersion(docid common.DocID, version string, doc common.DocData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	versions, ok := d.versionsDic[docid]
+	if false == ok { // nolint:gosimple
+		versions = map[string]common.DocData{}
+		d.versionsDic[docid] = versions
+	}
+	if _, ok := versions[version]; false == ok {
+		d.versionNames[docid] = append(d.versionNames[docid], version)
+	}
+	versions[version] = doc
+}
+
+// Versions は zip ドキュメントのバージョン名の一覧を古い順に取得します。
+func (d *docGroupInst) Versions(docid common.DocID) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]string{}, d.versionNames[docid]...)
+}
+
 // Ids はホストしている zip ドキュメントの名前の一覧を取得します。
 func (d *docGroupInst) Ids() []common.DocID {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	keys := make([]common.DocID, 0, len(d.docsDic))
 	for key := range d.docsDic {
 		keys = append(keys, key)
@@ -72,11 +137,20 @@ func (d *docGroupInst) Ids() []common.DocID {
 
 // Close はホストしている zip ドキュメントをクローズします。
 func (d *docGroupInst) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	// すべてのドキュメントをクローズ
 	for _, v := range d.docsDic {
 		v.Close()
 	}
+	for _, versions := range d.versionsDic {
+		for _, v := range versions {
+			v.Close()
+		}
+	}
 	d.docsDic = map[common.DocID]common.DocData{}
+	d.versionsDic = map[common.DocID]map[string]common.DocData{}
+	d.versionNames = map[common.DocID][]string{}
 }
 
 // SetTitleInfo はタイトル情報をセットします。
@@ -3,12 +3,15 @@ package model
 import (
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 )
 
 type docHostInst struct {
+	// 管理画面からの追加と並行して参照されるため
+	mu sync.RWMutex
 	// ホスト名
 	name common.HostName
 	// ポート番号
@@ -35,8 +38,9 @@ func (h *docHostInst) JSON() json.ElemObject {
 	elem.Put("apiPath", json.NewElemString(h.apiPath))
 	groupsDic := json.NewElemObject()
 	for _, id := range h.Ids() {
-		group := h.groupDic[id]
-		groupsDic.Put(group.Name(), group.JSON())
+		if group := h.Get(id); group != nil {
+			groupsDic.Put(group.Name(), group.JSON())
+		}
 	}
 	elem.Put("groups", groupsDic)
 	elem.Put("title", json.NewElemString(h.title))
@@ -68,11 +72,22 @@ func (h *docHostInst) Port() string {
 
 // Put はドキュメントグループを追加します。
 func (h *docHostInst) Put(groupid common.DocGroupName, group common.DocGroup) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	h.groupDic[groupid] = group
 }
 
+// Remove はドキュメントグループを取り除きます。
+func (h *docHostInst) Remove(groupid common.DocGroupName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.groupDic, groupid)
+}
+
 // Get はドキュメントグループを取得します。
 func (h *docHostInst) Get(group common.DocGroupName) common.DocGroup {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	if g, ok := h.groupDic[group]; ok {
 		return g
 	}
@@ -81,6 +96,8 @@ func (h *docHostInst) Get(group common.DocGroupName) common.DocGroup {
 
 // Ids はホストしているドキュメントグループの名前の一覧を取得します。
 func (h *docHostInst) Ids() []common.DocGroupName {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	keys := make([]common.DocGroupName, 0, len(h.groupDic))
 	for key := range h.groupDic {
 		keys = append(keys, key)
@@ -111,6 +128,8 @@ func (h *docHostInst) SetAPI(api common.API) {
 
 // Close はホストしているドキュメントグループをクローズします。
 func (h *docHostInst) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	for _, v := range h.groupDic {
 		v.Close()
 	}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/xorvercom/util/pkg/json"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
@@ -15,6 +16,12 @@ const (
 )
 
 type portManInst struct {
+	// 管理コンソールと準備の確認が別の goroutine から参照するため、以下を保護する
+	mu sync.Mutex
+	// 待ち受けを行わない (オフライン管理用)
+	offline bool
+	// リスナをクローズした
+	closed    bool
 	nextPort  int
 	listeners map[int]*net.TCPListener
 	// ポートグループ名 -> ポート
@@ -36,11 +43,21 @@ func NewPortMan(start int) common.PortMan {
 	}
 }
 
+// NewOfflinePortMan は待ち受けを行わないポートマネージャを生成します。
+// サーバを起動せずに設定を扱うサブコマンドで使用します。
+func NewOfflinePortMan(start int) common.PortMan {
+	p := NewPortMan(start).(*portManInst)
+	p.offline = true
+	return p
+}
+
 // OpenLockIn は
 func (p *portManInst) OpenLockIn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for host, port := range p.portMap {
 		if _, ok := p.listeners[port]; ok {
-			err := p.Put(host, port)
+			err := p.put(host, port)
 			if err != nil {
 				return err
 			}
@@ -51,12 +68,26 @@ func (p *portManInst) OpenLockIn() error {
 
 // PutLockIn は以前に利用していたポート番号を予約します。
 func (p *portManInst) PutLockIn(host common.HostName, port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.putLockIn(host, port)
+}
+
+// putLockIn は PutLockIn の本体です。mu を得てから呼び出します。
+func (p *portManInst) putLockIn(host common.HostName, port int) {
 	p.lockinPorts[host] = port
 	p.lockinHosts[port] = host
 }
 
 // Port はグループ名のポートを返します。未登録ならば空いているポートを探して確保します。
 func (p *portManInst) Port(host common.HostName) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.port(host)
+}
+
+// port は Port の本体です。mu を得てから呼び出します。
+func (p *portManInst) port(host common.HostName) int {
 	port, ok := p.portMap[host]
 	if false == ok { // nolint:gosimple
 		// 未登録ならば空いているポートを探して登録します。
@@ -68,6 +99,8 @@ func (p *portManInst) Port(host common.HostName) int {
 
 // HostName はポート番号のドキュメントグループ名称を返します。
 func (p *portManInst) HostName(port int) common.HostName {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if docGroupName, ok := p.lockinHosts[port]; ok {
 		return docGroupName
 	}
@@ -75,20 +108,29 @@ func (p *portManInst) HostName(port int) common.HostName {
 }
 
 // LockInPorts はグループ名-ポートのマップを返します。
+// 呼び出し側が変更できるように写しを返します。
 func (p *portManInst) LockInPorts() map[common.HostName]int {
-	return p.lockinPorts
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ports := make(map[common.HostName]int, len(p.lockinPorts))
+	for host, port := range p.lockinPorts {
+		ports[host] = port
+	}
+	return ports
 }
 
 // HostNames はグループ名を返します。
 func (p *portManInst) HostNames() []common.HostName {
-	return p.hostNames
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]common.HostName{}, p.hostNames...)
 }
 
-// assign は空いているポートを探して登録します。
+// assign は空いているポートを探して登録します。mu を得てから呼び出します。
 func (p *portManInst) assign(host common.HostName) {
 	// そのポートグループが以前に使われていたら、そのときのポートに割り当てる
 	if port, ok := p.lockinPorts[host]; ok {
-		err := p.Put(host, port)
+		err := p.put(host, port)
 		if err == nil {
 			return
 		}
@@ -101,7 +143,7 @@ func (p *portManInst) assign(host common.HostName) {
 			// 予約されているのでスキップ
 			continue
 		}
-		err := p.Put(host, port)
+		err := p.put(host, port)
 		if err == nil {
 			return
 		}
@@ -110,32 +152,57 @@ func (p *portManInst) assign(host common.HostName) {
 
 // Listeners はリスナーを返します。
 func (p *portManInst) Listener(host common.HostName) *net.TCPListener {
-	return p.listeners[p.Port(host)]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listeners[p.port(host)]
 }
 
 // Put はポートを登録します。固定ポートの登録時に使用します。
 func (p *portManInst) Put(host common.HostName, port int) error {
-	laddr, _ := net.ResolveTCPAddr("tcp", ":"+strconv.Itoa(port))
-	listener, err := net.ListenTCP("tcp", laddr)
-	if err != nil {
-		return err
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.put(host, port)
+}
+
+// put は Put の本体です。mu を得てから呼び出します。
+func (p *portManInst) put(host common.HostName, port int) error {
+	if false == p.offline {
+		laddr, _ := net.ResolveTCPAddr("tcp", ":"+strconv.Itoa(port))
+		listener, err := net.ListenTCP("tcp", laddr)
+		if err != nil {
+			return err
+		}
+		p.listeners[port] = listener
 	}
-	p.listeners[port] = listener
 	p.portMap[host] = port
 	p.hostNames = append(p.hostNames, host)
 	sort.Strings(p.hostNames)
 	// 使用ポート記録
-	p.PutLockIn(host, port)
+	p.putLockIn(host, port)
 	return nil
 }
 
 // Close は全てのリスナをクローズします。
 func (p *portManInst) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
 	for _, listener := range p.listeners {
 		listener.Close()
 	}
 }
 
+// Listening はホストのポートを待ち受けているかを返します。
+func (p *portManInst) Listening(host common.HostName) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	port, ok := p.portMap[host]
+	if false == ok || p.offline || p.closed { // nolint:gosimple
+		return false
+	}
+	return p.listeners[port] != nil
+}
+
 // Load はグループで使用するポートをポートロックインファイルから読みだします。
 func (p *portManInst) Load(portsfile string) {
 	element, err := json.LoadFromJSONFile(portsfile)
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+func TestPortManAssign(t *testing.T) {
+	p := NewOfflinePortMan(8900)
+	p.PutLockIn("b", 8901)
+	p.PutLockIn("c", 8950)
+	tests := []struct {
+		host common.HostName
+		want int
+	}{
+		{host: "a", want: 8900},
+		// 予約されたポートは飛ばす
+		{host: "d", want: 8902},
+		// 以前のポートに戻す
+		{host: "c", want: 8950},
+		{host: "b", want: 8901},
+		// 登録済みはそのまま
+		{host: "a", want: 8900},
+	}
+	for _, tt := range tests {
+		if got := p.Port(tt.host); got != tt.want {
+			t.Errorf("Port(%s) = %d, want %d", tt.host, got, tt.want)
+		}
+	}
+	if got := p.HostName(8902); got != "d" {
+		t.Errorf("HostName(8902) = %s, want d", got)
+	}
+	if got := fmt.Sprint(p.HostNames()); got != "[a b c d]" {
+		t.Errorf("HostNames() = %s", got)
+	}
+	if p.Listening("a") {
+		t.Errorf("offline port manager is listening")
+	}
+}
+
+func TestPortManConcurrent(t *testing.T) {
+	p := NewOfflinePortMan(9000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				host := fmt.Sprintf("h%d", (i*50+j)%40)
+				p.Port(host)
+				p.HostNames()
+				p.Listening(host)
+			}
+		}(i)
+	}
+	wg.Wait()
+	ports := map[int]common.HostName{}
+	for _, host := range p.HostNames() {
+		port := p.Port(host)
+		if other, ok := ports[port]; ok {
+			t.Errorf("port %d assigned to %s and %s", port, other, host)
+		}
+		ports[port] = host
+	}
+	if len(ports) != 40 {
+		t.Errorf("%d hosts, want 40", len(ports))
+	}
+}
@@ -84,3 +84,9 @@ func (s *securityManInst) UseLocalStorage(hostName common.HostName) bool {
 	}
 	return false
 }
+
+// Password はホストのパスワードを返します。
+func (s *securityManInst) Password(hostName common.HostName) (string, bool) {
+	pass, ok := s.pass[hostName]
+	return pass, ok
+}
@@ -0,0 +1,100 @@
+package publish
+
+import (
+	"crypto/ed25519"
+	srand "crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	fpath "path/filepath"
+
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+)
+
+const (
+	// 秘密鍵の拡張子
+	extPrivateKey = ".key"
+	// 公開鍵の拡張子
+	extPublicKey = ".pub"
+)
+
+// keyPair は署名に使用する鍵ペアです。
+type keyPair struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// publicPEM は公開鍵を PEM 文字列で返します。
+func (k *keyPair) publicPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.public)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// sign は署名します。
+func (k *keyPair) sign(message []byte) []byte {
+	return ed25519.Sign(k.private, message)
+}
+
+// loadOrCreateKey は keyDir/{host}.key の鍵ペアを読み出します。無ければ生成して保存します。
+func loadOrCreateKey(keyDir string, host common.HostName) (*keyPair, error) {
+	keyFile := fpath.Join(keyDir, host+extPrivateKey)
+	if common.FileExists(keyFile) {
+		return loadKey(keyFile)
+	}
+
+	// 鍵ペアを生成
+	public, private, err := ed25519.GenerateKey(srand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error ed25519.GenerateKey : %v", err)
+	}
+	key := &keyPair{private: private, public: public}
+
+	// 保存する
+	err = os.MkdirAll(keyDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", keyDir, err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error write %s : %v", keyFile, err)
+	}
+	pub, err := key.publicPEM()
+	if err != nil {
+		return nil, err
+	}
+	pubFile := fpath.Join(keyDir, host+extPublicKey)
+	err = os.WriteFile(pubFile, []byte(pub), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error write %s : %v", pubFile, err)
+	}
+	return key, nil
+}
+
+// loadKey は PEM 形式の秘密鍵を読み出します。
+func loadKey(keyFile string) (*keyPair, error) {
+	buf, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error read %s : %v", keyFile, err)
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("error %s is not PEM", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parse %s : %v", keyFile, err)
+	}
+	private, ok := parsed.(ed25519.PrivateKey)
+	if false == ok { // nolint:gosimple
+		return nil, fmt.Errorf("error %s is not ed25519 key", keyFile)
+	}
+	return &keyPair{private: private, public: private.Public().(ed25519.PublicKey)}, nil
+}
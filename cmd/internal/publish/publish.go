@@ -0,0 +1,189 @@
+package publish
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	fpath "path/filepath"
+
+	"github.com/xorvercom/util/pkg/json"
+	"github.com/xorvercom/ziphttpd/cmd/internal/common"
+	"github.com/ziphttpd/zhsig/pkg/zhsig"
+)
+
+const (
+	// 署名ファイルの拡張子
+	extSig = ".sig"
+	// 鍵の置き場 (設定ファイルのフォルダからの相対)
+	keyFolder = "publish"
+)
+
+// Result は配布したドキュメントの情報です。
+type Result struct {
+	// ドキュメントグループ名
+	Group common.DocGroupName
+	// ドキュメント名
+	DocID common.DocID
+	// 配布したファイル
+	File string
+	// SHA-256
+	Hash string
+}
+
+// Publish は localhost の docs に置かれたドキュメントを zhsig のカタログと署名として書き出します。
+// 出力先は conf.PublishPath() 以下で、ストアと同じフォルダ構成になります。
+func Publish(conf common.Config) ([]*Result, error) {
+	hostName := conf.PublishHost()
+	docHost := conf.DocHost(common.LocalHostName)
+	if docHost == nil {
+		return nil, fmt.Errorf("no document in %s", conf.DocPath())
+	}
+
+	// 鍵ペア
+	key, err := loadOrCreateKey(fpath.Join(conf.ConfigPath(), keyFolder), hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	host := zhsig.NewHost(conf.PublishPath(), hostName)
+	results := []*Result{}
+	cat := &zhsig.Catalog{Groups: map[string]*zhsig.Group{}}
+	for _, groupName := range docHost.Ids() {
+		docGroup := docHost.Get(groupName)
+		group := &zhsig.Group{
+			Title:       titleOf(docGroup.Title(), groupName),
+			Description: docGroup.Description(),
+			Docs:        map[string]*zhsig.Doc{},
+		}
+		for _, docID := range docGroup.Ids() {
+			doc := docGroup.Get(docID)
+			_, fileName := fpath.Split(doc.ZipPath())
+			res, err := publishDoc(key, doc, host.File(docID, fileName), host.File(docID, docID+extSig))
+			if err != nil {
+				return results, err
+			}
+			// zhsig で読み戻せることを確かめる
+			sig, err := zhsig.ReadSig(host, docID)
+			if err != nil {
+				return results, fmt.Errorf("error zhsig.ReadSig(%s) : %v", docID, err)
+			}
+			if sig.File() != fileName {
+				return results, fmt.Errorf("signature of %s names %s, not %s", docID, sig.File(), fileName)
+			}
+			res.Group = groupName
+			results = append(results, res)
+			group.Docs[docID] = &zhsig.Doc{Title: titleOf(doc.Title(), docID), Description: doc.Description()}
+		}
+		cat.Groups[groupName] = group
+	}
+
+	// ピア情報
+	pub, err := key.publicPEM()
+	if err != nil {
+		return results, err
+	}
+	peer := json.NewElemObject()
+	peer.Put("host", json.NewElemString(hostName))
+	peer.Put("publickey", json.NewElemString(pub))
+
+	// カタログを書き出す
+	catalog := json.NewElemObject()
+	catalog.Put("peer", peer)
+	catalog.Put("groups", groupsJSON(cat))
+	catalogFile := host.CatalogFile()
+	err = os.MkdirAll(fpath.Dir(catalogFile), 0755)
+	if err != nil {
+		return results, fmt.Errorf("error os.MkdirAll(%s) : %v", fpath.Dir(catalogFile), err)
+	}
+	err = json.SaveToJSONFile(catalogFile, catalog, true)
+	if err != nil {
+		return results, fmt.Errorf("error json.SaveToJSONFile(%s, ..., true) : %v", catalogFile, err)
+	}
+	// zhsig で読み戻せることを確かめる
+	if err := verifyCatalog(catalogFile, cat); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// groupsJSON はカタログのグループの JSON を返します。
+func groupsJSON(cat *zhsig.Catalog) json.ElemObject {
+	groups := json.NewElemObject()
+	for groupName, group := range cat.Groups {
+		docs := json.NewElemObject()
+		for docID, doc := range group.Docs {
+			docs.Put(docID, titleJSON(doc.Title, doc.Description))
+		}
+		elem := titleJSON(group.Title, group.Description)
+		elem.Put("docs", docs)
+		groups.Put(groupName, elem)
+	}
+	return groups
+}
+
+// verifyCatalog は書き出したカタログを zhsig で読み戻して、cat と同じ内容であるかを確かめます。
+func verifyCatalog(catalogFile string, cat *zhsig.Catalog) error {
+	read, err := zhsig.ReadCatalog(catalogFile)
+	if err != nil {
+		return fmt.Errorf("error zhsig.ReadCatalog(%s) : %v", catalogFile, err)
+	}
+	if len(read.Groups) != len(cat.Groups) {
+		return fmt.Errorf("catalog %s has %d groups, not %d", catalogFile, len(read.Groups), len(cat.Groups))
+	}
+	for groupName, group := range cat.Groups {
+		r, ok := read.Groups[groupName]
+		if false == ok || r.Title != group.Title || len(r.Docs) != len(group.Docs) { // nolint:gosimple
+			return fmt.Errorf("catalog %s does not match group %s", catalogFile, groupName)
+		}
+		for docID, doc := range group.Docs {
+			if d, ok := r.Docs[docID]; false == ok || d.Title != doc.Title { // nolint:gosimple
+				return fmt.Errorf("catalog %s does not match document %s/%s", catalogFile, groupName, docID)
+			}
+		}
+	}
+	return nil
+}
+
+// publishDoc はドキュメントのアーカイブを複写して署名ファイルを書き出します。
+func publishDoc(key *keyPair, doc common.DocData, dstName, sigFile string) (*Result, error) {
+	_, fileName := fpath.Split(dstName)
+	err := os.MkdirAll(fpath.Dir(dstName), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error os.MkdirAll(%s) : %v", fpath.Dir(dstName), err)
+	}
+
+	// 複写しながらハッシュを計算
+	hash, size, err := common.CopyFile(doc.ZipPath(), dstName)
+	if err != nil {
+		return nil, err
+	}
+
+	// 署名
+	sig := json.NewElemObject()
+	sig.Put("file", json.NewElemString(fileName))
+	sig.Put("size", json.NewElemFloat(float64(size)))
+	sig.Put("sha256", json.NewElemString(hex.EncodeToString(hash)))
+	sig.Put("signature", json.NewElemString(base64.StdEncoding.EncodeToString(key.sign(hash))))
+	err = json.SaveToJSONFile(sigFile, sig, true)
+	if err != nil {
+		return nil, fmt.Errorf("error json.SaveToJSONFile(%s, ..., true) : %v", sigFile, err)
+	}
+	return &Result{DocID: doc.DocID(), File: dstName, Hash: hex.EncodeToString(hash)}, nil
+}
+
+// titleOf はタイトルが無ければ defaultTitle を返します。
+func titleOf(title, defaultTitle string) string {
+	if title == "" {
+		return defaultTitle
+	}
+	return title
+}
+
+// titleJSON はカタログのタイトル情報を返します。
+func titleJSON(title, description string) json.ElemObject {
+	elem := json.NewElemObject()
+	elem.Put("title", json.NewElemString(title))
+	elem.Put("description", json.NewElemString(description))
+	return elem
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/xorvercom/util/pkg/easywork"
+	"github.com/xorvercom/ziphttpd/cmd/internal/command"
 	"github.com/xorvercom/ziphttpd/cmd/internal/common"
 	iconfig "github.com/xorvercom/ziphttpd/cmd/internal/config"
 	"github.com/xorvercom/ziphttpd/cmd/internal/httpd"
@@ -32,35 +33,86 @@ func main() {
 		logPath      = flag.String("log", "", "logging directory")
 		listenPort   = flag.Int("port", common.DefaultListenPort, "listen port")
 		firstDocPort = flag.Int("docport", common.DefaultFirstDocPort, "document listen port")
+		verbose      = flag.Bool("v", false, "verbose logging (debug level)")
+		quiet        = flag.Bool("quiet", false, "quiet logging (error level only)")
+		strict       = flag.Bool("strict", false, "refuse to start on configuration errors")
 	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [command [args]]\n", os.Args[0])
+		flag.PrintDefaults()
+		command.Usage()
+	}
 	flag.Parse()
 	util.SetConfigDir(*confPath)
 	util.SetLogDir(*logPath)
 	util.SetListenPort(*listenPort)
 	util.SetFirstDocPort(*firstDocPort)
+	util.SetStrict(*strict)
+	switch {
+	case *verbose:
+		util.SetLogLevel(common.LogDebug.String())
+	case *quiet:
+		util.SetLogLevel(common.LogError.String())
+	}
+
+	// サブコマンド
+	if flag.NArg() > 0 {
+		os.Exit(command.Run(util, flag.Args()))
+	}
 
-	// pidファイル作成
-	pidfile := fpath.Join(*confPath, "ziphttpd.pid")
+	// pidファイル作成 (一行目はプロセス ID、二行目は status が問い合わせる代表ポート)
+	pidfile := fpath.Join(util.ConfigDir(), common.PidFile)
 	os.Remove(pidfile)
 	if pidf, err := os.Create(pidfile); err == nil {
-		fmt.Fprintf(pidf, "%d\n", os.Getpid())
+		fmt.Fprintf(pidf, "%d\n%d\n", os.Getpid(), util.ListenPort())
 		pidf.Close()
 		defer os.Remove(pidfile)
 	}
 
+	// 標準入力からのコマンド
+	lines := make(chan string)
+	go func() {
+		stdin := bufio.NewScanner(os.Stdin)
+		for stdin.Scan() {
+			lines <- stdin.Text()
+		}
+		close(lines)
+	}()
+
+	// 再読み込みが要求される間は、設定を読み直してサーバを起動しなおす
+	for {
+		reload, err := serve(util, lines, *quiet)
+		if err != nil {
+			// 設定の誤りで起動できない
+			// 再読み込みは AdminMan().Reload で先に新しい設定を読み込めることを確かめてから止めるので、
+			// ここに来るのはポートを待ち受けられないなど起動しなおせない場合だけ
+			fmt.Fprintln(os.Stderr, err)
+			os.Remove(pidfile)
+			os.Exit(1)
+		}
+		if false == reload { // nolint:gosimple
+			break
+		}
+	}
+}
+
+// serve は設定を読み込んでサーバを起動し、停止するまで待ちます。
+// 再読み込みが要求されて停止した場合は真を返します。設定を読み込めなければエラーを返します。
+func serve(util common.ZipHttpdUtil, lines <-chan string, quiet bool) (bool, error) {
 	// 設定読み込み
 	conf, err := iconfig.OpenConfig(util)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	defer conf.Close()
-	fmt.Println(conf)
+	if false == quiet { // nolint:gosimple
+		fmt.Println(conf)
+	}
 
 	log := conf.Logger()
 	log.Info("---- server start ----")
 
 	wg := easywork.NewGroup()
-	defer wg.Wait()
 
 	// サーバ生成
 	for _, hostName := range conf.PortMan().HostNames() {
@@ -71,37 +123,71 @@ func main() {
 		wg.Start(httpd.NewServer(conf, hostName))
 	}
 
+	// 管理用のポートの計測値
+	var metricsServer *httpd.MetricsServer
+	if conf.Metrics().Enabled && conf.Metrics().Port != 0 {
+		if metricsServer, err = httpd.NewMetricsServer(conf); err != nil {
+			log.Errorf("%v", err)
+		} else {
+			wg.Start(metricsServer)
+		}
+	}
+
+	// 定期的な同期
+	stopSync := logic.StartSync(conf)
+
 	// Interrupt検知
 	interuptChan := make(chan os.Signal, 1)
-	go func() {
-		signal.Notify(interuptChan)
-		go func() {
-			// シグナルを同期して待つ
-			func() {
-				for {
-					s := <-interuptChan
-					conf.Logger().Infof("signal: %v", s)
-					switch s {
-					case os.Interrupt:
-						return
-					case os.Kill:
-						return
-					}
+	signal.Notify(interuptChan)
+	defer signal.Stop(interuptChan)
+
+	reload := false
+	func() {
+		for {
+			select {
+			case s := <-interuptChan:
+				conf.Logger().Infof("signal: %v", s)
+				switch s {
+				case os.Interrupt:
+					return
+				case os.Kill:
+					return
+				}
+			case <-conf.AdminMan().ReloadRequest():
+				reload = true
+				return
+			case text, ok := <-lines:
+				if false == ok { // nolint:gosimple
+					// 標準入力が閉じられたらシグナルだけを待つ
+					lines = nil
+					continue
 				}
-			}()
-			// リスナを閉じる
-			conf.PortMan().Close()
-		}()
+				if strings.ToLower(strings.TrimSpace(text)) == "quit" {
+					return
+				}
+				command.Console(conf, os.Stdout, text)
+			}
+		}
 	}()
 
-	// 標準入力からのコマンド
-	stdin := bufio.NewScanner(os.Stdin)
-	for stdin.Scan() {
-		text := stdin.Text()
-		if strings.ToLower(text) == "quit" {
-			interuptChan <- os.Interrupt
-			break
+	// 同期を止める
+	stopSync()
+	// リスナを閉じる
+	conf.PortMan().Close()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	// API を止めて変更通知の接続を閉じる
+	for _, hostName := range conf.PortMan().HostNames() {
+		if api := conf.DocHost(hostName).GetAPI(); api != nil {
+			api.Terminate()
 		}
 	}
-	log.Info("---- server stop ----")
+	wg.Wait()
+	if reload {
+		log.Info("---- server reload ----")
+	} else {
+		log.Info("---- server stop ----")
+	}
+	return reload, nil
 }
@@ -1,6 +1,6 @@
 module github.com/xorvercom/ziphttpd
 
-go 1.17
+go 1.19
 
 require (
 	github.com/xorvercom/util v0.0.0-20221021224830-18a570af9024